@@ -1,9 +1,22 @@
 package cli
 
 import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
 	"github.com/alecthomas/kingpin"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
 	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/config"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/icrypto"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/nitro"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/solidity"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/utils"
 	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/service"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
 	"gitlab.com/distributed_lab/kit/kv"
 	"gitlab.com/distributed_lab/logan/v3"
 )
@@ -25,6 +38,27 @@ func Run(args []string) bool {
 	runCmd := app.Command("run", "run command")
 	serviceCmd := runCmd.Command("service", "run service") // you can insert custom help
 
+	rotateKeyCmd := app.Command("rotate-key", "create new signer key generation, running services pick it up on reload")
+	kmsPolicyCmd := app.Command("kms-policy", "print KMS key policy the signer key would be created with, nothing is created")
+
+	imageCmd := app.Command("image", "upgrade enclave image keeping the signer key, see README")
+	authorizeImageCmd := imageCmd.Command("authorize", "allow the new image to use the KMS key, run by a key admin outside the enclave")
+	authorizePCR0 := authorizeImageCmd.Flag("pcr0", "hex PCR0 of the new image").Required().HexBytes()
+	authorizePCRs := authorizeImageCmd.Flag("pcr", "hex value of another key policy PCR of the new image, e.g. 8=<hex>, the one of the first authorized image by default").StringMap()
+	reattestImageCmd := imageCmd.Command("reattest", "rewrite attestation documents made by other authorized images, run by the new image")
+	retireImageCmd := imageCmd.Command("retire", "disallow the old image to use the KMS key, run by a key admin outside the enclave after reattest")
+	retirePCR0 := retireImageCmd.Flag("pcr0", "hex PCR0 of the old image").Required().HexBytes()
+
+	genCmd := app.Command("gen", "generate code for consumers of the signatures")
+	genSolidityCmd := genCmd.Command("solidity", "generate Solidity library verifying signatures of the signing configuration and its test vectors, see README")
+	genDomain := genSolidityCmd.Flag("domain", `EIP712 domain JSON as in requests, e.g. {"name":"Test","version":"1"}`).Required().String()
+	genPrimaryType := genSolidityCmd.Flag("primary-type", "primary type name").Default(utils.DefaultPrimaryType).String()
+	genFields := genSolidityCmd.Flag("field", "field to sign, repeat in the fields_to_sign order").Default(utils.DefaultFieldsToSign...).Strings()
+	genEncodings := genSolidityCmd.Flag("encoding", "encoding of a field, e.g. pcr0=split").StringMap()
+	genTTL := genSolidityCmd.Flag("ttl", "sign issued_at and deadline of expiring endorsements").Duration()
+	genLibrary := genSolidityCmd.Flag("library", "library name, <primary type>Verifier by default").String()
+	genOut := genSolidityCmd.Flag("out", "directory to write <library>.sol and <library>.json test vectors to").Default(".").ExistingDir()
+
 	// custom commands go here...
 
 	cmd, err := app.Parse(args[1:])
@@ -36,6 +70,88 @@ func Run(args []string) bool {
 	switch cmd {
 	case serviceCmd.FullCommand():
 		service.Run(cfg)
+	case rotateKeyCmd.FullCommand():
+		// Bootstrap would create the first generation just to supersede it
+		generation, err := cfg.GetPendingSigner().Rotate()
+		if err != nil {
+			log.WithError(err).Error("failed to rotate signer key")
+			return false
+		}
+		log.WithFields(logan.F{
+			"generation": generation.ID,
+			"address":    generation.Address.Hex(),
+		}).Info("signer key rotated")
+	case kmsPolicyCmd.FullCommand():
+		keyPolicy := cfg.GetKeyPolicy()
+		policy, err := keyPolicy.Render(cfg.GetKMSBackend(), cfg.GetAttestationProvider())
+		if err != nil {
+			log.WithError(err).Error("failed to render kms key policy")
+			return false
+		}
+		log.WithFields(logan.F{
+			"alias": keyPolicy.Alias,
+			"tags":  keyPolicy.Tags,
+		}).Info("kms key policy rendered")
+		warnPolicyAdmins(log, keyPolicy)
+		fmt.Println(policy)
+	case authorizeImageCmd.FullCommand():
+		pcrs := map[int][]byte{0: *authorizePCR0}
+		for key, value := range *authorizePCRs {
+			index, err := strconv.Atoi(key)
+			if err != nil {
+				log.WithError(err).Errorf("invalid PCR index %s", key)
+				return false
+			}
+			if pcrs[index], err = hex.DecodeString(value); err != nil {
+				log.WithError(err).Errorf("invalid PCR%d value", index)
+				return false
+			}
+		}
+
+		image, err := nitro.AuthorizeImage(cfg.GetKMSBackend(), cfg.GetAttestationProvider(), cfg.GetKeyPolicy(), cfg.GetStorage(), pcrs)
+		if err != nil {
+			log.WithError(err).Error("failed to authorize image")
+			return false
+		}
+		log.WithFields(logan.F{"conditions": image}).Info("image authorized")
+	case reattestImageCmd.FullCommand():
+		generations, err := nitro.ReattestGenerations(cfg.GetKMSBackend(), cfg.GetAttestationProvider(), cfg.GetKeyPolicy(), cfg.GetStorage())
+		if err != nil {
+			log.WithError(err).Error("failed to re-attest generations")
+			return false
+		}
+		log.WithFields(logan.F{"generations": generations}).Info("generations re-attested")
+	case retireImageCmd.FullCommand():
+		err := nitro.RetireImage(cfg.GetKMSBackend(), cfg.GetAttestationProvider(), cfg.GetKeyPolicy(), cfg.GetStorage(), *retirePCR0)
+		if err != nil {
+			log.WithError(err).Error("failed to retire image")
+			return false
+		}
+		log.WithFields(logan.F{"pcr0": hex.EncodeToString(*retirePCR0)}).Info("image retired")
+	case genSolidityCmd.FullCommand():
+		var typedDataDomain apitypes.TypedDataDomain
+		if err := json.Unmarshal([]byte(*genDomain), &typedDataDomain); err != nil {
+			log.WithError(err).Error("invalid domain")
+			return false
+		}
+		generator := &solidity.Generator{
+			Library:     *genLibrary,
+			Domain:      icrypto.GetDomain(typedDataDomain),
+			PrimaryType: *genPrimaryType,
+			Fields:      *genFields,
+			Encodings:   *genEncodings,
+			TTL:         *genTTL,
+		}
+		if generator.Library == "" {
+			generator.Library = generator.PrimaryType + "Verifier"
+		}
+
+		files, err := generateSolidity(generator, *genOut)
+		if err != nil {
+			log.WithError(err).Error("failed to generate solidity")
+			return false
+		}
+		log.WithFields(logan.F{"files": files}).Info("solidity generated")
 	// handle any custom commands here in the same way
 	default:
 		log.Errorf("unknown command %s", cmd)
@@ -44,3 +160,50 @@ func Run(args []string) bool {
 
 	return true
 }
+
+// warnPolicyAdmins warns that the admins can rewrite the key policy, and
+// with it release the signer key to anyone
+func warnPolicyAdmins(log *logan.Entry, keyPolicy nitro.KeyPolicy) {
+	if len(keyPolicy.AdminArns) == 0 {
+		log.Info("nobody is allowed to update the kms key policy, images can't be upgraded")
+		return
+	}
+
+	log.WithFields(logan.F{
+		"admins": keyPolicy.AdminArns,
+	}).Warn("KEY ADMINS CAN REWRITE THE KMS KEY POLICY AND RELEASE THE SIGNER KEY TO ANYONE, see README")
+	for _, adminArn := range keyPolicy.AdminArns {
+		if parsedArn, err := arn.Parse(adminArn); err == nil && parsedArn.Resource == "root" {
+			log.WithFields(logan.F{
+				"admin": adminArn,
+			}).Warn("ACCOUNT ROOT IS A KEY ADMIN, ANY PRINCIPAL OF THE ACCOUNT ALLOWED kms:PutKeyPolicy BY IAM CAN REWRITE THE KMS KEY POLICY")
+		}
+	}
+}
+
+// generateSolidity writes the library and its test vectors to the directory
+func generateSolidity(generator *solidity.Generator, directory string) ([]string, error) {
+	source, err := generator.Solidity()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate library: %w", err)
+	}
+	vectors, err := generator.Vectors()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate test vectors: %w", err)
+	}
+	rawVectors, err := json.MarshalIndent(vectors, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal test vectors: %w", err)
+	}
+
+	sourceFile := filepath.Join(directory, generator.Library+".sol")
+	vectorsFile := filepath.Join(directory, generator.Library+".json")
+	if err = os.WriteFile(sourceFile, source, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write library: %w", err)
+	}
+	if err = os.WriteFile(vectorsFile, append(rawVectors, '\n'), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write test vectors: %w", err)
+	}
+
+	return []string{sourceFile, vectorsFile}, nil
+}
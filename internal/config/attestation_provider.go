@@ -0,0 +1,77 @@
+package config
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/nitro"
+	figure "gitlab.com/distributed_lab/figure/v3"
+	"gitlab.com/distributed_lab/kit/kv"
+)
+
+const (
+	NSMNitro     = "nitro"
+	NSMSimulator = "simulator"
+)
+
+func (c *config) GetAttestationProvider() nitro.AttestationProvider {
+	return c.attestationProviderConfigurator.Do(func() any {
+		var cfg struct {
+			NSM string `fig:"nsm"`
+		}
+
+		err := figure.
+			Out(&cfg).
+			From(kv.MustGetStringMap(c.getter, "signer")).
+			Please()
+		if err != nil {
+			panic(fmt.Errorf("failed to figure out signer config: %w", err))
+		}
+
+		switch cfg.NSM {
+		case "", NSMNitro:
+			return nitro.NewNSMProvider()
+		case NSMSimulator:
+			return c.newSimulator()
+		default:
+			panic(fmt.Errorf("unknown signer nsm %q, must be one of [%s, %s]", cfg.NSM, NSMNitro, NSMSimulator))
+		}
+	}).(nitro.AttestationProvider)
+}
+
+func (c *config) newSimulator() *nitro.Simulator {
+	var cfg struct {
+		ModuleID    string            `fig:"module_id"`
+		PCRs        map[string]string `fig:"pcrs"`
+		CADirectory string            `fig:"ca_directory"`
+	}
+
+	err := figure.
+		Out(&cfg).
+		From(kv.MustGetStringMap(c.getter, "nsm_simulator")).
+		Please()
+	if err != nil {
+		panic(fmt.Errorf("failed to figure out nsm simulator config: %w", err))
+	}
+
+	pcrs := make(map[int][]byte, len(cfg.PCRs))
+	for key, value := range cfg.PCRs {
+		index, err := strconv.Atoi(strings.TrimPrefix(strings.ToLower(key), "pcr"))
+		if err != nil {
+			panic(fmt.Errorf("invalid nsm simulator PCR index %s: %w", key, err))
+		}
+
+		if pcrs[index], err = hex.DecodeString(strings.TrimPrefix(value, "0x")); err != nil {
+			panic(fmt.Errorf("invalid nsm simulator PCR%d hex value: %w", index, err))
+		}
+	}
+
+	simulator, err := nitro.NewSimulator(cfg.ModuleID, pcrs, cfg.CADirectory)
+	if err != nil {
+		panic(fmt.Errorf("failed to create nsm simulator: %w", err))
+	}
+
+	return simulator
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"fmt"
+	"runtime"
+
+	figure "gitlab.com/distributed_lab/figure/v3"
+	"gitlab.com/distributed_lab/kit/kv"
+)
+
+const (
+	DefaultBatchMaxItems   = 256
+	DefaultBatchMaxDomains = 16
+)
+
+// Batch limits batch signing requests
+type Batch struct {
+	// Maximum count of attestation documents in a request
+	MaxItems int
+	// Maximum count of domains in an item
+	MaxDomains int
+	// Count of documents verified and signed concurrently within a request
+	Workers int
+}
+
+func (c *config) GetBatch() *Batch {
+	return c.batchConfigurator.Do(func() any {
+		var cfg struct {
+			MaxItems   int `fig:"max_items"`
+			MaxDomains int `fig:"max_domains"`
+			Workers    int `fig:"workers"`
+		}
+
+		err := figure.
+			Out(&cfg).
+			From(kv.MustGetStringMap(c.getter, "batch")).
+			Please()
+		if err != nil {
+			panic(fmt.Errorf("failed to figure out batch config: %w", err))
+		}
+
+		batch := &Batch{
+			MaxItems:   cfg.MaxItems,
+			MaxDomains: cfg.MaxDomains,
+			Workers:    cfg.Workers,
+		}
+		if batch.MaxItems <= 0 {
+			batch.MaxItems = DefaultBatchMaxItems
+		}
+		if batch.MaxDomains <= 0 {
+			batch.MaxDomains = DefaultBatchMaxDomains
+		}
+		if batch.Workers <= 0 {
+			batch.Workers = runtime.NumCPU()
+		}
+
+		return batch
+	}).(*Batch)
+}
@@ -13,25 +13,34 @@ type inetListener struct {
 
 	Address  string `fig:"addr,required"`
 	Disabled bool   `fig:"disabled"`
+
+	server ServerConfig
 }
 
 func (l *inetListener) IsDisabled() bool {
 	return l.Disabled
 }
 
+func (l *inetListener) Server() ServerConfig {
+	return l.server
+}
+
 func (c *config) GetInetListener() Listener {
 	return c.inetConfigurator.Do(func() any {
 		var inetListener inetListener
 
+		raw := kv.MustGetStringMap(c.getter, "inet_listener")
 		err := figure.
 			Out(&inetListener).
-			From(kv.MustGetStringMap(c.getter, "inet_listener")).
+			From(raw).
 			Please()
 
 		if err != nil {
 			panic(fmt.Errorf("failed to figure out: %w", err))
 		}
 
+		inetListener.server = figureServerConfig("inet_listener", raw)
+
 		if inetListener.IsDisabled() {
 			return &inetListener
 		}
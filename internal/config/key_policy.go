@@ -0,0 +1,61 @@
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/nitro"
+	figure "gitlab.com/distributed_lab/figure/v3"
+	"gitlab.com/distributed_lab/kit/kv"
+)
+
+// GetKeyPolicy returns policy the signer KMS key is created with
+func (c *config) GetKeyPolicy() nitro.KeyPolicy {
+	return c.keyPolicyConfigurator.Do(func() any {
+		var cfg struct {
+			PCRs          []int             `fig:"key_policy_pcrs"`
+			ImageSha384   bool              `fig:"key_policy_image_sha384"`
+			TemplateFile  string            `fig:"key_policy_template"`
+			AdminArns     []string          `fig:"key_admin_arns"`
+			BypassLockout bool              `fig:"key_policy_bypass_lockout_check"`
+			RolePath      string            `fig:"key_role_path"`
+			Alias         string            `fig:"key_alias"`
+			Tags          map[string]string `fig:"key_tags"`
+		}
+
+		err := figure.
+			Out(&cfg).
+			From(kv.MustGetStringMap(c.getter, "signer")).
+			Please()
+		if err != nil {
+			panic(fmt.Errorf("failed to figure out signer key policy config: %w", err))
+		}
+
+		keyPolicy, err := nitro.NewKeyPolicy(cfg.PCRs, cfg.ImageSha384)
+		if err != nil {
+			panic(fmt.Errorf("failed to figure out signer key policy: %w", err))
+		}
+
+		keyPolicy.AdminArns = cfg.AdminArns
+		keyPolicy.BypassLockoutCheck = cfg.BypassLockout
+		keyPolicy.RolePath = cfg.RolePath
+		keyPolicy.Alias = cfg.Alias
+		keyPolicy.Tags = cfg.Tags
+
+		if cfg.TemplateFile != "" {
+			raw, err := os.ReadFile(cfg.TemplateFile)
+			if err != nil {
+				panic(fmt.Errorf("failed to read key policy template %s: %w", cfg.TemplateFile, err))
+			}
+			if keyPolicy.Template, err = nitro.ParseKeyPolicyTemplate(string(raw)); err != nil {
+				panic(fmt.Errorf("failed to parse key policy template %s: %w", cfg.TemplateFile, err))
+			}
+		}
+
+		if err = keyPolicy.Validate(); err != nil {
+			panic(fmt.Errorf("invalid signer key policy: %w", err))
+		}
+
+		return keyPolicy
+	}).(nitro.KeyPolicy)
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"context"
+	"fmt"
+
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/fakekms"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/nitro"
+	figure "gitlab.com/distributed_lab/figure/v3"
+	"gitlab.com/distributed_lab/kit/kv"
+)
+
+const (
+	KMSAWS  = "aws"
+	KMSFake = "fake"
+)
+
+func (c *config) GetKMSBackend() nitro.KMSBackend {
+	return c.kmsBackendConfigurator.Do(func() any {
+		var cfg struct {
+			KMS string `fig:"kms"`
+		}
+
+		err := figure.
+			Out(&cfg).
+			From(kv.MustGetStringMap(c.getter, "signer")).
+			Please()
+		if err != nil {
+			panic(fmt.Errorf("failed to figure out signer config: %w", err))
+		}
+
+		switch cfg.KMS {
+		case "", KMSAWS:
+			awsConfig, err := awsconfig.LoadDefaultConfig(context.Background())
+			if err != nil {
+				panic(fmt.Errorf("failed to load AWS config: %w", err))
+			}
+			return nitro.NewAWSKMSBackend(awsConfig)
+		case KMSFake:
+			return c.newFakeKMS()
+		default:
+			panic(fmt.Errorf("unknown signer kms %q, must be one of [%s, %s]", cfg.KMS, KMSAWS, KMSFake))
+		}
+	}).(nitro.KMSBackend)
+}
+
+func (c *config) newFakeKMS() *fakekms.Backend {
+	var cfg struct {
+		Directory    string `fig:"directory,required"`
+		PrincipalArn string `fig:"principal_arn"`
+	}
+
+	err := figure.
+		Out(&cfg).
+		From(kv.MustGetStringMap(c.getter, "fake_kms")).
+		Please()
+	if err != nil {
+		panic(fmt.Errorf("failed to figure out fake kms config: %w", err))
+	}
+
+	// Fake KMS trusts the same root as the attestation provider,
+	// so it works with both simulated and real documents
+	backend, err := fakekms.New(cfg.Directory, cfg.PrincipalArn, c.GetAttestationProvider().RootFingerprint())
+	if err != nil {
+		panic(fmt.Errorf("failed to create fake kms: %w", err))
+	}
+
+	return backend
+}
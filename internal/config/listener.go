@@ -2,7 +2,13 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"net"
+	"net/http"
+	"time"
+
+	figure "gitlab.com/distributed_lab/figure/v3"
+	"golang.org/x/net/netutil"
 )
 
 var (
@@ -10,7 +16,90 @@ var (
 	ErrListenerNotInitialized = errors.New("listener is not initialized")
 )
 
+const (
+	DefaultServerReadHeaderTimeout = 10 * time.Second
+	DefaultServerReadTimeout       = 30 * time.Second
+	DefaultServerWriteTimeout      = 60 * time.Second
+	DefaultServerIdleTimeout       = 2 * time.Minute
+	DefaultServerMaxHeaderBytes    = 64 << 10
+	DefaultServerMaxBodyBytes      = 8 << 20
+)
+
 type Listener interface {
 	net.Listener
 	IsDisabled() bool
+	// Server returns config of the HTTP server of the listener
+	Server() ServerConfig
+}
+
+// ServerConfig limits the HTTP server of a listener
+type ServerConfig struct {
+	ReadHeaderTimeout time.Duration `fig:"read_header_timeout"`
+	ReadTimeout       time.Duration `fig:"read_timeout"`
+	WriteTimeout      time.Duration `fig:"write_timeout"`
+	IdleTimeout       time.Duration `fig:"idle_timeout"`
+	MaxHeaderBytes    int           `fig:"max_header_bytes"`
+	MaxBodyBytes      int64         `fig:"max_body_bytes"`
+	// Maximum count of concurrent connections, unlimited if zero
+	MaxConnections int `fig:"max_connections"`
+}
+
+// NewServer returns HTTP server of the handler with the timeouts and
+// header and body size limits
+func (c ServerConfig) NewServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           http.MaxBytesHandler(handler, c.MaxBodyBytes),
+		ReadHeaderTimeout: c.ReadHeaderTimeout,
+		ReadTimeout:       c.ReadTimeout,
+		WriteTimeout:      c.WriteTimeout,
+		IdleTimeout:       c.IdleTimeout,
+		MaxHeaderBytes:    c.MaxHeaderBytes,
+	}
+}
+
+// Limit returns the listener accepting at most MaxConnections at once,
+// the next connections wait in the backlog
+func (c ServerConfig) Limit(listener net.Listener) net.Listener {
+	if c.MaxConnections <= 0 {
+		return listener
+	}
+
+	return netutil.LimitListener(listener, c.MaxConnections)
+}
+
+// figureServerConfig reads server config from the listener section
+func figureServerConfig(section string, raw map[string]interface{}) ServerConfig {
+	var server ServerConfig
+
+	err := figure.
+		Out(&server).
+		From(raw).
+		Please()
+	if err != nil {
+		panic(fmt.Errorf("failed to figure out %s server config: %w", section, err))
+	}
+
+	if server.ReadHeaderTimeout <= 0 {
+		server.ReadHeaderTimeout = DefaultServerReadHeaderTimeout
+	}
+	if server.ReadTimeout <= 0 {
+		server.ReadTimeout = DefaultServerReadTimeout
+	}
+	if server.WriteTimeout <= 0 {
+		server.WriteTimeout = DefaultServerWriteTimeout
+	}
+	if server.IdleTimeout <= 0 {
+		server.IdleTimeout = DefaultServerIdleTimeout
+	}
+	if server.MaxHeaderBytes <= 0 {
+		server.MaxHeaderBytes = DefaultServerMaxHeaderBytes
+	}
+	if server.MaxBodyBytes <= 0 {
+		server.MaxBodyBytes = DefaultServerMaxBodyBytes
+	}
+	if server.MaxConnections < 0 {
+		panic(fmt.Errorf("%s max_connections must not be negative", section))
+	}
+
+	return server
 }
@@ -1,6 +1,9 @@
 package config
 
 import (
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/icrypto"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/nitro"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/storage"
 	"gitlab.com/distributed_lab/kit/comfig"
 	"gitlab.com/distributed_lab/kit/kv"
 )
@@ -11,14 +14,41 @@ type Config interface {
 	GetVsockListener() Listener
 
 	GetSigner() *Signer
+	GetPendingSigner() *Signer
+	GetVerifier() *Verifier
+	GetNonces() *Nonces
+	GetPolicies() *Policies
+	GetBatch() *Batch
+	GetValidity() *Validity
+	GetDomainResolver() *icrypto.DomainResolver
+	GetMetrics() *Metrics
+	GetSelfTest() *SelfTest
+	GetShutdown() *Shutdown
+	GetAttestationProvider() nitro.AttestationProvider
+	GetKMSBackend() nitro.KMSBackend
+	GetKeyPolicy() nitro.KeyPolicy
+	GetStorage() storage.Storage
 }
 
 type config struct {
 	comfig.Logger
 
-	signerConfigurator comfig.Once
-	inetConfigurator   comfig.Once
-	vsockConfigurator  comfig.Once
+	signerConfigurator              comfig.Once
+	attestationProviderConfigurator comfig.Once
+	kmsBackendConfigurator          comfig.Once
+	keyPolicyConfigurator           comfig.Once
+	storageConfigurator             comfig.Once
+	verifierConfigurator            comfig.Once
+	noncesConfigurator              comfig.Once
+	policiesConfigurator            comfig.Once
+	batchConfigurator               comfig.Once
+	validityConfigurator            comfig.Once
+	rpcConfigurator                 comfig.Once
+	metricsConfigurator             comfig.Once
+	selfTestConfigurator            comfig.Once
+	shutdownConfigurator            comfig.Once
+	inetConfigurator                comfig.Once
+	vsockConfigurator               comfig.Once
 
 	getter kv.Getter
 }
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"fmt"
+	"net"
+
+	figure "gitlab.com/distributed_lab/figure/v3"
+	"gitlab.com/distributed_lab/kit/kv"
+)
+
+// Metrics configures /metrics endpoint
+type Metrics struct {
+	// Disabled hides the endpoint
+	Disabled bool
+	// Listener of the dedicated metrics server, /metrics is served by the
+	// inet and vsock listeners with the API if it is nil
+	Listener Listener
+}
+
+func (c *config) GetMetrics() *Metrics {
+	return c.metricsConfigurator.Do(func() any {
+		var cfg struct {
+			Disabled bool   `fig:"disabled"`
+			Address  string `fig:"addr"`
+		}
+
+		raw := kv.MustGetStringMap(c.getter, "metrics")
+		err := figure.
+			Out(&cfg).
+			From(raw).
+			Please()
+		if err != nil {
+			panic(fmt.Errorf("failed to figure out metrics config: %w", err))
+		}
+
+		metrics := &Metrics{Disabled: cfg.Disabled}
+		if metrics.Disabled || cfg.Address == "" {
+			return metrics
+		}
+
+		listener, err := net.Listen("tcp", cfg.Address)
+		if err != nil {
+			panic(fmt.Errorf("failed to listen metrics on %s with error: %w", cfg.Address, err))
+		}
+		metrics.Listener = &inetListener{
+			Listener: listener,
+			Address:  cfg.Address,
+			server:   figureServerConfig("metrics", raw),
+		}
+
+		return metrics
+	}).(*Metrics)
+}
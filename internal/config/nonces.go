@@ -0,0 +1,67 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/nonces"
+	figure "gitlab.com/distributed_lab/figure/v3"
+	"gitlab.com/distributed_lab/kit/kv"
+)
+
+// Nonces issues challenge nonces. If required, every signed
+// attestation document must carry an issued and unused nonce.
+type Nonces struct {
+	*nonces.Store
+	required     bool
+	clientHeader string
+}
+
+func (n *Nonces) Required() bool {
+	return n.required
+}
+
+// ClientHeader is the header set by a trusted proxy with the client
+// address, remote address is used if empty
+func (n *Nonces) ClientHeader() string {
+	return n.clientHeader
+}
+
+func (c *config) GetNonces() *Nonces {
+	return c.noncesConfigurator.Do(func() any {
+		var cfg struct {
+			Size               int           `fig:"size"`
+			TTL                time.Duration `fig:"ttl"`
+			MaxActive          int           `fig:"max_active"`
+			MaxActivePerClient int           `fig:"max_active_per_client"`
+			ClientHeader       string        `fig:"client_header"`
+			PersistenceFile    string        `fig:"persistence_file"`
+			Required           bool          `fig:"required"`
+		}
+
+		err := figure.
+			Out(&cfg).
+			From(kv.MustGetStringMap(c.getter, "nonces")).
+			Please()
+		if err != nil {
+			panic(fmt.Errorf("failed to figure out nonces config: %w", err))
+		}
+
+		store, err := nonces.NewStore(nonces.Options{
+			Size:               cfg.Size,
+			TTL:                cfg.TTL,
+			MaxActive:          cfg.MaxActive,
+			MaxActivePerClient: cfg.MaxActivePerClient,
+			PersistencePath:    cfg.PersistenceFile,
+		})
+		if err != nil {
+			panic(fmt.Errorf("failed to create nonce store: %w", err))
+		}
+
+		return &Nonces{
+			Store:        store,
+			required:     cfg.Required,
+			clientHeader: cfg.ClientHeader,
+		}
+	}).(*Nonces)
+}
@@ -0,0 +1,168 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	figure "gitlab.com/distributed_lab/figure/v3"
+	"gitlab.com/distributed_lab/kit/kv"
+)
+
+// PolicyPCRs are PCRs that can be pinned by a policy profile
+var PolicyPCRs = []int{0, 1, 2, 3, 4, 8}
+
+var (
+	ErrUnknownPolicy = errors.New("unknown policy profile")
+	ErrPolicyMatch   = errors.New("attestation document matches no accepted PCR set")
+)
+
+// PolicyMismatchError is returned when the document matches none of
+// the accepted sets. PCRs are those mismatched in the closest set.
+type PolicyMismatchError struct {
+	Profile string
+	PCRs    []int
+}
+
+func (e *PolicyMismatchError) Error() string {
+	pcrs := make([]string, len(e.PCRs))
+	for i, pcr := range e.PCRs {
+		pcrs[i] = fmt.Sprintf("pcr%d", pcr)
+	}
+
+	return fmt.Sprintf("%s of profile %s, mismatched %s", ErrPolicyMatch, e.Profile, strings.Join(pcrs, ", "))
+}
+
+func (e *PolicyMismatchError) Unwrap() error {
+	return ErrPolicyMatch
+}
+
+// pcrSet maps PCR index to its accepted value, absent PCRs aren't checked
+type pcrSet map[int][]byte
+
+// Policies are named PCR allowlists. Each profile accepts several PCR sets,
+// so an old and a new image can be endorsed during rollout.
+type Policies struct {
+	defaultProfile string
+	profiles       map[string][]pcrSet
+}
+
+// Match checks PCRs against the profile, or the default one if the profile
+// is empty, and returns the matched profile name. If no profiles are
+// configured every document is accepted and the name is empty.
+func (p *Policies) Match(profile string, pcrs map[int][]byte) (string, error) {
+	if profile == "" {
+		profile = p.defaultProfile
+	}
+	if profile == "" && len(p.profiles) == 0 {
+		return "", nil
+	}
+
+	sets, ok := p.profiles[profile]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownPolicy, profile)
+	}
+
+	var closest []int
+	for _, set := range sets {
+		var mismatched []int
+		for index, expected := range set {
+			if !bytes.Equal(pcrs[index], expected) {
+				mismatched = append(mismatched, index)
+			}
+		}
+
+		if len(mismatched) == 0 {
+			return profile, nil
+		}
+		if closest == nil || len(mismatched) < len(closest) {
+			closest = mismatched
+		}
+	}
+
+	sort.Ints(closest)
+	return "", &PolicyMismatchError{Profile: profile, PCRs: closest}
+}
+
+func (p *Policies) Profiles() []string {
+	profiles := make([]string, 0, len(p.profiles))
+	for profile := range p.profiles {
+		profiles = append(profiles, profile)
+	}
+	sort.Strings(profiles)
+
+	return profiles
+}
+
+func (c *config) GetPolicies() *Policies {
+	return c.policiesConfigurator.Do(func() any {
+		var cfg struct {
+			Default  string                         `fig:"default"`
+			Profiles map[string][]map[string]string `fig:"profiles"`
+		}
+
+		err := figure.
+			Out(&cfg).
+			From(kv.MustGetStringMap(c.getter, "policies")).
+			Please()
+		if err != nil {
+			panic(fmt.Errorf("failed to figure out policies config: %w", err))
+		}
+
+		policies := &Policies{
+			defaultProfile: cfg.Default,
+			profiles:       make(map[string][]pcrSet, len(cfg.Profiles)),
+		}
+
+		for name, rawSets := range cfg.Profiles {
+			if len(rawSets) == 0 {
+				panic(fmt.Errorf("policy profile %s has no accepted PCR sets", name))
+			}
+
+			sets := make([]pcrSet, len(rawSets))
+			for i, rawSet := range rawSets {
+				if sets[i], err = parsePCRSet(rawSet); err != nil {
+					panic(fmt.Errorf("invalid policy profile %s set #%d: %w", name, i, err))
+				}
+			}
+			policies.profiles[name] = sets
+		}
+
+		if len(policies.profiles) != 0 && policies.defaultProfile == "" {
+			panic(fmt.Errorf("policies default profile is required, must be one of %v", policies.Profiles()))
+		}
+		if _, ok := policies.profiles[policies.defaultProfile]; policies.defaultProfile != "" && !ok {
+			panic(fmt.Errorf("policies default profile %s is not configured", policies.defaultProfile))
+		}
+
+		return policies
+	}).(*Policies)
+}
+
+func parsePCRSet(rawSet map[string]string) (pcrSet, error) {
+	if len(rawSet) == 0 {
+		return nil, fmt.Errorf("set is empty")
+	}
+
+	set := make(pcrSet, len(rawSet))
+	for key, value := range rawSet {
+		index, err := strconv.Atoi(strings.TrimPrefix(strings.ToLower(key), "pcr"))
+		if err != nil || !slices.Contains(PolicyPCRs, index) {
+			return nil, fmt.Errorf("unsupported PCR %s, must be one of %v", key, PolicyPCRs)
+		}
+
+		pcr, err := hex.DecodeString(strings.TrimPrefix(value, "0x"))
+		if err != nil || len(pcr) != sha512.Size384 {
+			return nil, fmt.Errorf("PCR%d must be hex SHA-384", index)
+		}
+		set[index] = pcr
+	}
+
+	return set, nil
+}
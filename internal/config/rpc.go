@@ -0,0 +1,73 @@
+package config
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/icrypto"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/ethclient"
+	figure "gitlab.com/distributed_lab/figure/v3"
+	"gitlab.com/distributed_lab/kit/kv"
+)
+
+const (
+	DefaultRPCCacheTTL        = 10 * time.Minute
+	DefaultRPCCacheFailureTTL = time.Minute
+	DefaultRPCCacheSize       = 1024
+	DefaultRPCTimeout         = 5 * time.Second
+)
+
+// GetDomainResolver returns resolver of EIP712 domains of contracts on the
+// chains with RPC endpoints, it resolves nothing if none is configured
+func (c *config) GetDomainResolver() *icrypto.DomainResolver {
+	return c.rpcConfigurator.Do(func() any {
+		var cfg struct {
+			Endpoints       map[string]string `fig:"endpoints"`
+			CacheTTL        time.Duration     `fig:"cache_ttl"`
+			CacheFailureTTL time.Duration     `fig:"cache_failure_ttl"`
+			CacheSize       int               `fig:"cache_size"`
+			Timeout         time.Duration     `fig:"timeout"`
+		}
+
+		err := figure.
+			Out(&cfg).
+			From(kv.MustGetStringMap(c.getter, "rpc")).
+			Please()
+		if err != nil {
+			panic(fmt.Errorf("failed to figure out rpc config: %w", err))
+		}
+
+		if cfg.CacheTTL <= 0 {
+			cfg.CacheTTL = DefaultRPCCacheTTL
+		}
+		if cfg.CacheFailureTTL <= 0 {
+			cfg.CacheFailureTTL = DefaultRPCCacheFailureTTL
+		}
+		if cfg.CacheSize <= 0 {
+			cfg.CacheSize = DefaultRPCCacheSize
+		}
+		if cfg.Timeout <= 0 {
+			cfg.Timeout = DefaultRPCTimeout
+		}
+
+		callers := make(map[string]bind.ContractCaller, len(cfg.Endpoints))
+		for rawChainID, endpoint := range cfg.Endpoints {
+			chainID, ok := new(big.Int).SetString(rawChainID, 0)
+			if !ok || chainID.Sign() <= 0 {
+				panic(fmt.Errorf("invalid rpc endpoint chain ID %s", rawChainID))
+			}
+
+			// HTTP clients connect lazily, so unavailable chains don't
+			// prevent the start
+			client, err := ethclient.Dial(endpoint)
+			if err != nil {
+				panic(fmt.Errorf("failed to dial rpc endpoint of chain %s: %w", chainID, err))
+			}
+			callers[chainID.String()] = client
+		}
+
+		return icrypto.NewDomainResolver(callers, cfg.CacheTTL, cfg.CacheFailureTTL, cfg.CacheSize, cfg.Timeout)
+	}).(*icrypto.DomainResolver)
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/icrypto"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/nitro"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	figure "gitlab.com/distributed_lab/figure/v3"
+	"gitlab.com/distributed_lab/kit/kv"
+)
+
+const DefaultSelfTestInterval = 5 * time.Minute
+
+// selfTestDomain and selfTestMessage are the known vector signed by the
+// self-test, they are never returned to clients
+var (
+	selfTestDomain = icrypto.GetDomain(apitypes.TypedDataDomain{
+		Name:    "aws-nitro-enclaves-av self-test",
+		Version: "1",
+	})
+	selfTestMessage = &icrypto.Message{
+		TypedDataMessage: apitypes.TypedDataMessage{"probe": "self-test"},
+		DataTypes:        []apitypes.Type{{Name: "probe", Type: "string"}},
+		PrimaryType:      "SelfTest",
+	}
+)
+
+// SelfTest configures periodic self-test of the signer
+type SelfTest struct {
+	// Interval between self-tests
+	Interval time.Duration
+}
+
+// SelfTest signs the known vector with the current generation, verifies
+// the signature against its attested address and checks the KMS key still
+// decrypts the stored private key
+func (s *Signer) SelfTest() error {
+	generation := s.Current()
+	if generation == nil {
+		return ErrSignerNotBootstrapped
+	}
+
+	signature, _, err := selfTestDomain.SignTypedDataWithSigner(selfTestMessage, generation)
+	if err != nil {
+		return fmt.Errorf("failed to sign self-test vector: %w", err)
+	}
+	if err = selfTestDomain.VerifyTypedData(selfTestMessage, signature, generation.Address); err != nil {
+		return fmt.Errorf("self-test signature of generation %s: %w", generation.ID, err)
+	}
+
+	if err = nitro.CheckGenerationKey(s.kmsBackend, s.provider, s.keyPolicy, generation); err != nil {
+		return fmt.Errorf("KMS key of generation %s: %w", generation.ID, err)
+	}
+
+	return nil
+}
+
+func (c *config) GetSelfTest() *SelfTest {
+	return c.selfTestConfigurator.Do(func() any {
+		var cfg struct {
+			Interval time.Duration `fig:"interval"`
+		}
+
+		err := figure.
+			Out(&cfg).
+			From(kv.MustGetStringMap(c.getter, "self_test")).
+			Please()
+		if err != nil {
+			panic(fmt.Errorf("failed to figure out self_test config: %w", err))
+		}
+
+		selfTest := &SelfTest{Interval: cfg.Interval}
+		if selfTest.Interval <= 0 {
+			selfTest.Interval = DefaultSelfTestInterval
+		}
+
+		return selfTest
+	}).(*SelfTest)
+}
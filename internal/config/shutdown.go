@@ -0,0 +1,41 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	figure "gitlab.com/distributed_lab/figure/v3"
+	"gitlab.com/distributed_lab/kit/kv"
+)
+
+const DefaultShutdownTimeout = 20 * time.Second
+
+// Shutdown configures graceful shutdown of the service
+type Shutdown struct {
+	// Timeout of draining in-flight requests, connections still active
+	// after it are closed
+	Timeout time.Duration
+}
+
+func (c *config) GetShutdown() *Shutdown {
+	return c.shutdownConfigurator.Do(func() any {
+		var cfg struct {
+			Timeout time.Duration `fig:"timeout"`
+		}
+
+		err := figure.
+			Out(&cfg).
+			From(kv.MustGetStringMap(c.getter, "shutdown")).
+			Please()
+		if err != nil {
+			panic(fmt.Errorf("failed to figure out shutdown config: %w", err))
+		}
+
+		shutdown := &Shutdown{Timeout: cfg.Timeout}
+		if shutdown.Timeout <= 0 {
+			shutdown.Timeout = DefaultShutdownTimeout
+		}
+
+		return shutdown
+	}).(*Shutdown)
+}
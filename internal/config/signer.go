@@ -2,29 +2,284 @@ package config
 
 import (
 	"context"
-	"crypto/ecdsa"
+	"errors"
 	"fmt"
-	"os"
+	"sync"
+	"time"
 
-	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/nitro"
-	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/storage"
 	figure "gitlab.com/distributed_lab/figure/v3"
 	"gitlab.com/distributed_lab/kit/kv"
+	"gitlab.com/distributed_lab/logan/v3"
 )
 
+// SignerPCRs are PCRs of the running enclave exposed with the signer identity
+var SignerPCRs = []int{0, 1, 2, 3, 4, 8}
+
+const (
+	DefaultSignerOverlap        = 24 * time.Hour
+	DefaultSignerReloadInterval = time.Minute
+)
+
+var ErrSignerNotBootstrapped = errors.New("signer is not bootstrapped")
+
+// Signer signs with the latest key generation. Previous generations stay
+// loaded for the overlap period after being superseded, so signatures
+// made with them can still be verified, but they don't sign anymore.
 type Signer struct {
-	pk *ecdsa.PrivateKey
+	kmsBackend nitro.KMSBackend
+	provider   nitro.AttestationProvider
+	storage    storage.Storage
+	keyPolicy  nitro.KeyPolicy
+	onMismatch nitro.OnMismatch
+	pcrs       map[int][]byte
+
+	overlap          time.Duration
+	rotationInterval *time.Duration
+	reloadInterval   time.Duration
+
+	// Serializes bootstrap attempts
+	bootstrapMu sync.Mutex
+
+	mu       sync.RWMutex
+	current  *nitro.KeyGeneration
+	previous []SupersededGeneration
 }
 
+// SupersededGeneration is a previous key generation within the overlap period
+type SupersededGeneration struct {
+	*nitro.KeyGeneration
+	// Time the next generation was created at
+	SupersededAt time.Time
+	// Time the generation is unloaded at
+	ExpiresAt time.Time
+}
+
+// Current returns the generation new signatures are made with, nil until
+// the signer is bootstrapped
+func (s *Signer) Current() *nitro.KeyGeneration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.current
+}
+
+// Previous returns superseded generations within the overlap period, newest first
+func (s *Signer) Previous() []SupersededGeneration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	previous := make([]SupersededGeneration, 0, len(s.previous))
+	for _, generation := range s.previous {
+		if time.Now().Before(generation.ExpiresAt) {
+			previous = append(previous, generation)
+		}
+	}
+
+	return previous
+}
+
+// Sign signs with the current generation. Use Current to know the generation.
 func (s *Signer) Sign(data []byte) ([]byte, error) {
-	return crypto.Sign(data, s.pk)
+	current := s.Current()
+	if current == nil {
+		return nil, ErrSignerNotBootstrapped
+	}
+
+	return current.Sign(data)
+}
+
+// PCRs returns SignerPCRs of the running enclave
+func (s *Signer) PCRs() map[int][]byte {
+	return s.pcrs
+}
+
+// Rotate creates a new key generation and starts signing with it
+func (s *Signer) Rotate() (*nitro.KeyGeneration, error) {
+	generation, err := nitro.CreateGeneration(s.kmsBackend, s.provider, s.keyPolicy, s.onMismatch, s.storage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create key generation: %w", err)
+	}
+
+	if err = s.Reload(); err != nil {
+		return nil, err
+	}
+
+	return generation, nil
+}
+
+// Reload picks up generations created by other processes, e.g. rotate-key
+// command, and unloads generations whose overlap period is over
+func (s *Signer) Reload() error {
+	generations, err := nitro.ListGenerations(s.storage)
+	if err != nil {
+		return fmt.Errorf("failed to list key generations: %w", err)
+	}
+	if len(generations) == 0 {
+		return nitro.ErrNoGenerations
+	}
+
+	s.mu.RLock()
+	current, loaded := s.current, make(map[string]*nitro.KeyGeneration, len(s.previous)+1)
+	if current != nil {
+		loaded[current.ID] = current
+	}
+	for _, generation := range s.previous {
+		loaded[generation.ID] = generation.KeyGeneration
+	}
+	s.mu.RUnlock()
+
+	latest := generations[len(generations)-1]
+	if current == nil || current.ID != latest.ID {
+		if current, err = nitro.LoadGeneration(s.kmsBackend, s.provider, s.keyPolicy, s.onMismatch, latest); err != nil {
+			return fmt.Errorf("failed to load key generation %s: %w", latest.ID, err)
+		}
+	}
+
+	var (
+		now      = time.Now()
+		previous []SupersededGeneration
+	)
+	for i := len(generations) - 2; i >= 0; i-- {
+		supersededAt := generations[i+1].CreatedAt
+		expiresAt := supersededAt.Add(s.overlap)
+		if !now.Before(expiresAt) {
+			break
+		}
+
+		generation, ok := loaded[generations[i].ID]
+		if !ok {
+			if generation, err = nitro.LoadGenerationPublic(s.provider, s.keyPolicy, generations[i]); err != nil {
+				return fmt.Errorf("failed to load key generation %s: %w", generations[i].ID, err)
+			}
+		}
+
+		// Superseded generations must never sign again
+		public := *generation
+		public.PrivateKey = nil
+
+		previous = append(previous, SupersededGeneration{
+			KeyGeneration: &public,
+			SupersededAt:  supersededAt,
+			ExpiresAt:     expiresAt,
+		})
+	}
+
+	s.mu.Lock()
+	s.current, s.previous = current, previous
+	s.mu.Unlock()
+
+	return nil
 }
 
+// Bootstrap describes the enclave PCRs, creates the first key generation
+// if there is none and loads generations. It is no-op once succeeded, so
+// failed bootstrap may be retried.
+func (s *Signer) Bootstrap() error {
+	s.bootstrapMu.Lock()
+	defer s.bootstrapMu.Unlock()
+
+	if s.Current() != nil {
+		return nil
+	}
+
+	for _, index := range SignerPCRs {
+		value, err := s.provider.DescribePCR(index)
+		if err != nil {
+			return fmt.Errorf("failed to describe PCR%d: %w", index, err)
+		}
+		s.pcrs[index] = value
+	}
+
+	generations, err := nitro.ListGenerations(s.storage)
+	if err != nil {
+		return fmt.Errorf("failed to list key generations: %w", err)
+	}
+	if len(generations) == 0 {
+		if _, err = nitro.CreateGeneration(s.kmsBackend, s.provider, s.keyPolicy, s.onMismatch, s.storage); err != nil {
+			return fmt.Errorf("failed to create first key generation: %w", err)
+		}
+	}
+
+	if err = s.Reload(); err != nil {
+		return fmt.Errorf("failed to load key generations: %w", err)
+	}
+
+	return nil
+}
+
+// Run periodically reloads generations and rotates the key if the current
+// generation is older than the rotation interval
+func (s *Signer) Run(ctx context.Context, log *logan.Entry) {
+	ticker := time.NewTicker(s.reloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		loaded := s.Current()
+		if err := s.Reload(); err != nil {
+			log.WithError(err).Error("Failed to reload signer key generations")
+			continue
+		}
+
+		current := s.Current()
+		if current != loaded {
+			LogRegenerated(log, current)
+		}
+
+		if s.rotationInterval == nil || time.Since(current.CreatedAt) < *s.rotationInterval {
+			continue
+		}
+
+		generation, err := s.Rotate()
+		if err != nil {
+			log.WithError(err).Error("Failed to rotate signer key")
+			continue
+		}
+		log.WithFields(logan.F{
+			"generation": generation.ID,
+			"address":    generation.Address.Hex(),
+		}).Info("Signer key rotated")
+	}
+}
+
+// LogRegenerated warns about documents of the generation regenerated on load
+func LogRegenerated(log *logan.Entry, generation *nitro.KeyGeneration) {
+	if len(generation.Regenerated) == 0 {
+		return
+	}
+
+	log.WithFields(logan.F{
+		"generation": generation.ID,
+		"documents":  generation.Regenerated,
+	}).Warn("Mismatched signer attestation documents regenerated")
+}
+
+// GetSigner returns the bootstrapped signer, it panics if bootstrap fails
 func (c *config) GetSigner() *Signer {
+	signer := c.GetPendingSigner()
+	if err := signer.Bootstrap(); err != nil {
+		panic(err)
+	}
+
+	return signer
+}
+
+// GetPendingSigner returns the signer without bootstrapping it, so it
+// signs nothing until Bootstrap succeeds
+func (c *config) GetPendingSigner() *Signer {
 	return c.signerConfigurator.Do(func() any {
 		var cfg struct {
-			AttestationsDirectory string `fig:"attestations_directory,required"`
+			Overlap          *time.Duration `fig:"overlap"`
+			RotationInterval *time.Duration `fig:"rotation_interval"`
+			ReloadInterval   time.Duration  `fig:"reload_interval"`
+			OnMismatch       string         `fig:"on_mismatch"`
 		}
 
 		err := figure.
@@ -35,36 +290,42 @@ func (c *config) GetSigner() *Signer {
 			panic(fmt.Errorf("failed to figure out signer config: %w", err))
 		}
 
-		if err = os.MkdirAll(cfg.AttestationsDirectory, os.ModePerm); err != nil {
-			panic(fmt.Errorf("failed to create attestation target directory %s with error: %w", cfg.AttestationsDirectory, err))
+		if cfg.ReloadInterval <= 0 {
+			cfg.ReloadInterval = DefaultSignerReloadInterval
 		}
-
-		awsConfig, err := awsconfig.LoadDefaultConfig(context.Background())
-		if err != nil {
-			panic(fmt.Errorf("failed to load AWS config: %w", err))
+		overlap := DefaultSignerOverlap
+		if cfg.Overlap != nil {
+			overlap = *cfg.Overlap
 		}
-
-		kmsKeyID, err := nitro.GetAttestedKMSKeyID(awsConfig, cfg.AttestationsDirectory)
-		if err != nil {
-			panic(fmt.Errorf("failed to get attested KMS Key ID: %w", err))
+		if cfg.RotationInterval != nil && *cfg.RotationInterval <= 0 {
+			panic(fmt.Errorf("signer rotation_interval must be positive, got %s", *cfg.RotationInterval))
 		}
-
-		privateKey, err := nitro.GetAttestedPrivateKey(awsConfig, kmsKeyID, cfg.AttestationsDirectory)
-		if err != nil {
-			panic(fmt.Errorf("failed to get attested private key: %w", err))
+		// Otherwise the superseded generation may be unloaded before
+		// other processes pick up the next one
+		if overlap < cfg.ReloadInterval {
+			panic(fmt.Errorf("signer overlap %s must not be shorter than reload_interval %s", overlap, cfg.ReloadInterval))
 		}
 
-		publicKey, err := nitro.GetAttestedPublicKey(privateKey, cfg.AttestationsDirectory)
-		if err != nil {
-			panic(fmt.Errorf("failed to get attested public key: %w", err))
+		signer := &Signer{
+			kmsBackend:       c.GetKMSBackend(),
+			provider:         c.GetAttestationProvider(),
+			storage:          c.GetStorage(),
+			keyPolicy:        c.GetKeyPolicy(),
+			onMismatch:       nitro.OnMismatch(cfg.OnMismatch),
+			overlap:          overlap,
+			rotationInterval: cfg.RotationInterval,
+			reloadInterval:   cfg.ReloadInterval,
+			pcrs:             make(map[int][]byte, len(SignerPCRs)),
 		}
 
-		if _, err = nitro.GetAttestedAddress(publicKey, cfg.AttestationsDirectory); err != nil {
-			panic(fmt.Errorf("failed to get attested address: %w", err))
+		switch signer.onMismatch {
+		case "":
+			signer.onMismatch = nitro.OnMismatchFail
+		case nitro.OnMismatchFail, nitro.OnMismatchRegenerate:
+		default:
+			panic(fmt.Errorf("unknown signer on_mismatch %q, must be one of [%s, %s]", cfg.OnMismatch, nitro.OnMismatchFail, nitro.OnMismatchRegenerate))
 		}
 
-		return &Signer{
-			pk: privateKey,
-		}
+		return signer
 	}).(*Signer)
 }
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/nitro"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/storage"
+	figure "gitlab.com/distributed_lab/figure/v3"
+	"gitlab.com/distributed_lab/kit/kv"
+)
+
+const (
+	StorageLocal = "local"
+	StorageS3    = "s3"
+)
+
+// GetStorage returns storage of the signer attestation documents
+func (c *config) GetStorage() storage.Storage {
+	return c.storageConfigurator.Do(func() any {
+		var cfg struct {
+			Storage               string `fig:"storage"`
+			AttestationsDirectory string `fig:"attestations_directory"`
+		}
+
+		err := figure.
+			Out(&cfg).
+			From(kv.MustGetStringMap(c.getter, "signer")).
+			Please()
+		if err != nil {
+			panic(fmt.Errorf("failed to figure out signer config: %w", err))
+		}
+
+		switch cfg.Storage {
+		case "", StorageLocal:
+			if cfg.AttestationsDirectory == "" {
+				panic(fmt.Errorf("signer attestations_directory is required for %s storage", StorageLocal))
+			}
+
+			local, err := storage.NewLocal(cfg.AttestationsDirectory, nitro.PrivateFiles...)
+			if err != nil {
+				panic(fmt.Errorf("failed to create local storage: %w", err))
+			}
+			return local
+		case StorageS3:
+			return c.newS3Storage()
+		default:
+			panic(fmt.Errorf("unknown signer storage %q, must be one of [%s, %s]", cfg.Storage, StorageLocal, StorageS3))
+		}
+	}).(storage.Storage)
+}
+
+func (c *config) newS3Storage() *storage.S3 {
+	var cfg struct {
+		Bucket    string `fig:"bucket,required"`
+		Prefix    string `fig:"prefix"`
+		Region    string `fig:"region"`
+		Endpoint  string `fig:"endpoint"`
+		PathStyle bool   `fig:"path_style"`
+	}
+
+	err := figure.
+		Out(&cfg).
+		From(kv.MustGetStringMap(c.getter, "s3_storage")).
+		Please()
+	if err != nil {
+		panic(fmt.Errorf("failed to figure out s3 storage config: %w", err))
+	}
+
+	var opts []func(*awsconfig.LoadOptions) error
+	if cfg.Region != "" {
+		opts = append(opts, awsconfig.WithRegion(cfg.Region))
+	}
+
+	awsConfig, err := awsconfig.LoadDefaultConfig(context.Background(), opts...)
+	if err != nil {
+		panic(fmt.Errorf("failed to load AWS config: %w", err))
+	}
+
+	client := s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+		}
+		o.UsePathStyle = cfg.PathStyle
+		// Not every S3-compatible store supports trailing checksums
+		o.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+		o.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+	})
+
+	return storage.NewS3(client, cfg.Bucket, cfg.Prefix)
+}
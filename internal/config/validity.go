@@ -0,0 +1,71 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/utils"
+	figure "gitlab.com/distributed_lab/figure/v3"
+	"gitlab.com/distributed_lab/kit/kv"
+)
+
+const DefaultValidityMaxTTL = 24 * time.Hour
+
+// Validity bounds endorsements in time, expiring ones are signed with
+// issued_at and deadline fields
+type Validity struct {
+	// TTL of endorsements without requested one, they don't expire if zero
+	TTL time.Duration
+	// Maximum TTL, requested TTL is capped by it
+	MaxTTL time.Duration
+}
+
+// Window returns validity of the endorsement issued at now with the TTL
+// requested by client if any. Returns nil if the endorsement doesn't expire.
+func (v *Validity) Window(now time.Time, requested *time.Duration) *utils.Validity {
+	ttl := v.TTL
+	if requested != nil {
+		ttl = *requested
+	}
+	if ttl <= 0 {
+		return nil
+	}
+	ttl = min(ttl, v.MaxTTL)
+
+	// Fields are signed as unix seconds
+	issuedAt := now.Truncate(time.Second)
+	return &utils.Validity{
+		IssuedAt: issuedAt,
+		Deadline: issuedAt.Add(ttl),
+	}
+}
+
+func (c *config) GetValidity() *Validity {
+	return c.validityConfigurator.Do(func() any {
+		var cfg struct {
+			TTL    time.Duration `fig:"ttl"`
+			MaxTTL time.Duration `fig:"max_ttl"`
+		}
+
+		err := figure.
+			Out(&cfg).
+			From(kv.MustGetStringMap(c.getter, "validity")).
+			Please()
+		if err != nil {
+			panic(fmt.Errorf("failed to figure out validity config: %w", err))
+		}
+
+		validity := &Validity{
+			TTL:    cfg.TTL,
+			MaxTTL: cfg.MaxTTL,
+		}
+		if validity.MaxTTL <= 0 {
+			validity.MaxTTL = DefaultValidityMaxTTL
+		}
+		if validity.TTL > validity.MaxTTL {
+			panic(fmt.Errorf("validity ttl %s exceeds max_ttl %s", validity.TTL, validity.MaxTTL))
+		}
+
+		return validity
+	}).(*Validity)
+}
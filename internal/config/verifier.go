@@ -0,0 +1,171 @@
+package config
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/nitro"
+	"github.com/distributed-lab/enclave-extras/attestation"
+	figure "gitlab.com/distributed_lab/figure/v3"
+	"gitlab.com/distributed_lab/kit/kv"
+)
+
+const (
+	// Certificate chain is checked at the document timestamp
+	ChainTimeDocument = "document"
+	// Certificate chain is checked at the verification time
+	ChainTimeNow = "now"
+)
+
+var (
+	ErrStaleAttestation  = errors.New("attestation document is too old")
+	ErrFutureAttestation = errors.New("attestation document is from the future")
+)
+
+// Verifier checks attestation documents against pinned root certificates
+// and the time policy
+type Verifier struct {
+	rootFingerprints [][]byte
+
+	maxAge                *time.Duration
+	maxFutureSkew         *time.Duration
+	chainTime             string
+	allowVerificationTime bool
+}
+
+// Verify checks the document at the current time.
+// Returns SHA-256 fingerprint of the root the document is chained to.
+func (v *Verifier) Verify(doc *attestation.NSMAttestationDoc) ([]byte, error) {
+	return v.VerifyAt(doc, time.Now())
+}
+
+// VerifyAt checks certificate chain and signature of the document, and
+// applies the time policy as if the document is verified at the given time.
+// Returns SHA-256 fingerprint of the root the document is chained to.
+func (v *Verifier) VerifyAt(doc *attestation.NSMAttestationDoc, at time.Time) ([]byte, error) {
+	rootFingerprint, err := nitro.VerifyAttestationDoc(doc, v.rootFingerprints...)
+	if err != nil {
+		return nil, err
+	}
+
+	if age := at.Sub(doc.Timestamp); v.maxAge != nil && age > *v.maxAge {
+		return nil, fmt.Errorf("%w: issued %s before verification, max age is %s", ErrStaleAttestation, age, *v.maxAge)
+	}
+
+	if skew := doc.Timestamp.Sub(at); v.maxFutureSkew != nil && skew > *v.maxFutureSkew {
+		return nil, fmt.Errorf("%w: issued %s after verification, max skew is %s", ErrFutureAttestation, skew, *v.maxFutureSkew)
+	}
+
+	chainTime := doc.Timestamp
+	if v.chainTime == ChainTimeNow {
+		chainTime = at
+	}
+	if err = nitro.VerifyCertificatesAt(doc, chainTime); err != nil {
+		return nil, err
+	}
+
+	return rootFingerprint, nil
+}
+
+func (v *Verifier) RootFingerprints() [][]byte {
+	return v.rootFingerprints
+}
+
+// AllowVerificationTime reports whether clients may set explicit verification time
+func (v *Verifier) AllowVerificationTime() bool {
+	return v.allowVerificationTime
+}
+
+func (c *config) GetVerifier() *Verifier {
+	return c.verifierConfigurator.Do(func() any {
+		var cfg struct {
+			Roots                 []string       `fig:"roots"`
+			RootFingerprints      []string       `fig:"root_fingerprints"`
+			MaxAge                *time.Duration `fig:"max_age"`
+			MaxFutureSkew         *time.Duration `fig:"max_future_skew"`
+			ChainTime             string         `fig:"chain_time"`
+			AllowVerificationTime bool           `fig:"allow_verification_time"`
+		}
+
+		err := figure.
+			Out(&cfg).
+			From(kv.MustGetStringMap(c.getter, "verifier")).
+			Please()
+		if err != nil {
+			panic(fmt.Errorf("failed to figure out verifier config: %w", err))
+		}
+
+		rootFingerprints := make([][]byte, 0, len(cfg.Roots)+len(cfg.RootFingerprints))
+		for _, rootPath := range cfg.Roots {
+			fingerprints, err := readRootFingerprints(rootPath)
+			if err != nil {
+				panic(fmt.Errorf("failed to read verifier root %s: %w", rootPath, err))
+			}
+			rootFingerprints = append(rootFingerprints, fingerprints...)
+		}
+
+		for _, rawFingerprint := range cfg.RootFingerprints {
+			fingerprint, err := hex.DecodeString(strings.ReplaceAll(strings.TrimPrefix(rawFingerprint, "0x"), ":", ""))
+			if err != nil || len(fingerprint) != sha256.Size {
+				panic(fmt.Errorf("invalid verifier root fingerprint %s, must be hex SHA-256", rawFingerprint))
+			}
+			rootFingerprints = append(rootFingerprints, fingerprint)
+		}
+
+		if len(rootFingerprints) == 0 {
+			rootFingerprints = append(rootFingerprints, attestation.AWSNitroEnclavesRootCertFingerprint)
+		}
+
+		switch cfg.ChainTime {
+		case "":
+			cfg.ChainTime = ChainTimeDocument
+		case ChainTimeDocument, ChainTimeNow:
+		default:
+			panic(fmt.Errorf("unknown verifier chain time %q, must be one of [%s, %s]", cfg.ChainTime, ChainTimeDocument, ChainTimeNow))
+		}
+
+		return &Verifier{
+			rootFingerprints:      rootFingerprints,
+			maxAge:                cfg.MaxAge,
+			maxFutureSkew:         cfg.MaxFutureSkew,
+			chainTime:             cfg.ChainTime,
+			allowVerificationTime: cfg.AllowVerificationTime,
+		}
+	}).(*Verifier)
+}
+
+// readRootFingerprints returns fingerprints of every certificate in the PEM file
+func readRootFingerprints(rootPath string) ([][]byte, error) {
+	rootPEM, err := os.ReadFile(rootPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var fingerprints [][]byte
+	for block, rest := pem.Decode(rootPEM); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate: %w", err)
+		}
+
+		fingerprint := sha256.Sum256(cert.Raw)
+		fingerprints = append(fingerprints, fingerprint[:])
+	}
+
+	if len(fingerprints) == 0 {
+		return nil, fmt.Errorf("no certificates found")
+	}
+
+	return fingerprints, nil
+}
@@ -15,25 +15,34 @@ type vsockListener struct {
 	ContextID uint32 `fig:"context_id"`
 	Port      uint32 `fig:"port,required"`
 	Disabled  bool   `fig:"disabled"`
+
+	server ServerConfig
 }
 
 func (l *vsockListener) IsDisabled() bool {
 	return l.Disabled
 }
 
+func (l *vsockListener) Server() ServerConfig {
+	return l.server
+}
+
 func (c *config) GetVsockListener() Listener {
 	return c.vsockConfigurator.Do(func() any {
 		var vsockListener vsockListener
 
+		raw := kv.MustGetStringMap(c.getter, "vsock_listener")
 		err := figure.
 			Out(&vsockListener).
-			From(kv.MustGetStringMap(c.getter, "vsock_listener")).
+			From(raw).
 			Please()
 
 		if err != nil {
 			panic(fmt.Errorf("failed to figure out: %w", err))
 		}
 
+		vsockListener.server = figureServerConfig("vsock_listener", raw)
+
 		if vsockListener.IsDisabled() {
 			return &vsockListener
 		}
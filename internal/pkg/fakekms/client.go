@@ -0,0 +1,217 @@
+package fakekms
+
+import (
+	"context"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var (
+	oidPublicKeyECDSA = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidNamedCurveS256 = asn1.ObjectIdentifier{1, 3, 132, 0, 10}
+)
+
+// Client is nitro.KMSClient of the fake KMS. Like AWS KMS, only requests
+// returning plaintext carry the recipient attestation document, others are
+// authorized without kms:RecipientAttestation:* condition keys. optFns are
+// accepted for API compatibility only.
+type Client struct {
+	backend        *Backend
+	attestationDoc []byte
+}
+
+func (c *Client) CreateKey(_ context.Context, params *kms.CreateKeyInput, _ ...func(*kms.Options)) (*kms.CreateKeyOutput, error) {
+	if params == nil {
+		params = &kms.CreateKeyInput{}
+	}
+
+	tags := make(map[string]string, len(params.Tags))
+	for _, tag := range params.Tags {
+		tags[aws.ToString(tag.TagKey)] = aws.ToString(tag.TagValue)
+	}
+
+	key, err := c.backend.createKey(aws.ToString(params.Description), aws.ToString(params.Policy), params.BypassPolicyLockoutSafetyCheck, tags)
+	if err != nil {
+		return nil, err
+	}
+
+	return &kms.CreateKeyOutput{
+		KeyMetadata: &kmstypes.KeyMetadata{
+			KeyId:        aws.String(key.KeyID),
+			Arn:          aws.String(key.Arn),
+			Description:  aws.String(key.Description),
+			Enabled:      key.Enabled,
+			CreationDate: aws.Time(key.CreationDate),
+			KeyState:     kmstypes.KeyStateEnabled,
+			KeyUsage:     kmstypes.KeyUsageTypeEncryptDecrypt,
+			KeySpec:      kmstypes.KeySpecSymmetricDefault,
+		},
+	}, nil
+}
+
+// GenerateDataKeyPair supports only ECC_SECG_P256K1 key pairs
+func (c *Client) GenerateDataKeyPair(_ context.Context, params *kms.GenerateDataKeyPairInput, _ ...func(*kms.Options)) (*kms.GenerateDataKeyPairOutput, error) {
+	if params == nil {
+		return nil, fmt.Errorf("fake kms client: invalid params")
+	}
+	if params.KeyPairSpec != kmstypes.DataKeyPairSpecEccSecgP256k1 {
+		return nil, &kmstypes.UnsupportedOperationException{Message: aws.String(fmt.Sprintf("key pair spec %s is not supported", params.KeyPairSpec))}
+	}
+
+	key, err := c.backend.getKey(aws.ToString(params.KeyId))
+	if err != nil {
+		return nil, err
+	}
+
+	if err = c.backend.authorize(key, "kms:GenerateDataKeyPair", c.attestationDoc, nil); err != nil {
+		return nil, err
+	}
+
+	privateKey, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate secp256k1 key: %w", err)
+	}
+
+	privateKeyPlaintext, err := marshalPKCS8S256PrivateKey(crypto.FromECDSA(privateKey), crypto.FromECDSAPub(&privateKey.PublicKey))
+	if err != nil {
+		return nil, err
+	}
+
+	privateKeyCiphertextBlob, err := encrypt(key, privateKeyPlaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	return &kms.GenerateDataKeyPairOutput{
+		KeyId:                    aws.String(key.Arn),
+		KeyPairSpec:              params.KeyPairSpec,
+		PrivateKeyCiphertextBlob: privateKeyCiphertextBlob,
+		PrivateKeyPlaintext:      privateKeyPlaintext,
+	}, nil
+}
+
+func (c *Client) Decrypt(_ context.Context, params *kms.DecryptInput, _ ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	if params == nil {
+		return nil, fmt.Errorf("fake kms client: invalid params")
+	}
+
+	keyID := aws.ToString(params.KeyId)
+	if keyID == "" {
+		var err error
+		if keyID, err = ciphertextKeyID(params.CiphertextBlob); err != nil {
+			return nil, err
+		}
+	}
+
+	key, err := c.backend.getKey(keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = c.backend.authorize(key, "kms:Decrypt", c.attestationDoc, nil); err != nil {
+		return nil, err
+	}
+
+	plaintext, err := decrypt(key, params.CiphertextBlob)
+	if err != nil {
+		return nil, err
+	}
+
+	return &kms.DecryptOutput{
+		KeyId:               aws.String(key.Arn),
+		Plaintext:           plaintext,
+		EncryptionAlgorithm: kmstypes.EncryptionAlgorithmSpecSymmetricDefault,
+	}, nil
+}
+
+func (c *Client) CreateAlias(_ context.Context, params *kms.CreateAliasInput, _ ...func(*kms.Options)) (*kms.CreateAliasOutput, error) {
+	if params == nil {
+		return nil, fmt.Errorf("fake kms client: invalid params")
+	}
+
+	if err := c.backend.createAlias(aws.ToString(params.AliasName), aws.ToString(params.TargetKeyId)); err != nil {
+		return nil, err
+	}
+
+	return &kms.CreateAliasOutput{}, nil
+}
+
+// GetKeyPolicy supports only the default policy name. The request has no
+// recipient, so the attestation document isn't evaluated.
+func (c *Client) GetKeyPolicy(_ context.Context, params *kms.GetKeyPolicyInput, _ ...func(*kms.Options)) (*kms.GetKeyPolicyOutput, error) {
+	if params == nil {
+		return nil, fmt.Errorf("fake kms client: invalid params")
+	}
+
+	key, err := c.backend.getKey(aws.ToString(params.KeyId))
+	if err != nil {
+		return nil, err
+	}
+
+	if err = c.backend.authorize(key, "kms:GetKeyPolicy", nil, nil); err != nil {
+		return nil, err
+	}
+
+	return &kms.GetKeyPolicyOutput{
+		Policy:     aws.String(key.Policy),
+		PolicyName: aws.String(defaultPolicyName),
+	}, nil
+}
+
+func (c *Client) PutKeyPolicy(_ context.Context, params *kms.PutKeyPolicyInput, _ ...func(*kms.Options)) (*kms.PutKeyPolicyOutput, error) {
+	if params == nil {
+		return nil, fmt.Errorf("fake kms client: invalid params")
+	}
+
+	if err := c.backend.putKeyPolicy(aws.ToString(params.KeyId), aws.ToString(params.Policy), params.BypassPolicyLockoutSafetyCheck); err != nil {
+		return nil, err
+	}
+
+	return &kms.PutKeyPolicyOutput{}, nil
+}
+
+// marshalPKCS8S256PrivateKey encodes secp256k1 key the same way as AWS KMS,
+// x509.MarshalPKCS8PrivateKey doesn't support the curve
+func marshalPKCS8S256PrivateKey(privateKey []byte, publicKey []byte) ([]byte, error) {
+	ecPrivateKey, err := asn1.Marshal(struct {
+		Version       int
+		PrivateKey    []byte
+		NamedCurveOID asn1.ObjectIdentifier `asn1:"optional,explicit,tag:0"`
+		PublicKey     asn1.BitString        `asn1:"optional,explicit,tag:1"`
+	}{
+		Version:    1,
+		PrivateKey: privateKey,
+		PublicKey:  asn1.BitString{Bytes: publicKey, BitLength: 8 * len(publicKey)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal EC private key: %w", err)
+	}
+
+	curveOID, err := asn1.Marshal(oidNamedCurveS256)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal curve OID: %w", err)
+	}
+
+	pkcs8, err := asn1.Marshal(struct {
+		Version    int
+		Algo       pkix.AlgorithmIdentifier
+		PrivateKey []byte
+	}{
+		Algo: pkix.AlgorithmIdentifier{
+			Algorithm:  oidPublicKeyECDSA,
+			Parameters: asn1.RawValue{FullBytes: curveOID},
+		},
+		PrivateKey: ecPrivateKey,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal PKCS8 private key: %w", err)
+	}
+
+	return pkcs8, nil
+}
@@ -0,0 +1,450 @@
+// Package fakekms is in-process KMS stand-in for tests and local development.
+//
+// Keys are stored on disk and every call is checked against the key policy,
+// including kms:RecipientAttestation:* conditions evaluated against the
+// recipient attestation document, like AWS KMS does for Nitro Enclaves.
+package fakekms
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/nitro"
+	"github.com/distributed-lab/enclave-extras/attestation"
+)
+
+const (
+	DefaultPrincipalArn = "arn:aws:iam::000000000000:role/enclave"
+	DefaultRegion       = "us-east-1"
+
+	keyFileExtension = ".json"
+	// The only key policy name KMS supports
+	defaultPolicyName = "default"
+)
+
+var ErrAccessDenied = errors.New("access denied")
+
+// Backend is nitro.KMSBackend that keeps keys in a local directory.
+type Backend struct {
+	directory        string
+	principalArn     string
+	rootFingerprints [][]byte
+
+	// Shared with the backends of other principals, see WithPrincipal
+	mu *sync.Mutex
+}
+
+type storedKey struct {
+	KeyID        string            `json:"key_id"`
+	Arn          string            `json:"arn"`
+	Description  string            `json:"description"`
+	Policy       string            `json:"policy"`
+	Material     []byte            `json:"material"`
+	Enabled      bool              `json:"enabled"`
+	CreationDate time.Time         `json:"creation_date"`
+	Tags         map[string]string `json:"tags,omitempty"`
+	Aliases      []string          `json:"aliases,omitempty"`
+}
+
+// New creates fake KMS storing keys in directory. Every request is made
+// on behalf of principalArn. Recipient attestation documents are trusted
+// only if chained to one of rootFingerprints.
+func New(directory string, principalArn string, rootFingerprints ...[]byte) (*Backend, error) {
+	if principalArn == "" {
+		principalArn = DefaultPrincipalArn
+	}
+	if _, err := arn.Parse(principalArn); err != nil {
+		return nil, fmt.Errorf("invalid principal ARN: %w", err)
+	}
+
+	if err := os.MkdirAll(directory, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create fake KMS directory %s with error: %w", directory, err)
+	}
+
+	return &Backend{
+		directory:        directory,
+		principalArn:     principalArn,
+		rootFingerprints: rootFingerprints,
+		mu:               &sync.Mutex{},
+	}, nil
+}
+
+// WithPrincipal returns backend of the same keys making requests on behalf
+// of another principal, e.g. a key admin
+func (b *Backend) WithPrincipal(principalArn string) (*Backend, error) {
+	if _, err := arn.Parse(principalArn); err != nil {
+		return nil, fmt.Errorf("invalid principal ARN: %w", err)
+	}
+
+	return &Backend{
+		directory:        b.directory,
+		principalArn:     principalArn,
+		rootFingerprints: b.rootFingerprints,
+		mu:               b.mu,
+	}, nil
+}
+
+func (b *Backend) GetCallerArn() (string, error) {
+	return b.principalArn, nil
+}
+
+// NewClient returns client that, like attestedkms.KMSEnclaveClient,
+// attaches attestation document of the provider as recipient of the
+// requests returning plaintext
+func (b *Backend) NewClient(provider nitro.AttestationProvider) (nitro.KMSClient, error) {
+	attestationDoc, err := provider.GetAttestationDoc(nil, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attestation document: %w", err)
+	}
+
+	return &Client{
+		backend:        b,
+		attestationDoc: attestationDoc,
+	}, nil
+}
+
+// NewAdminClient returns client without recipient attestation document
+func (b *Backend) NewAdminClient() (nitro.KMSClient, error) {
+	return &Client{backend: b}, nil
+}
+
+// authorize evaluates key policy for the action. If recipientDoc is not
+// nil it must be valid, and its measurements become condition keys.
+// requestContext holds other condition keys of the request.
+func (b *Backend) authorize(key *storedKey, action string, recipientDoc []byte, requestContext map[string]string) error {
+	if !key.Enabled {
+		return &kmstypes.DisabledException{Message: aws.String(fmt.Sprintf("%s is disabled", key.Arn))}
+	}
+
+	policy, err := parsePolicy(key.Policy)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrAccessDenied, err)
+	}
+
+	if requestContext == nil {
+		requestContext = make(map[string]string)
+	}
+	if recipientDoc != nil {
+		doc, err := attestation.ParseNSMAttestationDoc(recipientDoc)
+		if err != nil {
+			return fmt.Errorf("%w: invalid recipient attestation document: %w", ErrAccessDenied, err)
+		}
+		if _, err = nitro.VerifyAttestationDoc(doc, b.rootFingerprints...); err != nil {
+			return fmt.Errorf("%w: invalid recipient attestation document: %w", ErrAccessDenied, err)
+		}
+
+		for index, value := range doc.PCRs {
+			requestContext[nitro.PcrXCondition(index)] = hex.EncodeToString(value)
+		}
+		if pcr0, ok := doc.PCRs[0]; ok {
+			requestContext[nitro.ImageSha384Condition] = hex.EncodeToString(pcr0)
+		}
+	}
+
+	return policy.evaluate(policyRequest{
+		Principal: b.principalArn,
+		Action:    action,
+		Context:   requestContext,
+	})
+}
+
+func (b *Backend) createKey(description, policy string, bypassPolicyLockoutSafetyCheck bool, tags map[string]string) (*storedKey, error) {
+	rootArn, err := nitro.ToRootArn(b.principalArn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make root arn: %w", err)
+	}
+
+	if policy == "" {
+		policy = defaultKeyPolicy(rootArn)
+	}
+
+	parsedPolicy, err := parsePolicy(policy)
+	if err != nil {
+		return nil, &kmstypes.MalformedPolicyDocumentException{Message: aws.String(err.Error())}
+	}
+
+	if err = b.checkPolicyLockout(parsedPolicy, bypassPolicyLockoutSafetyCheck); err != nil {
+		return nil, err
+	}
+
+	keyID, err := newKeyID()
+	if err != nil {
+		return nil, err
+	}
+
+	material := make([]byte, 32)
+	if _, err = rand.Read(material); err != nil {
+		return nil, fmt.Errorf("failed to generate key material: %w", err)
+	}
+
+	parsedRootArn, _ := arn.Parse(rootArn)
+	key := &storedKey{
+		KeyID:        keyID,
+		Arn:          fmt.Sprintf("arn:%s:kms:%s:%s:key/%s", parsedRootArn.Partition, DefaultRegion, parsedRootArn.AccountID, keyID),
+		Description:  description,
+		Policy:       policy,
+		Material:     material,
+		Enabled:      true,
+		CreationDate: time.Now().UTC(),
+		Tags:         tags,
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err = b.saveKey(key); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// putKeyPolicy replaces the key policy. KMS takes no recipient in the
+// request, so kms:RecipientAttestation:* conditions never match it.
+func (b *Backend) putKeyPolicy(keyID, policy string, bypassPolicyLockoutSafetyCheck bool) error {
+	// Key is authorized and updated under the same lock, so the policy
+	// can't change in between
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key, err := b.readKey(keyID)
+	if err != nil {
+		return err
+	}
+
+	if err = b.authorize(key, "kms:PutKeyPolicy", nil, nil); err != nil {
+		return err
+	}
+
+	parsedPolicy, err := parsePolicy(policy)
+	if err != nil {
+		return &kmstypes.MalformedPolicyDocumentException{Message: aws.String(err.Error())}
+	}
+	if err = b.checkPolicyLockout(parsedPolicy, bypassPolicyLockoutSafetyCheck); err != nil {
+		return err
+	}
+
+	key.Policy = policy
+	return b.saveKey(key)
+}
+
+// checkPolicyLockout like AWS KMS refuses policies that lock out the caller
+func (b *Backend) checkPolicyLockout(policy *policyDocument, bypass bool) error {
+	if bypass {
+		return nil
+	}
+
+	if err := policy.evaluate(policyRequest{Principal: b.principalArn, Action: "kms:PutKeyPolicy"}); err != nil {
+		return &kmstypes.MalformedPolicyDocumentException{
+			Message: aws.String(fmt.Sprintf("the new key policy will not allow you to update the key policy in the future: %s", err)),
+		}
+	}
+
+	return nil
+}
+
+// createAlias points alias to the key, aliases are unique within the backend
+func (b *Backend) createAlias(aliasName, keyID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key, err := b.readKey(keyID)
+	if err != nil {
+		return err
+	}
+
+	if err = b.authorize(key, "kms:CreateAlias", nil, map[string]string{nitro.AliasNameCondition: aliasName}); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(b.directory)
+	if err != nil {
+		return fmt.Errorf("failed to read fake KMS directory: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != keyFileExtension {
+			continue
+		}
+
+		raw, err := os.ReadFile(path.Join(b.directory, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read key %s: %w", entry.Name(), err)
+		}
+
+		var other storedKey
+		if err = json.Unmarshal(raw, &other); err != nil {
+			return fmt.Errorf("failed to unmarshal key %s: %w", entry.Name(), err)
+		}
+		if slices.Contains(other.Aliases, aliasName) {
+			return &kmstypes.AlreadyExistsException{Message: aws.String(fmt.Sprintf("alias %s already exists", aliasName))}
+		}
+	}
+
+	key.Aliases = append(key.Aliases, aliasName)
+	return b.saveKey(key)
+}
+
+// SetKeyEnabled enables or disables the key like kms:EnableKey and
+// kms:DisableKey of the key administrator, disabled keys refuse every use
+func (b *Backend) SetKeyEnabled(keyID string, enabled bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key, err := b.readKey(keyID)
+	if err != nil {
+		return err
+	}
+
+	key.Enabled = enabled
+	return b.saveKey(key)
+}
+
+func (b *Backend) getKey(keyID string) (*storedKey, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.readKey(keyID)
+}
+
+// readKey reads the key by ID or ARN, must be called under lock
+func (b *Backend) readKey(keyID string) (*storedKey, error) {
+	// Accept both key ID and key ARN
+	if parsedArn, err := arn.Parse(keyID); err == nil {
+		keyID = strings.TrimPrefix(parsedArn.Resource, "key/")
+	}
+
+	raw, err := os.ReadFile(b.keyPath(keyID))
+	if os.IsNotExist(err) {
+		return nil, &kmstypes.NotFoundException{Message: aws.String(fmt.Sprintf("key %s does not exist", keyID))}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key %s: %w", keyID, err)
+	}
+
+	var key storedKey
+	if err = json.Unmarshal(raw, &key); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal key %s: %w", keyID, err)
+	}
+
+	return &key, nil
+}
+
+func (b *Backend) saveKey(key *storedKey) error {
+	raw, err := json.Marshal(key)
+	if err != nil {
+		return fmt.Errorf("failed to marshal key %s: %w", key.KeyID, err)
+	}
+
+	if err = os.WriteFile(b.keyPath(key.KeyID), raw, 0600); err != nil {
+		return fmt.Errorf("failed to write key %s: %w", key.KeyID, err)
+	}
+
+	return nil
+}
+
+func (b *Backend) keyPath(keyID string) string {
+	return path.Join(b.directory, path.Base(keyID)+keyFileExtension)
+}
+
+// Ciphertext blob layout: len(keyID) || keyID || nonce || AES-GCM ciphertext
+func encrypt(key *storedKey, plaintext []byte) ([]byte, error) {
+	aead, err := newAEAD(key.Material)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	blob := append([]byte{byte(len(key.KeyID))}, key.KeyID...)
+	blob = append(blob, nonce...)
+	return aead.Seal(blob, nonce, plaintext, []byte(key.KeyID)), nil
+}
+
+func ciphertextKeyID(blob []byte) (string, error) {
+	if len(blob) < 1 || len(blob) < 1+int(blob[0]) {
+		return "", &kmstypes.InvalidCiphertextException{Message: aws.String("ciphertext blob is too short")}
+	}
+
+	return string(blob[1 : 1+int(blob[0])]), nil
+}
+
+func decrypt(key *storedKey, blob []byte) ([]byte, error) {
+	keyID, err := ciphertextKeyID(blob)
+	if err != nil {
+		return nil, err
+	}
+	if keyID != key.KeyID {
+		return nil, &kmstypes.IncorrectKeyException{Message: aws.String("ciphertext was encrypted under a different key")}
+	}
+
+	aead, err := newAEAD(key.Material)
+	if err != nil {
+		return nil, err
+	}
+
+	blob = blob[1+len(keyID):]
+	if len(blob) < aead.NonceSize() {
+		return nil, &kmstypes.InvalidCiphertextException{Message: aws.String("ciphertext blob is too short")}
+	}
+
+	plaintext, err := aead.Open(nil, blob[:aead.NonceSize()], blob[aead.NonceSize():], []byte(key.KeyID))
+	if err != nil {
+		return nil, &kmstypes.InvalidCiphertextException{Message: aws.String(err.Error())}
+	}
+
+	return plaintext, nil
+}
+
+func newAEAD(material []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(material)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create aes cipher: %w", err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func newKeyID() (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate key ID: %w", err)
+	}
+
+	encoded := hex.EncodeToString(raw)
+	return fmt.Sprintf("%s-%s-%s-%s-%s", encoded[0:8], encoded[8:12], encoded[12:16], encoded[16:20], encoded[20:]), nil
+}
+
+// Same as the AWS KMS default key policy
+func defaultKeyPolicy(rootArn string) string {
+	policy, _ := json.Marshal(map[string]any{
+		"Version": "2012-10-17",
+		"Id":      "key-default-1",
+		"Statement": []map[string]any{
+			{
+				"Sid":       "Enable IAM User Permissions",
+				"Effect":    "Allow",
+				"Principal": map[string]any{"AWS": rootArn},
+				"Action":    "kms:*",
+				"Resource":  "*",
+			},
+		},
+	})
+	return string(policy)
+}
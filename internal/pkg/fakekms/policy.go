@@ -0,0 +1,259 @@
+package fakekms
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+)
+
+// Subset of IAM policy grammar understood by the fake KMS. Anything
+// that can't be evaluated is treated as not granted (fail closed).
+type policyDocument struct {
+	Version   string            `json:"Version"`
+	ID        string            `json:"Id"`
+	Statement []policyStatement `json:"Statement"`
+}
+
+type policyStatement struct {
+	Sid       string                              `json:"Sid"`
+	Effect    string                              `json:"Effect"`
+	Principal policyPrincipal                     `json:"Principal"`
+	Action    stringOrSlice                       `json:"Action"`
+	Resource  stringOrSlice                       `json:"Resource"`
+	Condition map[string]map[string]stringOrSlice `json:"Condition"`
+}
+
+// policyPrincipal is either "*" or {"AWS": "arn" | ["arn", ...]}
+type policyPrincipal struct {
+	Any bool
+	AWS stringOrSlice
+}
+
+func (p *policyPrincipal) UnmarshalJSON(data []byte) error {
+	var wildcard string
+	if err := json.Unmarshal(data, &wildcard); err == nil {
+		if wildcard != "*" {
+			return fmt.Errorf("unsupported principal %q", wildcard)
+		}
+		p.Any = true
+		return nil
+	}
+
+	var principal struct {
+		AWS stringOrSlice `json:"AWS"`
+	}
+	if err := json.Unmarshal(data, &principal); err != nil {
+		return fmt.Errorf("failed to unmarshal principal: %w", err)
+	}
+	p.AWS = principal.AWS
+
+	return nil
+}
+
+type stringOrSlice []string
+
+func (s *stringOrSlice) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*s = stringOrSlice{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return fmt.Errorf("must be string or array of strings: %w", err)
+	}
+	*s = multiple
+
+	return nil
+}
+
+// policyRequest is what the key policy is evaluated against
+type policyRequest struct {
+	Principal string
+	Action    string
+	// Condition keys available for the request, e.g. kms:RecipientAttestation:PCR0
+	Context map[string]string
+}
+
+func parsePolicy(policy string) (*policyDocument, error) {
+	var doc policyDocument
+	if err := json.Unmarshal([]byte(policy), &doc); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal key policy: %w", err)
+	}
+
+	if len(doc.Statement) == 0 {
+		return nil, fmt.Errorf("key policy has no statements")
+	}
+
+	for i, statement := range doc.Statement {
+		if statement.Effect != "Allow" && statement.Effect != "Deny" {
+			return nil, fmt.Errorf("statement #%d: unsupported effect %q", i, statement.Effect)
+		}
+		if len(statement.Action) == 0 {
+			return nil, fmt.Errorf("statement #%d: action is empty", i)
+		}
+	}
+
+	return &doc, nil
+}
+
+// evaluate returns nil if request is allowed by the policy. Like IAM, an
+// explicit deny wins and absence of any matching allow is an implicit deny.
+func (d *policyDocument) evaluate(req policyRequest) error {
+	var (
+		allowed    bool
+		lastReason error
+	)
+
+	for _, statement := range d.Statement {
+		if !statement.matchesPrincipal(req.Principal) || !statement.matchesAction(req.Action) {
+			continue
+		}
+
+		err := statement.matchesConditions(req.Context)
+		if statement.Effect == "Deny" {
+			// Unevaluable deny conditions are treated as matched
+			if err == nil || !isConditionMismatch(err) {
+				return fmt.Errorf("%w: explicitly denied by statement %q", ErrAccessDenied, statement.Sid)
+			}
+			continue
+		}
+
+		if err != nil {
+			lastReason = fmt.Errorf("statement %q: %w", statement.Sid, err)
+			continue
+		}
+		allowed = true
+	}
+
+	if allowed {
+		return nil
+	}
+	if lastReason != nil {
+		return fmt.Errorf("%w: %s is not allowed for %s: %w", ErrAccessDenied, req.Action, req.Principal, lastReason)
+	}
+
+	return fmt.Errorf("%w: %s is not allowed for %s", ErrAccessDenied, req.Action, req.Principal)
+}
+
+func (s *policyStatement) matchesPrincipal(principal string) bool {
+	if s.Principal.Any {
+		return true
+	}
+
+	for _, statementPrincipal := range s.Principal.AWS {
+		if statementPrincipal == "*" || statementPrincipal == principal {
+			return true
+		}
+
+		// Like IAM, role principal matches sessions of the role, whatever its path
+		if sessionRole, ok := assumedRole(principal); ok {
+			roleArn, err := arn.Parse(statementPrincipal)
+			if err == nil && strings.HasPrefix(roleArn.Resource, "role/") &&
+				roleArn.AccountID == sessionRole.AccountID && path.Base(roleArn.Resource) == sessionRole.Resource {
+				return true
+			}
+		}
+
+		// Account root principal delegates access to every principal of the account
+		rootArn, err := arn.Parse(statementPrincipal)
+		if err != nil || rootArn.Resource != "root" {
+			continue
+		}
+		principalArn, err := arn.Parse(principal)
+		if err == nil && principalArn.AccountID == rootArn.AccountID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// assumedRole returns ARN with the role name as resource for STS assumed-role ARN
+func assumedRole(principal string) (arn.ARN, bool) {
+	principalArn, err := arn.Parse(principal)
+	if err != nil || principalArn.Service != "sts" {
+		return arn.ARN{}, false
+	}
+
+	parts := strings.Split(principalArn.Resource, "/")
+	if len(parts) != 3 || parts[0] != "assumed-role" {
+		return arn.ARN{}, false
+	}
+	principalArn.Resource = parts[1]
+
+	return principalArn, true
+}
+
+func (s *policyStatement) matchesAction(action string) bool {
+	for _, statementAction := range s.Action {
+		if statementAction == "*" || strings.EqualFold(statementAction, "kms:*") || strings.EqualFold(statementAction, action) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *policyStatement) matchesConditions(context map[string]string) error {
+	for operator, conditions := range s.Condition {
+		var equal func(a, b string) bool
+		switch operator {
+		case "StringEquals":
+			equal = func(a, b string) bool { return a == b }
+		case "StringEqualsIgnoreCase":
+			equal = strings.EqualFold
+		default:
+			return fmt.Errorf("unsupported condition operator %s", operator)
+		}
+
+		for key, expected := range conditions {
+			actual, ok := contextValue(context, key)
+			if !ok {
+				return &conditionMismatchError{key: key, reason: "absent in request context"}
+			}
+
+			matched := false
+			for _, value := range expected {
+				if equal(actual, value) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return &conditionMismatchError{key: key, reason: fmt.Sprintf("value %s is not in %v", actual, []string(expected))}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Condition keys are case-insensitive
+func contextValue(context map[string]string, key string) (string, bool) {
+	for contextKey, value := range context {
+		if strings.EqualFold(contextKey, key) {
+			return value, true
+		}
+	}
+
+	return "", false
+}
+
+type conditionMismatchError struct {
+	key    string
+	reason string
+}
+
+func (e *conditionMismatchError) Error() string {
+	return fmt.Sprintf("condition %s mismatch: %s", e.key, e.reason)
+}
+
+func isConditionMismatch(err error) bool {
+	_, ok := err.(*conditionMismatchError)
+	return ok
+}
@@ -0,0 +1,187 @@
+// Package fakes3 is in-process S3-compatible object store stand-in for
+// tests, like MinIO it serves path-style requests. Only the subset of API
+// used by storage.S3 is implemented: GetObject, HeadObject, PutObject with
+// If-None-Match and ListObjectsV2. Requests are not authenticated.
+package fakes3
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type object struct {
+	data    []byte
+	etag    string
+	modTime time.Time
+}
+
+// Server is http.Handler of the object store
+type Server struct {
+	mu      sync.Mutex
+	buckets map[string]map[string]object
+}
+
+// New creates the store with the given empty buckets
+func New(buckets ...string) *Server {
+	s := &Server{buckets: make(map[string]map[string]object, len(buckets))}
+	for _, bucket := range buckets {
+		s.buckets[bucket] = make(map[string]object)
+	}
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucketName, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucket, ok := s.buckets[bucketName]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+
+	switch {
+	case key == "" && r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		s.listObjects(w, bucketName, bucket, r.URL.Query().Get("prefix"))
+	case key != "" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		s.getObject(w, r, bucket, key)
+	case key != "" && r.Method == http.MethodPut:
+		s.putObject(w, r, bucket, key)
+	default:
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented", fmt.Sprintf("%s %s is not implemented", r.Method, r.URL.Path))
+	}
+}
+
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, bucket map[string]object, key string) {
+	obj, ok := bucket[key]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+
+	w.Header().Set("ETag", obj.etag)
+	w.Header().Set("Last-Modified", obj.modTime.Format(http.TimeFormat))
+	w.Header().Set("Content-Length", fmt.Sprint(len(obj.data)))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+
+	if r.Method == http.MethodGet {
+		w.Write(obj.data)
+	}
+}
+
+func (s *Server) putObject(w http.ResponseWriter, r *http.Request, bucket map[string]object, key string) {
+	if r.Header.Get("Content-Encoding") == "aws-chunked" {
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented", "aws-chunked encoding is not supported")
+		return
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+
+	if r.Header.Get("If-None-Match") == "*" {
+		if _, ok := bucket[key]; ok {
+			writeError(w, r, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold")
+			return
+		}
+	}
+
+	sum := md5.Sum(data)
+	obj := object{
+		data:    data,
+		etag:    `"` + hex.EncodeToString(sum[:]) + `"`,
+		modTime: time.Now().UTC().Truncate(time.Second),
+	}
+	bucket[key] = obj
+
+	w.Header().Set("ETag", obj.etag)
+	w.WriteHeader(http.StatusOK)
+}
+
+type listBucketResult struct {
+	XMLName     xml.Name         `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name        string           `xml:"Name"`
+	Prefix      string           `xml:"Prefix"`
+	KeyCount    int              `xml:"KeyCount"`
+	MaxKeys     int              `xml:"MaxKeys"`
+	IsTruncated bool             `xml:"IsTruncated"`
+	Contents    []listBucketItem `xml:"Contents"`
+}
+
+type listBucketItem struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int    `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+// listObjects returns every matching key in one page
+func (s *Server) listObjects(w http.ResponseWriter, bucketName string, bucket map[string]object, prefix string) {
+	result := listBucketResult{
+		Name:    bucketName,
+		Prefix:  prefix,
+		MaxKeys: 1000,
+	}
+
+	for key, obj := range bucket {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		result.Contents = append(result.Contents, listBucketItem{
+			Key:          key,
+			LastModified: obj.modTime.Format(time.RFC3339),
+			ETag:         obj.etag,
+			Size:         len(obj.data),
+			StorageClass: "STANDARD",
+		})
+	}
+	sort.Slice(result.Contents, func(i, j int) bool {
+		return result.Contents[i].Key < result.Contents[j].Key
+	})
+	result.KeyCount = len(result.Contents)
+
+	writeXML(w, http.StatusOK, result)
+}
+
+type errorResponse struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string   `xml:"Code"`
+	Message  string   `xml:"Message"`
+	Resource string   `xml:"Resource"`
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+
+	writeXML(w, status, errorResponse{
+		Code:     code,
+		Message:  message,
+		Resource: r.URL.Path,
+	})
+}
+
+func writeXML(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(v)
+}
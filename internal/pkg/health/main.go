@@ -0,0 +1,83 @@
+// Package health tracks state of the service components for readiness probes
+package health
+
+import (
+	"sync"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusPending  Status = "pending"
+	StatusOK       Status = "ok"
+	StatusDisabled Status = "disabled"
+	StatusFailed   Status = "failed"
+)
+
+// Component is the last reported state of a component
+type Component struct {
+	Status Status
+	// Error of the failed component
+	Error     string
+	UpdatedAt time.Time
+}
+
+// Health holds states of the components, the service is ready if all of
+// them are ok or disabled
+type Health struct {
+	mu         sync.RWMutex
+	components map[string]Component
+}
+
+// New returns Health of the pending components
+func New(names ...string) *Health {
+	h := &Health{components: make(map[string]Component, len(names))}
+	for _, name := range names {
+		h.Set(name, StatusPending, nil)
+	}
+
+	return h
+}
+
+// Set reports state of the component, err is kept for failed components
+func (h *Health) Set(name string, status Status, err error) {
+	component := Component{
+		Status:    status,
+		UpdatedAt: time.Now().UTC(),
+	}
+	if err != nil {
+		component.Error = err.Error()
+	}
+
+	h.mu.Lock()
+	h.components[name] = component
+	h.mu.Unlock()
+}
+
+// SetResult reports the component ok if err is nil and failed otherwise
+func (h *Health) SetResult(name string, err error) {
+	if err != nil {
+		h.Set(name, StatusFailed, err)
+		return
+	}
+
+	h.Set(name, StatusOK, nil)
+}
+
+// Report returns whether the service is ready and states of the components
+func (h *Health) Report() (bool, map[string]Component) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	ready := true
+	components := make(map[string]Component, len(h.components))
+	for name, component := range h.components {
+		components[name] = component
+		if component.Status != StatusOK && component.Status != StatusDisabled {
+			ready = false
+		}
+	}
+
+	return ready, components
+}
@@ -0,0 +1,275 @@
+package icrypto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+var (
+	// ErrDomainNotSupported is returned for contracts without EIP-5267 domain
+	ErrDomainNotSupported = errors.New("contract doesn't expose EIP-5267 eip712Domain")
+	ErrInvalidDomain      = errors.New("invalid domain")
+)
+
+// eip5267ABI is ABI of EIP-5267 eip712Domain function
+const eip5267ABI = `[{"type":"function","name":"eip712Domain","stateMutability":"view","inputs":[],"outputs":[
+	{"name":"fields","type":"bytes1"},
+	{"name":"name","type":"string"},
+	{"name":"version","type":"string"},
+	{"name":"chainId","type":"uint256"},
+	{"name":"verifyingContract","type":"address"},
+	{"name":"salt","type":"bytes32"},
+	{"name":"extensions","type":"uint256[]"}
+]}]`
+
+var eip5267 = func() abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(eip5267ABI))
+	if err != nil {
+		panic(fmt.Errorf("failed to parse EIP-5267 ABI: %w", err))
+	}
+	return parsed
+}()
+
+type eip5267Contract struct {
+	contract *bind.BoundContract
+}
+
+// NewDomainProvider returns DomainProvider calling eip712Domain of the contract
+func NewDomainProvider(address common.Address, caller bind.ContractCaller) DomainProvider {
+	return &eip5267Contract{
+		contract: bind.NewBoundContract(address, eip5267, caller, nil, nil),
+	}
+}
+
+func (c *eip5267Contract) Eip712Domain(opts *bind.CallOpts) (struct {
+	Fields            [1]byte
+	Name              string
+	Version           string
+	ChainId           *big.Int //nolint
+	VerifyingContract common.Address
+	Salt              [32]byte
+	Extensions        []*big.Int
+}, error) {
+	var (
+		out    []interface{}
+		result struct {
+			Fields            [1]byte
+			Name              string
+			Version           string
+			ChainId           *big.Int //nolint
+			VerifyingContract common.Address
+			Salt              [32]byte
+			Extensions        []*big.Int
+		}
+	)
+
+	if err := c.contract.Call(opts, &out, "eip712Domain"); err != nil {
+		return result, err
+	}
+
+	result.Fields = *abi.ConvertType(out[0], new([1]byte)).(*[1]byte)
+	result.Name = *abi.ConvertType(out[1], new(string)).(*string)
+	result.Version = *abi.ConvertType(out[2], new(string)).(*string)
+	result.ChainId = *abi.ConvertType(out[3], new(*big.Int)).(**big.Int)
+	result.VerifyingContract = *abi.ConvertType(out[4], new(common.Address)).(*common.Address)
+	result.Salt = *abi.ConvertType(out[5], new([32]byte)).(*[32]byte)
+	result.Extensions = *abi.ConvertType(out[6], new([]*big.Int)).(*[]*big.Int)
+
+	return result, nil
+}
+
+// DomainResolver fetches EIP712 domains of contracts with EIP-5267 and
+// caches them for TTL. Failures are cached for failure TTL, so a broken
+// contract or endpoint isn't called on every request.
+type DomainResolver struct {
+	// callers by decimal chain ID
+	callers    map[string]bind.ContractCaller
+	ttl        time.Duration
+	failureTTL time.Duration
+	cacheSize  int
+	timeout    time.Duration
+
+	mu    sync.Mutex
+	cache map[domainKey]cachedDomain
+}
+
+type domainKey struct {
+	chainID  string
+	contract common.Address
+}
+
+type cachedDomain struct {
+	domain    *Domain
+	err       error
+	expiresAt time.Time
+}
+
+// NewDomainResolver returns resolver of the chains by decimal chain ID.
+// At most cacheSize domains and failures are cached, timeout bounds every
+// contract call.
+func NewDomainResolver(callers map[string]bind.ContractCaller, ttl, failureTTL time.Duration, cacheSize int, timeout time.Duration) *DomainResolver {
+	return &DomainResolver{
+		callers:    callers,
+		ttl:        ttl,
+		failureTTL: failureTTL,
+		cacheSize:  cacheSize,
+		timeout:    timeout,
+		cache:      make(map[domainKey]cachedDomain),
+	}
+}
+
+// Resolves reports whether the domain is fetched from the contract, i.e. it
+// has only chainId and verifyingContract of a chain with endpoint
+func (r *DomainResolver) Resolves(domain apitypes.TypedDataDomain) bool {
+	if r == nil || domain.ChainId == nil || domain.VerifyingContract == "" {
+		return false
+	}
+	if domain.Name != "" || domain.Version != "" || domain.Salt != "" {
+		return false
+	}
+
+	_, ok := r.callers[(*big.Int)(domain.ChainId).String()]
+	return ok
+}
+
+// Resolve returns the domain of the contract if it Resolves, the domain as
+// is otherwise
+func (r *DomainResolver) Resolve(ctx context.Context, domain apitypes.TypedDataDomain) (*Domain, error) {
+	if !r.Resolves(domain) {
+		return GetDomain(domain), nil
+	}
+	if !common.IsHexAddress(domain.VerifyingContract) {
+		return nil, fmt.Errorf("%w: verifying contract %s isn't an address", ErrInvalidDomain, domain.VerifyingContract)
+	}
+
+	key := domainKey{
+		chainID:  (*big.Int)(domain.ChainId).String(),
+		contract: common.HexToAddress(domain.VerifyingContract),
+	}
+
+	r.mu.Lock()
+	cached, ok := r.cache[key]
+	r.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.domain, cached.err
+	}
+
+	fetched, err := r.fetch(ctx, key)
+	switch {
+	case err == nil:
+		r.store(key, cachedDomain{domain: fetched, expiresAt: time.Now().Add(r.ttl)})
+	// Canceled request says nothing about the contract
+	case ctx.Err() == nil:
+		r.store(key, cachedDomain{err: err, expiresAt: time.Now().Add(r.failureTTL)})
+	}
+
+	return fetched, err
+}
+
+// store caches the entry. If the cache is full, expired entries are dropped,
+// or the one expiring first if none is expired.
+func (r *DomainResolver) store(key domainKey, entry cachedDomain) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.cache[key]; !ok && len(r.cache) >= r.cacheSize {
+		now := time.Now()
+		var (
+			oldest    domainKey
+			oldestSet bool
+		)
+		for cachedKey, cached := range r.cache {
+			if !now.Before(cached.expiresAt) {
+				delete(r.cache, cachedKey)
+				continue
+			}
+			if !oldestSet || cached.expiresAt.Before(r.cache[oldest].expiresAt) {
+				oldest, oldestSet = cachedKey, true
+			}
+		}
+		if len(r.cache) >= r.cacheSize && oldestSet {
+			delete(r.cache, oldest)
+		}
+	}
+
+	r.cache[key] = entry
+}
+
+func (r *DomainResolver) fetch(ctx context.Context, key domainKey) (*Domain, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	provider := NewDomainProvider(key.contract, r.callers[key.chainID])
+	domainData, err := provider.Eip712Domain(&bind.CallOpts{Context: ctx})
+	if err != nil {
+		// Reverts carry error data, empty or malformed results fail to unpack
+		var dataErr interface{ ErrorData() interface{} }
+		if errors.Is(err, bind.ErrNoCode) || errors.As(err, &dataErr) || strings.Contains(err.Error(), "abi:") {
+			return nil, fmt.Errorf("%w: %s on chain %s: %w", ErrDomainNotSupported, key.contract.Hex(), key.chainID, err)
+		}
+		return nil, fmt.Errorf("failed to call eip712Domain of %s on chain %s: %w", key.contract.Hex(), key.chainID, err)
+	}
+	if len(domainData.Extensions) != 0 {
+		return nil, fmt.Errorf("%w: %s has unsupported extensions %v", ErrDomainNotSupported, key.contract.Hex(), domainData.Extensions)
+	}
+
+	domain := domainFromData(domainData.Fields, domainData.Name, domainData.Version, domainData.ChainId, domainData.VerifyingContract, domainData.Salt)
+
+	// The request named them, without them signatures would be valid for
+	// other chains and contracts
+	if domain.ChainId == nil || domain.VerifyingContract == "" {
+		return nil, fmt.Errorf("%w: domain of %s lacks chainId or verifyingContract, fields are %#02x", ErrDomainNotSupported, key.contract.Hex(), domainData.Fields[0])
+	}
+
+	// Wrong endpoint of the chain would make signatures for another chain
+	if (*big.Int)(domain.ChainId).String() != key.chainID {
+		return nil, fmt.Errorf("domain of %s is of chain %s, not %s", key.contract.Hex(), (*big.Int)(domain.ChainId), key.chainID)
+	}
+	if common.HexToAddress(domain.VerifyingContract) != key.contract {
+		return nil, fmt.Errorf("domain of %s is of contract %s", key.contract.Hex(), domain.VerifyingContract)
+	}
+
+	return domain, nil
+}
+
+// domainFromData returns domain of EIP-5267 fields, only fields set in the
+// bitmap are typed
+func domainFromData(fields [1]byte, name, version string, chainID *big.Int, verifyingContract common.Address, salt [32]byte) *Domain {
+	domain := &Domain{}
+	domainTypes := make([]apitypes.Type, 0, 5)
+	if fields[0]&0b00001 != 0 {
+		domain.Name = name
+		domainTypes = append(domainTypes, apitypes.Type{Name: "name", Type: "string"})
+	}
+	if fields[0]&0b00010 != 0 {
+		domain.Version = version
+		domainTypes = append(domainTypes, apitypes.Type{Name: "version", Type: "string"})
+	}
+	if fields[0]&0b00100 != 0 {
+		domain.ChainId = (*math.HexOrDecimal256)(chainID)
+		domainTypes = append(domainTypes, apitypes.Type{Name: "chainId", Type: "uint256"})
+	}
+	if fields[0]&0b01000 != 0 {
+		domain.VerifyingContract = verifyingContract.Hex()
+		domainTypes = append(domainTypes, apitypes.Type{Name: "verifyingContract", Type: "address"})
+	}
+	if fields[0]&0b10000 != 0 {
+		domain.Salt = hexutil.Encode(salt[:])
+		domainTypes = append(domainTypes, apitypes.Type{Name: "salt", Type: "bytes32"})
+	}
+	domain.DomainTypes = domainTypes
+
+	return domain
+}
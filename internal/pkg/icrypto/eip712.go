@@ -2,13 +2,14 @@ package icrypto
 
 import (
 	"crypto/ecdsa"
+	"encoding/json"
 	"fmt"
 	"math/big"
+	"time"
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
-	"github.com/ethereum/go-ethereum/common/math"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/signer/core/apitypes"
 )
@@ -24,6 +25,13 @@ type Message struct {
 	apitypes.TypedDataMessage
 	DataTypes   []apitypes.Type
 	PrimaryType string
+	// Bytes fields of the same length in every message, they don't make
+	// packed encoding ambiguous
+	FixedSizeFields map[string]bool
+	// Struct types the primary type refers to, caller-supplied schemas only
+	Types apitypes.Types
+	// Signed deadline of the endorsement, zero if it doesn't expire
+	Deadline time.Time
 }
 
 type DomainProvider interface {
@@ -44,31 +52,7 @@ func GetDomainWithProvider(provider DomainProvider) (*Domain, error) {
 		return nil, fmt.Errorf("failed to get eip712 domain: %w", err)
 	}
 
-	domain := &Domain{}
-	domainTypes := make([]apitypes.Type, 0, 5)
-	if domainData.Fields[0]&0b00001 != 0 {
-		domain.Name = domainData.Name
-		domainTypes = append(domainTypes, apitypes.Type{Name: "name", Type: "string"})
-	}
-	if domainData.Fields[0]&0b00010 != 0 {
-		domain.Version = domainData.Version
-		domainTypes = append(domainTypes, apitypes.Type{Name: "version", Type: "string"})
-	}
-	if domainData.Fields[0]&0b00100 != 0 {
-		domain.ChainId = (*math.HexOrDecimal256)(domainData.ChainId)
-		domainTypes = append(domainTypes, apitypes.Type{Name: "chainId", Type: "uint256"})
-	}
-	if domainData.Fields[0]&0b01000 != 0 {
-		domain.VerifyingContract = domainData.VerifyingContract.Hex()
-		domainTypes = append(domainTypes, apitypes.Type{Name: "verifyingContract", Type: "address"})
-	}
-	if domainData.Fields[0]&0b10000 != 0 {
-		domain.Salt = hexutil.Encode(domainData.Salt[:])
-		domainTypes = append(domainTypes, apitypes.Type{Name: "salt", Type: "bytes32"})
-	}
-	domain.DomainTypes = domainTypes
-
-	return domain, nil
+	return domainFromData(domainData.Fields, domainData.Name, domainData.Version, domainData.ChainId, domainData.VerifyingContract, domainData.Salt), nil
 }
 
 func GetDomain(typedDataDomain apitypes.TypedDataDomain) *Domain {
@@ -95,21 +79,98 @@ func GetDomain(typedDataDomain apitypes.TypedDataDomain) *Domain {
 	return domain
 }
 
-func (d *Domain) TypedDataAndHash(message *Message) (hash []byte, rawData string, err error) {
+// TypedData returns EIP712 typed data of the message, types include the domain type
+func (d *Domain) TypedData(message *Message) apitypes.TypedData {
 	primaryType := message.PrimaryType
-	types := apitypes.Types{
-		primaryType: message.DataTypes,
-		DomainType:  d.DomainTypes,
-	}
+	types := message.types()
+	types[DomainType] = d.DomainTypes
 
-	data := apitypes.TypedData{
+	return apitypes.TypedData{
 		Types:       types,
 		PrimaryType: primaryType,
 		Domain:      d.TypedDataDomain,
 		Message:     message.TypedDataMessage,
 	}
+}
+
+func (d *Domain) TypedDataAndHash(message *Message) (hash []byte, rawData string, err error) {
+	return apitypes.TypedDataAndHash(d.TypedData(message))
+}
+
+// MarshalTypedData returns eth_signTypedData_v4 compatible JSON of the typed
+// data: domain contains only typed fields, bytes are 0x-prefixed hex
+func (d *Domain) MarshalTypedData(message *Message) ([]byte, error) {
+	typedData := d.TypedData(message)
+
+	return json.Marshal(struct {
+		Types       apitypes.Types         `json:"types"`
+		PrimaryType string                 `json:"primaryType"`
+		Domain      map[string]interface{} `json:"domain"`
+		Message     map[string]interface{} `json:"message"`
+	}{
+		Types:       typedData.Types,
+		PrimaryType: typedData.PrimaryType,
+		Domain:      typedData.Domain.Map(),
+		Message:     hexValue(map[string]interface{}(typedData.Message)).(map[string]interface{}),
+	})
+}
+
+// hexValue returns copy of the message value with bytes replaced by hex,
+// nested structs and arrays included
+func hexValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case []byte:
+		return hexutil.Bytes(value)
+	case map[string]interface{}:
+		hexMap := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			hexMap[key] = hexValue(item)
+		}
+		return hexMap
+	case []interface{}:
+		hexSlice := make([]interface{}, len(value))
+		for i, item := range value {
+			hexSlice[i] = hexValue(item)
+		}
+		return hexSlice
+	default:
+		return value
+	}
+}
+
+// Separator returns EIP712 domain separator, i.e. hashStruct of the domain
+func (d *Domain) Separator() ([]byte, error) {
+	typedData := apitypes.TypedData{
+		Types:  apitypes.Types{DomainType: d.DomainTypes},
+		Domain: d.TypedDataDomain,
+	}
+
+	separator, err := typedData.HashStruct(DomainType, typedData.Domain.Map())
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash domain: %w", err)
+	}
 
-	return apitypes.TypedDataAndHash(data)
+	return separator, nil
+}
+
+// TypeHash returns keccak256 of the primary type encoding
+func (m *Message) TypeHash() []byte {
+	typedData := apitypes.TypedData{
+		Types: m.types(),
+	}
+
+	return typedData.TypeHash(m.PrimaryType)
+}
+
+// types returns the primary type along with the struct types it refers to
+func (m *Message) types() apitypes.Types {
+	types := make(apitypes.Types, len(m.Types)+2)
+	for name, fields := range m.Types {
+		types[name] = fields
+	}
+	types[m.PrimaryType] = m.DataTypes
+
+	return types
 }
 
 func (d *Domain) SignTypedData(message *Message, pk *ecdsa.PrivateKey) ([]byte, []byte, error) {
@@ -143,6 +204,10 @@ func (d *Domain) SignTypedDataWithSigner(message *Message, signer interface{ Sig
 }
 
 func (d *Domain) VerifyTypedData(message *Message, signature []byte, signer common.Address) error {
+	if err := CheckDeadline(message, time.Now()); err != nil {
+		return err
+	}
+
 	hash, _, err := d.TypedDataAndHash(message)
 	if err != nil {
 		return fmt.Errorf("failed to get typed data and hash: %w", err)
@@ -0,0 +1,205 @@
+package icrypto
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+// Scheme is how the message is turned into the signed digest
+type Scheme string
+
+const (
+	// SchemeEIP712 signs EIP712 typed data of the message within the domain
+	SchemeEIP712 Scheme = "eip712"
+	// SchemeEIP191 signs keccak256 of the packed message as EIP-191
+	// personal_sign message, i.e. "\x19Ethereum Signed Message:\n32" + hash
+	SchemeEIP191 Scheme = "eip191"
+	// SchemeRaw signs keccak256 of the packed message as is
+	SchemeRaw Scheme = "raw"
+)
+
+// ErrAmbiguousEncoding is returned if the packed encoding has more than one
+// dynamic field, so different messages could be encoded the same
+var ErrAmbiguousEncoding = errors.New("packed encoding allows only one dynamic field")
+
+// ErrExpired is returned on verification of the endorsement after its deadline
+var ErrExpired = errors.New("endorsement is expired")
+
+var fixedBytesType = regexp.MustCompile(`^bytes([1-9]|[12][0-9]|3[0-2])$`)
+
+// EncodePacked returns abi.encodePacked of the message fields in the order
+// of DataTypes: bytes, bytesN and string as is, address as 20 bytes, uint64
+// as 8 big-endian bytes
+func (m *Message) EncodePacked() ([]byte, error) {
+	var (
+		packed  []byte
+		dynamic string
+	)
+
+	for _, dataType := range m.DataTypes {
+		value, ok := m.TypedDataMessage[dataType.Name]
+		if !ok {
+			return nil, fmt.Errorf("field %s is absent in message", dataType.Name)
+		}
+
+		isDynamic := false
+		switch {
+		case fixedBytesType.MatchString(dataType.Type):
+			size, _ := strconv.Atoi(dataType.Type[len("bytes"):])
+			raw, ok := value.([]byte)
+			if !ok || len(raw) != size {
+				return nil, fmt.Errorf("field %s must be %s", dataType.Name, dataType.Type)
+			}
+			packed = append(packed, raw...)
+		case dataType.Type == "address":
+			address, ok := value.(string)
+			if !ok || !common.IsHexAddress(address) {
+				return nil, fmt.Errorf("field %s must be hex address", dataType.Name)
+			}
+			packed = append(packed, common.HexToAddress(address).Bytes()...)
+		case dataType.Type == "bytes":
+			raw, ok := value.([]byte)
+			if !ok {
+				return nil, fmt.Errorf("field %s must be bytes", dataType.Name)
+			}
+			packed = append(packed, raw...)
+			isDynamic = !m.FixedSizeFields[dataType.Name]
+		case dataType.Type == "string":
+			str, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("field %s must be string", dataType.Name)
+			}
+			packed = append(packed, str...)
+			isDynamic = true
+		case dataType.Type == "uint64":
+			number, ok := value.(*big.Int)
+			if !ok || !number.IsUint64() {
+				return nil, fmt.Errorf("field %s must be uint64", dataType.Name)
+			}
+			packed = binary.BigEndian.AppendUint64(packed, number.Uint64())
+		default:
+			return nil, fmt.Errorf("field %s has type %s unsupported by packed encoding", dataType.Name, dataType.Type)
+		}
+
+		if isDynamic {
+			if dynamic != "" {
+				return nil, fmt.Errorf("%w: %s and %s", ErrAmbiguousEncoding, dynamic, dataType.Name)
+			}
+			dynamic = dataType.Name
+		}
+	}
+
+	return packed, nil
+}
+
+// LayoutHash returns keccak256 of the scheme and the EIP712 type encoding
+// of the message, e.g. "raw:Register(bytes pcr0,bytes public_key)". It
+// binds packed message to the field names, types and encodings, so bytes
+// of one layout can't pass for another one.
+func (m *Message) LayoutHash(scheme Scheme) []byte {
+	typedData := apitypes.TypedData{
+		Types: m.types(),
+	}
+
+	return crypto.Keccak256(append([]byte(string(scheme)+":"), typedData.EncodeType(m.PrimaryType)...))
+}
+
+// PackedMessage returns the message eip191 and raw schemes hash: the layout
+// hash followed by the packed encoding of the fields
+func (m *Message) PackedMessage(scheme Scheme) ([]byte, error) {
+	packed, err := m.EncodePacked()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(m.LayoutHash(scheme), packed...), nil
+}
+
+// Digest returns the digest the scheme signs, domain is used only by eip712
+func Digest(scheme Scheme, domain *Domain, message *Message) ([]byte, error) {
+	switch scheme {
+	case SchemeEIP712:
+		hash, _, err := domain.TypedDataAndHash(message)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get typed data hash: %w", err)
+		}
+		return hash, nil
+	case SchemeEIP191, SchemeRaw:
+		packed, err := message.PackedMessage(scheme)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode packed message: %w", err)
+		}
+
+		hash := crypto.Keccak256(packed)
+		if scheme == SchemeEIP191 {
+			hash = accounts.TextHash(hash)
+		}
+		return hash, nil
+	default:
+		return nil, fmt.Errorf("unknown signature scheme %q", scheme)
+	}
+}
+
+// SignWithSigner signs the digest of the scheme, recovery byte is 27 or 28
+func SignWithSigner(scheme Scheme, domain *Domain, message *Message, signer interface{ Sign([]byte) ([]byte, error) }) ([]byte, []byte, error) {
+	hash, err := Digest(scheme, domain, message)
+	if err != nil {
+		return nil, nil, err
+	}
+	sig, err := signer.Sign(hash)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to sign: %w", err)
+	}
+
+	// set recovery byte
+	sig[64] += 0x1b
+	return sig, hash, nil
+}
+
+// Verify checks the signature of the message made with the scheme, expired
+// endorsements are rejected
+func Verify(scheme Scheme, domain *Domain, message *Message, signature []byte, signer common.Address) error {
+	if err := CheckDeadline(message, time.Now()); err != nil {
+		return err
+	}
+
+	hash, err := Digest(scheme, domain, message)
+	if err != nil {
+		return err
+	}
+
+	if err = VerifySignature(hash, signature, signer); err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+
+	return nil
+}
+
+// CheckDeadline returns ErrExpired if the message deadline is before now
+func CheckDeadline(message *Message, now time.Time) error {
+	if !message.Deadline.IsZero() && now.After(message.Deadline) {
+		return fmt.Errorf("%w: deadline %s passed", ErrExpired, message.Deadline.UTC().Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// VerifyEIP191 checks personal_sign signature of the packed message hash
+func VerifyEIP191(message *Message, signature []byte, signer common.Address) error {
+	return Verify(SchemeEIP191, nil, message, signature, signer)
+}
+
+// VerifyRaw checks signature of the packed message hash
+func VerifyRaw(message *Message, signature []byte, signer common.Address) error {
+	return Verify(SchemeRaw, nil, message, signature, signer)
+}
@@ -0,0 +1,114 @@
+// Package metrics holds Prometheus metrics of the service. They are
+// registered in a registry of their own, so /metrics exposes only them
+// and Go runtime and process metrics.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "nitro_av"
+
+// Results of sign requests
+const (
+	ResultSigned          = "signed"
+	ResultInvalidRequest  = "invalid_request"
+	ResultParseError      = "parse_error"
+	ResultBadSignature    = "bad_signature"
+	ResultPolicyRejection = "policy_rejection"
+	ResultNonceRejection  = "nonce_rejection"
+	ResultAbsentField     = "absent_field"
+	ResultEncodingError   = "encoding_error"
+	ResultDomainError     = "domain_error"
+	ResultInternalError   = "internal_error"
+)
+
+// Stages of signing an attestation document
+const (
+	StageParse  = "parse"
+	StageVerify = "verify"
+	StageEncode = "encode"
+	StageSign   = "sign"
+)
+
+// Steps of key generation bootstrap
+const (
+	StepKMSKeyID   = "kms_key_id"
+	StepPrivateKey = "private_key"
+	StepPublicKey  = "public_key"
+	StepAddress    = "address"
+)
+
+var (
+	// SignRequests counts signed attestation documents and rejections by
+	// reason, every batch item is counted
+	SignRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sign_requests_total",
+		Help:      "Attestation documents signed or rejected, by result",
+	}, []string{"endpoint", "result"})
+
+	StageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "sign_stage_duration_seconds",
+		Help:      "Duration of attestation document signing stages",
+		Buckets:   prometheus.ExponentialBuckets(0.00005, 4, 10),
+	}, []string{"stage"})
+
+	BatchItems = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "batch_items",
+		Help:      "Count of attestation documents in batch requests",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+	})
+
+	BootstrapStepDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "bootstrap_step_duration_seconds",
+		Help:      "Duration of key generation bootstrap steps",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"step"})
+
+	KMSCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kms_calls_total",
+		Help:      "KMS calls by operation and result",
+	}, []string{"operation", "result"})
+
+	KMSCallDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "kms_call_duration_seconds",
+		Help:      "Duration of KMS calls by operation",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+	}, []string{"operation"})
+)
+
+var registry = prometheus.NewRegistry()
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		SignRequests,
+		StageDuration,
+		BatchItems,
+		BootstrapStepDuration,
+		KMSCalls,
+		KMSCallDuration,
+	)
+}
+
+// Handler serves the metrics in Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ObserveSince observes seconds elapsed since start
+func ObserveSince(observer prometheus.Observer, start time.Time) {
+	observer.Observe(time.Since(start).Seconds())
+}
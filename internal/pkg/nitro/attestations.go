@@ -4,15 +4,14 @@ import (
 	"bytes"
 	"context"
 	"crypto/ecdsa"
+	"errors"
 	"fmt"
-	"os"
-	"path"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/kms"
 	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/storage"
 	"github.com/distributed-lab/enclave-extras/attestation"
-	"github.com/distributed-lab/enclave-extras/nsm"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
 )
@@ -32,54 +31,47 @@ const (
 	addressFile = "address.coses1"
 )
 
-func GetAttestedKMSKeyID(cfg aws.Config, attestationsPath string) (string, error) {
-	kmsKeyIDPath := path.Join(attestationsPath, kmsKeyIDFile)
+// PrivateFiles are stored documents only the owner may read, other
+// documents are public
+var PrivateFiles = []string{privateKeyFile}
 
-	_, pcr0Actual, err := nsm.DescribePCR(0)
-	if err != nil {
-		return "", fmt.Errorf("failed to get PCR0: %w", err)
-	}
+// ErrDocumentMismatch is returned if the stored attestation document is
+// malformed, isn't signed by the trusted root, was made by an enclave with
+// other measurements or doesn't attest the bootstrapped key
+var ErrDocumentMismatch = errors.New("stored attestation document mismatch")
 
-	kmsKeyIDAttestationDocRaw, err := os.ReadFile(kmsKeyIDPath)
+func GetAttestedKMSKeyID(kmsBackend KMSBackend, provider AttestationProvider, keyPolicy KeyPolicy, store storage.Storage) (string, error) {
+	kmsKeyIDAttestationDocRaw, err := store.Read(kmsKeyIDFile)
 	// if attestation document exist just read KMS Key ID
 	if err == nil {
-		kmsKeyIDAttestationDoc, err := attestation.ParseNSMAttestationDoc(kmsKeyIDAttestationDocRaw)
+		kmsKeyIDAttestationDoc, err := verifyStoredDoc(provider, keyPolicy, kmsKeyIDFile, kmsKeyIDAttestationDocRaw)
 		if err != nil {
-			return "", fmt.Errorf("failed to parse %s: %w", kmsKeyIDPath, err)
-		}
-
-		if err = kmsKeyIDAttestationDoc.Verify(); err != nil {
-			return "", fmt.Errorf("%s have invalid signature: %w", kmsKeyIDPath, err)
-		}
-
-		if pcr0Stored, ok := kmsKeyIDAttestationDoc.PCRs[0]; !ok || !bytes.Equal(pcr0Stored, pcr0Actual) {
-			return "", fmt.Errorf("PCR0 from %s mismatch with actual PCR0 value", kmsKeyIDPath)
+			return "", err
 		}
 
 		return string(kmsKeyIDAttestationDoc.UserData), nil
 	}
 
-	// if attestation document exists, but we can't open file
-	if !os.IsNotExist(err) {
-		return "", fmt.Errorf("failed to read %s, check file permissions. err: %w", kmsKeyIDPath, err)
+	// if attestation document exists, but we can't read it
+	if !errors.Is(err, storage.ErrNotExist) {
+		return "", fmt.Errorf("failed to read %s from %s: %w", kmsKeyIDFile, store, err)
 	}
 
-	rootArn, principalArn, err := GetArns(cfg)
+	kmsKeyPolicy, err := keyPolicy.Render(kmsBackend, provider)
 	if err != nil {
-		return "", fmt.Errorf("failed to get root and principal arns for kms key policy: %w", err)
+		return "", fmt.Errorf("failed to render kms key policy: %w", err)
 	}
 
-	kmsEnclaveClient, err := GetKMSEnclaveClient(cfg)
+	kmsEnclaveClient, err := newKMSClient(kmsBackend, provider)
 	if err != nil {
 		return "", fmt.Errorf("failed to get kms enclave client: %w", err)
 	}
 
-	kmsKeyPolicy := DefaultPolicies(rootArn, principalArn, map[int][]byte{0: pcr0Actual})
 	createKeyOutput, err := kmsEnclaveClient.CreateKey(context.Background(), &kms.CreateKeyInput{
-		// DANGER: The key may become unmanageable
-		BypassPolicyLockoutSafetyCheck: true,
+		BypassPolicyLockoutSafetyCheck: keyPolicy.SkipLockoutCheck(),
 		Description:                    aws.String("Nitro Enclave Key"),
 		Policy:                         aws.String(kmsKeyPolicy),
+		Tags:                           keyPolicy.KMSTags(),
 	})
 	if err != nil {
 		return "", fmt.Errorf("failed to create KMS key: %w", err)
@@ -87,44 +79,40 @@ func GetAttestedKMSKeyID(cfg aws.Config, attestationsPath string) (string, error
 
 	kmsKeyID := deref(createKeyOutput.KeyMetadata.KeyId)
 
+	if keyPolicy.Alias != "" {
+		_, err = kmsEnclaveClient.CreateAlias(context.Background(), &kms.CreateAliasInput{
+			AliasName:   aws.String(keyPolicy.Alias),
+			TargetKeyId: aws.String(kmsKeyID),
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to create alias %s for KMS key %s: %w", keyPolicy.Alias, kmsKeyID, err)
+		}
+	}
+
 	// Save KMS Key
-	kmsKeyIDAttestationDocRaw, err = nsm.GetAttestationDoc([]byte(kmsKeyID), nil, nil)
+	kmsKeyIDAttestationDocRaw, err = provider.GetAttestationDoc([]byte(kmsKeyID), nil, nil)
 	if err != nil {
-		return "", fmt.Errorf("failed to get attestation document for %s: %w", kmsKeyIDPath, err)
+		return "", fmt.Errorf("failed to get attestation document for %s: %w", kmsKeyIDFile, err)
 	}
-	if err = os.WriteFile(kmsKeyIDPath, kmsKeyIDAttestationDocRaw, 0644); err != nil {
-		return "", fmt.Errorf("failed to write %s: %w", kmsKeyIDPath, err)
+	if err = store.Write(kmsKeyIDFile, kmsKeyIDAttestationDocRaw); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", kmsKeyIDFile, err)
 	}
 
 	return kmsKeyID, nil
 }
 
-func GetAttestedPrivateKey(cfg aws.Config, kmsKeyID string, attestationsPath string) (*ecdsa.PrivateKey, error) {
-	kmsEnclaveClient, err := GetKMSEnclaveClient(cfg)
+func GetAttestedPrivateKey(kmsBackend KMSBackend, provider AttestationProvider, keyPolicy KeyPolicy, kmsKeyID string, store storage.Storage) (*ecdsa.PrivateKey, error) {
+	kmsEnclaveClient, err := newKMSClient(kmsBackend, provider)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get kms enclave client: %w", err)
 	}
 
-	privateKeyPath := path.Join(attestationsPath, privateKeyFile)
-	privateKeyAttestationDocRaw, err := os.ReadFile(privateKeyPath)
+	privateKeyAttestationDocRaw, err := store.Read(privateKeyFile)
 	// if attestation document exist just read and decrypt private key
 	if err == nil {
-		privateKeyAttestationDoc, err := attestation.ParseNSMAttestationDoc(privateKeyAttestationDocRaw)
+		privateKeyAttestationDoc, err := verifyStoredDoc(provider, keyPolicy, privateKeyFile, privateKeyAttestationDocRaw)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse %s: %w", privateKeyPath, err)
-		}
-
-		if err = privateKeyAttestationDoc.Verify(); err != nil {
-			return nil, fmt.Errorf("%s have invalid signature: %w", privateKeyPath, err)
-		}
-
-		_, pcr0Actual, err := nsm.DescribePCR(0)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get PCR0: %w", err)
-		}
-
-		if pcr0Stored, ok := privateKeyAttestationDoc.PCRs[0]; !ok || !bytes.Equal(pcr0Stored, pcr0Actual) {
-			return nil, fmt.Errorf("PCR0 from %s mismatch with actual PCR0 value", privateKeyPath)
+			return nil, err
 		}
 
 		decryptResp, err := kmsEnclaveClient.Decrypt(context.Background(), &kms.DecryptInput{
@@ -143,9 +131,9 @@ func GetAttestedPrivateKey(cfg aws.Config, kmsKeyID string, attestationsPath str
 		return privateKey, nil
 	}
 
-	// if attestation document exists, but we can't open file
-	if !os.IsNotExist(err) {
-		return nil, fmt.Errorf("failed to read %s, check file permissions. err: %w", privateKeyPath, err)
+	// if attestation document exists, but we can't read it
+	if !errors.Is(err, storage.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read %s from %s: %w", privateKeyFile, store, err)
 	}
 
 	// Create private key
@@ -163,69 +151,187 @@ func GetAttestedPrivateKey(cfg aws.Config, kmsKeyID string, attestationsPath str
 	}
 
 	// Save private key
-	privateKeyAttestationDocRaw, err = nsm.GetAttestationDoc(generateDataKeyPairResp.PrivateKeyCiphertextBlob, nil, nil)
+	privateKeyAttestationDocRaw, err = provider.GetAttestationDoc(generateDataKeyPairResp.PrivateKeyCiphertextBlob, nil, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get attestation doc for %s: %w", privateKeyPath, err)
+		return nil, fmt.Errorf("failed to get attestation doc for %s: %w", privateKeyFile, err)
 	}
-	if err = os.WriteFile(privateKeyPath, privateKeyAttestationDocRaw, 0600); err != nil {
-		return nil, fmt.Errorf("failed to write %s: %w", privateKeyPath, err)
+	if err = store.Write(privateKeyFile, privateKeyAttestationDocRaw); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", privateKeyFile, err)
 	}
 
 	return privateKey, nil
 }
 
-func GetAttestedPublicKey(privateKey *ecdsa.PrivateKey, attestationsPath string) (*ecdsa.PublicKey, error) {
-	publicKeyPath := path.Join(attestationsPath, publicKeyFile)
+// CheckGenerationKey decrypts the stored private key of the generation
+// with its KMS key and checks it is the loaded one. It fails once the KMS
+// key is disabled or the enclave is no longer allowed to decrypt with it.
+func CheckGenerationKey(kmsBackend KMSBackend, provider AttestationProvider, keyPolicy KeyPolicy, generation *KeyGeneration) error {
+	kmsEnclaveClient, err := newKMSClient(kmsBackend, provider)
+	if err != nil {
+		return fmt.Errorf("failed to get kms enclave client: %w", err)
+	}
+
+	raw, err := generation.Storage.Read(privateKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to read %s from %s: %w", privateKeyFile, generation.Storage, err)
+	}
+	privateKeyAttestationDoc, err := verifyStoredDoc(provider, keyPolicy, privateKeyFile, raw)
+	if err != nil {
+		return err
+	}
 
-	// if attestation document exist just read public key
-	_, err := os.ReadFile(publicKeyPath)
-	if err == nil {
-		return &privateKey.PublicKey, nil
+	decryptResp, err := kmsEnclaveClient.Decrypt(context.Background(), &kms.DecryptInput{
+		KeyId:          aws.String(generation.KMSKeyID),
+		CiphertextBlob: privateKeyAttestationDoc.UserData,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to decrypt private key: %w", err)
 	}
 
-	// if attestation document exists, but we can't open file
-	if !os.IsNotExist(err) {
-		return nil, fmt.Errorf("failed to read %s, check file permissions. err: %w", publicKeyPath, err)
+	privateKey, err := parsePKCS8ECPrivateKey(decryptResp.Plaintext)
+	if err != nil {
+		return fmt.Errorf("failed to parse secp256k1: %w", err)
 	}
+	if !bytes.Equal(crypto.FromECDSA(privateKey), crypto.FromECDSA(generation.PrivateKey)) {
+		return fmt.Errorf("%w: %s doesn't hold the loaded private key", ErrDocumentMismatch, privateKeyFile)
+	}
+
+	return nil
+}
 
+// GetAttestedPublicKey returns ErrDocumentMismatch if the stored document
+// doesn't attest the public key of the private key
+func GetAttestedPublicKey(provider AttestationProvider, keyPolicy KeyPolicy, privateKey *ecdsa.PrivateKey, store storage.Storage) (*ecdsa.PublicKey, error) {
 	publicKey := &privateKey.PublicKey
 
-	// Save public key
-	publicKeyAttestationDocRaw, err := nsm.GetAttestationDoc(crypto.FromECDSAPub(publicKey), nil, crypto.FromECDSAPub(publicKey))
-	if err != nil {
-		return nil, fmt.Errorf("failed to get attestation doc for %s: %w", publicKeyPath, err)
+	// if attestation document exist just check it
+	publicKeyAttestationDocRaw, err := store.Read(publicKeyFile)
+	if err == nil {
+		publicKeyAttestationDoc, err := verifyStoredDoc(provider, keyPolicy, publicKeyFile, publicKeyAttestationDocRaw)
+		if err != nil {
+			return nil, err
+		}
+
+		expected := crypto.FromECDSAPub(publicKey)
+		if !bytes.Equal(publicKeyAttestationDoc.UserData, expected) || !bytes.Equal(publicKeyAttestationDoc.PublicKey, expected) {
+			return nil, fmt.Errorf("%w: %s doesn't attest public key of the private key", ErrDocumentMismatch, publicKeyFile)
+		}
+
+		return publicKey, nil
 	}
-	if err = os.WriteFile(publicKeyPath, publicKeyAttestationDocRaw, 0600); err != nil {
-		return nil, fmt.Errorf("failed to write %s: %w", publicKeyPath, err)
+
+	// if attestation document exists, but we can't read it
+	if !errors.Is(err, storage.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read %s from %s: %w", publicKeyFile, store, err)
+	}
+
+	if err = writeAttestedPublicKey(provider, publicKey, store); err != nil {
+		return nil, err
 	}
 
 	return publicKey, nil
 }
 
-func GetAttestedAddress(publicKey *ecdsa.PublicKey, attestationsPath string) (common.Address, error) {
+// GetAttestedAddress returns ErrDocumentMismatch if the stored document
+// doesn't attest the address of the public key
+func GetAttestedAddress(provider AttestationProvider, keyPolicy KeyPolicy, publicKey *ecdsa.PublicKey, store storage.Storage) (common.Address, error) {
 	address := crypto.PubkeyToAddress(*publicKey)
 
-	addressPath := path.Join(attestationsPath, addressFile)
-
-	// if attestation document exist just read address
-	_, err := os.ReadFile(addressPath)
+	// if attestation document exist just check it
+	addressAttestationDocRaw, err := store.Read(addressFile)
 	if err == nil {
+		addressAttestationDoc, err := verifyStoredDoc(provider, keyPolicy, addressFile, addressAttestationDocRaw)
+		if err != nil {
+			return address, err
+		}
+
+		if !bytes.Equal(addressAttestationDoc.UserData, address[:]) {
+			return address, fmt.Errorf("%w: %s doesn't attest address of the public key", ErrDocumentMismatch, addressFile)
+		}
+
 		return address, nil
 	}
 
-	// if attestation document exists, but we can't open file
-	if !os.IsNotExist(err) {
-		return address, fmt.Errorf("failed to read %s, check file permissions. err: %w", addressPath, err)
+	// if attestation document exists, but we can't read it
+	if !errors.Is(err, storage.ErrNotExist) {
+		return address, fmt.Errorf("failed to read %s from %s: %w", addressFile, store, err)
 	}
 
-	// Save address
-	addressAttestationDocRaw, err := nsm.GetAttestationDoc(address[:], nil, nil)
+	if err = writeAttestedAddress(provider, address, store); err != nil {
+		return address, err
+	}
+
+	return address, nil
+}
+
+func writeAttestedPublicKey(provider AttestationProvider, publicKey *ecdsa.PublicKey, store storage.Storage) error {
+	publicKeyAttestationDocRaw, err := provider.GetAttestationDoc(crypto.FromECDSAPub(publicKey), nil, crypto.FromECDSAPub(publicKey))
 	if err != nil {
-		return address, fmt.Errorf("failed to get attestation doc for %s: %w", addressPath, err)
+		return fmt.Errorf("failed to get attestation doc for %s: %w", publicKeyFile, err)
 	}
-	if err = os.WriteFile(addressPath, addressAttestationDocRaw, 0600); err != nil {
-		return address, fmt.Errorf("failed to write %s: %w", addressPath, err)
+	if err = store.Write(publicKeyFile, publicKeyAttestationDocRaw); err != nil {
+		return fmt.Errorf("failed to write %s: %w", publicKeyFile, err)
 	}
 
-	return address, nil
+	return nil
+}
+
+func writeAttestedAddress(provider AttestationProvider, address common.Address, store storage.Storage) error {
+	addressAttestationDocRaw, err := provider.GetAttestationDoc(address[:], nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get attestation doc for %s: %w", addressFile, err)
+	}
+	if err = store.Write(addressFile, addressAttestationDocRaw); err != nil {
+		return fmt.Errorf("failed to write %s: %w", addressFile, err)
+	}
+
+	return nil
+}
+
+// verifyStoredDoc parses the stored document and checks it was made by the
+// enclave with the same measurements as the provider
+func verifyStoredDoc(provider AttestationProvider, keyPolicy KeyPolicy, file string, raw []byte) (*attestation.NSMAttestationDoc, error) {
+	doc, err := attestation.ParseNSMAttestationDoc(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to parse %s: %w", ErrDocumentMismatch, file, err)
+	}
+
+	if _, err = VerifyAttestationDoc(doc, provider.RootFingerprint()); err != nil {
+		return nil, fmt.Errorf("%w: %s have invalid signature: %w", ErrDocumentMismatch, file, err)
+	}
+
+	if err = keyPolicy.Check(provider, doc); err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrDocumentMismatch, file, err)
+	}
+
+	return doc, nil
+}
+
+// AttestationDocs are the documents proving the signer key was generated
+// within the enclave, as stored by the bootstrap
+type AttestationDocs struct {
+	KMSKeyID  []byte
+	PublicKey []byte
+	Address   []byte
+}
+
+// ReadAttestationDocs reads the public bootstrap documents. The private key
+// document is left out, it is of no use outside the enclave.
+func ReadAttestationDocs(store storage.Storage) (*AttestationDocs, error) {
+	var (
+		docs = &AttestationDocs{}
+		err  error
+	)
+
+	for file, doc := range map[string]*[]byte{
+		kmsKeyIDFile:  &docs.KMSKeyID,
+		publicKeyFile: &docs.PublicKey,
+		addressFile:   &docs.Address,
+	} {
+		if *doc, err = store.Read(file); err != nil {
+			return nil, fmt.Errorf("failed to read %s from %s: %w", file, store, err)
+		}
+	}
+
+	return docs, nil
 }
@@ -0,0 +1,282 @@
+package nitro
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/metrics"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/storage"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const (
+	// Prefix of key generations, each under its own prefix named by ID
+	generationsDir = "generations"
+	// Generation metadata, written once the generation is bootstrapped
+	generationFile = "generation.json"
+	// Claims generation ID, so concurrently created generations don't mix
+	claimFile = ".claim"
+	// Keys bootstrapped before generations were introduced are stored
+	// directly in the attestations storage and get this ID
+	LegacyGenerationID = "0"
+)
+
+var ErrNoGenerations = errors.New("no key generations found")
+
+// GenerationMeta describes a stored key generation
+type GenerationMeta struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	// Storage with attestation documents of the generation
+	Storage storage.Storage `json:"-"`
+}
+
+// KeyGeneration is a signer key with its attestation documents. Private key
+// is absent for generations loaded only to verify signatures.
+type KeyGeneration struct {
+	GenerationMeta
+	KMSKeyID   string
+	PrivateKey *ecdsa.PrivateKey
+	Address    common.Address
+	// Documents regenerated on load because of mismatch
+	Regenerated []string
+}
+
+func (g *KeyGeneration) Sign(data []byte) ([]byte, error) {
+	if g.PrivateKey == nil {
+		return nil, fmt.Errorf("key generation %s is not allowed to sign", g.ID)
+	}
+
+	return crypto.Sign(data, g.PrivateKey)
+}
+
+// ListGenerations returns generations sorted from the oldest to the newest.
+// Partially created generations are never listed, as their metadata is
+// written only when they are complete.
+func ListGenerations(store storage.Storage) ([]GenerationMeta, error) {
+	var generations []GenerationMeta
+
+	legacyCreatedAt, err := store.ModTime(addressFile)
+	if err == nil {
+		generations = append(generations, GenerationMeta{
+			ID:        LegacyGenerationID,
+			CreatedAt: legacyCreatedAt,
+			Storage:   store,
+		})
+	} else if !errors.Is(err, storage.ErrNotExist) {
+		return nil, fmt.Errorf("failed to stat legacy generation: %w", err)
+	}
+
+	names, err := store.List(generationsDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list generations: %w", err)
+	}
+
+	for _, name := range names {
+		id, file, ok := generationObject(name)
+		if !ok || file != generationFile {
+			continue
+		}
+
+		generationStorage := storage.Sub(store, path.Join(generationsDir, id))
+		raw, err := generationStorage.Read(generationFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read generation %s: %w", id, err)
+		}
+
+		var meta GenerationMeta
+		if err = json.Unmarshal(raw, &meta); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal generation %s: %w", id, err)
+		}
+		if meta.ID != id {
+			return nil, fmt.Errorf("generation %s has mismatched ID %s", id, meta.ID)
+		}
+		meta.Storage = generationStorage
+
+		generations = append(generations, meta)
+	}
+
+	sort.Slice(generations, func(i, j int) bool {
+		a, _ := strconv.ParseUint(generations[i].ID, 10, 64)
+		b, _ := strconv.ParseUint(generations[j].ID, 10, 64)
+		return a < b
+	})
+
+	return generations, nil
+}
+
+// generationObject splits generations/<id>/<file> object name
+func generationObject(name string) (id string, file string, ok bool) {
+	parts := strings.Split(name, "/")
+	if len(parts) != 3 || parts[0] != generationsDir {
+		return "", "", false
+	}
+	if _, err := strconv.ParseUint(parts[1], 10, 64); err != nil {
+		return "", "", false
+	}
+
+	return parts[1], parts[2], true
+}
+
+// OnMismatch is what loading does if a stored public-facing document, i.e.
+// public_key.coses1 or address.coses1, doesn't match the decrypted key.
+// KMS Key ID and private key documents can't be regenerated.
+type OnMismatch string
+
+const (
+	OnMismatchFail       OnMismatch = "fail"
+	OnMismatchRegenerate OnMismatch = "regenerate"
+)
+
+// LoadGeneration decrypts the generation private key, so it can sign. All
+// stored documents are cross-checked with the key and actual measurements.
+func LoadGeneration(kmsBackend KMSBackend, provider AttestationProvider, keyPolicy KeyPolicy, onMismatch OnMismatch, meta GenerationMeta) (*KeyGeneration, error) {
+	start := time.Now()
+	kmsKeyID, err := GetAttestedKMSKeyID(kmsBackend, provider, keyPolicy, meta.Storage)
+	metrics.ObserveSince(metrics.BootstrapStepDuration.WithLabelValues(metrics.StepKMSKeyID), start)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attested KMS Key ID: %w", err)
+	}
+
+	start = time.Now()
+	privateKey, err := GetAttestedPrivateKey(kmsBackend, provider, keyPolicy, kmsKeyID, meta.Storage)
+	metrics.ObserveSince(metrics.BootstrapStepDuration.WithLabelValues(metrics.StepPrivateKey), start)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attested private key: %w", err)
+	}
+
+	var regenerated []string
+
+	start = time.Now()
+	publicKey, err := GetAttestedPublicKey(provider, keyPolicy, privateKey, meta.Storage)
+	if errors.Is(err, ErrDocumentMismatch) && onMismatch == OnMismatchRegenerate {
+		publicKey = &privateKey.PublicKey
+		if err = writeAttestedPublicKey(provider, publicKey, meta.Storage); err == nil {
+			regenerated = append(regenerated, publicKeyFile)
+		}
+	}
+	metrics.ObserveSince(metrics.BootstrapStepDuration.WithLabelValues(metrics.StepPublicKey), start)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attested public key: %w", err)
+	}
+
+	start = time.Now()
+	address, err := GetAttestedAddress(provider, keyPolicy, publicKey, meta.Storage)
+	if errors.Is(err, ErrDocumentMismatch) && onMismatch == OnMismatchRegenerate {
+		if err = writeAttestedAddress(provider, address, meta.Storage); err == nil {
+			regenerated = append(regenerated, addressFile)
+		}
+	}
+	metrics.ObserveSince(metrics.BootstrapStepDuration.WithLabelValues(metrics.StepAddress), start)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attested address: %w", err)
+	}
+
+	return &KeyGeneration{
+		GenerationMeta: meta,
+		KMSKeyID:       kmsKeyID,
+		PrivateKey:     privateKey,
+		Address:        address,
+		Regenerated:    regenerated,
+	}, nil
+}
+
+// LoadGenerationPublic reads the generation address from its attestation
+// documents without access to KMS, so it can only verify signatures. The
+// documents must pass the key policy check, like LoadGeneration requires.
+func LoadGenerationPublic(provider AttestationProvider, keyPolicy KeyPolicy, meta GenerationMeta) (*KeyGeneration, error) {
+	docs, err := ReadAttestationDocs(meta.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	generation := &KeyGeneration{GenerationMeta: meta}
+	for file, raw := range map[string][]byte{
+		kmsKeyIDFile: docs.KMSKeyID,
+		addressFile:  docs.Address,
+	} {
+		doc, err := verifyStoredDoc(provider, keyPolicy, file, raw)
+		if err != nil {
+			return nil, fmt.Errorf("generation %s: %w", meta.ID, err)
+		}
+
+		if file == kmsKeyIDFile {
+			generation.KMSKeyID = string(doc.UserData)
+			continue
+		}
+		if len(doc.UserData) != common.AddressLength {
+			return nil, fmt.Errorf("%s of generation %s has invalid address", file, meta.ID)
+		}
+		generation.Address = common.BytesToAddress(doc.UserData)
+	}
+
+	return generation, nil
+}
+
+// CreateGeneration bootstraps a new key generation with the next ID. The
+// KMS key of the latest generation is reused, if any. Generation is listed
+// only after it is bootstrapped completely.
+func CreateGeneration(kmsBackend KMSBackend, provider AttestationProvider, keyPolicy KeyPolicy, onMismatch OnMismatch, store storage.Storage) (*KeyGeneration, error) {
+	generations, err := ListGenerations(store)
+	if err != nil {
+		return nil, err
+	}
+
+	// Generations claimed by crashed or concurrent bootstraps are skipped
+	names, err := store.List(generationsDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list generations: %w", err)
+	}
+
+	var nextID uint64 = 1
+	for _, name := range names {
+		if id, _, ok := generationObject(name); ok {
+			claimedID, _ := strconv.ParseUint(id, 10, 64)
+			nextID = max(nextID, claimedID+1)
+		}
+	}
+
+	meta := GenerationMeta{
+		ID:        strconv.FormatUint(nextID, 10),
+		CreatedAt: time.Now().UTC(),
+		Storage:   storage.Sub(store, path.Join(generationsDir, strconv.FormatUint(nextID, 10))),
+	}
+
+	if err = meta.Storage.Create(claimFile, nil); err != nil {
+		return nil, fmt.Errorf("failed to claim generation %s: %w", meta.ID, err)
+	}
+
+	if len(generations) != 0 {
+		latest := generations[len(generations)-1]
+		kmsKeyIDDoc, err := latest.Storage.Read(kmsKeyIDFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read KMS Key ID of generation %s: %w", latest.ID, err)
+		}
+		if err = meta.Storage.Write(kmsKeyIDFile, kmsKeyIDDoc); err != nil {
+			return nil, fmt.Errorf("failed to copy KMS Key ID of generation %s: %w", latest.ID, err)
+		}
+	}
+
+	generation, err := LoadGeneration(kmsBackend, provider, keyPolicy, onMismatch, meta)
+	if err != nil {
+		return nil, fmt.Errorf("failed to bootstrap generation %s: %w", meta.ID, err)
+	}
+
+	raw, err := json.Marshal(meta)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal generation %s: %w", meta.ID, err)
+	}
+	if err = meta.Storage.Create(generationFile, raw); err != nil {
+		return nil, fmt.Errorf("failed to publish generation %s: %w", meta.ID, err)
+	}
+
+	return generation, nil
+}
@@ -0,0 +1,53 @@
+package nitro
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// KMSClient is the subset of KMS API used by the signer bootstrap.
+// Calls returning plaintext must be bound to the recipient attestation
+// document, so plaintext is released only to the attested enclave.
+type KMSClient interface {
+	CreateKey(ctx context.Context, params *kms.CreateKeyInput, optFns ...func(*kms.Options)) (*kms.CreateKeyOutput, error)
+	GenerateDataKeyPair(ctx context.Context, params *kms.GenerateDataKeyPairInput, optFns ...func(*kms.Options)) (*kms.GenerateDataKeyPairOutput, error)
+	Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error)
+	CreateAlias(ctx context.Context, params *kms.CreateAliasInput, optFns ...func(*kms.Options)) (*kms.CreateAliasOutput, error)
+	GetKeyPolicy(ctx context.Context, params *kms.GetKeyPolicyInput, optFns ...func(*kms.Options)) (*kms.GetKeyPolicyOutput, error)
+	PutKeyPolicy(ctx context.Context, params *kms.PutKeyPolicyInput, optFns ...func(*kms.Options)) (*kms.PutKeyPolicyOutput, error)
+}
+
+// KMSBackend creates KMS clients and resolves principals for the key policy.
+type KMSBackend interface {
+	// GetCallerArn returns ARN of the caller, it may be STS assumed-role ARN
+	GetCallerArn() (string, error)
+	// NewClient returns KMS client that attaches attestation
+	// document of the provider as recipient of plaintext
+	NewClient(provider AttestationProvider) (KMSClient, error)
+	// NewAdminClient returns KMS client of the caller without recipient,
+	// for key admin commands run outside the enclave
+	NewAdminClient() (KMSClient, error)
+}
+
+// AWSKMSBackend is KMSBackend backed by AWS KMS and STS.
+type AWSKMSBackend struct {
+	cfg aws.Config
+}
+
+func NewAWSKMSBackend(cfg aws.Config) *AWSKMSBackend {
+	return &AWSKMSBackend{cfg: cfg}
+}
+
+func (b *AWSKMSBackend) GetCallerArn() (string, error) {
+	return GetCallerArn(b.cfg)
+}
+
+func (b *AWSKMSBackend) NewClient(provider AttestationProvider) (KMSClient, error) {
+	return GetKMSEnclaveClient(b.cfg, provider)
+}
+
+func (b *AWSKMSBackend) NewAdminClient() (KMSClient, error) {
+	return kms.NewFromConfig(b.cfg), nil
+}
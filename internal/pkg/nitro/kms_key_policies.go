@@ -1,63 +1,710 @@
 package nitro
 
 import (
+	"bytes"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/distributed-lab/enclave-extras/attestation"
 )
 
+// Condition on SHA-384 hash of the enclave image, i.e. PCR0
+const ImageSha384Condition = "kms:RecipientAttestation:ImageSha384"
+
+// Condition on alias name in CreateAlias requests
+const AliasNameCondition = "kms:AliasName"
+
+// Prefix of condition keys on the recipient enclave measurements
+const recipientAttestationPrefix = "kms:RecipientAttestation:"
+
 // Return PCRx condition to be used when creating a KMS key
 func PcrXCondition(pcrIndex int) string {
 	return fmt.Sprintf("kms:RecipientAttestation:PCR%d", pcrIndex)
 }
 
-func DefaultPolicies(rootARN, principalARN string, pcrs map[int][]byte) string {
-	pcrConditions := make(map[string]string, len(pcrs))
-	for k, v := range pcrs {
-		pcrConditions[PcrXCondition(k)] = hex.EncodeToString(v)
-	}
-
-	defaultPolicy := map[string]interface{}{
-		"Version": "2012-10-17",
-		"Id":      "key-default-1",
-		"Statement": []map[string]interface{}{
-			{
-				"Sid":    "Allow access for Key Administrators",
-				"Effect": "Allow",
-				"Principal": map[string]interface{}{
-					"AWS": rootARN,
-				},
-				"Action": []string{
-					"kms:CancelKeyDeletion",
-					"kms:DescribeKey",
-					"kms:DisableKey",
-					"kms:EnableKey",
-					"kms:GetKeyPolicy",
-					"kms:ScheduleKeyDeletion",
-				},
-				"Resource": "*",
+// KeyPolicyPCRs are PCRs the KMS key policy can be bound to
+var KeyPolicyPCRs = []int{0, 1, 2, 3, 4, 8}
+
+// Actions releasing key material or letting to change who it is released to.
+// They must be granted only on the enclave measurements.
+var keyPolicySensitiveActions = []string{
+	"kms:Decrypt",
+	"kms:GenerateDataKey",
+	"kms:GenerateDataKeyPair",
+	"kms:ReEncryptFrom",
+	"kms:ReEncryptTo",
+	"kms:PutKeyPolicy",
+	"kms:CreateGrant",
+}
+
+// Sensitive actions the explicitly configured admins may be granted without
+// the enclave measurements, so they can authorize and retire images
+var keyPolicyAdminActions = []string{
+	"kms:PutKeyPolicy",
+}
+
+var aliasRegexp = regexp.MustCompile(`^alias/[a-zA-Z0-9/_-]{1,250}$`)
+
+// DefaultKeyPolicyTemplate grants key management to the admins and key usage
+// to the enclave principal on measurements of the authorized images only.
+// Every image has its own statement, so measurements of different images
+// can't be mixed. KMS evaluates kms:RecipientAttestation:* only in requests
+// with a recipient, so the enclave reads the policy without conditions and
+// only the explicitly configured admins update it.
+const DefaultKeyPolicyTemplate = `{
+  "Version": "2012-10-17",
+  "Id": "key-default-1",
+  "Statement": [
+    {
+      "Sid": "Allow access for Key Administrators",
+      "Effect": "Allow",
+      "Principal": {"AWS": {{ json .AdminArns }}},
+      "Action": [
+        "kms:CancelKeyDeletion",
+        "kms:DescribeKey",
+        "kms:DisableKey",
+        "kms:EnableKey",
+        "kms:GetKeyPolicy",
+        "kms:ScheduleKeyDeletion"
+      ],
+      "Resource": "*"
+    },
+{{- if .PolicyAdminArns }}
+    {
+      "Sid": "Allow Key Administrators to update the key policy",
+      "Effect": "Allow",
+      "Principal": {"AWS": {{ json .PolicyAdminArns }}},
+      "Action": "kms:PutKeyPolicy",
+      "Resource": "*"
+    },
+{{- end }}
+{{- range $i, $conditions := .Images }}
+    {
+      "Sid": "Enable enclave{{ if $i }} image {{ $i }}{{ end }}",
+      "Effect": "Allow",
+      "Principal": {"AWS": {{ json $.PrincipalArn }}},
+      "Action": [
+        "kms:Decrypt",
+        "kms:GenerateRandom",
+        "kms:GenerateDataKey",
+        "kms:GenerateDataKeyPair"
+      ],
+      "Resource": "*",
+      "Condition": {"StringEqualsIgnoreCase": {{ json $conditions }}}
+    },
+{{- end }}
+    {
+      "Sid": "Allow enclave to read the key policy",
+      "Effect": "Allow",
+      "Principal": {"AWS": {{ json .PrincipalArn }}},
+      "Action": "kms:GetKeyPolicy",
+      "Resource": "*"
+    }{{ if .Alias }},
+    {
+      "Sid": "Allow enclave to create alias",
+      "Effect": "Allow",
+      "Principal": {"AWS": {{ json .PrincipalArn }}},
+      "Action": "kms:CreateAlias",
+      "Resource": "*",
+      "Condition": {"StringEquals": {"kms:AliasName": {{ json .Alias }}}}
+    }{{ end }}
+  ]
+}
+`
+
+var defaultKeyPolicyTemplate = template.Must(ParseKeyPolicyTemplate(DefaultKeyPolicyTemplate))
+
+// KeyPolicyParams are placeholders available in the key policy template
+type KeyPolicyParams struct {
+	// Principals allowed to manage the key
+	AdminArns []string
+	// Principals allowed to update the key policy, the explicitly configured
+	// admins only. Empty if the admins are the default account root.
+	PolicyAdminArns []string
+	// IAM principal of the enclave
+	PrincipalArn string
+	// kms:RecipientAttestation:* conditions with actual values of the
+	// enclave, or of the first image if rendered by the admins
+	Conditions map[string]string
+	// Conditions of every authorized image, the running one is the first
+	Images []map[string]string
+	Alias  string
+	Tags   map[string]string
+}
+
+// ParseKeyPolicyTemplate parses text/template of the key policy. Besides
+// builtins, json function is available to put values as JSON and inc to
+// increment an index.
+func ParseKeyPolicyTemplate(text string) (*template.Template, error) {
+	return template.New("key_policy").
+		Option("missingkey=error").
+		Funcs(template.FuncMap{
+			"json": func(v any) (string, error) {
+				raw, err := json.Marshal(v)
+				return string(raw), err
 			},
-			{
-				"Sid":    "Enable enclave",
-				"Effect": "Allow",
-				"Principal": map[string]interface{}{
-					"AWS": principalARN,
-				},
-				"Action": []string{
-					"kms:Decrypt",
-					"kms:GenerateRandom",
-					"kms:GenerateDataKey",
-					"kms:GenerateDataKeyPair",
-				},
-				"Resource": "*",
-				"Condition": map[string]interface{}{
-					"StringEqualsIgnoreCase": pcrConditions,
-				},
+			"inc": func(i int) int {
+				return i + 1
 			},
-		},
+		}).
+		Parse(text)
+}
+
+// KeyPolicy selects measurements of the enclave the KMS key is bound to and
+// how the key policy is rendered. PCR0 is always included.
+type KeyPolicy struct {
+	PCRs []int
+	// Bind to kms:RecipientAttestation:ImageSha384 as well, it is equal to PCR0
+	ImageSha384 bool
+
+	// Principals allowed to manage the key, may belong to other accounts.
+	// Only these may update the key policy, and with it release the key to
+	// anyone. Account root of the enclave principal if empty, then nobody
+	// may update the policy: the root would let any principal of the
+	// account allowed by IAM rewrite it.
+	AdminArns []string
+	// Skip the KMS lockout check on key creation. KMS refuses to create the
+	// key unless the enclave role is allowed to update its policy, i.e. some
+	// admin is the root of the enclave role account. Always skipped without
+	// admins, as the policy is not to be updated then.
+	BypassLockoutCheck bool
+	// Path of the enclave role, STS doesn't report it in assumed-role ARN
+	RolePath string
+	// Key policy template, DefaultKeyPolicyTemplate if nil
+	Template *template.Template
+	// Alias created for the key, optional
+	Alias string
+	// Tags the key is created with
+	Tags map[string]string
+	// Conditions of other images authorized besides the running one, see
+	// AuthorizeImage. Their stored documents pass Check.
+	Images []map[string]string
+}
+
+// NewKeyPolicy validates PCR indexes and adds PCR0 if absent
+func NewKeyPolicy(pcrs []int, imageSha384 bool) (KeyPolicy, error) {
+	policy := KeyPolicy{PCRs: []int{0}, ImageSha384: imageSha384}
+	for _, index := range pcrs {
+		if !slices.Contains(KeyPolicyPCRs, index) {
+			return KeyPolicy{}, fmt.Errorf("PCR%d can't be used in key policy, must be one of %v", index, KeyPolicyPCRs)
+		}
+		if !slices.Contains(policy.PCRs, index) {
+			policy.PCRs = append(policy.PCRs, index)
+		}
+	}
+	slices.Sort(policy.PCRs)
+
+	return policy, nil
+}
+
+// Validate checks admin ARNs, role path and alias
+func (p KeyPolicy) Validate() error {
+	for _, adminArn := range p.AdminArns {
+		if err := validateAdminArn(adminArn); err != nil {
+			return fmt.Errorf("invalid admin ARN %s: %w", adminArn, err)
+		}
+	}
+
+	if p.RolePath != "" && (!strings.HasPrefix(p.RolePath, "/") || !strings.HasSuffix(p.RolePath, "/")) {
+		return fmt.Errorf("role path %s must begin and end with /", p.RolePath)
+	}
+
+	if p.Alias != "" && (!aliasRegexp.MatchString(p.Alias) || strings.HasPrefix(p.Alias, "alias/aws/")) {
+		return fmt.Errorf("invalid alias %s, must be alias/<name> and not AWS managed", p.Alias)
+	}
+
+	return nil
+}
+
+// Conditions returns key policy conditions with actual values of the provider
+func (p KeyPolicy) Conditions(provider AttestationProvider) (map[string]string, error) {
+	pcrs := make(map[int][]byte, len(p.PCRs))
+	for _, index := range p.PCRs {
+		pcr, err := provider.DescribePCR(index)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get PCR%d: %w", index, err)
+		}
+		pcrs[index] = pcr
+	}
+
+	return p.ImageConditions(pcrs)
+}
+
+// ImageConditions returns key policy conditions of the image with the PCRs
+func (p KeyPolicy) ImageConditions(pcrs map[int][]byte) (map[string]string, error) {
+	conditions := make(map[string]string, len(p.PCRs)+1)
+	for _, index := range p.PCRs {
+		pcr, ok := pcrs[index]
+		if !ok {
+			return nil, fmt.Errorf("PCR%d of the image is missing", index)
+		}
+		conditions[PcrXCondition(index)] = hex.EncodeToString(pcr)
+
+		if index == 0 && p.ImageSha384 {
+			conditions[ImageSha384Condition] = hex.EncodeToString(pcr)
+		}
+	}
+
+	return conditions, nil
+}
+
+// Check ensures the stored document was made by the enclave with the same
+// measurements as the provider or by one of the other authorized images
+func (p KeyPolicy) Check(provider AttestationProvider, doc *attestation.NSMAttestationDoc) error {
+	var mismatch error
+	for _, index := range p.PCRs {
+		actual, err := provider.DescribePCR(index)
+		if err != nil {
+			return fmt.Errorf("failed to get PCR%d: %w", index, err)
+		}
+
+		if stored, ok := doc.PCRs[index]; !ok || !bytes.Equal(stored, actual) {
+			mismatch = fmt.Errorf("PCR%d mismatch with actual PCR%d value", index, index)
+			break
+		}
+	}
+	if mismatch == nil {
+		return nil
+	}
+
+	for _, image := range p.Images {
+		if p.matchesImage(image, doc) {
+			return nil
+		}
+	}
+
+	return mismatch
+}
+
+func (p KeyPolicy) matchesImage(image map[string]string, doc *attestation.NSMAttestationDoc) bool {
+	for _, index := range p.PCRs {
+		stored, ok := doc.PCRs[index]
+		if !ok || !strings.EqualFold(image[PcrXCondition(index)], hex.EncodeToString(stored)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Params resolves placeholders of the key policy template
+func (p KeyPolicy) Params(kmsBackend KMSBackend, provider AttestationProvider) (*KeyPolicyParams, error) {
+	callerArn, err := kmsBackend.GetCallerArn()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller arn: %w", err)
+	}
+
+	principalArn, err := EnsureArnIsIam(callerArn, p.RolePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to cast arn: %w", err)
+	}
+
+	conditions, err := p.Conditions(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.imageParams(principalArn, append([]map[string]string{conditions}, p.Images...))
+}
+
+// imageParams resolves placeholders of the key policy template for the
+// enclave principal and the images, the first one is put as the running one
+func (p KeyPolicy) imageParams(principalArn string, authorized []map[string]string) (*KeyPolicyParams, error) {
+	if len(authorized) == 0 {
+		return nil, fmt.Errorf("no authorized images")
+	}
+
+	adminArns, policyAdminArns := p.AdminArns, p.AdminArns
+	if len(adminArns) == 0 {
+		rootArn, err := ToRootArn(principalArn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to make root arn: %w", err)
+		}
+		adminArns = []string{rootArn}
+	}
+
+	var images []map[string]string
+	for _, image := range authorized {
+		if !slices.ContainsFunc(images, func(other map[string]string) bool { return maps.Equal(image, other) }) {
+			images = append(images, image)
+		}
+	}
+
+	tags := p.Tags
+	if tags == nil {
+		tags = map[string]string{}
+	}
+
+	return &KeyPolicyParams{
+		AdminArns:       adminArns,
+		PolicyAdminArns: policyAdminArns,
+		PrincipalArn:    principalArn,
+		Conditions:      images[0],
+		Images:          images,
+		Alias:           p.Alias,
+		Tags:            tags,
+	}, nil
+}
+
+// Render returns the key policy the enclave of the provider creates its key
+// with. Policy is validated, so the admins keep access to the key.
+func (p KeyPolicy) Render(kmsBackend KMSBackend, provider AttestationProvider) (string, error) {
+	params, err := p.Params(kmsBackend, provider)
+	if err != nil {
+		return "", err
+	}
+
+	return p.render(params)
+}
+
+// RenderImages returns the key policy releasing the key to the enclave
+// principal on the images. It is rendered by the admins outside the
+// enclave, so the first image takes place of the running one.
+func (p KeyPolicy) RenderImages(principalArn string, images []map[string]string) (string, error) {
+	params, err := p.imageParams(principalArn, images)
+	if err != nil {
+		return "", err
+	}
+
+	return p.render(params)
+}
+
+func (p KeyPolicy) render(params *KeyPolicyParams) (string, error) {
+	tmpl := p.Template
+	if tmpl == nil {
+		tmpl = defaultKeyPolicyTemplate
+	}
+
+	var policy bytes.Buffer
+	if err := tmpl.Execute(&policy, params); err != nil {
+		return "", fmt.Errorf("failed to render key policy: %w", err)
+	}
+
+	if err := ValidateKeyPolicy(policy.String(), params); err != nil {
+		return "", fmt.Errorf("unsafe key policy: %w", err)
+	}
+
+	return policy.String(), nil
+}
+
+// SkipLockoutCheck reports whether the key is created without the KMS
+// lockout check, see BypassLockoutCheck
+func (p KeyPolicy) SkipLockoutCheck() bool {
+	return p.BypassLockoutCheck || len(p.AdminArns) == 0
+}
+
+// KMSTags returns tags in the KMS API form, sorted by key
+func (p KeyPolicy) KMSTags() []kmstypes.Tag {
+	tags := make([]kmstypes.Tag, 0, len(p.Tags))
+	for key, value := range p.Tags {
+		tags = append(tags, kmstypes.Tag{TagKey: aws.String(key), TagValue: aws.String(value)})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return *tags[i].TagKey < *tags[j].TagKey
+	})
+
+	return tags
+}
+
+type keyPolicyDocument struct {
+	Version   string               `json:"Version"`
+	Statement []keyPolicyStatement `json:"Statement"`
+}
+
+type keyPolicyStatement struct {
+	Sid          string                                `json:"Sid"`
+	Effect       string                                `json:"Effect"`
+	Principal    json.RawMessage                       `json:"Principal"`
+	NotPrincipal json.RawMessage                       `json:"NotPrincipal"`
+	Action       stringOrSlice                         `json:"Action"`
+	NotAction    json.RawMessage                       `json:"NotAction"`
+	Condition    map[string]map[string]json.RawMessage `json:"Condition"`
+}
+
+type stringOrSlice []string
+
+func (s *stringOrSlice) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*s = stringOrSlice{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return fmt.Errorf("must be string or array of strings: %w", err)
+	}
+	*s = multiple
+
+	return nil
+}
+
+// ValidateKeyPolicy ensures the key policy can't release the key to anyone
+// but the enclave principal with measurements of one of the authorized
+// images, and
+// that every admin is granted something, so the key doesn't become
+// unmanageable
+func ValidateKeyPolicy(policy string, params *KeyPolicyParams) error {
+	var doc keyPolicyDocument
+	if err := json.Unmarshal([]byte(policy), &doc); err != nil {
+		return fmt.Errorf("failed to unmarshal key policy: %w", err)
+	}
+	if doc.Version != "2012-10-17" {
+		return fmt.Errorf("unsupported key policy version %q", doc.Version)
+	}
+	if len(doc.Statement) == 0 {
+		return fmt.Errorf("key policy has no statements")
+	}
+
+	grantedAdmins := make(map[string]bool, len(params.AdminArns))
+	for i, statement := range doc.Statement {
+		name := statement.Sid
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+		}
+
+		if statement.NotPrincipal != nil || statement.NotAction != nil {
+			return fmt.Errorf("statement %s: NotPrincipal and NotAction are not supported", name)
+		}
+		if statement.Effect != "Allow" {
+			continue
+		}
+
+		principals, err := keyPolicyPrincipals(statement.Principal)
+		if err != nil {
+			return fmt.Errorf("statement %s: %w", name, err)
+		}
+		for _, principal := range principals {
+			grantedAdmins[principal] = true
+		}
+
+		var exempt []string
+		if len(principals) > 0 && isSubset(principals, params.PolicyAdminArns) {
+			exempt = keyPolicyAdminActions
+		}
+
+		action, ok := sensitiveAction(statement.Action, exempt)
+		if !ok {
+			continue
+		}
+		if key := missingImageCondition(statement.Condition, params.Images); key != "" {
+			return fmt.Errorf("statement %s grants %s without %s condition", name, action, key)
+		}
+		if len(principals) == 0 {
+			return fmt.Errorf("statement %s grants %s to non-AWS principal", name, action)
+		}
+		for _, principal := range principals {
+			if principal != params.PrincipalArn {
+				return fmt.Errorf("statement %s grants %s to %s, not the enclave principal", name, action, principal)
+			}
+		}
+	}
+
+	for _, adminArn := range params.AdminArns {
+		if !grantedAdmins[adminArn] {
+			return fmt.Errorf("admin %s is not granted by any statement", adminArn)
+		}
+	}
+
+	return nil
+}
+
+// missingImageCondition returns a condition key of the closest image the
+// statement lacks, or empty string if the statement has all conditions of
+// some image
+func missingImageCondition(conditions map[string]map[string]json.RawMessage, images []map[string]string) string {
+	missing, matched := "", -1
+	for _, image := range images {
+		count, first := 0, ""
+		for _, key := range slices.Sorted(maps.Keys(image)) {
+			if hasCondition(conditions, key, image[key]) {
+				count++
+			} else if first == "" {
+				first = key
+			}
+		}
+
+		if first == "" {
+			return ""
+		}
+		if count > matched {
+			missing, matched = first, count
+		}
+	}
+
+	return missing
+}
+
+// KeyPolicyImages returns conditions of the images the key policy releases
+// the key to, i.e. kms:RecipientAttestation:* conditions of statements
+// allowing kms:Decrypt
+func KeyPolicyImages(policy string) ([]map[string]string, error) {
+	var doc keyPolicyDocument
+	if err := json.Unmarshal([]byte(policy), &doc); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal key policy: %w", err)
+	}
+
+	var images []map[string]string
+	for _, statement := range doc.Statement {
+		if statement.Effect != "Allow" || !grantsAction(statement.Action, "kms:Decrypt") {
+			continue
+		}
+
+		image := make(map[string]string)
+		for _, operator := range []string{"StringEquals", "StringEqualsIgnoreCase"} {
+			for key, raw := range statement.Condition[operator] {
+				var values stringOrSlice
+				if !strings.HasPrefix(key, recipientAttestationPrefix) || json.Unmarshal(raw, &values) != nil || len(values) != 1 {
+					continue
+				}
+				image[key] = strings.ToLower(values[0])
+			}
+		}
+
+		if _, ok := image[PcrXCondition(0)]; !ok {
+			continue
+		}
+		if !slices.ContainsFunc(images, func(other map[string]string) bool { return maps.Equal(image, other) }) {
+			images = append(images, image)
+		}
+	}
+
+	return images, nil
+}
+
+// KeyPolicyPrincipal returns the enclave principal of the key policy, i.e.
+// the only principal of statements allowing kms:Decrypt
+func KeyPolicyPrincipal(policy string) (string, error) {
+	var doc keyPolicyDocument
+	if err := json.Unmarshal([]byte(policy), &doc); err != nil {
+		return "", fmt.Errorf("failed to unmarshal key policy: %w", err)
+	}
+
+	var principalArn string
+	for _, statement := range doc.Statement {
+		if statement.Effect != "Allow" || !grantsAction(statement.Action, "kms:Decrypt") {
+			continue
+		}
+
+		principals, err := keyPolicyPrincipals(statement.Principal)
+		if err != nil {
+			return "", err
+		}
+		for _, principal := range principals {
+			if principalArn != "" && principal != principalArn {
+				return "", fmt.Errorf("kms:Decrypt is allowed to both %s and %s", principalArn, principal)
+			}
+			principalArn = principal
+		}
+	}
+	if principalArn == "" {
+		return "", fmt.Errorf("kms:Decrypt is allowed to no principal")
+	}
+
+	return principalArn, nil
+}
+
+func grantsAction(actions []string, action string) bool {
+	for _, granted := range actions {
+		if strings.EqualFold(granted, action) || granted == "*" {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(granted, "*"); ok && strings.HasPrefix(strings.ToLower(action), strings.ToLower(prefix)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// keyPolicyPrincipals returns AWS principals of the statement, "*" included
+func keyPolicyPrincipals(raw json.RawMessage) ([]string, error) {
+	var wildcard string
+	if err := json.Unmarshal(raw, &wildcard); err == nil {
+		return []string{wildcard}, nil
+	}
+
+	var principal struct {
+		AWS stringOrSlice `json:"AWS"`
+	}
+	if err := json.Unmarshal(raw, &principal); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal principal: %w", err)
+	}
+
+	return principal.AWS, nil
+}
+
+// sensitiveAction returns the first action granting one of the sensitive
+// actions, except the exempt ones
+func sensitiveAction(actions, exempt []string) (string, bool) {
+	for _, action := range actions {
+		for _, sensitive := range keyPolicySensitiveActions {
+			if slices.Contains(exempt, sensitive) {
+				continue
+			}
+			if strings.EqualFold(action, sensitive) {
+				return action, true
+			}
+			if prefix, ok := strings.CutSuffix(action, "*"); ok && strings.HasPrefix(strings.ToLower(sensitive), strings.ToLower(prefix)) {
+				return action, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// isSubset reports whether every value is one of the set
+func isSubset(values, set []string) bool {
+	for _, value := range values {
+		if !slices.Contains(set, value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hasCondition reports whether the key is required to be exactly the value
+func hasCondition(conditions map[string]map[string]json.RawMessage, key, value string) bool {
+	for _, operator := range []string{"StringEquals", "StringEqualsIgnoreCase"} {
+		for conditionKey, raw := range conditions[operator] {
+			if !strings.EqualFold(conditionKey, key) {
+				continue
+			}
+
+			var values stringOrSlice
+			if err := json.Unmarshal(raw, &values); err != nil {
+				return false
+			}
+			return len(values) == 1 && strings.EqualFold(values[0], value)
+		}
+	}
+
+	return false
+}
+
+func validateAdminArn(v string) error {
+	adminArn, err := arn.Parse(v)
+	if err != nil {
+		return err
+	}
+	if adminArn.Service != AwsIamServiceID {
+		return fmt.Errorf("must be IAM ARN")
+	}
+	if adminArn.Resource != "root" && !strings.HasPrefix(adminArn.Resource, "role/") && !strings.HasPrefix(adminArn.Resource, "user/") {
+		return fmt.Errorf("must be account root, role or user")
 	}
 
-	// should never panic
-	policy, _ := json.Marshal(defaultPolicy)
-	return string(policy)
+	return nil
 }
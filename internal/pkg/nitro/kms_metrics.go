@@ -0,0 +1,86 @@
+package nitro
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/metrics"
+)
+
+// instrumentedKMSClient counts calls of the client and observes their
+// latencies by operation
+type instrumentedKMSClient struct {
+	client KMSClient
+}
+
+// newKMSClient returns client of the backend instrumented with metrics
+func newKMSClient(kmsBackend KMSBackend, provider AttestationProvider) (KMSClient, error) {
+	client, err := kmsBackend.NewClient(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	return &instrumentedKMSClient{client: client}, nil
+}
+
+// newKMSAdminClient returns admin client of the backend instrumented with
+// metrics
+func newKMSAdminClient(kmsBackend KMSBackend) (KMSClient, error) {
+	client, err := kmsBackend.NewAdminClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return &instrumentedKMSClient{client: client}, nil
+}
+
+func observeKMSCall[T any](operation string, call func() (T, error)) (T, error) {
+	start := time.Now()
+	out, err := call()
+	metrics.ObserveSince(metrics.KMSCallDuration.WithLabelValues(operation), start)
+
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	metrics.KMSCalls.WithLabelValues(operation, result).Inc()
+
+	return out, err
+}
+
+func (c *instrumentedKMSClient) CreateKey(ctx context.Context, params *kms.CreateKeyInput, optFns ...func(*kms.Options)) (*kms.CreateKeyOutput, error) {
+	return observeKMSCall("CreateKey", func() (*kms.CreateKeyOutput, error) {
+		return c.client.CreateKey(ctx, params, optFns...)
+	})
+}
+
+func (c *instrumentedKMSClient) GenerateDataKeyPair(ctx context.Context, params *kms.GenerateDataKeyPairInput, optFns ...func(*kms.Options)) (*kms.GenerateDataKeyPairOutput, error) {
+	return observeKMSCall("GenerateDataKeyPair", func() (*kms.GenerateDataKeyPairOutput, error) {
+		return c.client.GenerateDataKeyPair(ctx, params, optFns...)
+	})
+}
+
+func (c *instrumentedKMSClient) Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	return observeKMSCall("Decrypt", func() (*kms.DecryptOutput, error) {
+		return c.client.Decrypt(ctx, params, optFns...)
+	})
+}
+
+func (c *instrumentedKMSClient) CreateAlias(ctx context.Context, params *kms.CreateAliasInput, optFns ...func(*kms.Options)) (*kms.CreateAliasOutput, error) {
+	return observeKMSCall("CreateAlias", func() (*kms.CreateAliasOutput, error) {
+		return c.client.CreateAlias(ctx, params, optFns...)
+	})
+}
+
+func (c *instrumentedKMSClient) GetKeyPolicy(ctx context.Context, params *kms.GetKeyPolicyInput, optFns ...func(*kms.Options)) (*kms.GetKeyPolicyOutput, error) {
+	return observeKMSCall("GetKeyPolicy", func() (*kms.GetKeyPolicyOutput, error) {
+		return c.client.GetKeyPolicy(ctx, params, optFns...)
+	})
+}
+
+func (c *instrumentedKMSClient) PutKeyPolicy(ctx context.Context, params *kms.PutKeyPolicyInput, optFns ...func(*kms.Options)) (*kms.PutKeyPolicyOutput, error) {
+	return observeKMSCall("PutKeyPolicy", func() (*kms.PutKeyPolicyOutput, error) {
+		return c.client.PutKeyPolicy(ctx, params, optFns...)
+	})
+}
@@ -0,0 +1,40 @@
+package nitro
+
+import (
+	"github.com/distributed-lab/enclave-extras/attestation"
+	"github.com/distributed-lab/enclave-extras/nsm"
+)
+
+// AttestationProvider is the source of PCR values and
+// attestation documents used during the signer bootstrap.
+type AttestationProvider interface {
+	// DescribePCR returns actual value of PCR with the given index
+	DescribePCR(pcrIndex int) ([]byte, error)
+	// GetAttestationDoc returns COSE Sign1 attestation document
+	// with the given UserData, Nonce and PublicKey fields
+	GetAttestationDoc(userData []byte, nonce []byte, publicKey []byte) ([]byte, error)
+	// RootFingerprint returns SHA-256 fingerprint of the root
+	// certificate that the produced documents are chained to
+	RootFingerprint() []byte
+}
+
+// NSMProvider is AttestationProvider backed by the
+// Nitro Secure Module device of the running enclave.
+type NSMProvider struct{}
+
+func NewNSMProvider() *NSMProvider {
+	return &NSMProvider{}
+}
+
+func (p *NSMProvider) DescribePCR(pcrIndex int) ([]byte, error) {
+	_, pcr, err := nsm.DescribePCR(pcrIndex)
+	return pcr, err
+}
+
+func (p *NSMProvider) GetAttestationDoc(userData []byte, nonce []byte, publicKey []byte) ([]byte, error) {
+	return nsm.GetAttestationDoc(userData, nonce, publicKey)
+}
+
+func (p *NSMProvider) RootFingerprint() []byte {
+	return attestation.AWSNitroEnclavesRootCertFingerprint
+}
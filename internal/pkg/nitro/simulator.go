@@ -0,0 +1,303 @@
+package nitro
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/distributed-lab/enclave-extras/nsm"
+	cbor "github.com/fxamacker/cbor/v2"
+)
+
+const (
+	// Simulated NSM exposes the same PCR count as the real one
+	simulatorPCRCount = 16
+	// SHA-384 PCR size
+	simulatorPCRSize = 48
+	// Root CA certificate and private key of the simulator
+	simulatorRootFile = "simulator_root.pem"
+
+	DefaultSimulatorModuleID = "i-00000000000000000-enc0000000000000000"
+)
+
+var (
+	ErrInvalidPCRIndex = errors.New("invalid PCR index")
+	ErrInvalidPCRValue = errors.New("invalid PCR value")
+)
+
+// Simulator is software AttestationProvider that mimics Nitro Secure Module.
+// Documents are signed by a locally generated root -> intermediate -> leaf
+// chain, so they are verifiable only against Simulator.RootFingerprint.
+//
+// DO NOT use outside tests and local development, simulated
+// documents prove nothing about the code that produced them.
+type Simulator struct {
+	moduleID string
+	pcrs     map[int][]byte
+
+	rootCert         *x509.Certificate
+	intermediateCert *x509.Certificate
+	intermediateKey  *ecdsa.PrivateKey
+
+	mu sync.Mutex
+}
+
+// NewSimulator creates NSM simulator with the given PCR values, absent PCRs
+// are zeroed. If caDirectory is not empty the root CA is loaded from or
+// saved to it, so documents stay verifiable across restarts.
+func NewSimulator(moduleID string, pcrs map[int][]byte, caDirectory string) (*Simulator, error) {
+	if moduleID == "" {
+		moduleID = DefaultSimulatorModuleID
+	}
+
+	simulatorPCRs := make(map[int][]byte, simulatorPCRCount)
+	for i := 0; i < simulatorPCRCount; i++ {
+		simulatorPCRs[i] = make([]byte, simulatorPCRSize)
+	}
+	for index, value := range pcrs {
+		if index < 0 || index >= simulatorPCRCount {
+			return nil, fmt.Errorf("%w: %d", ErrInvalidPCRIndex, index)
+		}
+		if len(value) != simulatorPCRSize {
+			return nil, fmt.Errorf("%w: PCR%d must be %d bytes, got %d", ErrInvalidPCRValue, index, simulatorPCRSize, len(value))
+		}
+		simulatorPCRs[index] = append([]byte{}, value...)
+	}
+
+	rootCert, rootKey, err := loadOrCreateSimulatorRoot(caDirectory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get simulator root CA: %w", err)
+	}
+
+	intermediateKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate intermediate key: %w", err)
+	}
+
+	intermediateCert, err := issueSimulatorCert(&x509.Certificate{
+		Subject:               pkix.Name{Organization: []string{"Simulator"}, CommonName: moduleID + ".simulator.nitro-enclaves"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().AddDate(0, 0, 30),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            0,
+		MaxPathLenZero:        true,
+	}, &intermediateKey.PublicKey, rootCert, rootKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue intermediate certificate: %w", err)
+	}
+
+	return &Simulator{
+		moduleID:         moduleID,
+		pcrs:             simulatorPCRs,
+		rootCert:         rootCert,
+		intermediateCert: intermediateCert,
+		intermediateKey:  intermediateKey,
+	}, nil
+}
+
+func (s *Simulator) DescribePCR(pcrIndex int) ([]byte, error) {
+	pcr, ok := s.pcrs[pcrIndex]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidPCRIndex, pcrIndex)
+	}
+
+	return append([]byte{}, pcr...), nil
+}
+
+func (s *Simulator) GetAttestationDoc(userData []byte, nonce []byte, publicKey []byte) ([]byte, error) {
+	if len(userData) > nsm.NSM_MAX_USER_DATA_SIZE {
+		return nil, nsm.ErrUserDataTooLarge
+	}
+	if len(nonce) > nsm.NSM_MAX_USER_DATA_SIZE {
+		return nil, nsm.ErrNonceTooLarge
+	}
+	if len(publicKey) > nsm.NSM_MAX_USER_DATA_SIZE {
+		return nil, nsm.ErrPubKeyTooLarge
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Like the real NSM, every document is signed by a fresh leaf certificate
+	leafKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate leaf key: %w", err)
+	}
+
+	now := time.Now()
+	leafCert, err := issueSimulatorCert(&x509.Certificate{
+		Subject:   pkix.Name{Organization: []string{"Simulator"}, CommonName: s.moduleID + ".simulator"},
+		NotBefore: now.Add(-time.Minute),
+		NotAfter:  now.Add(3 * time.Hour),
+		KeyUsage:  x509.KeyUsageDigitalSignature,
+	}, &leafKey.PublicKey, s.intermediateCert, s.intermediateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue leaf certificate: %w", err)
+	}
+
+	payload, err := cbor.Marshal(simulatedAttestationDoc{
+		ModuleID:    s.moduleID,
+		Digest:      "SHA384",
+		Timestamp:   uint64(now.UnixMilli()),
+		PCRs:        s.pcrs,
+		Certificate: leafCert.Raw,
+		CABundle:    [][]byte{s.rootCert.Raw, s.intermediateCert.Raw},
+		PublicKey:   publicKey,
+		UserData:    userData,
+		Nonce:       nonce,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal attestation document: %w", err)
+	}
+
+	digest, err := coseSign1Digest(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	r, sig, err := ecdsa.Sign(rand.Reader, leafKey, digest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign attestation document: %w", err)
+	}
+
+	signature := make([]byte, 96)
+	r.FillBytes(signature[:48])
+	sig.FillBytes(signature[48:])
+
+	return cbor.Marshal(simulatedCOSESign1{
+		Protected:   coseProtectedHeader,
+		Unprotected: map[int]any{},
+		Payload:     payload,
+		Signature:   signature,
+	})
+}
+
+func (s *Simulator) RootFingerprint() []byte {
+	fingerprint := sha256.Sum256(s.rootCert.Raw)
+	return fingerprint[:]
+}
+
+type simulatedAttestationDoc struct {
+	ModuleID    string         `cbor:"module_id"`
+	Digest      string         `cbor:"digest"`
+	Timestamp   uint64         `cbor:"timestamp"`
+	PCRs        map[int][]byte `cbor:"pcrs"`
+	Certificate []byte         `cbor:"certificate"`
+	CABundle    [][]byte       `cbor:"cabundle"`
+	PublicKey   []byte         `cbor:"public_key"`
+	UserData    []byte         `cbor:"user_data"`
+	Nonce       []byte         `cbor:"nonce"`
+}
+
+type simulatedCOSESign1 struct {
+	_           struct{} `cbor:",toarray"`
+	Protected   []byte
+	Unprotected map[int]any
+	Payload     []byte
+	Signature   []byte
+}
+
+func loadOrCreateSimulatorRoot(caDirectory string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	var rootPath string
+	if caDirectory != "" {
+		rootPath = path.Join(caDirectory, simulatorRootFile)
+
+		rootPEM, err := os.ReadFile(rootPath)
+		if err == nil {
+			return parseSimulatorRoot(rootPEM)
+		}
+		if !os.IsNotExist(err) {
+			return nil, nil, fmt.Errorf("failed to read %s, check file permissions. err: %w", rootPath, err)
+		}
+	}
+
+	rootKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate root key: %w", err)
+	}
+
+	rootTemplate := &x509.Certificate{
+		Subject:               pkix.Name{Organization: []string{"Simulator"}, CommonName: "simulator.nitro-enclaves"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().AddDate(30, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	rootCert, err := issueSimulatorCert(rootTemplate, &rootKey.PublicKey, rootTemplate, rootKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to issue root certificate: %w", err)
+	}
+
+	if rootPath == "" {
+		return rootCert, rootKey, nil
+	}
+
+	rootKeyDER, err := x509.MarshalECPrivateKey(rootKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal root key: %w", err)
+	}
+
+	rootPEM := append(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rootCert.Raw}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: rootKeyDER})...,
+	)
+	if err = os.MkdirAll(caDirectory, os.ModePerm); err != nil {
+		return nil, nil, fmt.Errorf("failed to create simulator CA directory %s with error: %w", caDirectory, err)
+	}
+	if err = os.WriteFile(rootPath, rootPEM, 0600); err != nil {
+		return nil, nil, fmt.Errorf("failed to write %s: %w", rootPath, err)
+	}
+
+	return rootCert, rootKey, nil
+}
+
+func parseSimulatorRoot(rootPEM []byte) (cert *x509.Certificate, key *ecdsa.PrivateKey, err error) {
+	for block, rest := pem.Decode(rootPEM); block != nil; block, rest = pem.Decode(rest) {
+		switch block.Type {
+		case "CERTIFICATE":
+			if cert, err = x509.ParseCertificate(block.Bytes); err != nil {
+				return nil, nil, fmt.Errorf("failed to parse root certificate: %w", err)
+			}
+		case "EC PRIVATE KEY":
+			if key, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+				return nil, nil, fmt.Errorf("failed to parse root key: %w", err)
+			}
+		}
+	}
+
+	if cert == nil || key == nil {
+		return nil, nil, fmt.Errorf("root certificate or key is absent")
+	}
+
+	return cert, key, nil
+}
+
+func issueSimulatorCert(template *x509.Certificate, publicKey *ecdsa.PublicKey, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, error) {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	template.SerialNumber = serialNumber
+	template.SignatureAlgorithm = x509.ECDSAWithSHA384
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, parent, publicKey, parentKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return x509.ParseCertificate(certDER)
+}
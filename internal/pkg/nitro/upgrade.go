@@ -0,0 +1,363 @@
+package nitro
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/storage"
+	"github.com/distributed-lab/enclave-extras/attestation"
+)
+
+// Image upgrade keeps the signer key when a new enclave image is shipped:
+//  1. a key admin authorizes the new image with AuthorizeImage, so the KMS
+//     key policy releases the key to both images;
+//  2. the new image re-attests stored documents with ReattestGenerations;
+//  3. a key admin retires the old image with RetireImage.
+//
+// KMS key policy is the only source of authorized images, stored documents
+// made by other images are accepted only while the policy authorizes them.
+// KMS evaluates kms:RecipientAttestation:* conditions only in requests with
+// a recipient, so the enclave can't be allowed to update the policy on its
+// measurements. The admins update it with their own credentials outside the
+// enclave, the enclave only reads it.
+
+var (
+	ErrImageNotAuthorized = errors.New("image is not authorized by the KMS key policy")
+	ErrKeyPolicyChanged   = errors.New("KMS key policy was changed concurrently")
+)
+
+// storedDocFiles are documents of a generation re-attested on upgrade
+var storedDocFiles = []string{kmsKeyIDFile, privateKeyFile, publicKeyFile, addressFile}
+
+// GetAuthorizedImages returns conditions of the images the KMS key of the
+// latest generation is released to, and the KMS Key ID. The policy is read
+// by the enclave of the provider.
+func GetAuthorizedImages(kmsBackend KMSBackend, provider AttestationProvider, store storage.Storage) ([]map[string]string, string, error) {
+	kmsKeyID, err := latestKMSKeyID(provider, store)
+	if err != nil {
+		return nil, "", err
+	}
+
+	kmsEnclaveClient, err := newKMSClient(kmsBackend, provider)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get kms enclave client: %w", err)
+	}
+
+	policy, err := getKeyPolicy(kmsEnclaveClient, kmsKeyID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	images, err := KeyPolicyImages(policy)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse policy of KMS key %s: %w", kmsKeyID, err)
+	}
+
+	return images, kmsKeyID, nil
+}
+
+// AuthorizeImage updates the KMS key policy to release the key to the image
+// with the PCRs as well. It is run by a key admin outside the enclave, only
+// the trusted root of the provider is used. PCRs of the key policy absent in
+// pcrs are taken from the first authorized image, e.g. PCR8 of the same
+// signing certificate.
+func AuthorizeImage(kmsBackend KMSBackend, provider AttestationProvider, keyPolicy KeyPolicy, store storage.Storage, pcrs map[int][]byte) (map[string]string, error) {
+	kmsKeyID, err := latestKMSKeyID(provider, store)
+	if err != nil {
+		return nil, err
+	}
+
+	kmsAdminClient, err := newKMSAdminClient(kmsBackend)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get kms admin client: %w", err)
+	}
+
+	policy, images, err := getKeyPolicyImages(kmsAdminClient, kmsKeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	imagePCRs := make(map[int][]byte, len(keyPolicy.PCRs))
+	for _, index := range keyPolicy.PCRs {
+		pcr, ok := pcrs[index]
+		if !ok {
+			if index == 0 {
+				return nil, fmt.Errorf("PCR0 of the image is required")
+			}
+
+			value, ok := images[0][PcrXCondition(index)]
+			if !ok {
+				return nil, fmt.Errorf("PCR%d of the image is required, authorized images aren't bound to it", index)
+			}
+			if pcr, err = hex.DecodeString(value); err != nil {
+				return nil, fmt.Errorf("invalid PCR%d of the authorized image: %w", index, err)
+			}
+		}
+		imagePCRs[index] = pcr
+	}
+
+	image, err := keyPolicy.ImageConditions(imagePCRs)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = putKeyPolicy(kmsAdminClient, keyPolicy, kmsKeyID, policy, append(images, image)); err != nil {
+		return nil, err
+	}
+
+	return image, nil
+}
+
+// ReattestGenerations rewrites stored documents of every generation made by
+// other authorized images with documents of the running enclave. The key is
+// decrypted first, so it fails unless the running image is authorized.
+// Returns IDs of the generations with rewritten documents.
+func ReattestGenerations(kmsBackend KMSBackend, provider AttestationProvider, keyPolicy KeyPolicy, store storage.Storage) ([]string, error) {
+	images, _, err := GetAuthorizedImages(kmsBackend, provider, store)
+	if err != nil {
+		return nil, err
+	}
+
+	generations, err := ListGenerations(store)
+	if err != nil {
+		return nil, err
+	}
+
+	authorized := keyPolicy
+	authorized.Images = images
+
+	var reattested []string
+	for _, meta := range generations {
+		if _, err = LoadGeneration(kmsBackend, provider, authorized, OnMismatchFail, meta); err != nil {
+			return reattested, fmt.Errorf("failed to load generation %s: %w", meta.ID, err)
+		}
+
+		rewritten := false
+		for _, file := range storedDocFiles {
+			raw, err := meta.Storage.Read(file)
+			if err != nil {
+				return reattested, fmt.Errorf("failed to read %s of generation %s: %w", file, meta.ID, err)
+			}
+
+			doc, err := verifyStoredDoc(provider, authorized, file, raw)
+			if err != nil {
+				return reattested, err
+			}
+			if keyPolicy.Check(provider, doc) == nil {
+				continue
+			}
+
+			if raw, err = provider.GetAttestationDoc(doc.UserData, nil, doc.PublicKey); err != nil {
+				return reattested, fmt.Errorf("failed to get attestation doc for %s: %w", file, err)
+			}
+			if err = meta.Storage.Write(file, raw); err != nil {
+				return reattested, fmt.Errorf("failed to write %s of generation %s: %w", file, meta.ID, err)
+			}
+			rewritten = true
+		}
+
+		if rewritten {
+			reattested = append(reattested, meta.ID)
+		}
+	}
+
+	return reattested, nil
+}
+
+// RetireImage updates the KMS key policy to stop releasing the key to the
+// image with the PCR0. It is run by a key admin outside the enclave, only the
+// trusted root of the provider is used. Documents of every generation must
+// be re-attested by a remaining image first, and the only authorized image
+// can't be retired.
+func RetireImage(kmsBackend KMSBackend, provider AttestationProvider, keyPolicy KeyPolicy, store storage.Storage, pcr0 []byte) error {
+	kmsKeyID, err := latestKMSKeyID(provider, store)
+	if err != nil {
+		return err
+	}
+
+	kmsAdminClient, err := newKMSAdminClient(kmsBackend)
+	if err != nil {
+		return fmt.Errorf("failed to get kms admin client: %w", err)
+	}
+
+	policy, images, err := getKeyPolicyImages(kmsAdminClient, kmsKeyID)
+	if err != nil {
+		return err
+	}
+
+	retired := slices.DeleteFunc(slices.Clone(images), func(image map[string]string) bool {
+		return strings.EqualFold(image[PcrXCondition(0)], hex.EncodeToString(pcr0))
+	})
+	if len(retired) == len(images) {
+		return fmt.Errorf("%w: PCR0 %x", ErrImageNotAuthorized, pcr0)
+	}
+	if len(retired) == 0 {
+		return fmt.Errorf("the only authorized image can't be retired")
+	}
+
+	generations, err := ListGenerations(store)
+	if err != nil {
+		return err
+	}
+	for _, meta := range generations {
+		for _, file := range storedDocFiles {
+			raw, err := meta.Storage.Read(file)
+			if err != nil {
+				return fmt.Errorf("failed to read %s of generation %s: %w", file, meta.ID, err)
+			}
+			if err = checkImageDoc(provider, keyPolicy, retired, file, raw); err != nil {
+				return fmt.Errorf("generation %s must be re-attested first: %w", meta.ID, err)
+			}
+		}
+	}
+
+	return putKeyPolicy(kmsAdminClient, keyPolicy, kmsKeyID, policy, retired)
+}
+
+// checkImageDoc ensures the stored document is signed by the trusted root
+// and made by one of the images. Unlike verifyStoredDoc, it doesn't need the
+// running enclave.
+func checkImageDoc(provider AttestationProvider, keyPolicy KeyPolicy, images []map[string]string, file string, raw []byte) error {
+	doc, err := attestation.ParseNSMAttestationDoc(raw)
+	if err != nil {
+		return fmt.Errorf("%w: failed to parse %s: %w", ErrDocumentMismatch, file, err)
+	}
+
+	if _, err = VerifyAttestationDoc(doc, provider.RootFingerprint()); err != nil {
+		return fmt.Errorf("%w: %s have invalid signature: %w", ErrDocumentMismatch, file, err)
+	}
+
+	if !slices.ContainsFunc(images, func(image map[string]string) bool { return keyPolicy.matchesImage(image, doc) }) {
+		return fmt.Errorf("%w: %s isn't made by a remaining image", ErrDocumentMismatch, file)
+	}
+
+	return nil
+}
+
+// latestKMSKeyID reads KMS Key ID of the latest generation. The document is
+// only checked to be signed by the trusted root, as it may be made by any
+// authorized image, KMS refuses the key to others anyway.
+func latestKMSKeyID(provider AttestationProvider, store storage.Storage) (string, error) {
+	generations, err := ListGenerations(store)
+	if err != nil {
+		return "", err
+	}
+	if len(generations) == 0 {
+		return "", ErrNoGenerations
+	}
+	latest := generations[len(generations)-1]
+
+	raw, err := latest.Storage.Read(kmsKeyIDFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s of generation %s: %w", kmsKeyIDFile, latest.ID, err)
+	}
+
+	doc, err := attestation.ParseNSMAttestationDoc(raw)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %s of generation %s: %w", kmsKeyIDFile, latest.ID, err)
+	}
+	if _, err = VerifyAttestationDoc(doc, provider.RootFingerprint()); err != nil {
+		return "", fmt.Errorf("%s of generation %s have invalid signature: %w", kmsKeyIDFile, latest.ID, err)
+	}
+
+	return string(doc.UserData), nil
+}
+
+// getKeyPolicy returns the KMS key policy
+func getKeyPolicy(client KMSClient, kmsKeyID string) (string, error) {
+	getKeyPolicyOutput, err := client.GetKeyPolicy(context.Background(), &kms.GetKeyPolicyInput{
+		KeyId: aws.String(kmsKeyID),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get policy of KMS key %s: %w", kmsKeyID, err)
+	}
+
+	return deref(getKeyPolicyOutput.Policy), nil
+}
+
+// getKeyPolicyImages returns the KMS key policy and its images, at least one
+func getKeyPolicyImages(client KMSClient, kmsKeyID string) (string, []map[string]string, error) {
+	policy, err := getKeyPolicy(client, kmsKeyID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	images, err := KeyPolicyImages(policy)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse policy of KMS key %s: %w", kmsKeyID, err)
+	}
+	if len(images) == 0 {
+		return "", nil, fmt.Errorf("policy of KMS key %s authorizes no images", kmsKeyID)
+	}
+
+	return policy, images, nil
+}
+
+// putKeyPolicy renders the policy with the enclave principal of the current
+// policy and the images and replaces the KMS key policy with it. KMS has no
+// conditional updates, so the policy is read back to detect a concurrent
+// update that overwrote this one.
+func putKeyPolicy(client KMSClient, keyPolicy KeyPolicy, kmsKeyID, current string, images []map[string]string) error {
+	principalArn, err := KeyPolicyPrincipal(current)
+	if err != nil {
+		return fmt.Errorf("failed to get enclave principal of KMS key %s: %w", kmsKeyID, err)
+	}
+
+	policy, err := keyPolicy.RenderImages(principalArn, images)
+	if err != nil {
+		return fmt.Errorf("failed to render kms key policy: %w", err)
+	}
+
+	// Never bypassed, the admin must keep access to the policy it puts
+	_, err = client.PutKeyPolicy(context.Background(), &kms.PutKeyPolicyInput{
+		KeyId:  aws.String(kmsKeyID),
+		Policy: aws.String(policy),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put policy of KMS key %s: %w", kmsKeyID, err)
+	}
+
+	actualPolicy, err := getKeyPolicy(client, kmsKeyID)
+	if err != nil {
+		return err
+	}
+
+	expected, err := KeyPolicyImages(policy)
+	if err != nil {
+		return fmt.Errorf("failed to parse rendered kms key policy: %w", err)
+	}
+	actual, err := KeyPolicyImages(actualPolicy)
+	if err != nil {
+		return fmt.Errorf("failed to parse policy of KMS key %s: %w", kmsKeyID, err)
+	}
+	if !sameImages(expected, actual) {
+		return fmt.Errorf("%w: KMS key %s authorizes other images than put", ErrKeyPolicyChanged, kmsKeyID)
+	}
+
+	return nil
+}
+
+// sameImages reports whether both lists have the same image conditions,
+// whatever the order
+func sameImages(a, b []map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for _, image := range a {
+		if !slices.ContainsFunc(b, func(other map[string]string) bool {
+			return maps.Equal(image, other)
+		}) {
+			return false
+		}
+	}
+
+	return true
+}
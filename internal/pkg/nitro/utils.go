@@ -13,7 +13,6 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws/arn"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/distributed-lab/enclave-extras/attestedkms"
-	"github.com/distributed-lab/enclave-extras/nsm"
 )
 
 const (
@@ -21,7 +20,10 @@ const (
 	AwsStsServiceID = "sts"
 )
 
-func EnsureArnIsIam(v string) (string, error) {
+// EnsureArnIsIam converts STS assumed-role ARN to the IAM role ARN. STS
+// doesn't report the role path, so it must be provided, "/" if empty.
+// IAM ARNs are returned as is.
+func EnsureArnIsIam(v string, rolePath string) (string, error) {
 	resourceArn, err := arn.Parse(v)
 	if err != nil {
 		return "", fmt.Errorf("failed to parse resource ARN: %w", err)
@@ -36,9 +38,21 @@ func EnsureArnIsIam(v string) (string, error) {
 		return "", fmt.Errorf("unsuported conversion, can convert only STS assumed-role in IAM role")
 	}
 
+	// assumed-role/<role name>/<session name>, neither can contain slashes
+	parts := strings.Split(resourceArn.Resource, "/")
+	if len(parts) != 3 || parts[1] == "" {
+		return "", fmt.Errorf("invalid assumed-role resource %s", resourceArn.Resource)
+	}
+
+	if rolePath == "" {
+		rolePath = "/"
+	}
+	if !strings.HasPrefix(rolePath, "/") || !strings.HasSuffix(rolePath, "/") {
+		return "", fmt.Errorf("role path %s must begin and end with /", rolePath)
+	}
+
 	resourceArn.Service = AwsIamServiceID
-	// Should never be out of range, because of AWS guarantee that role can't be empty string
-	resourceArn.Resource = "role/" + strings.Split(resourceArn.Resource, "/")[1]
+	resourceArn.Resource = "role" + rolePath + parts[1]
 
 	return resourceArn.String(), nil
 }
@@ -55,27 +69,18 @@ func ToRootArn(v string) (string, error) {
 	return resourceArn.String(), nil
 }
 
-func GetArns(cfg aws.Config) (rootArn string, principalArn string, err error) {
+// GetCallerArn returns ARN of the principal the AWS config is resolved to
+func GetCallerArn(cfg aws.Config) (string, error) {
 	stsClient := sts.NewFromConfig(cfg)
 	callerIdentityResponse, err := stsClient.GetCallerIdentity(context.Background(), &sts.GetCallerIdentityInput{})
 	if err != nil {
-		return "", "", fmt.Errorf("failed to get caller identity: %w", err)
-	}
-
-	principalArn, err = EnsureArnIsIam(deref(callerIdentityResponse.Arn))
-	if err != nil {
-		return "", "", fmt.Errorf("failed to cast arn: %w", err)
-	}
-
-	rootArn, err = ToRootArn(principalArn)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to make root arn: %w", err)
+		return "", fmt.Errorf("failed to get caller identity: %w", err)
 	}
 
-	return rootArn, principalArn, nil
+	return deref(callerIdentityResponse.Arn), nil
 }
 
-func GetKMSEnclaveClient(cfg aws.Config) (*attestedkms.KMSEnclaveClient, error) {
+func GetKMSEnclaveClient(cfg aws.Config, provider AttestationProvider) (*attestedkms.KMSEnclaveClient, error) {
 	privateKey, err := rsa.GenerateKey(rand.Reader, 4096)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate RSA private key: %w", err)
@@ -86,7 +91,7 @@ func GetKMSEnclaveClient(cfg aws.Config) (*attestedkms.KMSEnclaveClient, error)
 		return nil, fmt.Errorf("failed to marshal public key PKIX: %w", err)
 	}
 
-	attestationDoc, err := nsm.GetAttestationDoc(nil, nil, derEncodedPublicKey)
+	attestationDoc, err := provider.GetAttestationDoc(nil, nil, derEncodedPublicKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get attestation document: %w", err)
 	}
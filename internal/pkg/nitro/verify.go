@@ -0,0 +1,137 @@
+package nitro
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/distributed-lab/enclave-extras/attestation"
+	cbor "github.com/fxamacker/cbor/v2"
+)
+
+// COSE protected header {1: -35}, i.e. ECDSA with SHA-384
+var coseProtectedHeader = []byte{0xa1, 0x01, 0x38, 0x22}
+
+var (
+	ErrUntrustedRoot       = errors.New("root certificate fingerprint does not match any trusted root")
+	ErrCertificateExpired  = errors.New("certificate is expired")
+	ErrCertificateNotValid = errors.New("certificate is not yet valid")
+)
+
+// VerifyAttestationDoc checks certificate chain and COSE signature of the
+// attestation document. Unlike attestation.NSMAttestationDoc.Verify the chain
+// may end in any of the given root fingerprints. Matched fingerprint returned.
+func VerifyAttestationDoc(doc *attestation.NSMAttestationDoc, rootFingerprints ...[]byte) ([]byte, error) {
+	if doc == nil {
+		return nil, fmt.Errorf("attestation document shouldn't be nil")
+	}
+
+	rootFingerprint, err := verifyCertChain(doc, rootFingerprints)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = verifyCOSESignature(doc); err != nil {
+		return nil, err
+	}
+
+	return rootFingerprint, nil
+}
+
+// VerifyCertificatesAt checks that every certificate of the attestation
+// document chain, including NSM certificate, is valid at the given time
+func VerifyCertificatesAt(doc *attestation.NSMAttestationDoc, at time.Time) error {
+	if doc == nil {
+		return fmt.Errorf("attestation document shouldn't be nil")
+	}
+
+	certs := append([]*x509.Certificate{doc.Certificate}, doc.CABundle...)
+	for _, cert := range certs {
+		if at.After(cert.NotAfter) {
+			return fmt.Errorf("%w: %s expired at %s", ErrCertificateExpired, cert.Subject, cert.NotAfter.UTC().Format(time.RFC3339))
+		}
+		if at.Before(cert.NotBefore) {
+			return fmt.Errorf("%w: %s valid from %s", ErrCertificateNotValid, cert.Subject, cert.NotBefore.UTC().Format(time.RFC3339))
+		}
+	}
+
+	return nil
+}
+
+func verifyCertChain(doc *attestation.NSMAttestationDoc, rootFingerprints [][]byte) ([]byte, error) {
+	if len(doc.CABundle) < 1 {
+		return nil, fmt.Errorf("CA bundle don't have certs")
+	}
+
+	rootCertHash := sha256.Sum256(doc.CABundle[0].Raw)
+
+	var rootFingerprint []byte
+	for _, fingerprint := range rootFingerprints {
+		if bytes.Equal(rootCertHash[:], fingerprint) {
+			rootFingerprint = rootCertHash[:]
+			break
+		}
+	}
+	if rootFingerprint == nil {
+		return nil, ErrUntrustedRoot
+	}
+
+	certCount := len(doc.CABundle)
+	for i := certCount - 1; i > 0; i-- {
+		if err := doc.CABundle[i].CheckSignatureFrom(doc.CABundle[i-1]); err != nil {
+			return nil, fmt.Errorf("failed to verify certificate chain: %w", err)
+		}
+	}
+
+	if err := doc.Certificate.CheckSignatureFrom(doc.CABundle[certCount-1]); err != nil {
+		return nil, fmt.Errorf("failed to verify NSM certificate: %w", err)
+	}
+
+	return rootFingerprint, nil
+}
+
+func verifyCOSESignature(doc *attestation.NSMAttestationDoc) error {
+	digest, err := coseSign1Digest(doc.Payload)
+	if err != nil {
+		return err
+	}
+
+	publicKey, ok := doc.Certificate.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("invalid public key")
+	}
+	if len(doc.Signature) != 96 {
+		return fmt.Errorf("invalid signature length")
+	}
+
+	r := new(big.Int).SetBytes(doc.Signature[:48])
+	s := new(big.Int).SetBytes(doc.Signature[48:])
+
+	if !ecdsa.Verify(publicKey, digest, r, s) {
+		return fmt.Errorf("invalid ecdsa signature")
+	}
+
+	return nil
+}
+
+// coseSign1Digest returns SHA-384 of the COSE Sig_structure for the payload
+func coseSign1Digest(payload []byte) ([]byte, error) {
+	sigStructure, err := cbor.Marshal([]any{
+		"Signature1",
+		coseProtectedHeader,
+		[]byte{},
+		payload,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal COSE Sign1: %w", err)
+	}
+
+	sum384 := sha512.Sum384(sigStructure)
+	return sum384[:], nil
+}
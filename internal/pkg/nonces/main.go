@@ -0,0 +1,368 @@
+// Package nonces issues single-use challenge nonces for attestation documents.
+package nonces
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultSize      = 32
+	DefaultTTL       = 5 * time.Minute
+	DefaultMaxActive = 100000
+	// Limit of the attestation document nonce field
+	MaxSize = 512
+)
+
+var (
+	ErrMissing  = errors.New("nonce is missing")
+	ErrUnknown  = errors.New("nonce was not issued")
+	ErrExpired  = errors.New("nonce is expired")
+	ErrUsed     = errors.New("nonce is already used")
+	ErrTooMany  = errors.New("too many active nonces")
+	ErrBadSize  = errors.New("invalid nonce size")
+	ErrPersist  = errors.New("failed to persist nonces")
+	errNotFound = errors.New("persisted nonces not found")
+)
+
+// ErrClientTooMany wraps ErrTooMany, so both are handled the same way
+var ErrClientTooMany = fmt.Errorf("%w of the client", ErrTooMany)
+
+type Options struct {
+	// Size of the nonce in bytes
+	Size int
+	// TTL of the issued nonce
+	TTL time.Duration
+	// Maximum count of issued, not consumed and not expired nonces
+	MaxActive int
+	// Maximum count of active nonces of a single client, unlimited if zero
+	MaxActivePerClient int
+	// Append-only log of issued and consumed nonces, in-memory only if empty
+	PersistencePath string
+}
+
+// Log is compacted when it has this many records more than twice the
+// count of entries
+const compactThreshold = 1024
+
+// Store keeps issued nonces until they expire. Consumed nonces are
+// remembered until expiration too, so reuse is reported as such.
+type Store struct {
+	opts Options
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	// Keys in issuance order, so in expiration order as TTL is the same
+	order []string
+	// Count of entries not consumed yet
+	active int
+	// Count of entries not consumed yet by client
+	clients map[string]int
+
+	log *os.File
+	// Records in the log, including the ones of purged entries
+	logged int
+	// Last append failed, so the log may end with a partial record
+	dirty bool
+}
+
+type entry struct {
+	ExpiresAt time.Time `json:"expires_at"`
+	Used      bool      `json:"used"`
+	Client    string    `json:"client,omitempty"`
+}
+
+// record is a line of the log. Issuance has expiration time, consumption
+// has only the nonce and used flag.
+type record struct {
+	Nonce     string     `json:"nonce"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Used      bool       `json:"used,omitempty"`
+	Client    string     `json:"client,omitempty"`
+}
+
+func NewStore(opts Options) (*Store, error) {
+	if opts.Size == 0 {
+		opts.Size = DefaultSize
+	}
+	if opts.Size < 0 || opts.Size > MaxSize {
+		return nil, fmt.Errorf("%w: must be in range [1, %d]", ErrBadSize, MaxSize)
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultTTL
+	}
+	if opts.MaxActive <= 0 {
+		opts.MaxActive = DefaultMaxActive
+	}
+	if opts.MaxActivePerClient < 0 {
+		opts.MaxActivePerClient = 0
+	}
+
+	store := &Store{
+		opts:    opts,
+		entries: make(map[string]*entry),
+		clients: make(map[string]int),
+	}
+
+	err := store.load()
+	if errors.Is(err, errNotFound) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Drops records of expired nonces and a partial record of the crash
+	if err = store.compact(); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Issue returns new random nonce and its expiration time. The client
+// identifies the requester for the per-client limit, empty one is exempt.
+func (s *Store) Issue(client string) ([]byte, time.Time, error) {
+	nonce := make([]byte, s.opts.Size)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.purge(now)
+
+	if client != "" && s.opts.MaxActivePerClient > 0 && s.clients[client] >= s.opts.MaxActivePerClient {
+		return nil, time.Time{}, ErrClientTooMany
+	}
+	if s.active >= s.opts.MaxActive {
+		return nil, time.Time{}, ErrTooMany
+	}
+
+	key := hex.EncodeToString(nonce)
+	expiresAt := now.Add(s.opts.TTL)
+	e := &entry{ExpiresAt: expiresAt, Client: client}
+	s.entries[key] = e
+	s.order = append(s.order, key)
+	s.count(e, 1)
+
+	// Issuance isn't synced: nonce lost on a crash is only rejected as unknown
+	if err := s.append(record{Nonce: key, ExpiresAt: &expiresAt, Client: client}, false); err != nil {
+		delete(s.entries, key)
+		s.order = s.order[:len(s.order)-1]
+		s.count(e, -1)
+		return nil, time.Time{}, err
+	}
+
+	return nonce, expiresAt, nil
+}
+
+// Consume atomically checks that the nonce is issued, not expired
+// and not used yet, then marks it as used
+func (s *Store) Consume(nonce []byte) error {
+	if len(nonce) == 0 {
+		return ErrMissing
+	}
+
+	key := hex.EncodeToString(nonce)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	e, ok := s.entries[key]
+	switch {
+	case !ok:
+		return ErrUnknown
+	case e.Used:
+		return ErrUsed
+	case !now.Before(e.ExpiresAt):
+		return ErrExpired
+	}
+
+	e.Used = true
+	s.count(e, -1)
+
+	// Consumption is synced, otherwise the nonce could be reused after a crash
+	if err := s.append(record{Nonce: key, Used: true}, true); err != nil {
+		e.Used = false
+		s.count(e, 1)
+		return err
+	}
+
+	s.purge(now)
+	return nil
+}
+
+func (s *Store) TTL() time.Duration {
+	return s.opts.TTL
+}
+
+// purge drops expired entries, must be called under lock
+func (s *Store) purge(now time.Time) {
+	for len(s.order) > 0 {
+		e := s.entries[s.order[0]]
+		if now.Before(e.ExpiresAt) {
+			return
+		}
+
+		if !e.Used {
+			s.count(e, -1)
+		}
+		delete(s.entries, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// count adds delta to the active counts of the entry, must be called
+// under lock
+func (s *Store) count(e *entry, delta int) {
+	s.active += delta
+	if e.Client == "" {
+		return
+	}
+
+	s.clients[e.Client] += delta
+	if s.clients[e.Client] <= 0 {
+		delete(s.clients, e.Client)
+	}
+}
+
+// append writes the record to the log, the log is compacted instead if it
+// has grown too much or the last append failed. Must be called under lock
+// after the change is applied to the entries.
+func (s *Store) append(rec record, sync bool) error {
+	if s.opts.PersistencePath == "" {
+		return nil
+	}
+	if s.dirty || s.logged > 2*len(s.entries)+compactThreshold {
+		return s.compact()
+	}
+
+	raw, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPersist, err)
+	}
+
+	if _, err = s.log.Write(append(raw, '\n')); err != nil {
+		s.dirty = true
+		return fmt.Errorf("%w: %w", ErrPersist, err)
+	}
+	s.logged++
+
+	if sync {
+		if err = s.log.Sync(); err != nil {
+			s.dirty = true
+			return fmt.Errorf("%w: %w", ErrPersist, err)
+		}
+	}
+
+	return nil
+}
+
+// compact writes entries to a temporary file, renames it over the log and
+// reopens the log for appending, must be called under lock
+func (s *Store) compact() error {
+	var buf bytes.Buffer
+	for _, key := range s.order {
+		e := s.entries[key]
+		raw, err := json.Marshal(record{Nonce: key, ExpiresAt: &e.ExpiresAt, Used: e.Used, Client: e.Client})
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrPersist, err)
+		}
+		buf.Write(append(raw, '\n'))
+	}
+
+	tmp, err := os.CreateTemp(path.Dir(s.opts.PersistencePath), path.Base(s.opts.PersistencePath)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPersist, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("%w: %w", ErrPersist, err)
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("%w: %w", ErrPersist, err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("%w: %w", ErrPersist, err)
+	}
+
+	if err = os.Rename(tmp.Name(), s.opts.PersistencePath); err != nil {
+		return fmt.Errorf("%w: %w", ErrPersist, err)
+	}
+
+	log, err := os.OpenFile(s.opts.PersistencePath, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		s.dirty = true
+		return fmt.Errorf("%w: %w", ErrPersist, err)
+	}
+	if s.log != nil {
+		s.log.Close()
+	}
+	s.log, s.logged, s.dirty = log, len(s.order), false
+
+	return nil
+}
+
+// load replays the log, the last line is skipped if it isn't terminated
+// as the process could crash while writing it
+func (s *Store) load() error {
+	if s.opts.PersistencePath == "" {
+		return errNotFound
+	}
+
+	raw, err := os.ReadFile(s.opts.PersistencePath)
+	if os.IsNotExist(err) {
+		// Compaction creates the log
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", s.opts.PersistencePath, err)
+	}
+
+	lines := bytes.Split(raw, []byte("\n"))
+	for i, line := range lines[:len(lines)-1] {
+		var rec record
+		if err = json.Unmarshal(line, &rec); err != nil {
+			return fmt.Errorf("failed to unmarshal record %d of %s: %w", i+1, s.opts.PersistencePath, err)
+		}
+
+		e, ok := s.entries[rec.Nonce]
+		if rec.ExpiresAt != nil && !ok {
+			e = &entry{ExpiresAt: *rec.ExpiresAt, Client: rec.Client}
+			s.entries[rec.Nonce] = e
+			s.order = append(s.order, rec.Nonce)
+		}
+		if e != nil && rec.Used {
+			e.Used = true
+		}
+	}
+
+	// TTL could be changed between restarts
+	slices.SortStableFunc(s.order, func(a, b string) int {
+		return s.entries[a].ExpiresAt.Compare(s.entries[b].ExpiresAt)
+	})
+	for _, e := range s.entries {
+		if !e.Used {
+			s.count(e, 1)
+		}
+	}
+
+	s.purge(time.Now())
+	return nil
+}
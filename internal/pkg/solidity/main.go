@@ -0,0 +1,284 @@
+package solidity
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/icrypto"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/utils"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// reserved are Solidity keywords and types that can't name a struct field
+var reserved = map[string]bool{
+	"address": true, "bool": true, "string": true, "bytes": true, "byte": true,
+	"int": true, "uint": true, "fixed": true, "ufixed": true, "mapping": true,
+	"struct": true, "enum": true, "function": true, "event": true, "error": true,
+	"modifier": true, "contract": true, "library": true, "interface": true,
+	"return": true, "returns": true, "memory": true, "storage": true,
+	"calldata": true, "public": true, "private": true, "internal": true,
+	"external": true, "pure": true, "view": true, "payable": true,
+	"constant": true, "immutable": true, "if": true, "else": true, "for": true,
+	"while": true, "do": true, "break": true, "continue": true, "new": true,
+	"delete": true, "emit": true, "try": true, "catch": true, "assembly": true,
+	"this": true, "super": true, "true": true, "false": true, "type": true,
+	"data": true,
+}
+
+// Generator generates Solidity library verifying endorsements of a signing
+// configuration, i.e. the request primary_type, fields_to_sign, encodings
+// and domain, and test vectors of it made by the Go encoder
+type Generator struct {
+	// Library is name of the generated library
+	Library     string
+	Domain      *icrypto.Domain
+	PrimaryType string
+	// Fields must not have duplicate items
+	Fields    []string
+	Encodings map[string]string
+	// TTL of the endorsements, issued_at and deadline are signed if set
+	TTL time.Duration
+}
+
+// message returns the message of the sample document as the service builds it
+func (g *Generator) message(index int) (*icrypto.Message, map[string]interface{}, error) {
+	doc, err := sampleDocument(index, g.Encodings)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to make sample document: %w", err)
+	}
+
+	message, err := utils.BuildEncodedAttestationMessage(doc, g.PrimaryType, g.Fields, g.Encodings, samplePolicy)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build message: %w", err)
+	}
+
+	inputs := make(map[string]interface{}, len(g.Fields)+2)
+	for _, name := range g.Fields {
+		field, err := utils.LookupAttestationField(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		value, err := field.Value(doc, samplePolicy)
+		if err != nil {
+			return nil, nil, err
+		}
+		inputs[name] = inputValue(value)
+	}
+
+	if g.TTL > 0 {
+		validity := utils.Validity{IssuedAt: doc.Timestamp, Deadline: doc.Timestamp.Add(g.TTL)}
+		if err = utils.WithValidity(message, validity); err != nil {
+			return nil, nil, err
+		}
+		inputs[utils.IssuedAtField] = validity.IssuedAt.Unix()
+		inputs[utils.DeadlineField] = validity.Deadline.Unix()
+	}
+
+	return message, inputs, nil
+}
+
+func (g *Generator) validate() error {
+	if g.Domain == nil {
+		return fmt.Errorf("domain is required")
+	}
+	if !identifier.MatchString(g.Library) || !identifier.MatchString(g.PrimaryType) {
+		return fmt.Errorf("library %q and primary type %q must be identifiers", g.Library, g.PrimaryType)
+	}
+	if g.Library == g.PrimaryType {
+		return fmt.Errorf("library and primary type must have different names")
+	}
+
+	return utils.ValidateFieldEncodings(g.PrimaryType, g.Fields, g.Encodings)
+}
+
+// solidityStruct is Solidity struct of the typed data type
+type solidityStruct struct {
+	Name         string
+	TypeHashName string
+	TypeString   string
+	Fields       []solidityField
+}
+
+type solidityField struct {
+	Name string
+	Type string
+	// Encoded is the field expression of hashStruct encoding
+	Encoded string
+}
+
+func newSolidityStruct(typedData apitypes.TypedData, name string) (*solidityStruct, error) {
+	result := &solidityStruct{
+		Name:         name,
+		TypeHashName: constantName(name) + "_TYPEHASH",
+		TypeString:   string(typedData.EncodeType(name)),
+	}
+
+	for _, field := range typedData.Types[name] {
+		if !identifier.MatchString(field.Name) || reserved[field.Name] {
+			return nil, fmt.Errorf("field %s of %s can't be a Solidity identifier", field.Name, name)
+		}
+
+		var encoded string
+		switch {
+		case strings.HasSuffix(field.Type, "]"):
+			return nil, fmt.Errorf("array field %s of %s isn't supported", field.Name, name)
+		case field.Type == "string":
+			encoded = fmt.Sprintf("keccak256(bytes(data.%s))", field.Name)
+		case field.Type == "bytes":
+			encoded = fmt.Sprintf("keccak256(data.%s)", field.Name)
+		case typedData.Types[field.Type] != nil:
+			encoded = fmt.Sprintf("hash(data.%s)", field.Name)
+		default:
+			encoded = "data." + field.Name
+		}
+
+		result.Fields = append(result.Fields, solidityField{Name: field.Name, Type: field.Type, Encoded: encoded})
+	}
+
+	return result, nil
+}
+
+// constantName returns UPPER_SNAKE_CASE of the type name, e.g. USER_DATA for UserData
+func constantName(name string) string {
+	var result strings.Builder
+	for i, r := range name {
+		if i > 0 && r >= 'A' && r <= 'Z' {
+			result.WriteByte('_')
+		}
+		result.WriteRune(r)
+	}
+
+	return strings.ToUpper(result.String())
+}
+
+// Solidity returns source of the library with type hashes, struct hashes,
+// digest and recover functions of the signed message
+func (g *Generator) Solidity() ([]byte, error) {
+	if err := g.validate(); err != nil {
+		return nil, err
+	}
+
+	message, _, err := g.message(0)
+	if err != nil {
+		return nil, err
+	}
+	typedData := g.Domain.TypedData(message)
+
+	separator, err := g.Domain.Separator()
+	if err != nil {
+		return nil, err
+	}
+	domain, err := json.Marshal(typedData.Domain.Map())
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal domain: %w", err)
+	}
+
+	// Referred types first, as Solidity has them declared before use
+	var structs []*solidityStruct
+	for _, name := range append(slices.Sorted(maps.Keys(message.Types)), g.PrimaryType) {
+		solidityStruct, err := newSolidityStruct(typedData, name)
+		if err != nil {
+			return nil, err
+		}
+		structs = append(structs, solidityStruct)
+	}
+
+	var source bytes.Buffer
+	err = libraryTemplate.Execute(&source, struct {
+		Library         string
+		PrimaryType     string
+		Domain          string
+		DomainSeparator string
+		Structs         []*solidityStruct
+		Expiring        bool
+	}{
+		Library:         g.Library,
+		PrimaryType:     g.PrimaryType,
+		Domain:          string(domain),
+		DomainSeparator: hexutil.Encode(separator),
+		Structs:         structs,
+		Expiring:        g.TTL > 0,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute library template: %w", err)
+	}
+
+	return source.Bytes(), nil
+}
+
+var libraryTemplate = template.Must(template.New("library").Parse(`// SPDX-License-Identifier: MIT
+// Code generated by aws-nitro-enclaves-av gen solidity. DO NOT EDIT.
+pragma solidity ^0.8.0;
+
+/// @notice Verifies endorsements of attestation documents signed as {{ .PrimaryType }}
+/// within the domain {{ .Domain }}
+library {{ .Library }} {
+{{- range .Structs }}
+    struct {{ .Name }} {
+{{- range .Fields }}
+        {{ .Type }} {{ .Name }};
+{{- end }}
+    }
+{{ end }}
+{{- range .Structs }}
+    bytes32 internal constant {{ .TypeHashName }} =
+        keccak256("{{ .TypeString }}");
+{{ end }}
+    bytes32 internal constant DOMAIN_SEPARATOR = {{ .DomainSeparator }};
+
+    /// @dev Upper bound of s of the signatures, the service signs with low s only
+    uint256 private constant MAX_S = 0x7FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF5D576E7357A4501DDFE92F46681B20A0;
+{{ range .Structs }}
+    function hash({{ .Name }} memory data) internal pure returns (bytes32) {
+        return keccak256(abi.encode(
+            {{ .TypeHashName }}
+{{- range .Fields }},
+            {{ .Encoded }}
+{{- end }}
+        ));
+    }
+{{ end }}
+    /// @notice Returns EIP712 digest the endorsement is signed over
+    function digest({{ .PrimaryType }} memory data) internal pure returns (bytes32) {
+        return keccak256(abi.encodePacked("\x19\x01", DOMAIN_SEPARATOR, hash(data)));
+    }
+
+    /// @notice Returns the signer of the endorsement, reverts on malformed signature
+    function recover({{ .PrimaryType }} memory data, bytes memory signature) internal pure returns (address signer) {
+        require(signature.length == 65, "invalid signature length");
+
+        bytes32 r;
+        bytes32 s;
+        uint8 v;
+        assembly {
+            r := mload(add(signature, 0x20))
+            s := mload(add(signature, 0x40))
+            v := byte(0, mload(add(signature, 0x60)))
+        }
+        require(uint256(s) <= MAX_S, "invalid signature s");
+
+        signer = ecrecover(digest(data), v, r, s);
+        require(signer != address(0), "invalid signature");
+    }
+
+    /// @notice Checks the endorsement is signed by the signer{{ if .Expiring }} and isn't expired{{ end }}
+    function verify({{ .PrimaryType }} memory data, bytes memory signature, address signer) internal {{ if .Expiring }}view{{ else }}pure{{ end }} returns (bool) {
+{{- if .Expiring }}
+        if (block.timestamp > data.deadline) {
+            return false;
+        }
+{{- end }}
+        return recover(data, signature) == signer;
+    }
+}
+`))
@@ -0,0 +1,206 @@
+package solidity
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/utils"
+	"github.com/distributed-lab/enclave-extras/attestation"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// vectorsCount is count of sample documents, more than one catches values
+// mixed up between fields
+const vectorsCount = 2
+
+const samplePolicy = "production"
+
+// vectorsKey signs the vectors, it is derived from a public seed, so the
+// vectors are reproducible and the key must never be trusted
+var vectorsKey, _ = crypto.ToECDSA(crypto.Keccak256([]byte("aws-nitro-enclaves-av test vectors")))
+
+// Vectors are endorsements of sample documents made by the Go encoder
+type Vectors struct {
+	Library         string                 `json:"library"`
+	PrimaryType     string                 `json:"primary_type"`
+	TypeString      string                 `json:"type_string"`
+	TypeHash        string                 `json:"type_hash"`
+	Domain          map[string]interface{} `json:"domain"`
+	DomainSeparator string                 `json:"domain_separator"`
+	Signer          common.Address         `json:"signer"`
+	Vectors         []Vector               `json:"vectors"`
+}
+
+type Vector struct {
+	// Inputs are the attestation fields before encoding, and validity
+	Inputs map[string]interface{} `json:"inputs"`
+	// TypedData is eth_signTypedData_v4 compatible typed data as the service returns it
+	TypedData  json.RawMessage `json:"typed_data"`
+	StructHash string          `json:"struct_hash"`
+	Digest     string          `json:"digest"`
+	// Signature is hex signature with recovery byte 27 or 28
+	Signature string `json:"signature"`
+}
+
+// Vectors returns endorsements of sample documents signed with a test key
+func (g *Generator) Vectors() (*Vectors, error) {
+	if err := g.validate(); err != nil {
+		return nil, err
+	}
+
+	separator, err := g.Domain.Separator()
+	if err != nil {
+		return nil, err
+	}
+
+	vectors := &Vectors{
+		Library:         g.Library,
+		PrimaryType:     g.PrimaryType,
+		Domain:          g.Domain.Map(),
+		DomainSeparator: hexutil.Encode(separator),
+		Signer:          crypto.PubkeyToAddress(vectorsKey.PublicKey),
+	}
+
+	for index := range vectorsCount {
+		message, inputs, err := g.message(index)
+		if err != nil {
+			return nil, err
+		}
+
+		typedData := g.Domain.TypedData(message)
+		vectors.TypeString = string(typedData.EncodeType(g.PrimaryType))
+		vectors.TypeHash = hexutil.Encode(message.TypeHash())
+
+		structHash, err := typedData.HashStruct(g.PrimaryType, typedData.Message)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash message: %w", err)
+		}
+		signature, digest, err := g.Domain.SignTypedData(message, vectorsKey)
+		if err != nil {
+			return nil, err
+		}
+		rawTypedData, err := g.Domain.MarshalTypedData(message)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal typed data: %w", err)
+		}
+
+		vectors.Vectors = append(vectors.Vectors, Vector{
+			Inputs:     inputs,
+			TypedData:  rawTypedData,
+			StructHash: hexutil.Encode(structHash),
+			Digest:     hexutil.Encode(digest),
+			Signature:  hexutil.Encode(signature),
+		})
+	}
+
+	return vectors, nil
+}
+
+// sampleDocument returns deterministic document of the index with values
+// every encoding accepts: bytes fields are secp256k1 public keys for address
+// encoding, or ABI tuples for abi encoding
+func sampleDocument(index int, encodings map[string]string) (*attestation.NSMAttestationDoc, error) {
+	seed := func(label string) []byte {
+		return crypto.Keccak256([]byte(fmt.Sprintf("%s/%d", label, index)))
+	}
+	sampleBytes := func(name string) ([]byte, error) {
+		if encoding, _, _ := strings.Cut(encodings[name], "("); encoding == utils.EncodingABI {
+			_, arg, err := utils.LookupEncoding(encodings[name])
+			if err != nil {
+				return nil, err
+			}
+			return sampleTuple(arg, seed)
+		}
+
+		key, err := crypto.ToECDSA(seed(name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive sample key: %w", err)
+		}
+		return crypto.FromECDSAPub(&key.PublicKey), nil
+	}
+
+	doc := &attestation.NSMAttestationDoc{
+		ModuleID:  fmt.Sprintf("i-%017x-enc%016x", index, index),
+		Timestamp: time.Date(2026, time.January, 1, index, 0, 0, 0, time.UTC),
+		Digest:    "SHA384",
+		PCRs:      make(map[int][]byte),
+	}
+	// 32 PCRs of 48 bytes
+	for pcr := range 32 {
+		value := seed(fmt.Sprintf("pcr%d", pcr))
+		doc.PCRs[pcr] = append(value, value[:16]...)
+	}
+
+	var err error
+	if doc.PublicKey, err = sampleBytes("public_key"); err != nil {
+		return nil, err
+	}
+	if doc.UserData, err = sampleBytes("user_data"); err != nil {
+		return nil, err
+	}
+	if doc.Nonce, err = sampleBytes("nonce"); err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+// sampleTuple returns ABI-encoded tuple of the abi encoding argument
+func sampleTuple(arg string, seed func(string) []byte) ([]byte, error) {
+	arguments, err := utils.TupleArguments(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]interface{}, len(arguments))
+	for i, argument := range arguments {
+		value := reflect.New(argument.Type.GetType()).Elem()
+		switch argument.Type.T {
+		case abi.IntTy, abi.UintTy:
+			number := new(big.Int).SetBytes(seed(argument.Name)[:argument.Type.Size/8-1])
+			switch value.Kind() {
+			case reflect.Pointer:
+				value.Set(reflect.ValueOf(number))
+			case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				value.SetInt(number.Int64())
+			default:
+				value.SetUint(number.Uint64())
+			}
+		case abi.BoolTy:
+			value.SetBool(seed(argument.Name)[0]%2 == 0)
+		case abi.StringTy:
+			value.SetString(hexutil.Encode(seed(argument.Name)))
+		case abi.AddressTy:
+			value.Set(reflect.ValueOf(common.BytesToAddress(seed(argument.Name))))
+		case abi.FixedBytesTy:
+			reflect.Copy(value, reflect.ValueOf(seed(argument.Name)))
+		case abi.BytesTy:
+			value.SetBytes(seed(argument.Name))
+		}
+		values[i] = value.Interface()
+	}
+
+	encoded, err := arguments.Pack(values...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode sample tuple: %w", err)
+	}
+
+	return encoded, nil
+}
+
+// inputValue returns JSON friendly value of the attestation field
+func inputValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case []byte:
+		return hexutil.Bytes(value)
+	default:
+		return value
+	}
+}
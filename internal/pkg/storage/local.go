@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Prefix of temporary files, they are never listed
+const tmpPrefix = ".tmp-"
+
+// Local is Storage in a local directory, e.g. NFS mount. Objects are
+// written to a temporary file, synced and then renamed, or linked if they
+// must not be replaced, so a crash never leaves a truncated object.
+type Local struct {
+	root string
+	// Base names of objects readable by the owner only
+	private []string
+}
+
+// NewLocal creates the root directory if absent. Objects are readable by
+// everyone, except the ones with private base names.
+func NewLocal(root string, private ...string) (*Local, error) {
+	if err := os.MkdirAll(root, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory %s: %w", root, err)
+	}
+
+	return &Local{root: root, private: private}, nil
+}
+
+func (l *Local) Read(name string) ([]byte, error) {
+	return os.ReadFile(l.path(name))
+}
+
+func (l *Local) Write(name string, data []byte) error {
+	return l.write(name, data, os.Rename)
+}
+
+func (l *Local) Create(name string, data []byte) error {
+	// Unlike rename, link fails if the target exists
+	return l.write(name, data, os.Link)
+}
+
+func (l *Local) List(prefix string) ([]string, error) {
+	var names []string
+
+	err := filepath.WalkDir(l.path(prefix), func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), tmpPrefix) {
+			return nil
+		}
+
+		name, err := filepath.Rel(l.root, filePath)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(name))
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", prefix, err)
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+func (l *Local) ModTime(name string) (time.Time, error) {
+	info, err := os.Stat(l.path(name))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return info.ModTime(), nil
+}
+
+func (l *Local) String() string {
+	return l.root
+}
+
+func (l *Local) path(name string) string {
+	return filepath.Join(l.root, filepath.FromSlash(clean(name)))
+}
+
+// write puts data in a synced temporary file and publishes it with publish
+func (l *Local) write(name string, data []byte, publish func(oldpath, newpath string) error) error {
+	target := l.path(name)
+	dir := filepath.Dir(target)
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create directory of %s: %w", name, err)
+	}
+
+	tmp, err := os.CreateTemp(dir, tmpPrefix+"*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for %s: %w", name, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if err = tmp.Chmod(l.mode(name)); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to chmod %s: %w", name, err)
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync %s: %w", name, err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", name, err)
+	}
+
+	if err = publish(tmp.Name(), target); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("%s: %w", name, ErrExist)
+		}
+		return fmt.Errorf("failed to publish %s: %w", name, err)
+	}
+
+	// Persist the directory entry as well
+	if err = syncDir(dir); err != nil {
+		return fmt.Errorf("failed to sync directory of %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// mode returns permissions of the object, temporary files are created
+// readable by the owner only
+func (l *Local) mode(name string) os.FileMode {
+	if slices.Contains(l.private, path.Base(clean(name))) {
+		return 0600
+	}
+
+	return 0644
+}
+
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return f.Sync()
+}
@@ -0,0 +1,83 @@
+// Package storage keeps bootstrap artifacts, e.g. attestation documents,
+// in a local directory or an S3-compatible object store.
+package storage
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotExist is returned if the object is absent
+	ErrNotExist = fs.ErrNotExist
+	// ErrExist is returned by Create if the object is already present
+	ErrExist = fs.ErrExist
+)
+
+// Storage is a flat store of objects with slash-separated names. Writes are
+// atomic, readers see either the previous or the new object, never a part
+// of it, even if the writer crashes.
+type Storage interface {
+	// Read returns ErrNotExist if the object is absent
+	Read(name string) ([]byte, error)
+	// Write creates or replaces the object
+	Write(name string, data []byte) error
+	// Create writes the object only if it is absent, ErrExist otherwise
+	Create(name string, data []byte) error
+	// List returns sorted names of objects under the prefix, recursively
+	List(prefix string) ([]string, error)
+	// ModTime returns the time the object was written at
+	ModTime(name string) (time.Time, error)
+	// String describes the location in logs and errors
+	String() string
+}
+
+// Sub returns storage of the objects under the prefix of s
+func Sub(s Storage, prefix string) Storage {
+	return &sub{parent: s, prefix: strings.Trim(prefix, "/")}
+}
+
+type sub struct {
+	parent Storage
+	prefix string
+}
+
+func (s *sub) Read(name string) ([]byte, error) {
+	return s.parent.Read(path.Join(s.prefix, name))
+}
+
+func (s *sub) Write(name string, data []byte) error {
+	return s.parent.Write(path.Join(s.prefix, name), data)
+}
+
+func (s *sub) Create(name string, data []byte) error {
+	return s.parent.Create(path.Join(s.prefix, name), data)
+}
+
+func (s *sub) List(prefix string) ([]string, error) {
+	names, err := s.parent.List(path.Join(s.prefix, prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	for i, name := range names {
+		names[i] = strings.TrimPrefix(name, s.prefix+"/")
+	}
+
+	return names, nil
+}
+
+func (s *sub) ModTime(name string) (time.Time, error) {
+	return s.parent.ModTime(path.Join(s.prefix, name))
+}
+
+func (s *sub) String() string {
+	return strings.TrimSuffix(s.parent.String(), "/") + "/" + s.prefix
+}
+
+// clean makes the name relative to the storage root, so it can't escape it
+func clean(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// S3Client is the subset of S3 API used by the storage
+type S3Client interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+}
+
+// S3 is Storage in a bucket of S3 or S3-compatible object store. Object
+// uploads are atomic by themselves, Create relies on conditional writes.
+type S3 struct {
+	client S3Client
+	bucket string
+	prefix string
+}
+
+// NewS3 returns storage of the objects under the prefix of the bucket
+func NewS3(client S3Client, bucket, prefix string) *S3 {
+	return &S3{
+		client: client,
+		bucket: bucket,
+		prefix: strings.Trim(prefix, "/"),
+	}
+}
+
+func (s *S3) Read(name string) ([]byte, error) {
+	out, err := s.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(name)),
+	})
+	if err != nil {
+		return nil, s.wrap(name, err)
+	}
+	defer out.Body.Close()
+
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+
+	return data, nil
+}
+
+func (s *S3) Write(name string, data []byte) error {
+	return s.put(name, data, nil)
+}
+
+func (s *S3) Create(name string, data []byte) error {
+	return s.put(name, data, aws.String("*"))
+}
+
+func (s *S3) List(prefix string) ([]string, error) {
+	keyPrefix := s.key(prefix)
+	if keyPrefix != "" {
+		keyPrefix += "/"
+	}
+
+	var names []string
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(keyPrefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", prefix, err)
+		}
+
+		for _, object := range page.Contents {
+			name := strings.TrimPrefix(aws.ToString(object.Key), s.prefix)
+			names = append(names, strings.TrimPrefix(name, "/"))
+		}
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *S3) ModTime(name string) (time.Time, error) {
+	out, err := s.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(name)),
+	})
+	if err != nil {
+		return time.Time{}, s.wrap(name, err)
+	}
+
+	return aws.ToTime(out.LastModified), nil
+}
+
+func (s *S3) String() string {
+	return "s3://" + path.Join(s.bucket, s.prefix)
+}
+
+func (s *S3) key(name string) string {
+	return strings.TrimPrefix(path.Join(s.prefix, clean(name)), "/")
+}
+
+func (s *S3) put(name string, data []byte, ifNoneMatch *string) error {
+	_, err := s.client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(s.key(name)),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+		IfNoneMatch:   ifNoneMatch,
+	})
+	if err != nil {
+		return s.wrap(name, err)
+	}
+
+	return nil
+}
+
+// wrap maps S3 errors to ErrNotExist and ErrExist
+func (s *S3) wrap(name string, err error) error {
+	var (
+		noSuchKey *s3types.NoSuchKey
+		notFound  *s3types.NotFound
+		apiErr    smithy.APIError
+	)
+	switch {
+	case errors.As(err, &noSuchKey), errors.As(err, &notFound):
+		return fmt.Errorf("%s: %w", name, ErrNotExist)
+	case errors.As(err, &apiErr) && (apiErr.ErrorCode() == "PreconditionFailed" || apiErr.ErrorCode() == "ConditionalRequestConflict"):
+		return fmt.Errorf("%s: %w", name, ErrExist)
+	default:
+		return fmt.Errorf("failed to access %s in %s: %w", name, s, err)
+	}
+}
@@ -0,0 +1,306 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+// Encoding turns value of the attestation field into typed data fields
+type Encoding interface {
+	// Types returns typed data fields the attestation field is encoded into
+	// and struct types they refer to, arg is the encoding argument
+	Types(name string, field AttestationField, arg string) ([]apitypes.Type, apitypes.Types, error)
+	// Encode returns values of the fields returned by Types in the same order
+	Encode(name string, value interface{}, arg string) ([]interface{}, error)
+}
+
+const (
+	// EncodingRaw signs the field as is, it is used if no encoding is set
+	EncodingRaw = "raw"
+	// EncodingKeccak256 signs keccak256 of bytes or string field as bytes32
+	EncodingKeccak256 = "keccak256"
+	// EncodingSplit signs 48 bytes PCR as <field>_hi bytes32 and <field>_lo bytes16
+	EncodingSplit = "split"
+	// EncodingAddress signs Ethereum address of secp256k1 public key in the field
+	EncodingAddress = "address"
+	// EncodingABI signs ABI-encoded tuple in the field as a struct, e.g.
+	// "abi(address wallet,uint256 deadline)"
+	EncodingABI = "abi"
+)
+
+var encodings = map[string]Encoding{
+	EncodingRaw:       rawEncoding{},
+	EncodingKeccak256: keccak256Encoding{},
+	EncodingSplit:     splitEncoding{},
+	EncodingAddress:   addressEncoding{},
+	EncodingABI:       abiEncoding{},
+}
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// RegisterEncoding makes the encoding available by name. Encodings are
+// registered on init, it isn't safe to call concurrently with signing.
+func RegisterEncoding(name string, encoding Encoding) {
+	encodings[name] = encoding
+}
+
+// LookupEncoding returns the encoding and its argument of the spec like
+// "keccak256" or "abi(address wallet)", raw encoding if spec is empty
+func LookupEncoding(spec string) (Encoding, string, error) {
+	if spec == "" {
+		return encodings[EncodingRaw], "", nil
+	}
+
+	name, arg := spec, ""
+	if open := strings.IndexByte(spec, '('); open >= 0 {
+		if !strings.HasSuffix(spec, ")") {
+			return nil, "", fmt.Errorf("encoding %q has unclosed argument", spec)
+		}
+		name, arg = spec[:open], spec[open+1:len(spec)-1]
+	}
+
+	encoding, ok := encodings[name]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown encoding %q", name)
+	}
+
+	return encoding, arg, nil
+}
+
+type rawEncoding struct{}
+
+func (rawEncoding) Types(name string, field AttestationField, arg string) ([]apitypes.Type, apitypes.Types, error) {
+	if arg != "" {
+		return nil, nil, fmt.Errorf("%s encoding has no argument", EncodingRaw)
+	}
+	return []apitypes.Type{{Name: name, Type: field.Type}}, nil, nil
+}
+
+func (rawEncoding) Encode(_ string, value interface{}, _ string) ([]interface{}, error) {
+	return []interface{}{value}, nil
+}
+
+type keccak256Encoding struct{}
+
+func (keccak256Encoding) Types(name string, field AttestationField, arg string) ([]apitypes.Type, apitypes.Types, error) {
+	if arg != "" {
+		return nil, nil, fmt.Errorf("%s encoding has no argument", EncodingKeccak256)
+	}
+	if field.Type != "bytes" && field.Type != "string" {
+		return nil, nil, fmt.Errorf("%s encoding requires bytes or string field, got %s", EncodingKeccak256, field.Type)
+	}
+	return []apitypes.Type{{Name: name, Type: "bytes32"}}, nil, nil
+}
+
+func (keccak256Encoding) Encode(_ string, value interface{}, _ string) ([]interface{}, error) {
+	switch value := value.(type) {
+	case []byte:
+		return []interface{}{crypto.Keccak256(value)}, nil
+	case string:
+		return []interface{}{crypto.Keccak256([]byte(value))}, nil
+	default:
+		return nil, fmt.Errorf("unexpected value of %T", value)
+	}
+}
+
+type splitEncoding struct{}
+
+// pcrSize is SHA-384 size, the PCR bank algorithm of Nitro Enclaves
+const pcrSize = 48
+
+func (splitEncoding) Types(name string, field AttestationField, arg string) ([]apitypes.Type, apitypes.Types, error) {
+	if arg != "" {
+		return nil, nil, fmt.Errorf("%s encoding has no argument", EncodingSplit)
+	}
+	if field.Type != "bytes" || !field.FixedSize {
+		return nil, nil, fmt.Errorf("%s encoding requires PCR field", EncodingSplit)
+	}
+	return []apitypes.Type{
+		{Name: name + "_hi", Type: "bytes32"},
+		{Name: name + "_lo", Type: "bytes16"},
+	}, nil, nil
+}
+
+func (splitEncoding) Encode(_ string, value interface{}, _ string) ([]interface{}, error) {
+	raw, ok := value.([]byte)
+	if !ok || len(raw) != pcrSize {
+		return nil, fmt.Errorf("value must be %d bytes", pcrSize)
+	}
+	return []interface{}{raw[:32], raw[32:]}, nil
+}
+
+type addressEncoding struct{}
+
+func (addressEncoding) Types(name string, field AttestationField, arg string) ([]apitypes.Type, apitypes.Types, error) {
+	if arg != "" {
+		return nil, nil, fmt.Errorf("%s encoding has no argument", EncodingAddress)
+	}
+	if field.Type != "bytes" || field.FixedSize {
+		return nil, nil, fmt.Errorf("%s encoding requires public_key, user_data or nonce field", EncodingAddress)
+	}
+	return []apitypes.Type{{Name: name, Type: "address"}}, nil, nil
+}
+
+// Encode accepts uncompressed (65 bytes) and compressed (33 bytes) key
+func (addressEncoding) Encode(_ string, value interface{}, _ string) ([]interface{}, error) {
+	raw, ok := value.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("unexpected value of %T", value)
+	}
+
+	var (
+		key *ecdsa.PublicKey
+		err error
+	)
+	switch len(raw) {
+	case 65:
+		key, err = crypto.UnmarshalPubkey(raw)
+	case 33:
+		key, err = crypto.DecompressPubkey(raw)
+	default:
+		return nil, fmt.Errorf("value of %d bytes isn't secp256k1 public key", len(raw))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid secp256k1 public key: %w", err)
+	}
+
+	// apitypes encodes address only from hex string, bytes or [20]byte
+	return []interface{}{crypto.PubkeyToAddress(*key).Hex()}, nil
+}
+
+type abiEncoding struct{}
+
+func (abiEncoding) Types(name string, field AttestationField, arg string) ([]apitypes.Type, apitypes.Types, error) {
+	if field.Type != "bytes" || field.FixedSize {
+		return nil, nil, fmt.Errorf("%s encoding requires public_key, user_data or nonce field", EncodingABI)
+	}
+
+	elements, _, err := parseTuple(arg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	structType := structTypeName(name)
+	return []apitypes.Type{{Name: name, Type: structType}}, apitypes.Types{structType: elements}, nil
+}
+
+func (abiEncoding) Encode(_ string, value interface{}, arg string) ([]interface{}, error) {
+	raw, ok := value.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("unexpected value of %T", value)
+	}
+
+	elements, arguments, err := parseTuple(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := arguments.Unpack(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode abi tuple: %w", err)
+	}
+
+	tuple := make(map[string]interface{}, len(elements))
+	for i, element := range elements {
+		tuple[element.Name] = typedDataValue(values[i])
+	}
+
+	return []interface{}{tuple}, nil
+}
+
+// TupleArguments returns ABI arguments of the abi encoding argument like
+// "address wallet,uint256 deadline"
+func TupleArguments(arg string) (abi.Arguments, error) {
+	_, arguments, err := parseTuple(arg)
+	return arguments, err
+}
+
+// parseTuple parses elements like "address wallet,uint256 deadline", only
+// elementary types are supported
+func parseTuple(arg string) ([]apitypes.Type, abi.Arguments, error) {
+	if strings.TrimSpace(arg) == "" {
+		return nil, nil, fmt.Errorf("%s encoding requires tuple elements, e.g. abi(address wallet,uint256 deadline)", EncodingABI)
+	}
+
+	var (
+		elements  []apitypes.Type
+		arguments abi.Arguments
+		names     = make(map[string]bool)
+	)
+	for _, element := range strings.Split(arg, ",") {
+		parts := strings.Fields(element)
+		if len(parts) != 2 {
+			return nil, nil, fmt.Errorf("tuple element %q must be type and name", strings.TrimSpace(element))
+		}
+		elementType, name := parts[0], parts[1]
+
+		if !identifier.MatchString(name) || names[name] {
+			return nil, nil, fmt.Errorf("tuple element name %q is invalid or duplicated", name)
+		}
+		names[name] = true
+
+		abiType, err := abi.NewType(elementType, "", nil)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid type of tuple element %s: %w", name, err)
+		}
+		switch abiType.T {
+		case abi.IntTy, abi.UintTy, abi.BoolTy, abi.StringTy, abi.AddressTy, abi.FixedBytesTy, abi.BytesTy:
+		default:
+			return nil, nil, fmt.Errorf("type %s of tuple element %s isn't elementary", elementType, name)
+		}
+
+		// String is canonical, e.g. uint256 for uint
+		elements = append(elements, apitypes.Type{Name: name, Type: abiType.String()})
+		arguments = append(arguments, abi.Argument{Name: name, Type: abiType})
+	}
+
+	return elements, arguments, nil
+}
+
+// typedDataValue converts decoded ABI value to the one apitypes encodes
+func typedDataValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case common.Address:
+		return value.Hex()
+	case *big.Int, bool, string, []byte:
+		return value
+	}
+
+	reflected := reflect.ValueOf(value)
+	switch reflected.Kind() {
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return new(big.Int).SetUint64(reflected.Uint())
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return big.NewInt(reflected.Int())
+	case reflect.Array:
+		// bytesN
+		raw := make([]byte, reflected.Len())
+		reflect.Copy(reflect.ValueOf(raw), reflected)
+		return raw
+	default:
+		return value
+	}
+}
+
+// structTypeName returns EIP712 struct type name of the field, e.g.
+// UserData for user_data
+func structTypeName(field string) string {
+	var name strings.Builder
+	for _, part := range strings.Split(field, "_") {
+		if part == "" {
+			continue
+		}
+		name.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+
+	return name.String()
+}
@@ -3,6 +3,9 @@ package utils
 import (
 	"errors"
 	"fmt"
+	"maps"
+	"math/big"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -15,13 +18,42 @@ const DefaultPrimaryType string = "Register"
 
 var DefaultFieldsToSign = []string{"pcr0", "public_key"}
 
-var fieldToType = map[string]apitypes.Type{
-	"public_key": {Name: "public_key", Type: "bytes"},
-	"user_data":  {Name: "user_data", Type: "bytes"},
-	"nonce":      {Name: "nonce", Type: "bytes"},
-	"timestamp":  {Name: "timestamp", Type: "uint64"},
-	"digest":     {Name: "digest", Type: "string"},
-	"module_id":  {Name: "module_id", Type: "string"},
+// AttestationField is a field of the attestation document that can be signed
+type AttestationField struct {
+	// Type is Solidity type of the field value
+	Type string
+	// FixedSize is set for bytes of the same length in every document, they
+	// don't make packed encoding ambiguous
+	FixedSize bool
+	// CallerControlled is set for fields the enclave puts any value in, they
+	// prove nothing about the enclave alone
+	CallerControlled bool
+	// Value returns the field value as apitypes encodes it, policy is the
+	// matched PCR policy profile name
+	Value func(attestationDocument *attestation.NSMAttestationDoc, policy string) (interface{}, error)
+}
+
+// attestationFields are fields besides pcrX, see RegisterAttestationField
+var attestationFields = map[string]AttestationField{
+	"public_key": {Type: "bytes", CallerControlled: true, Value: bytesField("public_key", func(doc *attestation.NSMAttestationDoc) []byte { return doc.PublicKey })},
+	"user_data":  {Type: "bytes", CallerControlled: true, Value: bytesField("user_data", func(doc *attestation.NSMAttestationDoc) []byte { return doc.UserData })},
+	"nonce":      {Type: "bytes", CallerControlled: true, Value: bytesField("nonce", func(doc *attestation.NSMAttestationDoc) []byte { return doc.Nonce })},
+	"timestamp": {Type: "uint64", Value: func(doc *attestation.NSMAttestationDoc, _ string) (interface{}, error) {
+		// apitypes encodes integers only from big.Int, strings and floats
+		return big.NewInt(doc.Timestamp.Unix()), nil
+	}},
+	"digest": {Type: "string", Value: func(doc *attestation.NSMAttestationDoc, _ string) (interface{}, error) {
+		return doc.Digest, nil
+	}},
+	"module_id": {Type: "string", Value: func(doc *attestation.NSMAttestationDoc, _ string) (interface{}, error) {
+		return doc.ModuleID, nil
+	}},
+	"policy": {Type: "string", Value: func(_ *attestation.NSMAttestationDoc, policy string) (interface{}, error) {
+		if policy == "" {
+			return nil, fmt.Errorf("%w: policy, no policy profile is matched", ErrAbsentField)
+		}
+		return policy, nil
+	}},
 }
 
 var (
@@ -29,66 +61,205 @@ var (
 	ErrInvalidField = errors.New("invalid attestation document field")
 )
 
-// fields must not have duplicate items
-func BuildTypedDataAttestationMessage(attestationDocument *attestation.NSMAttestationDoc, primaryType string, fields []string) (*icrypto.Message, error) {
-	if attestationDocument == nil {
-		return nil, fmt.Errorf("attestation document shouldn't be nil")
+// RegisterAttestationField makes the field available to sign. Fields are
+// registered on init, it isn't safe to call concurrently with signing.
+func RegisterAttestationField(name string, field AttestationField) {
+	if strings.HasPrefix(name, "pcr") {
+		panic(fmt.Errorf("field %s is reserved for PCRs", name))
 	}
+	attestationFields[name] = field
+}
 
-	dataTypes := make([]apitypes.Type, 0, len(fields))
-	dataValues := make(apitypes.TypedDataMessage, len(fields))
-	for _, field := range fields {
-		if dataType, ok := fieldToType[field]; ok {
-			dataTypes = append(dataTypes, dataType)
-		}
+// LookupAttestationField returns the registered field or pcrX field
+func LookupAttestationField(name string) (AttestationField, error) {
+	if field, ok := attestationFields[name]; ok {
+		return field, nil
+	}
 
-		switch {
-		case strings.HasPrefix(field, "pcr"):
-			pcr, err := strconv.ParseUint(field[3:], 10, 5)
-			if err != nil {
-				return nil, fmt.Errorf("invalid attestation document pcr: %s", field)
-			}
+	if !strings.HasPrefix(name, "pcr") {
+		return AttestationField{}, fmt.Errorf("%w: %s", ErrInvalidField, name)
+	}
+	// 5 bit because currently maximum count of pcr in nsm module is 32
+	pcr, err := strconv.ParseUint(name[3:], 10, 5)
+	if err != nil {
+		return AttestationField{}, fmt.Errorf("invalid attestation document pcr: %s", name)
+	}
 
-			pcrValue, ok := attestationDocument.PCRs[int(pcr)]
+	return AttestationField{
+		Type: "bytes",
+		// PCRs are SHA-384 of the document digest
+		FixedSize: true,
+		Value: func(doc *attestation.NSMAttestationDoc, _ string) (interface{}, error) {
+			pcrValue, ok := doc.PCRs[int(pcr)]
 			if !ok {
-				return nil, fmt.Errorf("%w: %s", ErrAbsentField, field)
+				return nil, fmt.Errorf("%w: %s", ErrAbsentField, name)
 			}
+			return pcrValue, nil
+		},
+	}, nil
+}
 
-			dataTypes = append(dataTypes, apitypes.Type{Name: field, Type: "bytes"})
-			dataValues[field] = pcrValue
-		case field == "public_key":
-			if attestationDocument.PublicKey == nil {
-				return nil, fmt.Errorf("%w: %s", ErrAbsentField, field)
-			}
-			dataValues[field] = attestationDocument.PublicKey
-		case field == "user_data":
-			if attestationDocument.UserData == nil {
-				return nil, fmt.Errorf("%w: %s", ErrAbsentField, field)
-			}
-			dataValues[field] = attestationDocument.UserData
-		case field == "nonce":
-			if attestationDocument.Nonce == nil {
-				return nil, fmt.Errorf("%w: %s", ErrAbsentField, field)
-			}
-			dataValues[field] = attestationDocument.Nonce
-		case field == "timestamp":
-			dataValues[field] = uint64(attestationDocument.Timestamp.Unix())
-		case field == "module_id":
-			dataValues[field] = attestationDocument.ModuleID
-		case field == "digest":
-			dataValues[field] = attestationDocument.Digest
-		default:
-			return nil, fmt.Errorf("%w: %s", ErrInvalidField, field)
+// AttestationFieldNames returns sorted names of the registered fields besides pcrX
+func AttestationFieldNames() []string {
+	return slices.Sorted(maps.Keys(attestationFields))
+}
+
+func bytesField(name string, get func(*attestation.NSMAttestationDoc) []byte) func(*attestation.NSMAttestationDoc, string) (interface{}, error) {
+	return func(doc *attestation.NSMAttestationDoc, _ string) (interface{}, error) {
+		value := get(doc)
+		if value == nil {
+			return nil, fmt.Errorf("%w: %s", ErrAbsentField, name)
+		}
+		return value, nil
+	}
+}
+
+// fields must not have duplicate items, policy is the matched PCR policy profile name
+func BuildTypedDataAttestationMessage(attestationDocument *attestation.NSMAttestationDoc, primaryType string, fields []string, policy string) (*icrypto.Message, error) {
+	return BuildEncodedAttestationMessage(attestationDocument, primaryType, fields, nil, policy)
+}
+
+// BuildEncodedAttestationMessage is BuildTypedDataAttestationMessage with
+// encodings of the fields, raw encoding is used for fields without one
+func BuildEncodedAttestationMessage(attestationDocument *attestation.NSMAttestationDoc, primaryType string, fields []string, encodings map[string]string, policy string) (*icrypto.Message, error) {
+	if attestationDocument == nil {
+		return nil, fmt.Errorf("attestation document shouldn't be nil")
+	}
+
+	layout, err := newFieldsLayout(primaryType, fields, encodings)
+	if err != nil {
+		return nil, err
+	}
+
+	dataValues := make(apitypes.TypedDataMessage, len(layout.dataTypes))
+	for _, field := range layout.fields {
+		values, err := field.encode(attestationDocument, policy)
+		if err != nil {
+			return nil, err
+		}
+		for i, dataType := range field.dataTypes {
+			dataValues[dataType.Name] = values[i]
 		}
 	}
 
 	return &icrypto.Message{
 		TypedDataMessage: dataValues,
-		DataTypes:        dataTypes,
+		DataTypes:        layout.dataTypes,
 		PrimaryType:      primaryType,
+		FixedSizeFields:  layout.fixedSizeFields,
+		Types:            layout.types,
 	}, nil
 }
 
+// ValidateFieldEncodings checks fields to sign and their encodings without
+// attestation document
+func ValidateFieldEncodings(primaryType string, fields []string, encodings map[string]string) error {
+	for name := range encodings {
+		if !slices.Contains(fields, name) {
+			return fmt.Errorf("encoding of %s which isn't signed", name)
+		}
+	}
+
+	_, err := newFieldsLayout(primaryType, fields, encodings)
+	return err
+}
+
+// fieldsLayout is typed data of the attestation fields without values
+type fieldsLayout struct {
+	fields          []encodedField
+	dataTypes       []apitypes.Type
+	types           apitypes.Types
+	fixedSizeFields map[string]bool
+}
+
+// encodedField is the attestation field with its encoding applied
+type encodedField struct {
+	AttestationField
+	name      string
+	encoding  Encoding
+	arg       string
+	dataTypes []apitypes.Type
+	types     apitypes.Types
+}
+
+func newEncodedField(name string, encodingSpec string) (*encodedField, error) {
+	attestationField, err := LookupAttestationField(name)
+	if err != nil {
+		return nil, err
+	}
+	encoding, arg, err := LookupEncoding(encodingSpec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encoding of %s: %w", name, err)
+	}
+
+	dataTypes, types, err := encoding.Types(name, attestationField, arg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encoding of %s: %w", name, err)
+	}
+
+	return &encodedField{
+		AttestationField: attestationField,
+		name:             name,
+		encoding:         encoding,
+		arg:              arg,
+		dataTypes:        dataTypes,
+		types:            types,
+	}, nil
+}
+
+// encode returns values of the field data types in the same order
+func (f *encodedField) encode(attestationDocument *attestation.NSMAttestationDoc, policy string) ([]interface{}, error) {
+	value, err := f.Value(attestationDocument, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := f.encoding.Encode(f.name, value, f.arg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", f.name, err)
+	}
+	if len(values) != len(f.dataTypes) {
+		return nil, fmt.Errorf("encoding of %s returned %d values for %d fields", f.name, len(values), len(f.dataTypes))
+	}
+
+	return values, nil
+}
+
+func newFieldsLayout(primaryType string, fields []string, encodings map[string]string) (*fieldsLayout, error) {
+	layout := &fieldsLayout{
+		dataTypes:       make([]apitypes.Type, 0, len(fields)),
+		types:           make(apitypes.Types),
+		fixedSizeFields: make(map[string]bool),
+	}
+
+	for _, name := range fields {
+		field, err := newEncodedField(name, encodings[name])
+		if err != nil {
+			return nil, err
+		}
+
+		for _, dataType := range field.dataTypes {
+			if slices.ContainsFunc(layout.dataTypes, func(t apitypes.Type) bool { return t.Name == dataType.Name }) {
+				return nil, fmt.Errorf("field %s of %s is already signed", dataType.Name, name)
+			}
+			if field.FixedSize && dataType.Type == field.Type {
+				layout.fixedSizeFields[dataType.Name] = true
+			}
+		}
+		for typeName, typeFields := range field.types {
+			if _, ok := layout.types[typeName]; ok || typeName == primaryType || typeName == icrypto.DomainType {
+				return nil, fmt.Errorf("type %s of %s is already defined", typeName, name)
+			}
+			layout.types[typeName] = typeFields
+		}
+
+		layout.dataTypes = append(layout.dataTypes, field.dataTypes...)
+		layout.fields = append(layout.fields, *field)
+	}
+
+	return layout, nil
+}
+
 func AsPointer[T any](v T) *T {
 	return &v
 }
@@ -0,0 +1,321 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/icrypto"
+	"github.com/distributed-lab/enclave-extras/attestation"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+// Caller-supplied typed data: the caller sends EIP712 types and message,
+// message values like "$pcr0" are placeholders filled from the verified
+// attestation document. Anything else in the message is caller data, so
+// the schema is refused if it could pass it off as attested:
+//   - a field named after an attestation field must hold its placeholder;
+//   - a placeholder must be a struct field of a compatible type;
+//   - at least one placeholder must be used.
+//
+// Encodings of the placeholder fields are applied if set, only encodings
+// into a single primitive field can be used. Expiring endorsements must
+// carry $deadline placeholder, $issued_at is optional.
+
+// PlaceholderPrefix starts message values filled from the attestation document
+const PlaceholderPrefix = "$"
+
+var ErrUnattestedSchema = errors.New("typed data schema could pass unverified data off as attested")
+
+var (
+	arraySuffix    = regexp.MustCompile(`\[\d*\]$`)
+	uintType       = regexp.MustCompile(`^uint(\d+)$`)
+	pcrFieldPrefix = regexp.MustCompile(`^pcr(\d+)`)
+)
+
+// ValidateTypedDataSchema checks the caller-supplied schema and message
+// without attestation document, placeholders are left unfilled
+func ValidateTypedDataSchema(primaryType string, types apitypes.Types, message map[string]interface{}, encodings map[string]string) error {
+	_, err := newSchemaFiller(nil, types, encodings, "", nil).fill(primaryType, message)
+	return err
+}
+
+// BuildTypedDataSchemaMessage returns message of the caller-supplied schema
+// with placeholders filled from the attestation document, policy is the
+// matched PCR policy profile name, validity is nil if it doesn't expire
+func BuildTypedDataSchemaMessage(attestationDocument *attestation.NSMAttestationDoc, primaryType string, types apitypes.Types, message map[string]interface{}, encodings map[string]string, policy string, validity *Validity) (*icrypto.Message, error) {
+	if attestationDocument == nil {
+		return nil, fmt.Errorf("attestation document shouldn't be nil")
+	}
+
+	filler := newSchemaFiller(attestationDocument, types, encodings, policy, validity)
+	filled, err := filler.fill(primaryType, message)
+	if err != nil {
+		return nil, err
+	}
+	if validity != nil && !filler.validityPlaceholders[DeadlineField] {
+		return nil, fmt.Errorf("message of expiring endorsement must have %s%s placeholder", PlaceholderPrefix, DeadlineField)
+	}
+
+	structTypes := make(apitypes.Types, len(types))
+	for name, fields := range types {
+		if name != primaryType {
+			structTypes[name] = fields
+		}
+	}
+
+	typedDataMessage := &icrypto.Message{
+		TypedDataMessage: filled,
+		DataTypes:        types[primaryType],
+		PrimaryType:      primaryType,
+		Types:            structTypes,
+	}
+	if validity != nil {
+		typedDataMessage.Deadline = validity.Deadline
+	}
+
+	return typedDataMessage, nil
+}
+
+type schemaFiller struct {
+	// nil if only the schema is validated
+	attestationDocument *attestation.NSMAttestationDoc
+	types               apitypes.Types
+	encodings           map[string]string
+	policy              string
+	// nil if the endorsement doesn't expire or only the schema is validated
+	validity *Validity
+	// Attestation and validity fields filled at least once
+	placeholders         map[string]bool
+	validityPlaceholders map[string]bool
+}
+
+func newSchemaFiller(attestationDocument *attestation.NSMAttestationDoc, types apitypes.Types, encodings map[string]string, policy string, validity *Validity) *schemaFiller {
+	return &schemaFiller{
+		attestationDocument:  attestationDocument,
+		types:                types,
+		encodings:            encodings,
+		policy:               policy,
+		validity:             validity,
+		placeholders:         make(map[string]bool),
+		validityPlaceholders: make(map[string]bool),
+	}
+}
+
+func (f *schemaFiller) fill(primaryType string, message map[string]interface{}) (map[string]interface{}, error) {
+	if _, ok := f.types[icrypto.DomainType]; ok {
+		return nil, fmt.Errorf("types must not define %s, it is built from the domain", icrypto.DomainType)
+	}
+	if _, ok := f.types[primaryType]; !ok {
+		return nil, fmt.Errorf("primary type %s is absent in types", primaryType)
+	}
+
+	filled, err := f.fillStruct(primaryType, message, primaryType)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.placeholders) == 0 {
+		return nil, fmt.Errorf("%w: message has no placeholders", ErrUnattestedSchema)
+	}
+	for name := range f.encodings {
+		if !f.placeholders[name] {
+			return nil, fmt.Errorf("encoding of %s which has no placeholder", name)
+		}
+	}
+
+	return filled, nil
+}
+
+// fillStruct returns copy of the struct value with placeholders filled,
+// path is the value location used in errors
+func (f *schemaFiller) fillStruct(typeName string, value map[string]interface{}, path string) (map[string]interface{}, error) {
+	fields := f.types[typeName]
+	if len(value) > len(fields) {
+		for key := range value {
+			if !hasField(fields, key) {
+				return nil, fmt.Errorf("%s.%s is absent in type %s", path, key, typeName)
+			}
+		}
+	}
+
+	filled := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		fieldPath := path + "." + field.Name
+		fieldValue, ok := value[field.Name]
+		if !ok {
+			return nil, fmt.Errorf("%s is absent in message", fieldPath)
+		}
+
+		placeholder, isPlaceholder := placeholderName(fieldValue)
+		if attested, ok := f.attestedFieldName(field.Name); ok && placeholder != attested {
+			return nil, fmt.Errorf("%w: %s must be %s%s placeholder", ErrUnattestedSchema, fieldPath, PlaceholderPrefix, attested)
+		}
+
+		var err error
+		if isPlaceholder {
+			if filled[field.Name], err = f.fillPlaceholder(field, placeholder, fieldPath); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if filled[field.Name], err = f.fillValue(field.Type, fieldValue, fieldPath); err != nil {
+			return nil, err
+		}
+	}
+
+	return filled, nil
+}
+
+// attestedFieldName returns the attestation or validity field the struct
+// field is named after: equal to it or starting with it, ignoring case and
+// underscores, e.g. publicKey and pcr0Value. The longest one is returned.
+func (f *schemaFiller) attestedFieldName(name string) (string, bool) {
+	normalized := normalizeFieldName(name)
+
+	var names []string
+	for attestationName := range attestationFields {
+		names = append(names, attestationName)
+	}
+	if f.validity != nil {
+		names = append(names, IssuedAtField, DeadlineField)
+	}
+	if match := pcrFieldPrefix.FindStringSubmatch(normalized); match != nil {
+		if index, err := strconv.ParseUint(match[1], 10, 5); err == nil {
+			names = append(names, "pcr"+strconv.FormatUint(index, 10))
+		}
+	}
+
+	attested := ""
+	for _, candidate := range names {
+		prefix := normalizeFieldName(candidate)
+		if strings.HasPrefix(normalized, prefix) && len(prefix) > len(normalizeFieldName(attested)) {
+			attested = candidate
+		}
+	}
+
+	return attested, attested != ""
+}
+
+func normalizeFieldName(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "_", ""))
+}
+
+// fillValue returns copy of the non-placeholder value, placeholders are
+// refused inside arrays as they can't be typed there
+func (f *schemaFiller) fillValue(fieldType string, value interface{}, path string) (interface{}, error) {
+	if _, ok := placeholderName(value); ok {
+		return nil, fmt.Errorf("%w: placeholder %v at %s must be a struct field", ErrUnattestedSchema, value, path)
+	}
+
+	if itemType := arraySuffix.ReplaceAllString(fieldType, ""); itemType != fieldType {
+		items, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s must be array of %s", path, itemType)
+		}
+
+		filled := make([]interface{}, len(items))
+		for i, item := range items {
+			var err error
+			if filled[i], err = f.fillValue(itemType, item, path+"["+strconv.Itoa(i)+"]"); err != nil {
+				return nil, err
+			}
+		}
+		return filled, nil
+	}
+
+	if _, ok := f.types[fieldType]; ok {
+		structValue, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s must be %s struct", path, fieldType)
+		}
+		return f.fillStruct(fieldType, structValue, path)
+	}
+
+	return value, nil
+}
+
+func (f *schemaFiller) fillPlaceholder(field apitypes.Type, placeholder string, path string) (interface{}, error) {
+	if isValidityField(placeholder) {
+		return f.fillValidityPlaceholder(field, placeholder, path)
+	}
+
+	encoded, err := newEncodedField(placeholder, f.encodings[placeholder])
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid placeholder %s%s: %w", path, PlaceholderPrefix, placeholder, err)
+	}
+	if len(encoded.dataTypes) != 1 || len(encoded.types) != 0 {
+		return nil, fmt.Errorf("%s: encoding of %s%s must be a single primitive field", path, PlaceholderPrefix, placeholder)
+	}
+	if attestationType := encoded.dataTypes[0].Type; !compatibleType(attestationType, field.Type) {
+		return nil, fmt.Errorf("%w: placeholder %s%s of %s type can't be %s %s", ErrUnattestedSchema, PlaceholderPrefix, placeholder, attestationType, field.Type, path)
+	}
+	f.placeholders[placeholder] = true
+
+	if f.attestationDocument == nil {
+		return nil, nil
+	}
+
+	values, err := encoded.encode(f.attestationDocument, f.policy)
+	if err != nil {
+		return nil, err
+	}
+
+	return values[0], nil
+}
+
+func (f *schemaFiller) fillValidityPlaceholder(field apitypes.Type, placeholder string, path string) (interface{}, error) {
+	if !compatibleType(validityType, field.Type) {
+		return nil, fmt.Errorf("placeholder %s%s of %s type can't be %s %s", PlaceholderPrefix, placeholder, validityType, field.Type, path)
+	}
+	f.validityPlaceholders[placeholder] = true
+
+	if f.attestationDocument == nil {
+		return nil, nil
+	}
+	if f.validity == nil {
+		return nil, fmt.Errorf("%s: placeholder %s%s is filled only for expiring endorsement", path, PlaceholderPrefix, placeholder)
+	}
+
+	return f.validity.value(placeholder), nil
+}
+
+// compatibleType reports whether value of the attestation field type is
+// encoded by EIP712 the same as the schema type, i.e. without truncation
+func compatibleType(attestationType, schemaType string) bool {
+	if attestationType == schemaType {
+		return true
+	}
+	if attestationType != "uint64" {
+		return false
+	}
+
+	match := uintType.FindStringSubmatch(schemaType)
+	if match == nil {
+		return false
+	}
+	size, err := strconv.Atoi(match[1])
+
+	return err == nil && size >= 64 && size <= 256 && size%8 == 0
+}
+
+func placeholderName(value interface{}) (string, bool) {
+	str, ok := value.(string)
+	if !ok {
+		return "", false
+	}
+
+	return strings.CutPrefix(str, PlaceholderPrefix)
+}
+
+func hasField(fields []apitypes.Type, name string) bool {
+	for _, field := range fields {
+		if field.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
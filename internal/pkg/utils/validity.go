@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"fmt"
+	"math/big"
+	"slices"
+	"time"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/icrypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+const (
+	// IssuedAtField is unix time the endorsement is signed at
+	IssuedAtField = "issued_at"
+	// DeadlineField is unix time the endorsement expires at
+	DeadlineField = "deadline"
+)
+
+// validityType is the type the validity fields are signed as
+const validityType = "uint64"
+
+// Validity is the time window of an expiring endorsement
+type Validity struct {
+	IssuedAt time.Time
+	Deadline time.Time
+}
+
+// value returns the field value as apitypes encodes it
+func (v *Validity) value(field string) *big.Int {
+	if field == IssuedAtField {
+		return big.NewInt(v.IssuedAt.Unix())
+	}
+	return big.NewInt(v.Deadline.Unix())
+}
+
+// WithValidity appends issued_at and deadline uint64 fields to the message
+// built of the attestation fields
+func WithValidity(message *icrypto.Message, validity Validity) error {
+	for _, field := range []string{IssuedAtField, DeadlineField} {
+		if slices.ContainsFunc(message.DataTypes, func(t apitypes.Type) bool { return t.Name == field }) {
+			return fmt.Errorf("field %s is already signed", field)
+		}
+
+		message.DataTypes = append(message.DataTypes, apitypes.Type{Name: field, Type: validityType})
+		message.TypedDataMessage[field] = validity.value(field)
+	}
+	message.Deadline = validity.Deadline
+
+	return nil
+}
+
+func isValidityField(name string) bool {
+	return name == IssuedAtField || name == DeadlineField
+}
@@ -5,6 +5,8 @@ import (
 	"net/http"
 
 	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/config"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/health"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/icrypto"
 	"gitlab.com/distributed_lab/logan/v3"
 )
 
@@ -13,6 +15,13 @@ type ctxKey int
 const (
 	logCtxKey ctxKey = iota
 	signerCtxKey
+	verifierCtxKey
+	noncesCtxKey
+	policiesCtxKey
+	batchCtxKey
+	validityCtxKey
+	domainResolverCtxKey
+	healthCtxKey
 )
 
 func CtxLog(entry *logan.Entry) func(context.Context) context.Context {
@@ -34,3 +43,73 @@ func CtxSigner(signer *config.Signer) func(context.Context) context.Context {
 func Signer(r *http.Request) *config.Signer {
 	return r.Context().Value(signerCtxKey).(*config.Signer)
 }
+
+func CtxVerifier(verifier *config.Verifier) func(context.Context) context.Context {
+	return func(ctx context.Context) context.Context {
+		return context.WithValue(ctx, verifierCtxKey, verifier)
+	}
+}
+
+func Verifier(r *http.Request) *config.Verifier {
+	return r.Context().Value(verifierCtxKey).(*config.Verifier)
+}
+
+func CtxNonces(nonces *config.Nonces) func(context.Context) context.Context {
+	return func(ctx context.Context) context.Context {
+		return context.WithValue(ctx, noncesCtxKey, nonces)
+	}
+}
+
+func Nonces(r *http.Request) *config.Nonces {
+	return r.Context().Value(noncesCtxKey).(*config.Nonces)
+}
+
+func CtxPolicies(policies *config.Policies) func(context.Context) context.Context {
+	return func(ctx context.Context) context.Context {
+		return context.WithValue(ctx, policiesCtxKey, policies)
+	}
+}
+
+func Policies(r *http.Request) *config.Policies {
+	return r.Context().Value(policiesCtxKey).(*config.Policies)
+}
+
+func CtxBatch(batch *config.Batch) func(context.Context) context.Context {
+	return func(ctx context.Context) context.Context {
+		return context.WithValue(ctx, batchCtxKey, batch)
+	}
+}
+
+func Batch(r *http.Request) *config.Batch {
+	return r.Context().Value(batchCtxKey).(*config.Batch)
+}
+
+func CtxValidity(validity *config.Validity) func(context.Context) context.Context {
+	return func(ctx context.Context) context.Context {
+		return context.WithValue(ctx, validityCtxKey, validity)
+	}
+}
+
+func Validity(r *http.Request) *config.Validity {
+	return r.Context().Value(validityCtxKey).(*config.Validity)
+}
+
+func CtxDomainResolver(resolver *icrypto.DomainResolver) func(context.Context) context.Context {
+	return func(ctx context.Context) context.Context {
+		return context.WithValue(ctx, domainResolverCtxKey, resolver)
+	}
+}
+
+func DomainResolver(r *http.Request) *icrypto.DomainResolver {
+	return r.Context().Value(domainResolverCtxKey).(*icrypto.DomainResolver)
+}
+
+func CtxHealth(health *health.Health) func(context.Context) context.Context {
+	return func(ctx context.Context) context.Context {
+		return context.WithValue(ctx, healthCtxKey, health)
+	}
+}
+
+func Health(r *http.Request) *health.Health {
+	return r.Context().Value(healthCtxKey).(*health.Health)
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/nitro"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/resources"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"gitlab.com/distributed_lab/ape"
+	"gitlab.com/distributed_lab/ape/problems"
+)
+
+// GetSigner renders the signer identity with the attestation documents
+// proving its key was generated within the enclave
+func GetSigner(w http.ResponseWriter, r *http.Request) {
+	signer := Signer(r)
+	generation := signer.Current()
+
+	docs, err := nitro.ReadAttestationDocs(generation.Storage)
+	if err != nil {
+		Log(r).WithError(err).Error("Failed to read signer attestation documents")
+		ape.RenderErr(w, problems.InternalError())
+		return
+	}
+
+	pcrs := make(map[string]string, len(signer.PCRs()))
+	for index, value := range signer.PCRs() {
+		pcrs[fmt.Sprintf("pcr%d", index)] = hex.EncodeToString(value)
+	}
+
+	previous := signer.Previous()
+	previousGenerations := make([]resources.SignerGeneration, len(previous))
+	for i, generation := range previous {
+		previousGenerations[i] = resources.SignerGeneration{
+			Generation:   generation.ID,
+			Address:      generation.Address.Hex(),
+			SupersededAt: generation.SupersededAt.UTC(),
+			ExpiresAt:    generation.ExpiresAt.UTC(),
+		}
+	}
+
+	ape.Render(w, resources.SignerResponse{
+		Data: resources.Signer{
+			Key: resources.Key{
+				ID:   generation.Address.Hex(),
+				Type: resources.SIGNERS,
+			},
+			Attributes: resources.SignerAttributes{
+				Address:   generation.Address.Hex(),
+				PublicKey: hexutil.Encode(crypto.FromECDSAPub(&generation.PrivateKey.PublicKey)),
+				KmsKeyId:  generation.KMSKeyID,
+				Attestations: resources.SignerAttestations{
+					KmsKeyId:  base64.StdEncoding.EncodeToString(docs.KMSKeyID),
+					PublicKey: base64.StdEncoding.EncodeToString(docs.PublicKey),
+					Address:   base64.StdEncoding.EncodeToString(docs.Address),
+				},
+				Pcrs:                pcrs,
+				Generation:          generation.ID,
+				PreviousGenerations: previousGenerations,
+			},
+		},
+	})
+}
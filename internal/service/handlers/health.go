@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/resources"
+	"github.com/google/jsonapi"
+	"gitlab.com/distributed_lab/ape"
+)
+
+// Healthz reports the process is alive, it doesn't depend on components
+func Healthz(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok"))
+}
+
+// Readyz renders states of the signer, listeners and self-test. Status is
+// 503 unless all of them are ok or disabled.
+func Readyz(w http.ResponseWriter, r *http.Request) {
+	ready, components := Health(r).Report()
+
+	attributes := resources.HealthAttributes{
+		Ready:      ready,
+		Components: make(map[string]resources.HealthComponent, len(components)),
+	}
+	for name, component := range components {
+		attributes.Components[name] = resources.HealthComponent{
+			Status:    string(component.Status),
+			Error:     component.Error,
+			UpdatedAt: component.UpdatedAt,
+		}
+	}
+
+	if !ready {
+		// Render doesn't set status, so the header is set before it
+		w.Header().Set("content-type", jsonapi.MediaType)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	ape.Render(w, resources.HealthResponse{
+		Data: resources.Health{
+			Key: resources.Key{
+				Type: resources.HEALTH,
+			},
+			Attributes: attributes,
+		},
+	})
+}
+
+// RequireSigner rejects requests with 503 until the signer is bootstrapped
+func RequireSigner(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if Signer(r).Current() == nil {
+			ape.RenderErr(w, signerUnavailableProblem())
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/nonces"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/resources"
+	"github.com/google/jsonapi"
+	"gitlab.com/distributed_lab/ape"
+	"gitlab.com/distributed_lab/ape/problems"
+)
+
+func IssueNonce(w http.ResponseWriter, r *http.Request) {
+	nonce, expiresAt, err := Nonces(r).Issue(nonceClient(r))
+	if errors.Is(err, nonces.ErrTooMany) {
+		ape.RenderErr(w, problems.TooManyRequests())
+		return
+	}
+	if err != nil {
+		Log(r).WithError(err).Error("Failed to issue nonce")
+		ape.RenderErr(w, problems.InternalError())
+		return
+	}
+
+	// Render doesn't set status, so the header is set before it
+	w.Header().Set("content-type", jsonapi.MediaType)
+	w.WriteHeader(http.StatusCreated)
+	ape.Render(w, resources.NonceResponse{
+		Data: resources.Nonce{
+			Key: resources.Key{
+				Type: resources.NONCES,
+			},
+			Attributes: resources.NonceAttributes{
+				Nonce:     base64.StdEncoding.EncodeToString(nonce),
+				ExpiresAt: expiresAt.UTC(),
+			},
+		},
+	})
+}
+
+// nonceClient identifies the requester for the per-client nonce limit:
+// the last address of the configured proxy header, as the proxy appends
+// it, or the remote host
+func nonceClient(r *http.Request) string {
+	if header := Nonces(r).ClientHeader(); header != "" {
+		if values := r.Header.Values(header); len(values) > 0 {
+			addrs := strings.Split(values[len(values)-1], ",")
+			if client := strings.TrimSpace(addrs[len(addrs)-1]); client != "" {
+				return client
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
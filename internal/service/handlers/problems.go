@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/config"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/icrypto"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/nitro"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/nonces"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/google/jsonapi"
+	"gitlab.com/distributed_lab/ape/problems"
+)
+
+// Application-specific JSON:API error codes
+const (
+	CodeAttestationStale        = "attestation_stale"
+	CodeAttestationFutureDated  = "attestation_future_dated"
+	CodeAttestationChainExpired = "attestation_chain_expired"
+	CodeNonceMissing            = "nonce_missing"
+	CodeNonceUnknown            = "nonce_unknown"
+	CodeNonceExpired            = "nonce_expired"
+	CodeNonceUsed               = "nonce_used"
+	CodePolicyMismatch          = "policy_mismatch"
+	CodeDomainUnsupported       = "domain_unsupported"
+	CodeDomainUnavailable       = "domain_unavailable"
+	CodeSignerUnavailable       = "signer_unavailable"
+)
+
+// verificationProblems renders attestation verification error. Time policy
+// violations get distinct codes, so clients can tell them apart.
+func verificationProblems(err error) []*jsonapi.ErrorObject {
+	var code string
+	switch {
+	case errors.Is(err, config.ErrStaleAttestation):
+		code = CodeAttestationStale
+	case errors.Is(err, config.ErrFutureAttestation):
+		code = CodeAttestationFutureDated
+	case errors.Is(err, nitro.ErrCertificateExpired), errors.Is(err, nitro.ErrCertificateNotValid):
+		code = CodeAttestationChainExpired
+	default:
+		return problems.BadRequest(validation.Errors{
+			"data/attributes/attestation": fmt.Errorf("invalid signature: %w", err),
+		})
+	}
+
+	return codedProblem(code, "data/attributes/attestation", err)
+}
+
+// nonceProblems renders the error of attestation document nonce consumption
+func nonceProblems(err error) []*jsonapi.ErrorObject {
+	var code string
+	switch {
+	case errors.Is(err, nonces.ErrMissing):
+		code = CodeNonceMissing
+	case errors.Is(err, nonces.ErrUnknown):
+		code = CodeNonceUnknown
+	case errors.Is(err, nonces.ErrExpired):
+		code = CodeNonceExpired
+	case errors.Is(err, nonces.ErrUsed):
+		code = CodeNonceUsed
+	default:
+		return problems.BadRequest(validation.Errors{
+			"data/attributes/attestation": err,
+		})
+	}
+
+	return codedProblem(code, "data/attributes/attestation", err)
+}
+
+// policyProblems renders PCR policy error, mismatched PCRs are listed in meta
+func policyProblems(err error) []*jsonapi.ErrorObject {
+	var mismatch *config.PolicyMismatchError
+	if !errors.As(err, &mismatch) {
+		return problems.BadRequest(validation.Errors{
+			"data/attributes/policy": err,
+		})
+	}
+
+	pcrs := make([]string, len(mismatch.PCRs))
+	for i, pcr := range mismatch.PCRs {
+		pcrs[i] = fmt.Sprintf("pcr%d", pcr)
+	}
+
+	errs := codedProblem(CodePolicyMismatch, "data/attributes/attestation", err)
+	(*errs[0].Meta)["policy"] = mismatch.Profile
+	(*errs[0].Meta)["mismatched_pcrs"] = pcrs
+
+	return errs
+}
+
+// domainProblems renders the error of fetching the domain from the contract.
+// Contracts without EIP-5267 domain are rejected as bad request, RPC failures
+// are reported as bad gateway.
+func domainProblems(r *http.Request, field string, err error) []*jsonapi.ErrorObject {
+	switch {
+	case errors.Is(err, icrypto.ErrDomainNotSupported):
+		return codedProblem(CodeDomainUnsupported, field, err)
+	case errors.Is(err, icrypto.ErrInvalidDomain):
+		return problems.BadRequest(validation.Errors{field: err})
+	}
+
+	Log(r).WithError(err).Warn("Failed to fetch EIP712 domain")
+	errs := codedProblem(CodeDomainUnavailable, field, err)
+	errs[0].Title = http.StatusText(http.StatusBadGateway)
+	errs[0].Status = fmt.Sprintf("%d", http.StatusBadGateway)
+
+	return errs
+}
+
+// signerUnavailableProblem renders request received before the signer is
+// bootstrapped
+func signerUnavailableProblem() *jsonapi.ErrorObject {
+	return &jsonapi.ErrorObject{
+		Title:  http.StatusText(http.StatusServiceUnavailable),
+		Status: fmt.Sprintf("%d", http.StatusServiceUnavailable),
+		Code:   CodeSignerUnavailable,
+		Detail: "Signer is not bootstrapped yet",
+	}
+}
+
+func codedProblem(code, field string, err error) []*jsonapi.ErrorObject {
+	return []*jsonapi.ErrorObject{
+		{
+			Title:  http.StatusText(http.StatusBadRequest),
+			Status: fmt.Sprintf("%d", http.StatusBadRequest),
+			Code:   code,
+			Meta: &map[string]interface{}{
+				"field": field,
+				"error": err.Error(),
+			},
+		},
+	}
+}
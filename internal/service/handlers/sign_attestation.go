@@ -2,15 +2,24 @@ package handlers
 
 import (
 	"encoding/base64"
-	"fmt"
+	"encoding/hex"
+	"errors"
+	"math"
 	"net/http"
+	"time"
 
 	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/icrypto"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/metrics"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/nitro"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/nonces"
 	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/utils"
 	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/service/requests"
 	"github.com/distributed-lab/aws-nitro-enclaves-av/resources"
 	"github.com/distributed-lab/enclave-extras/attestation"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
 	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/google/jsonapi"
 	"gitlab.com/distributed_lab/ape"
 	"gitlab.com/distributed_lab/ape/problems"
 )
@@ -18,64 +27,279 @@ import (
 func VerifyAttestation(w http.ResponseWriter, r *http.Request) {
 	req, err := requests.NewSignAttestation(r)
 	if err != nil {
+		metrics.SignRequests.WithLabelValues(endpointSingle, metrics.ResultInvalidRequest).Inc()
 		ape.RenderErr(w, problems.BadRequest(err)...)
 		return
 	}
 
-	var (
-		// Should never panic because of request validation
-		attestationDocumentBytes, _ = base64.StdEncoding.DecodeString(req.Data.Attributes.Attestation)
-		primaryType                 = req.Data.Attributes.PrimaryType
-	)
+	signed, errs := signAttestation(r, endpointSingle, req.Data.Attributes, []apitypes.TypedDataDomain{req.Data.Attributes.Domain})
+	if errs != nil {
+		ape.RenderErr(w, errs...)
+		return
+	}
+
+	attributes := resources.SignedAttestationsAttributes{
+		Signature:       base64.StdEncoding.EncodeToString(signed.signatures[0].signature),
+		Scheme:          string(signed.scheme),
+		RootFingerprint: hex.EncodeToString(signed.rootFingerprint),
+		Policy:          signed.policy,
+		Digest:          hexutil.Encode(signed.signatures[0].digest),
+		Signer:          signed.generation.Address.Hex(),
+		Generation:      signed.generation.ID,
+	}
+	if signed.validity != nil {
+		attributes.IssuedAt = &signed.validity.IssuedAt
+		attributes.Deadline = &signed.validity.Deadline
+	}
+	if signed.scheme == icrypto.SchemeEIP712 {
+		attributes.TypedData = signed.signatures[0].typedData
+		attributes.DomainSeparator = hexutil.Encode(signed.signatures[0].separator)
+		attributes.TypeHash = hexutil.Encode(signed.typeHash)
+	} else {
+		attributes.Message = hexutil.Encode(signed.packed)
+	}
+
+	ape.Render(w, resources.SignedAttestationsResponse{
+		Data: resources.SignedAttestations{
+			Key: resources.Key{
+				Type: resources.ATTESTATIONS,
+			},
+			Attributes: attributes,
+		},
+	})
+}
+
+// Endpoint labels of sign requests metrics
+const (
+	endpointSingle = "single"
+	endpointBatch  = "batch"
+)
+
+type signedAttestation struct {
+	attestationVerification
+	generation *nitro.KeyGeneration
+	scheme     icrypto.Scheme
+	// Signed validity window, nil if the endorsement doesn't expire
+	validity *utils.Validity
+	// EIP712 primary type hash, eip712 scheme only
+	typeHash []byte
+	// Packed encoding of the fields, eip191 and raw schemes only
+	packed []byte
+	// One per domain in the same order
+	signatures []domainSignature
+}
+
+type domainSignature struct {
+	signature []byte
+	digest    []byte
+	// EIP712 domain separator and typed data, eip712 scheme only
+	separator []byte
+	typedData []byte
+}
 
-	attestationDocument, err := attestation.ParseNSMAttestationDoc(attestationDocumentBytes)
+// signAttestation verifies the attestation document, consumes its nonce if
+// required and signs its fields with the scheme for every domain. The
+// outcome is counted with the endpoint label.
+func signAttestation(r *http.Request, endpoint string, attr resources.SignAttestationsAttributes, rawDomains []apitypes.TypedDataDomain) (*signedAttestation, []*jsonapi.ErrorObject) {
+	result := metrics.ResultInternalError
+	defer func() {
+		metrics.SignRequests.WithLabelValues(endpoint, result).Inc()
+	}()
+
+	start := time.Now()
+	attestationDocument, errs := parseAttestationDocument(attr.Attestation)
+	metrics.ObserveSince(metrics.StageDuration.WithLabelValues(metrics.StageParse), start)
+	if errs != nil {
+		result = metrics.ResultParseError
+		return nil, errs
+	}
+
+	verificationTime, errs := getVerificationTime(r, attr.VerificationTime)
+	if errs != nil {
+		result = metrics.ResultInvalidRequest
+		return nil, errs
+	}
+
+	start = time.Now()
+	rootFingerprint, errs := verifyDocumentChain(r, attestationDocument, verificationTime)
+	if errs != nil {
+		result = metrics.ResultBadSignature
+		return nil, errs
+	}
+	policy, errs := matchPolicy(r, attestationDocument, attr.Policy)
+	metrics.ObserveSince(metrics.StageDuration.WithLabelValues(metrics.StageVerify), start)
+	if errs != nil {
+		result = metrics.ResultPolicyRejection
+		return nil, errs
+	}
+	verification := attestationVerification{
+		rootFingerprint: rootFingerprint,
+		policy:          policy,
+	}
+
+	// Domains are fetched only for verified documents, so invalid requests
+	// don't reach RPC endpoints
+	domains, errs := resolveDomains(r, attr, rawDomains)
+	if errs != nil {
+		result = metrics.ResultDomainError
+		return nil, errs
+	}
+
+	start = time.Now()
+	validity := Validity(r).Window(time.Now(), requestedTTL(attr.TTL))
+	typedDataMessage, err := buildAttestationMessage(attestationDocument, attr, domains[0], verification.policy, validity)
 	if err != nil {
-		ape.RenderErr(w, problems.BadRequest(validation.Errors{
-			"data/attributes/attestation": fmt.Errorf("failed to parse attestation document: %w", err),
-		})...)
-		return
+		result = encodingResult(err)
+		return nil, problems.BadRequest(err)
 	}
-	if err = attestationDocument.Verify(); err != nil {
-		ape.RenderErr(w, problems.BadRequest(validation.Errors{
-			"data/attributes/attestation": fmt.Errorf("invalid signature: %w", err),
-		})...)
-		return
+
+	scheme := icrypto.Scheme(attr.Scheme)
+	var packed []byte
+	if scheme != icrypto.SchemeEIP712 {
+		if packed, err = typedDataMessage.PackedMessage(scheme); err != nil {
+			result = metrics.ResultEncodingError
+			return nil, problems.BadRequest(validation.Errors{
+				"data/attributes/fields_to_sign": err,
+			})
+		}
 	}
+	metrics.ObserveSince(metrics.StageDuration.WithLabelValues(metrics.StageEncode), start)
 
-	presentFields := make(map[string]struct{}, len(req.Data.Attributes.FieldsToSign))
-	fields := make([]string, 0, len(req.Data.Attributes.FieldsToSign))
-	for _, field := range req.Data.Attributes.FieldsToSign {
-		if _, ok := presentFields[field]; ok {
+	// Nonce is consumed last, so a request rejected for other reasons
+	// doesn't burn it
+	if Nonces(r).Required() {
+		if err = Nonces(r).Consume(attestationDocument.Nonce); err != nil {
+			if errors.Is(err, nonces.ErrPersist) {
+				Log(r).WithError(err).Error("Failed to consume nonce")
+				return nil, []*jsonapi.ErrorObject{problems.InternalError()}
+			}
+			result = metrics.ResultNonceRejection
+			return nil, nonceProblems(err)
+		}
+	}
+
+	// Every domain is signed with the same generation even if rotated meanwhile
+	start = time.Now()
+	generation := Signer(r).Current()
+	signatures := make([]domainSignature, len(domains))
+	for i, domain := range domains {
+		if signatures[i].signature, signatures[i].digest, err = icrypto.SignWithSigner(scheme, domain, typedDataMessage, generation); err != nil {
+			Log(r).WithError(err).Errorf("Failed to sign attestation with %s scheme", scheme)
+			return nil, []*jsonapi.ErrorObject{problems.InternalError()}
+		}
+		if scheme != icrypto.SchemeEIP712 {
 			continue
 		}
-		presentFields[field] = struct{}{}
-		fields = append(fields, field)
+
+		// Digest is already computed, so these can't fail on valid typed data
+		if signatures[i].separator, err = domain.Separator(); err != nil {
+			Log(r).WithError(err).Errorf("Failed to hash attestation typed data domain")
+			return nil, []*jsonapi.ErrorObject{problems.InternalError()}
+		}
+		if signatures[i].typedData, err = domain.MarshalTypedData(typedDataMessage); err != nil {
+			Log(r).WithError(err).Errorf("Failed to marshal attestation typed data")
+			return nil, []*jsonapi.ErrorObject{problems.InternalError()}
+		}
+	}
+	metrics.ObserveSince(metrics.StageDuration.WithLabelValues(metrics.StageSign), start)
+
+	result = metrics.ResultSigned
+	return &signedAttestation{
+		attestationVerification: verification,
+		generation:              generation,
+		scheme:                  scheme,
+		validity:                validity,
+		typeHash:                typedDataMessage.TypeHash(),
+		packed:                  packed,
+		signatures:              signatures,
+	}, nil
+}
+
+// resolveDomains returns the domains to sign for, the ones naming only
+// chainId and verifyingContract are fetched from the contract if the chain
+// has RPC endpoint
+func resolveDomains(r *http.Request, attr resources.SignAttestationsAttributes, rawDomains []apitypes.TypedDataDomain) ([]*icrypto.Domain, []*jsonapi.ErrorObject) {
+	field := "data/attributes/domain"
+	if len(attr.Domains) != 0 {
+		field = "data/attributes/domains"
+	}
+
+	domains := make([]*icrypto.Domain, len(rawDomains))
+	for i, rawDomain := range rawDomains {
+		// Packed encoding doesn't depend on domain
+		if icrypto.Scheme(attr.Scheme) != icrypto.SchemeEIP712 {
+			domains[i] = icrypto.GetDomain(rawDomain)
+			continue
+		}
+
+		var err error
+		if domains[i], err = DomainResolver(r).Resolve(r.Context(), rawDomain); err != nil {
+			return nil, domainProblems(r, field, err)
+		}
+	}
+
+	return domains, nil
+}
+
+// buildAttestationMessage returns message of the caller-supplied schema if
+// any, or of the fields to sign otherwise. Domain is the first one to sign
+// for. Validity is nil if the endorsement doesn't expire. Errors are
+// validation.Errors of the request field.
+func buildAttestationMessage(attestationDocument *attestation.NSMAttestationDoc, attr resources.SignAttestationsAttributes, domain *icrypto.Domain, policy string, validity *utils.Validity) (*icrypto.Message, error) {
+	if attr.Types != nil {
+		message, err := utils.BuildTypedDataSchemaMessage(attestationDocument, *attr.PrimaryType, attr.Types, attr.Message, attr.Encodings, policy, validity)
+		if err != nil {
+			return nil, validation.Errors{
+				"data/attributes/message": err,
+			}
+		}
+
+		// Caller values are encoded only on signing, so check them before
+		// the nonce is consumed
+		if _, err = icrypto.Digest(icrypto.SchemeEIP712, domain, message); err != nil {
+			return nil, validation.Errors{
+				"data/attributes/message": err,
+			}
+		}
+
+		return message, nil
 	}
 
-	typedDataMessage, err := utils.BuildTypedDataAttestationMessage(attestationDocument, *primaryType, fields)
+	// Fields to sign are deduplicated by request validation
+	message, err := utils.BuildEncodedAttestationMessage(attestationDocument, *attr.PrimaryType, attr.FieldsToSign, attr.Encodings, policy)
+	if err == nil && validity != nil {
+		err = utils.WithValidity(message, *validity)
+	}
 	if err != nil {
-		ape.RenderErr(w, problems.BadRequest(validation.Errors{
+		return nil, validation.Errors{
 			"data/attributes": err,
-		})...)
-		return
+		}
 	}
 
-	domain := icrypto.GetDomain(req.Data.Attributes.Domain)
-	sig, _, err := domain.SignTypedDataWithSigner(typedDataMessage, Signer(r))
-	if err != nil {
-		Log(r).WithError(err).Errorf("Failed to sign attestation typed data")
-		ape.RenderErr(w, problems.InternalError())
-		return
+	return message, nil
+}
+
+// encodingResult returns metrics result of buildAttestationMessage error
+func encodingResult(err error) string {
+	var errs validation.Errors
+	if errors.As(err, &errs) {
+		for _, fieldErr := range errs {
+			if errors.Is(fieldErr, utils.ErrAbsentField) {
+				return metrics.ResultAbsentField
+			}
+		}
 	}
 
-	ape.Render(w, resources.SignedAttestationsResponse{
-		Data: resources.SignedAttestations{
-			Key: resources.Key{
-				Type: resources.ATTESTATIONS,
-			},
-			Attributes: resources.SignedAttestationsAttributes{
-				Signature: base64.StdEncoding.EncodeToString(sig),
-			},
-		},
-	})
+	return metrics.ResultEncodingError
+}
+
+// requestedTTL converts TTL in seconds requested by client, nil if absent
+func requestedTTL(seconds *uint64) *time.Duration {
+	if seconds == nil {
+		return nil
+	}
+
+	// Capped by config anyway, so only overflow matters
+	ttl := time.Duration(min(*seconds, uint64(math.MaxInt64/int64(time.Second)))) * time.Second
+	return &ttl
 }
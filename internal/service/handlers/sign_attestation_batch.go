@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/metrics"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/service/requests"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/resources"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"gitlab.com/distributed_lab/ape"
+	"gitlab.com/distributed_lab/ape/problems"
+)
+
+// SignAttestationBatch signs every item of the batch like VerifyAttestation
+// does. Items are processed concurrently by a bounded count of workers,
+// results are rendered in the request order with ID equal to item index.
+func SignAttestationBatch(w http.ResponseWriter, r *http.Request) {
+	batch := Batch(r)
+
+	req, err := requests.NewSignAttestationBatch(r, batch.MaxItems)
+	if err != nil {
+		ape.RenderErr(w, problems.BadRequest(err)...)
+		return
+	}
+	metrics.BatchItems.Observe(float64(len(req.Data)))
+
+	results := make([]resources.BatchSignedAttestations, len(req.Data))
+	items := make(chan int)
+
+	var wg sync.WaitGroup
+	for range min(batch.Workers, len(req.Data)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range items {
+				results[i] = signBatchItem(r, i, &req.Data[i], batch.MaxDomains)
+			}
+		}()
+	}
+
+	for i := range req.Data {
+		items <- i
+	}
+	close(items)
+	wg.Wait()
+
+	ape.Render(w, resources.BatchSignedAttestationsListResponse{
+		Data: results,
+	})
+}
+
+func signBatchItem(r *http.Request, index int, item *resources.SignAttestations, maxDomains int) resources.BatchSignedAttestations {
+	result := resources.BatchSignedAttestations{
+		Key: resources.Key{
+			ID:   strconv.Itoa(index),
+			Type: resources.ATTESTATIONS,
+		},
+	}
+
+	if err := requests.ValidateSignAttestation(item, maxDomains); err != nil {
+		metrics.SignRequests.WithLabelValues(endpointBatch, metrics.ResultInvalidRequest).Inc()
+		result.Attributes.Errors = problems.BadRequest(err)
+		return result
+	}
+
+	domains := item.Attributes.Domains
+	if len(domains) == 0 {
+		domains = []apitypes.TypedDataDomain{item.Attributes.Domain}
+	}
+
+	signed, errs := signAttestation(r, endpointBatch, item.Attributes, domains)
+	if errs != nil {
+		result.Attributes.Errors = errs
+		return result
+	}
+
+	signatures := make([]string, len(signed.signatures))
+	digests := make([]string, len(signed.signatures))
+	for i, sig := range signed.signatures {
+		signatures[i] = base64.StdEncoding.EncodeToString(sig.signature)
+		digests[i] = hexutil.Encode(sig.digest)
+	}
+
+	result.Attributes = resources.BatchSignedAttestationsAttributes{
+		Signatures:      signatures,
+		Digests:         digests,
+		Scheme:          string(signed.scheme),
+		Signer:          signed.generation.Address.Hex(),
+		Generation:      signed.generation.ID,
+		RootFingerprint: hex.EncodeToString(signed.rootFingerprint),
+		Policy:          signed.policy,
+	}
+	if signed.validity != nil {
+		result.Attributes.IssuedAt = &signed.validity.IssuedAt
+		result.Attributes.Deadline = &signed.validity.Deadline
+	}
+
+	return result
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/distributed-lab/enclave-extras/attestation"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/google/jsonapi"
+	"gitlab.com/distributed_lab/ape/problems"
+)
+
+// attestationVerification is the outcome of the checks shared by
+// the signing and verification-only endpoints
+type attestationVerification struct {
+	rootFingerprint []byte
+	policy          string
+}
+
+// parseAttestationDocument decodes already validated base64 attestation document
+func parseAttestationDocument(attestationB64 string) (*attestation.NSMAttestationDoc, []*jsonapi.ErrorObject) {
+	// Should never fail because of request validation
+	attestationDocumentBytes, _ := base64.StdEncoding.DecodeString(attestationB64)
+
+	attestationDocument, err := attestation.ParseNSMAttestationDoc(attestationDocumentBytes)
+	if err != nil {
+		return nil, problems.BadRequest(validation.Errors{
+			"data/attributes/attestation": fmt.Errorf("failed to parse attestation document: %w", err),
+		})
+	}
+
+	return attestationDocument, nil
+}
+
+// getVerificationTime returns the explicit verification time if the
+// verifier allows it, and the current time if it is absent
+func getVerificationTime(r *http.Request, explicit *time.Time) (time.Time, []*jsonapi.ErrorObject) {
+	if explicit == nil {
+		return time.Now(), nil
+	}
+
+	if !Verifier(r).AllowVerificationTime() {
+		return time.Time{}, problems.BadRequest(validation.Errors{
+			"data/attributes/verification_time": fmt.Errorf("explicit verification time is not allowed"),
+		})
+	}
+
+	return *explicit, nil
+}
+
+// verifyAttestationDocument checks the document against trusted roots,
+// time policy and PCR policy profile. Nonce is not consumed here.
+func verifyAttestationDocument(r *http.Request, doc *attestation.NSMAttestationDoc, at time.Time, profile *string) (attestationVerification, []*jsonapi.ErrorObject) {
+	rootFingerprint, errs := verifyDocumentChain(r, doc, at)
+	if errs != nil {
+		return attestationVerification{}, errs
+	}
+
+	policy, errs := matchPolicy(r, doc, profile)
+	if errs != nil {
+		return attestationVerification{}, errs
+	}
+
+	return attestationVerification{
+		rootFingerprint: rootFingerprint,
+		policy:          policy,
+	}, nil
+}
+
+// verifyDocumentChain checks the document signature and certificate chain
+// against trusted roots and time policy, returns the root fingerprint
+func verifyDocumentChain(r *http.Request, doc *attestation.NSMAttestationDoc, at time.Time) ([]byte, []*jsonapi.ErrorObject) {
+	rootFingerprint, err := Verifier(r).VerifyAt(doc, at)
+	if err != nil {
+		return nil, verificationProblems(err)
+	}
+
+	return rootFingerprint, nil
+}
+
+// matchPolicy returns the PCR policy profile the document matches
+func matchPolicy(r *http.Request, doc *attestation.NSMAttestationDoc, profile *string) (string, []*jsonapi.ErrorObject) {
+	var requested string
+	if profile != nil {
+		requested = *profile
+	}
+
+	policy, err := Policies(r).Match(requested, doc.PCRs)
+	if err != nil {
+		return "", policyProblems(err)
+	}
+
+	return policy, nil
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/service/requests"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/resources"
+	"github.com/distributed-lab/enclave-extras/attestation"
+	"github.com/google/jsonapi"
+	"gitlab.com/distributed_lab/ape"
+	"gitlab.com/distributed_lab/ape/problems"
+)
+
+// VerifyAttestationDocument decodes and verifies the attestation document
+// without signing. Rejected documents are rendered with the verdict
+// explaining the rejection, only unparsable documents are bad requests.
+func VerifyAttestationDocument(w http.ResponseWriter, r *http.Request) {
+	req, err := requests.NewVerifyAttestation(r)
+	if err != nil {
+		ape.RenderErr(w, problems.BadRequest(err)...)
+		return
+	}
+
+	attr := req.Data.Attributes
+
+	attestationDocument, errs := parseAttestationDocument(attr.Attestation)
+	if errs != nil {
+		ape.RenderErr(w, errs...)
+		return
+	}
+
+	verificationTime, errs := getVerificationTime(r, attr.VerificationTime)
+	if errs != nil {
+		ape.RenderErr(w, errs...)
+		return
+	}
+
+	var verdict resources.AttestationVerdict
+	verification, errs := verifyAttestationDocument(r, attestationDocument, verificationTime, attr.Policy)
+	if errs != nil {
+		verdict = newRejectedVerdict(errs[0])
+	} else {
+		verdict = resources.AttestationVerdict{
+			Valid:           true,
+			RootFingerprint: hex.EncodeToString(verification.rootFingerprint),
+			Policy:          verification.policy,
+		}
+	}
+
+	ape.Render(w, resources.AttestationDocumentResponse{
+		Data: resources.AttestationDocument{
+			Key: resources.Key{
+				Type: resources.ATTESTATION_DOCUMENTS,
+			},
+			Attributes: newAttestationDocumentAttributes(attestationDocument, verdict),
+		},
+	})
+}
+
+func newAttestationDocumentAttributes(doc *attestation.NSMAttestationDoc, verdict resources.AttestationVerdict) resources.AttestationDocumentAttributes {
+	pcrs := make(map[string]string, len(doc.PCRs))
+	for index, value := range doc.PCRs {
+		pcrs[fmt.Sprintf("pcr%d", index)] = hex.EncodeToString(value)
+	}
+
+	// CA bundle starts from the root, summary starts from the NSM certificate
+	chain := append([]*x509.Certificate{doc.Certificate}, doc.CABundle...)
+	slices.Reverse(chain[1:])
+
+	certificates := make([]resources.CertificateSummary, 0, len(chain))
+	for _, cert := range chain {
+		if cert == nil {
+			continue
+		}
+
+		fingerprint := sha256.Sum256(cert.Raw)
+		certificates = append(certificates, resources.CertificateSummary{
+			Subject:     cert.Subject.String(),
+			Issuer:      cert.Issuer.String(),
+			NotBefore:   cert.NotBefore.UTC(),
+			NotAfter:    cert.NotAfter.UTC(),
+			Fingerprint: hex.EncodeToString(fingerprint[:]),
+		})
+	}
+
+	return resources.AttestationDocumentAttributes{
+		ModuleId:     doc.ModuleID,
+		Digest:       doc.Digest,
+		Timestamp:    doc.Timestamp.UTC(),
+		Pcrs:         pcrs,
+		PublicKey:    encodeOptionalBase64(doc.PublicKey),
+		UserData:     encodeOptionalBase64(doc.UserData),
+		Nonce:        encodeOptionalBase64(doc.Nonce),
+		Certificates: certificates,
+		Verdict:      verdict,
+	}
+}
+
+// newRejectedVerdict takes code and reason from the error the signing
+// endpoint would render for the same document
+func newRejectedVerdict(problem *jsonapi.ErrorObject) resources.AttestationVerdict {
+	verdict := resources.AttestationVerdict{
+		Code:  problem.Code,
+		Error: problem.Detail,
+	}
+
+	if problem.Meta != nil {
+		if reason, ok := (*problem.Meta)["error"].(string); ok {
+			verdict.Error = reason
+		}
+	}
+
+	return verdict
+}
+
+func encodeOptionalBase64(value []byte) *string {
+	if value == nil {
+		return nil
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(value)
+	return &encoded
+}
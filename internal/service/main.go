@@ -1,72 +1,207 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"os"
+	"os/signal"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/config"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/health"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/icrypto"
 	"gitlab.com/distributed_lab/logan/v3"
 )
 
+// Components reported by /readyz
+const (
+	componentSigner        = "signer"
+	componentSelfTest      = "self_test"
+	componentInetListener  = "inet_listener"
+	componentVsockListener = "vsock_listener"
+	// Reported only if metrics have a listener of their own
+	componentMetricsListener = "metrics_listener"
+)
+
+// bootstrapRetryInterval is the delay between failed signer bootstraps
+const bootstrapRetryInterval = 30 * time.Second
+
 type service struct {
-	log    *logan.Entry
-	signer *config.Signer
+	log      *logan.Entry
+	signer   *config.Signer
+	verifier *config.Verifier
+	nonces   *config.Nonces
+	policies *config.Policies
+	batch    *config.Batch
+	validity *config.Validity
+	domains  *icrypto.DomainResolver
+	metrics  *config.Metrics
+	selfTest *config.SelfTest
+	health   *health.Health
+	shutdown *config.Shutdown
 
 	inetListener  config.Listener
 	vsockListener config.Listener
 }
 
-func (s *service) run() error {
-	var wg sync.WaitGroup
+// servedListener is an entry of the listener registry
+type servedListener struct {
+	// Name of the readiness component
+	name     string
+	listener config.Listener
+	handler  http.Handler
+}
 
-	wg.Add(1)
-	go func() {
-		if s.inetListener.IsDisabled() {
-			s.log.Warn("Inet listener disabled")
-			wg.Done()
-			return
-		}
+// listeners returns registry of the listeners served by the service
+func (s *service) listeners() []servedListener {
+	listeners := []servedListener{
+		{name: componentInetListener, listener: s.inetListener, handler: s.router()},
+		{name: componentVsockListener, listener: s.vsockListener, handler: s.router()},
+	}
+	if s.metrics.Listener != nil {
+		listeners = append(listeners, servedListener{name: componentMetricsListener, listener: s.metrics.Listener, handler: s.metricsRouter()})
+	}
 
-		r := s.router()
+	return listeners
+}
 
-		s.log.Info("Inet listener started")
-		if err := http.Serve(s.inetListener, r); err != nil {
-			s.log.WithError(err).Error("Inet serve exit with error")
+func (s *service) run() error {
+	// Signals stop accepting, background jobs are stopped after draining
+	signals, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stopSignals()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Signer isn't waited for, it is stopped with the service. Listeners
+	// serve probes while it bootstraps.
+	go s.bootstrap(ctx)
+
+	var (
+		wg      sync.WaitGroup
+		servers []*http.Server
+	)
+	for _, served := range s.listeners() {
+		log := s.log.WithField("listener", served.name)
+		if served.listener.IsDisabled() {
+			s.health.Set(served.name, health.StatusDisabled, nil)
+			log.Warn("Listener disabled")
+			continue
 		}
-		s.log.Info("Inet listener stopped")
 
-		wg.Done()
-	}()
+		serverConfig := served.listener.Server()
+		server := serverConfig.NewServer(served.handler)
+		servers = append(servers, server)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			s.health.Set(served.name, health.StatusOK, nil)
+			log.Info("Listener started")
+			if err := server.Serve(serverConfig.Limit(served.listener)); !errors.Is(err, http.ErrServerClosed) {
+				s.health.Set(served.name, health.StatusFailed, err)
+				log.WithError(err).Error("Listener serve exit with error")
+			}
+			log.Info("Listener stopped")
+		}()
+	}
 
-	wg.Add(1)
+	stopped := make(chan struct{})
 	go func() {
-		if s.inetListener.IsDisabled() {
-			s.log.Warn("Vsock listener disabled")
-			wg.Done()
+		wg.Wait()
+		close(stopped)
+	}()
+
+	s.log.Info("Service started")
+	select {
+	case <-signals.Done():
+		s.log.WithField("timeout", s.shutdown.Timeout).Info("Service shutting down, draining requests")
+		s.drain(servers)
+		<-stopped
+	case <-stopped:
+	}
+	s.log.Info("Service stopped")
+	return nil
+}
+
+// drain stops accepting on every server and waits for in-flight requests
+// up to the shutdown timeout, connections still active after it are closed
+func (s *service) drain(servers []*http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.shutdown.Timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if err := server.Shutdown(ctx); err != nil {
+				s.log.WithError(err).Warn("Failed to drain requests in time, closing connections")
+				_ = server.Close()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// bootstrap retries signer bootstrap until it succeeds, then runs the
+// signer and its periodic self-test
+func (s *service) bootstrap(ctx context.Context) {
+	for {
+		err := s.signer.Bootstrap()
+		s.health.SetResult(componentSigner, err)
+		if err == nil {
+			break
+		}
+		s.log.WithError(err).Error("Failed to bootstrap signer")
+
+		select {
+		case <-ctx.Done():
 			return
+		case <-time.After(bootstrapRetryInterval):
 		}
+	}
 
-		r := s.router()
+	config.LogRegenerated(s.log, s.signer.Current())
+	go s.signer.Run(ctx, s.log)
 
-		s.log.Info("Vsock listener started")
-		if err := http.Serve(s.vsockListener, r); err != nil {
-			s.log.WithError(err).Error("Vsock serve exit with error")
-		}
-		s.log.Info("Vsock listener stopped")
+	ticker := time.NewTicker(s.selfTest.Interval)
+	defer ticker.Stop()
 
-		wg.Done()
-	}()
+	for {
+		err := s.signer.SelfTest()
+		s.health.SetResult(componentSelfTest, err)
+		if err != nil {
+			s.log.WithError(err).Error("Signer self-test failed")
+		}
 
-	s.log.Info("Service started")
-	wg.Wait()
-	s.log.Info("Service stopped")
-	return nil
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
 }
 
 func newService(cfg config.Config) *service {
 	return &service{
-		log:    cfg.Log(),
-		signer: cfg.GetSigner(),
+		log:      cfg.Log(),
+		signer:   cfg.GetPendingSigner(),
+		verifier: cfg.GetVerifier(),
+		nonces:   cfg.GetNonces(),
+		policies: cfg.GetPolicies(),
+		batch:    cfg.GetBatch(),
+		validity: cfg.GetValidity(),
+		domains:  cfg.GetDomainResolver(),
+		metrics:  cfg.GetMetrics(),
+		selfTest: cfg.GetSelfTest(),
+		shutdown: cfg.GetShutdown(),
+		health:   health.New(componentSigner, componentSelfTest, componentInetListener, componentVsockListener),
 
 		inetListener:  cfg.GetInetListener(),
 		vsockListener: cfg.GetVsockListener(),
@@ -4,9 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"strconv"
 	"strings"
 
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/icrypto"
 	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/utils"
 	"github.com/distributed-lab/aws-nitro-enclaves-av/resources"
 	validation "github.com/go-ozzo/ozzo-validation/v4"
@@ -19,23 +19,73 @@ func NewSignAttestation(r *http.Request) (req resources.SignAttestationsRequest,
 		return req, err
 	}
 
-	attr := &req.Data.Attributes
+	errs := validateSignAttestation(&req.Data)
+	if errs["data/attributes/domains"] == nil {
+		errs["data/attributes/domains"] = validation.Validate(req.Data.Attributes.Domains,
+			validation.Empty.Error("multiple domains are supported only by batch signing"))
+	}
+
+	return req, errs.Filter()
+}
+
+// ValidateSignAttestation validates a batch item and sets defaults, like NewSignAttestation does
+func ValidateSignAttestation(item *resources.SignAttestations, maxDomains int) error {
+	errs := validateSignAttestation(item)
+	if errs["data/attributes/domains"] == nil {
+		errs["data/attributes/domains"] = validation.Validate(item.Attributes.Domains, validation.Length(0, maxDomains))
+	}
+
+	return errs.Filter()
+}
+
+func validateSignAttestation(item *resources.SignAttestations) validation.Errors {
+	attr := &item.Attributes
 	errs := validation.Errors{
-		"data/type":                   validation.Validate(req.Data.Type, validation.Required, validation.In(resources.ATTESTATIONS)),
+		"data/type":                   validation.Validate(item.Type, validation.Required, validation.In(resources.ATTESTATIONS)),
 		"data/attributes/attestation": validation.Validate(attr.Attestation, validation.Required, is.Base64),
+		"data/attributes/ttl":         validation.Validate(attr.TTL, validation.NilOrNotEmpty),
 	}
 
-	if len(attr.FieldsToSign) == 0 {
+	if len(attr.FieldsToSign) == 0 && attr.Types == nil {
 		attr.FieldsToSign = append([]string{}, utils.DefaultFieldsToSign...)
 	}
+	attr.FieldsToSign = uniqueFields(attr.FieldsToSign)
 
 	if attr.PrimaryType == nil || len(*attr.PrimaryType) == 0 {
 		attr.PrimaryType = utils.AsPointer(utils.DefaultPrimaryType)
 	}
 
-	errs["data/attributes/fields_to_sign"] = validateAttestationFields(attr.FieldsToSign)
+	if attr.Scheme == "" {
+		attr.Scheme = string(icrypto.SchemeEIP712)
+	}
 
-	return req, errs.Filter()
+	errs["data/attributes/scheme"] = validation.Validate(icrypto.Scheme(attr.Scheme), validation.In(icrypto.SchemeEIP712, icrypto.SchemeEIP191, icrypto.SchemeRaw))
+	if attr.Types == nil {
+		errs["data/attributes/fields_to_sign"] = validateAttestationFields(attr.FieldsToSign)
+		errs["data/attributes/message"] = validation.Validate(attr.Message, validation.Empty.Error("allowed only with types"))
+		if errs["data/attributes/fields_to_sign"] == nil {
+			errs["data/attributes/encodings"] = utils.ValidateFieldEncodings(*attr.PrimaryType, attr.FieldsToSign, attr.Encodings)
+		}
+	} else {
+		// Caller-supplied schema replaces fields to sign
+		errs["data/attributes/fields_to_sign"] = validation.Validate(attr.FieldsToSign, validation.Empty.Error("not allowed with types"))
+		errs["data/attributes/message"] = validation.Validate(attr.Message, validation.Required)
+		if errs["data/attributes/message"] == nil {
+			errs["data/attributes/message"] = utils.ValidateTypedDataSchema(*attr.PrimaryType, attr.Types, attr.Message, attr.Encodings)
+		}
+		if errs["data/attributes/scheme"] == nil {
+			errs["data/attributes/scheme"] = validation.Validate(icrypto.Scheme(attr.Scheme), validation.In(icrypto.SchemeEIP712).Error("types are supported only by eip712 scheme"))
+		}
+	}
+	if icrypto.Scheme(attr.Scheme) != icrypto.SchemeEIP712 {
+		// Packed encoding doesn't depend on domain, so it would be the same signature
+		errs["data/attributes/domains"] = validation.Validate(attr.Domains, validation.Empty.Error("multiple domains are supported only by eip712 scheme"))
+		if errs["data/attributes/fields_to_sign"] == nil {
+			errs["data/attributes/fields_to_sign"] = validatePackedFields(attr.FieldsToSign)
+		}
+	}
+
+	return errs
 }
 
 func newDecodeError(what string, err error) error {
@@ -50,22 +100,37 @@ func validateAttestationFields(fields []string) error {
 	}
 
 	for _, field := range fields {
-		if field == "public_key" || field == "user_data" ||
-			field == "nonce" || field == "module_id" ||
-			field == "digest" || field == "timestamp" {
-			continue
+		if _, err := utils.LookupAttestationField(field); err != nil {
+			return fmt.Errorf("invalid field to sign: %s, must be one of [pcr0, pcr1, ..., pcr31, %s]", field, strings.Join(utils.AttestationFieldNames(), ", "))
 		}
-		if !strings.HasPrefix(field, "pcr") {
-			return fmt.Errorf("invalid field to sign: %s, must be one of [pcr0, pcr1, ..., pcr31, public_key, user_data, nonce, module_id, digest, timestamp]", field)
+	}
+
+	return nil
+}
+
+// validatePackedFields requires a field the enclave can't choose, otherwise
+// the signature would endorse arbitrary bytes of the enclave
+func validatePackedFields(fields []string) error {
+	for _, field := range fields {
+		if attestationField, err := utils.LookupAttestationField(field); err == nil && !attestationField.CallerControlled {
+			return nil
 		}
+	}
 
-		pcrNum := field[3:]
+	return fmt.Errorf("eip191 and raw schemes require a field besides public_key, user_data and nonce, e.g. pcr0")
+}
 
-		// 5 bit because currently maximum count of pcr in nsm module is 32
-		if _, err := strconv.ParseUint(pcrNum, 10, 5); err != nil {
-			return fmt.Errorf("invalid field to sign: %s, must be one of [pcr0, pcr1, ..., pcr31, public_key, user_data, nonce, module_id, digest, timestamp]", field)
+// uniqueFields returns fields without duplicates in the order of first occurrence
+func uniqueFields(fields []string) []string {
+	present := make(map[string]struct{}, len(fields))
+	unique := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if _, ok := present[field]; ok {
+			continue
 		}
+		present[field] = struct{}{}
+		unique = append(unique, field)
 	}
 
-	return nil
+	return unique
 }
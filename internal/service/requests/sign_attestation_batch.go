@@ -0,0 +1,23 @@
+package requests
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/resources"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+// NewSignAttestationBatch decodes the batch and checks its size. Items are
+// validated separately with ValidateSignAttestation, so an invalid item
+// doesn't reject the whole batch.
+func NewSignAttestationBatch(r *http.Request, maxItems int) (req resources.SignAttestationsListRequest, err error) {
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		err = newDecodeError("body", err)
+		return req, err
+	}
+
+	return req, validation.Errors{
+		"data": validation.Validate(req.Data, validation.Required, validation.Length(1, maxItems)),
+	}.Filter()
+}
@@ -0,0 +1,25 @@
+package requests
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/resources"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+)
+
+func NewVerifyAttestation(r *http.Request) (req resources.VerifyAttestationsRequest, err error) {
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		err = newDecodeError("body", err)
+		return req, err
+	}
+
+	attr := &req.Data.Attributes
+	errs := validation.Errors{
+		"data/type":                   validation.Validate(req.Data.Type, validation.Required, validation.In(resources.ATTESTATIONS)),
+		"data/attributes/attestation": validation.Validate(attr.Attestation, validation.Required, is.Base64),
+	}
+
+	return req, errs.Filter()
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/metrics"
 	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/service/handlers"
 	"github.com/go-chi/chi"
 	"gitlab.com/distributed_lab/ape"
@@ -15,11 +16,42 @@ func (s *service) router() chi.Router {
 		ape.CtxMiddleware(
 			handlers.CtxLog(s.log),
 			handlers.CtxSigner(s.signer),
+			handlers.CtxVerifier(s.verifier),
+			handlers.CtxNonces(s.nonces),
+			handlers.CtxPolicies(s.policies),
+			handlers.CtxBatch(s.batch),
+			handlers.CtxValidity(s.validity),
+			handlers.CtxDomainResolver(s.domains),
+			handlers.CtxHealth(s.health),
 		),
 	)
+	// Metrics are served with the API unless they have a listener of their own
+	if !s.metrics.Disabled && s.metrics.Listener == nil {
+		r.Get("/metrics", metrics.Handler().ServeHTTP)
+	}
+	r.Get("/healthz", handlers.Healthz)
+	r.Get("/readyz", handlers.Readyz)
 	r.Route("/v1", func(r chi.Router) {
-		r.Post("/attestations", handlers.VerifyAttestation)
+		r.Post("/attestations/verify", handlers.VerifyAttestationDocument)
+		r.Post("/nonces", handlers.IssueNonce)
+
+		r.Group(func(r chi.Router) {
+			r.Use(handlers.RequireSigner)
+			r.Post("/attestations", handlers.VerifyAttestation)
+			r.Post("/attestations/batch", handlers.SignAttestationBatch)
+			r.Get("/signer", handlers.GetSigner)
+		})
 	})
 
 	return r
 }
+
+// metricsRouter serves only /metrics on the dedicated listener
+func (s *service) metricsRouter() chi.Router {
+	r := chi.NewRouter()
+
+	r.Use(ape.RecoverMiddleware(s.log))
+	r.Get("/metrics", metrics.Handler().ServeHTTP)
+
+	return r
+}
@@ -0,0 +1,43 @@
+/*
+ * GENERATED. Do not modify. Your changes might be overwritten!
+ */
+
+package resources
+
+import "encoding/json"
+
+type AttestationDocument struct {
+	Key
+	Attributes AttestationDocumentAttributes `json:"attributes"`
+}
+type AttestationDocumentResponse struct {
+	Data     AttestationDocument `json:"data"`
+	Included Included            `json:"included"`
+}
+
+type AttestationDocumentListResponse struct {
+	Data     []AttestationDocument `json:"data"`
+	Included Included              `json:"included"`
+	Links    *Links                `json:"links"`
+	Meta     json.RawMessage       `json:"meta,omitempty"`
+}
+
+func (r *AttestationDocumentListResponse) PutMeta(v interface{}) (err error) {
+	r.Meta, err = json.Marshal(v)
+	return err
+}
+
+func (r *AttestationDocumentListResponse) GetMeta(out interface{}) error {
+	return json.Unmarshal(r.Meta, out)
+}
+
+// MustAttestationDocument - returns AttestationDocument from include collection.
+// if entry with specified key does not exist - returns nil
+// if entry with specified key exists but type or ID mismatches - panics
+func (c *Included) MustAttestationDocument(key Key) *AttestationDocument {
+	var attestationDocument AttestationDocument
+	if c.tryFindEntry(key, &attestationDocument) {
+		return &attestationDocument
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+/*
+ * GENERATED. Do not modify. Your changes might be overwritten!
+ */
+
+package resources
+
+import "time"
+
+type AttestationDocumentAttributes struct {
+	ModuleId  string    `json:"module_id"`
+	Digest    string    `json:"digest"`
+	Timestamp time.Time `json:"timestamp"`
+	// Hex-encoded PCR values by name, e.g. pcr0
+	Pcrs map[string]string `json:"pcrs"`
+	// Standard base64-encoded public key, absent if not set in the document
+	PublicKey *string `json:"public_key,omitempty"`
+	// Standard base64-encoded user data, absent if not set in the document
+	UserData *string `json:"user_data,omitempty"`
+	// Standard base64-encoded nonce, absent if not set in the document
+	Nonce *string `json:"nonce,omitempty"`
+	// Certificate chain from the NSM certificate to the root
+	Certificates []CertificateSummary `json:"certificates"`
+	Verdict      AttestationVerdict   `json:"verdict"`
+}
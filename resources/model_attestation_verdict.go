@@ -0,0 +1,18 @@
+/*
+ * GENERATED. Do not modify. Your changes might be overwritten!
+ */
+
+package resources
+
+type AttestationVerdict struct {
+	// Whether the attestation document would be accepted for signing
+	Valid bool `json:"valid"`
+	// Hex-encoded SHA-256 fingerprint of the trusted root the attestation document is chained to
+	RootFingerprint string `json:"root_fingerprint,omitempty"`
+	// Name of the matched PCR policy profile
+	Policy string `json:"policy,omitempty"`
+	// Error code of the failed check, same as returned by signing endpoint
+	Code string `json:"code,omitempty"`
+	// Reason the attestation document is rejected
+	Error string `json:"error,omitempty"`
+}
@@ -0,0 +1,43 @@
+/*
+ * GENERATED. Do not modify. Your changes might be overwritten!
+ */
+
+package resources
+
+import "encoding/json"
+
+type BatchSignedAttestations struct {
+	Key
+	Attributes BatchSignedAttestationsAttributes `json:"attributes"`
+}
+type BatchSignedAttestationsResponse struct {
+	Data     BatchSignedAttestations `json:"data"`
+	Included Included                `json:"included"`
+}
+
+type BatchSignedAttestationsListResponse struct {
+	Data     []BatchSignedAttestations `json:"data"`
+	Included Included                  `json:"included"`
+	Links    *Links                    `json:"links"`
+	Meta     json.RawMessage           `json:"meta,omitempty"`
+}
+
+func (r *BatchSignedAttestationsListResponse) PutMeta(v interface{}) (err error) {
+	r.Meta, err = json.Marshal(v)
+	return err
+}
+
+func (r *BatchSignedAttestationsListResponse) GetMeta(out interface{}) error {
+	return json.Unmarshal(r.Meta, out)
+}
+
+// MustBatchSignedAttestations - returns BatchSignedAttestations from include collection.
+// if entry with specified key does not exist - returns nil
+// if entry with specified key exists but type or ID mismatches - panics
+func (c *Included) MustBatchSignedAttestations(key Key) *BatchSignedAttestations {
+	var batchSignedAttestations BatchSignedAttestations
+	if c.tryFindEntry(key, &batchSignedAttestations) {
+		return &batchSignedAttestations
+	}
+	return nil
+}
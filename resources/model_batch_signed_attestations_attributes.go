@@ -0,0 +1,34 @@
+/*
+ * GENERATED. Do not modify. Your changes might be overwritten!
+ */
+
+package resources
+
+import (
+	"time"
+
+	"github.com/google/jsonapi"
+)
+
+type BatchSignedAttestationsAttributes struct {
+	// Standard base64-encoded signatures, one per requested domain in the same order
+	Signatures []string `json:"signatures,omitempty"`
+	// 0x-prefixed hex digests, one per requested domain in the same order
+	Digests []string `json:"digests,omitempty"`
+	// Signature scheme: eip712, eip191 or raw
+	Scheme string `json:"scheme,omitempty"`
+	// Address of the signer
+	Signer string `json:"signer,omitempty"`
+	// ID of the signer key generation
+	Generation string `json:"generation,omitempty"`
+	// Hex-encoded SHA-256 fingerprint of the root certificate the attestation document is chained to
+	RootFingerprint string `json:"root_fingerprint,omitempty"`
+	// Name of the PCR policy profile the attestation document matched
+	Policy string `json:"policy,omitempty"`
+	// Time the endorsement is signed at, signed as issued_at unix seconds. Absent if it doesn't expire
+	IssuedAt *time.Time `json:"issued_at,omitempty"`
+	// Time the endorsement expires at, signed as deadline unix seconds. Absent if it doesn't expire
+	Deadline *time.Time `json:"deadline,omitempty"`
+	// Errors the item is rejected with, signatures are absent then
+	Errors []*jsonapi.ErrorObject `json:"errors,omitempty"`
+}
@@ -0,0 +1,14 @@
+/*
+ * GENERATED. Do not modify. Your changes might be overwritten!
+ */
+
+package resources
+
+type Health struct {
+	Key
+	Attributes HealthAttributes `json:"attributes"`
+}
+type HealthResponse struct {
+	Data     Health   `json:"data"`
+	Included Included `json:"included"`
+}
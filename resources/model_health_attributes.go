@@ -0,0 +1,12 @@
+/*
+ * GENERATED. Do not modify. Your changes might be overwritten!
+ */
+
+package resources
+
+type HealthAttributes struct {
+	// Whether every component is ok or disabled
+	Ready bool `json:"ready"`
+	// States of the components by name
+	Components map[string]HealthComponent `json:"components"`
+}
@@ -0,0 +1,16 @@
+/*
+ * GENERATED. Do not modify. Your changes might be overwritten!
+ */
+
+package resources
+
+import "time"
+
+type HealthComponent struct {
+	// One of pending, ok, disabled or failed
+	Status string `json:"status"`
+	// Error of the failed component
+	Error string `json:"error,omitempty"`
+	// Time the state was reported at
+	UpdatedAt time.Time `json:"updated_at"`
+}
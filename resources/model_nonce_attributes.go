@@ -0,0 +1,14 @@
+/*
+ * GENERATED. Do not modify. Your changes might be overwritten!
+ */
+
+package resources
+
+import "time"
+
+type NonceAttributes struct {
+	// Standard base64-encoded nonce to be put in the attestation document
+	Nonce string `json:"nonce"`
+	// Time after which the nonce is no longer accepted
+	ExpiresAt time.Time `json:"expires_at"`
+}
@@ -8,5 +8,9 @@ type ResourceType string
 
 // List of ResourceType
 const (
-	ATTESTATIONS ResourceType = "attestations"
+	ATTESTATIONS          ResourceType = "attestations"
+	NONCES                ResourceType = "nonces"
+	ATTESTATION_DOCUMENTS ResourceType = "attestation_documents"
+	SIGNERS               ResourceType = "signers"
+	HEALTH                ResourceType = "health"
 )
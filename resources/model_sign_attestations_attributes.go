@@ -4,12 +4,32 @@
 
 package resources
 
-import "github.com/ethereum/go-ethereum/signer/core/apitypes"
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
 
 type SignAttestationsAttributes struct {
 	// Standard base64-encoded EIP712 AWS Nitro Enclave attestation document
-	Attestation  string                   `json:"attestation"`
-	Domain       apitypes.TypedDataDomain `json:"domain"`
-	PrimaryType  *string                  `json:"primary_type"`
-	FieldsToSign []string                 `json:"fields_to_sign"`
+	Attestation string                   `json:"attestation"`
+	Domain      apitypes.TypedDataDomain `json:"domain"`
+	// Domains to sign the attestation document for instead of domain, one signature per domain. Supported only by batch signing
+	Domains      []apitypes.TypedDataDomain `json:"domains,omitempty"`
+	PrimaryType  *string                    `json:"primary_type"`
+	FieldsToSign []string                   `json:"fields_to_sign"`
+	// Time the attestation document is verified at instead of the current time. Allowed only if enabled by the verifier config
+	VerificationTime *time.Time `json:"verification_time,omitempty"`
+	// Name of the PCR policy profile the attestation document must match. Server default is used if absent
+	Policy *string `json:"policy,omitempty"`
+	// Signature scheme: eip712, eip191 or raw. eip712 if absent
+	Scheme string `json:"scheme,omitempty"`
+	// EIP712 types of the message to sign instead of fields_to_sign, without EIP712Domain. eip712 scheme only
+	Types apitypes.Types `json:"types,omitempty"`
+	// Message of primary_type to sign, "$<field>" values are filled from the attestation document. Required with types
+	Message map[string]interface{} `json:"message,omitempty"`
+	// Requested validity of the endorsement in seconds, capped by the server. Server default is used if absent
+	TTL *uint64 `json:"ttl,omitempty"`
+	// Encodings of the signed attestation fields by field name, e.g. keccak256, split, address or abi(address wallet). Fields are signed as is if absent
+	Encodings map[string]string `json:"encodings,omitempty"`
 }
@@ -4,7 +4,36 @@
 
 package resources
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type SignedAttestationsAttributes struct {
-	// Standard base64-encoded EIP712 signature
+	// Standard base64-encoded signature
 	Signature string `json:"signature"`
+	// Signature scheme: eip712, eip191 or raw
+	Scheme string `json:"scheme"`
+	// Hex-encoded SHA-256 fingerprint of the root certificate the attestation document is chained to
+	RootFingerprint string `json:"root_fingerprint"`
+	// Name of the PCR policy profile the attestation document matched. Absent if no policies are configured
+	Policy string `json:"policy,omitempty"`
+	// 0x-prefixed hex digest the signature is made over
+	Digest string `json:"digest"`
+	// eth_signTypedData_v4 compatible typed data, bytes are 0x-prefixed hex. eip712 scheme only
+	TypedData json.RawMessage `json:"typed_data,omitempty"`
+	// 0x-prefixed hex EIP712 domain separator. eip712 scheme only
+	DomainSeparator string `json:"domain_separator,omitempty"`
+	// 0x-prefixed hex hash of the primary type encoding. eip712 scheme only
+	TypeHash string `json:"type_hash,omitempty"`
+	// 0x-prefixed hex packed encoding of the signed fields. eip191 and raw schemes only
+	Message string `json:"message,omitempty"`
+	// Address of the signer
+	Signer string `json:"signer"`
+	// ID of the signer key generation
+	Generation string `json:"generation"`
+	// Time the endorsement is signed at, signed as issued_at unix seconds. Absent if it doesn't expire
+	IssuedAt *time.Time `json:"issued_at,omitempty"`
+	// Time the endorsement expires at, signed as deadline unix seconds. Absent if it doesn't expire
+	Deadline *time.Time `json:"deadline,omitempty"`
 }
@@ -0,0 +1,14 @@
+/*
+ * GENERATED. Do not modify. Your changes might be overwritten!
+ */
+
+package resources
+
+type SignerAttestations struct {
+	// Standard base64-encoded attestation document with the KMS key ID in user data
+	KmsKeyId string `json:"kms_key_id"`
+	// Standard base64-encoded attestation document with the public key in user data and public key fields
+	PublicKey string `json:"public_key"`
+	// Standard base64-encoded attestation document with the address in user data
+	Address string `json:"address"`
+}
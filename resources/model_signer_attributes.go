@@ -0,0 +1,21 @@
+/*
+ * GENERATED. Do not modify. Your changes might be overwritten!
+ */
+
+package resources
+
+type SignerAttributes struct {
+	// Ethereum address of the signer
+	Address string `json:"address"`
+	// 0x-prefixed hex uncompressed secp256k1 public key of the signer
+	PublicKey string `json:"public_key"`
+	// KMS key the signer private key is encrypted with
+	KmsKeyId     string             `json:"kms_key_id"`
+	Attestations SignerAttestations `json:"attestations"`
+	// Hex-encoded PCR values of the running enclave by name, e.g. pcr0
+	Pcrs map[string]string `json:"pcrs"`
+	// ID of the signer key generation
+	Generation string `json:"generation"`
+	// Superseded key generations, which no longer sign, within the overlap period
+	PreviousGenerations []SignerGeneration `json:"previous_generations"`
+}
@@ -0,0 +1,18 @@
+/*
+ * GENERATED. Do not modify. Your changes might be overwritten!
+ */
+
+package resources
+
+import "time"
+
+type SignerGeneration struct {
+	// ID of the signer key generation
+	Generation string `json:"generation"`
+	// Ethereum address of the generation key
+	Address string `json:"address"`
+	// Time the generation stopped signing at
+	SupersededAt time.Time `json:"superseded_at"`
+	// Time the generation is unloaded at
+	ExpiresAt time.Time `json:"expires_at"`
+}
@@ -0,0 +1,43 @@
+/*
+ * GENERATED. Do not modify. Your changes might be overwritten!
+ */
+
+package resources
+
+import "encoding/json"
+
+type VerifyAttestations struct {
+	Key
+	Attributes VerifyAttestationsAttributes `json:"attributes"`
+}
+type VerifyAttestationsRequest struct {
+	Data     VerifyAttestations `json:"data"`
+	Included Included           `json:"included"`
+}
+
+type VerifyAttestationsListRequest struct {
+	Data     []VerifyAttestations `json:"data"`
+	Included Included             `json:"included"`
+	Links    *Links               `json:"links"`
+	Meta     json.RawMessage      `json:"meta,omitempty"`
+}
+
+func (r *VerifyAttestationsListRequest) PutMeta(v interface{}) (err error) {
+	r.Meta, err = json.Marshal(v)
+	return err
+}
+
+func (r *VerifyAttestationsListRequest) GetMeta(out interface{}) error {
+	return json.Unmarshal(r.Meta, out)
+}
+
+// MustVerifyAttestations - returns VerifyAttestations from include collection.
+// if entry with specified key does not exist - returns nil
+// if entry with specified key exists but type or ID mismatches - panics
+func (c *Included) MustVerifyAttestations(key Key) *VerifyAttestations {
+	var verifyAttestations VerifyAttestations
+	if c.tryFindEntry(key, &verifyAttestations) {
+		return &verifyAttestations
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+/*
+ * GENERATED. Do not modify. Your changes might be overwritten!
+ */
+
+package resources
+
+import "time"
+
+type VerifyAttestationsAttributes struct {
+	// Standard base64-encoded AWS Nitro Enclave attestation document
+	Attestation string `json:"attestation"`
+	// Time the attestation document is verified at instead of the current time. Allowed only if enabled by the verifier config
+	VerificationTime *time.Time `json:"verification_time,omitempty"`
+	// Name of the PCR policy profile the attestation document must match. Server default is used if absent
+	Policy *string `json:"policy,omitempty"`
+}
@@ -4,17 +4,25 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/icrypto"
 	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/utils"
 	"github.com/distributed-lab/aws-nitro-enclaves-av/resources"
+	"github.com/distributed-lab/enclave-extras/attestation"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"github.com/google/jsonapi"
 	"github.com/mdlayher/vsock"
 )
 
@@ -24,10 +32,29 @@ type Client struct {
 	base        *url.URL
 	domain      apitypes.TypedDataDomain
 	primaryType string
+	scheme      icrypto.Scheme
+	encodings   map[string]string
+	ttl         *time.Duration
 
 	c *http.Client
 }
 
+// Endorsement is the signature of the attestation document, validity is
+// zero if it doesn't expire
+type Endorsement struct {
+	Signature []byte
+	IssuedAt  time.Time
+	Deadline  time.Time
+}
+
+func (e *Endorsement) validity() *utils.Validity {
+	if e.Deadline.IsZero() {
+		return nil
+	}
+
+	return &utils.Validity{IssuedAt: e.IssuedAt, Deadline: e.Deadline}
+}
+
 func NewInetClient(target string, domain apitypes.TypedDataDomain, primaryType *string) (*Client, error) {
 	base, err := url.Parse(target)
 	if err != nil {
@@ -42,6 +69,7 @@ func NewInetClient(target string, domain apitypes.TypedDataDomain, primaryType *
 		base:        base,
 		domain:      domain,
 		primaryType: *primaryType,
+		scheme:      icrypto.SchemeEIP712,
 		c:           http.DefaultClient,
 	}, nil
 }
@@ -55,6 +83,7 @@ func NewVsockClient(contextID uint32, port uint32, domain apitypes.TypedDataDoma
 		base:        vsockTarget,
 		domain:      domain,
 		primaryType: *primaryType,
+		scheme:      icrypto.SchemeEIP712,
 		c: &http.Client{
 			Transport: &http.Transport{
 				Proxy: http.ProxyFromEnvironment,
@@ -71,8 +100,112 @@ func NewVsockClient(contextID uint32, port uint32, domain apitypes.TypedDataDoma
 	}
 }
 
+// WithScheme returns a copy of the client signing with the scheme, eip712
+// by default. Domain and primary type are used only by eip712.
+func (c *Client) WithScheme(scheme icrypto.Scheme) *Client {
+	clone := *c
+	clone.scheme = scheme
+	return &clone
+}
+
+// WithEncodings returns a copy of the client signing the fields with the
+// encodings by field name, e.g. keccak256 or address
+func (c *Client) WithEncodings(encodings map[string]string) *Client {
+	clone := *c
+	clone.encodings = encodings
+	return &clone
+}
+
+// WithTTL returns a copy of the client requesting endorsements valid for
+// the TTL, it is capped by the service. Service default is used otherwise.
+func (c *Client) WithTTL(ttl time.Duration) *Client {
+	clone := *c
+	clone.ttl = &ttl
+	return &clone
+}
+
+// VerifySignature checks the signature of the attestation document fields
+// made with the client scheme, encodings, domain and primary type. Policy is
+// the matched PCR policy profile, required only if signed.
+func (c *Client) VerifySignature(attestationDocument []byte, fields []string, policy string, signature []byte, signer common.Address) error {
+	doc, err := attestation.ParseNSMAttestationDoc(attestationDocument)
+	if err != nil {
+		return fmt.Errorf("failed to parse attestation document: %w", err)
+	}
+
+	message, err := utils.BuildEncodedAttestationMessage(doc, c.primaryType, fields, c.encodings, policy)
+	if err != nil {
+		return fmt.Errorf("failed to build message: %w", err)
+	}
+
+	return icrypto.Verify(c.scheme, icrypto.GetDomain(c.domain), message, signature, signer)
+}
+
+// VerifyEndorsement is VerifySignature of the endorsement, which is
+// rejected with icrypto.ErrExpired after its deadline
+func (c *Client) VerifyEndorsement(attestationDocument []byte, fields []string, policy string, endorsement *Endorsement, signer common.Address) error {
+	doc, err := attestation.ParseNSMAttestationDoc(attestationDocument)
+	if err != nil {
+		return fmt.Errorf("failed to parse attestation document: %w", err)
+	}
+
+	message, err := utils.BuildEncodedAttestationMessage(doc, c.primaryType, fields, c.encodings, policy)
+	if err != nil {
+		return fmt.Errorf("failed to build message: %w", err)
+	}
+	if validity := endorsement.validity(); validity != nil {
+		if err = utils.WithValidity(message, *validity); err != nil {
+			return fmt.Errorf("failed to build message: %w", err)
+		}
+	}
+
+	return icrypto.Verify(c.scheme, icrypto.GetDomain(c.domain), message, endorsement.Signature, signer)
+}
+
+// VerifyTypedDataEndorsement checks the endorsement of the caller-supplied
+// typed data with placeholders filled from the attestation document, made
+// with the client domain and primary type. Expired ones are rejected with
+// icrypto.ErrExpired.
+func (c *Client) VerifyTypedDataEndorsement(attestationDocument []byte, types apitypes.Types, message map[string]interface{}, policy string, endorsement *Endorsement, signer common.Address) error {
+	doc, err := attestation.ParseNSMAttestationDoc(attestationDocument)
+	if err != nil {
+		return fmt.Errorf("failed to parse attestation document: %w", err)
+	}
+
+	typedDataMessage, err := utils.BuildTypedDataSchemaMessage(doc, c.primaryType, types, message, c.encodings, policy, endorsement.validity())
+	if err != nil {
+		return fmt.Errorf("failed to build message: %w", err)
+	}
+
+	return icrypto.GetDomain(c.domain).VerifyTypedData(typedDataMessage, endorsement.Signature, signer)
+}
+
 func (c *Client) SignAttestationDocument(attestationDocument []byte, fields []string) (sig []byte, err error) {
-	reqResource := newSignAttestationRequest(base64.StdEncoding.EncodeToString(attestationDocument), fields, &c.primaryType, c.domain)
+	endorsement, err := c.Endorse(attestationDocument, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	return endorsement.Signature, nil
+}
+
+// Endorse is SignAttestationDocument returning validity of the signature too
+func (c *Client) Endorse(attestationDocument []byte, fields []string) (*Endorsement, error) {
+	return c.signAttestation(c.newSignAttestationRequest(attestationDocument, fields))
+}
+
+// EndorseTypedData signs the typed data of the client primary type, message
+// values like "$pcr0" are filled from the attestation document by the
+// service. Supported only by eip712 scheme.
+func (c *Client) EndorseTypedData(attestationDocument []byte, types apitypes.Types, message map[string]interface{}) (*Endorsement, error) {
+	reqResource := c.newSignAttestationRequest(attestationDocument, nil)
+	reqResource.Data.Attributes.Types = types
+	reqResource.Data.Attributes.Message = message
+
+	return c.signAttestation(reqResource)
+}
+
+func (c *Client) signAttestation(reqResource resources.SignAttestationsRequest) (*Endorsement, error) {
 	reqBody, err := json.Marshal(reqResource)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request body: %w", err)
@@ -103,24 +236,244 @@ func (c *Client) SignAttestationDocument(attestationDocument []byte, fields []st
 		return nil, fmt.Errorf("failed to unmarshal signed attestation response: %w", err)
 	}
 
-	if sig, err = base64.StdEncoding.DecodeString(resResource.Data.Attributes.Signature); err != nil {
+	attributes := resResource.Data.Attributes
+	endorsement := &Endorsement{}
+	if endorsement.Signature, err = base64.StdEncoding.DecodeString(attributes.Signature); err != nil {
 		return nil, fmt.Errorf("invalid base64 signature: %w", err)
 	}
+	if attributes.IssuedAt != nil && attributes.Deadline != nil {
+		endorsement.IssuedAt, endorsement.Deadline = *attributes.IssuedAt, *attributes.Deadline
+	}
+
+	return endorsement, nil
+}
+
+// BatchResult is the outcome of a single attestation document of the batch
+type BatchResult struct {
+	// Signatures in the order of requested domains
+	Signatures [][]byte
+	// Validity of the signatures, zero if they don't expire
+	IssuedAt time.Time
+	Deadline time.Time
+	Err      error
+}
+
+// SignAttestationDocuments signs attestation documents in a single request.
+// Every document is signed for each of domains, or for the client domain if
+// none are given. Results are in the order of documents, a rejected document
+// has only Err set.
+func (c *Client) SignAttestationDocuments(attestationDocuments [][]byte, fields []string, domains ...apitypes.TypedDataDomain) ([]BatchResult, error) {
+	items := make([]resources.SignAttestations, len(attestationDocuments))
+	for i, attestationDocument := range attestationDocuments {
+		items[i] = c.newSignAttestationRequest(attestationDocument, fields).Data
+		items[i].Attributes.Domains = domains
+	}
+
+	reqBody, err := json.Marshal(resources.SignAttestationsListRequest{Data: items})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.base.JoinPath("v1/attestations/batch").String(), bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create POST request: %w", err)
+	}
+
+	res, err := c.c.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to Do request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	var resResource resources.BatchSignedAttestationsListResponse
+	if err := json.Unmarshal(resBody, &resResource); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal batch signed attestations response: %w", err)
+	}
+
+	if len(resResource.Data) != len(attestationDocuments) {
+		return nil, fmt.Errorf("unexpected count of results: %d, expected %d", len(resResource.Data), len(attestationDocuments))
+	}
+
+	results := make([]BatchResult, len(resResource.Data))
+	for i, item := range resResource.Data {
+		if len(item.Attributes.Errors) != 0 {
+			results[i].Err = newItemError(item.Attributes.Errors[0])
+			continue
+		}
+
+		results[i].Signatures = make([][]byte, len(item.Attributes.Signatures))
+		for j, sig := range item.Attributes.Signatures {
+			if results[i].Signatures[j], err = base64.StdEncoding.DecodeString(sig); err != nil {
+				return nil, fmt.Errorf("invalid base64 signature of item %d: %w", i, err)
+			}
+		}
+		if item.Attributes.IssuedAt != nil && item.Attributes.Deadline != nil {
+			results[i].IssuedAt, results[i].Deadline = *item.Attributes.IssuedAt, *item.Attributes.Deadline
+		}
+	}
+
+	return results, nil
+}
+
+func newItemError(problem *jsonapi.ErrorObject) error {
+	reason := problem.Detail
+	if problem.Meta != nil {
+		if metaErr, ok := (*problem.Meta)["error"].(string); ok {
+			reason = metaErr
+		}
+	}
+
+	if problem.Code != "" {
+		return fmt.Errorf("%s: %s: %s", problem.Title, problem.Code, reason)
+	}
+	return fmt.Errorf("%s: %s", problem.Title, reason)
+}
+
+// SignerIdentity is the signer key with attestation documents proving it
+// was generated within the enclave. Documents must be verified by the
+// client before the signer is trusted.
+type SignerIdentity struct {
+	// Key generation the signer belongs to
+	Generation string
+	Address    common.Address
+	PublicKey  []byte
+	KMSKeyID   string
+	// Raw COSE Sign1 attestation documents
+	KMSKeyIDAttestation  []byte
+	PublicKeyAttestation []byte
+	AddressAttestation   []byte
+	// PCRs of the running enclave
+	PCRs map[int][]byte
+}
+
+// GetSigner requests the signer identity
+func (c *Client) GetSigner() (*SignerIdentity, error) {
+	req, err := http.NewRequest(http.MethodGet, c.base.JoinPath("v1/signer").String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GET request: %w", err)
+	}
+
+	res, err := c.c.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to Do request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	var resResource resources.SignerResponse
+	if err := json.Unmarshal(resBody, &resResource); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal signer response: %w", err)
+	}
+	attrs := resResource.Data.Attributes
+
+	if !common.IsHexAddress(attrs.Address) {
+		return nil, fmt.Errorf("invalid signer address: %s", attrs.Address)
+	}
+
+	identity := &SignerIdentity{
+		Generation: attrs.Generation,
+		Address:    common.HexToAddress(attrs.Address),
+		KMSKeyID:   attrs.KmsKeyId,
+		PCRs:       make(map[int][]byte, len(attrs.Pcrs)),
+	}
+
+	if identity.PublicKey, err = hexutil.Decode(attrs.PublicKey); err != nil {
+		return nil, fmt.Errorf("invalid hex public key: %w", err)
+	}
 
-	return sig, nil
+	for field, doc := range map[*[]byte]string{
+		&identity.KMSKeyIDAttestation:  attrs.Attestations.KmsKeyId,
+		&identity.PublicKeyAttestation: attrs.Attestations.PublicKey,
+		&identity.AddressAttestation:   attrs.Attestations.Address,
+	} {
+		if *field, err = base64.StdEncoding.DecodeString(doc); err != nil {
+			return nil, fmt.Errorf("invalid base64 attestation document: %w", err)
+		}
+	}
+
+	for name, value := range attrs.Pcrs {
+		index, err := strconv.Atoi(strings.TrimPrefix(name, "pcr"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid PCR name: %s", name)
+		}
+		if identity.PCRs[index], err = hex.DecodeString(value); err != nil {
+			return nil, fmt.Errorf("invalid hex %s: %w", name, err)
+		}
+	}
+
+	return identity, nil
 }
 
-func newSignAttestationRequest(attestationB64 string, fieldsToSign []string, primaryType *string, domain apitypes.TypedDataDomain) resources.SignAttestationsRequest {
+// GetNonce requests a challenge nonce to be put in the attestation document
+func (c *Client) GetNonce() (nonce []byte, expiresAt time.Time, err error) {
+	req, err := http.NewRequest(http.MethodPost, c.base.JoinPath("v1/nonces").String(), nil)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to create POST request: %w", err)
+	}
+
+	res, err := c.c.Do(req)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to Do request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated {
+		return nil, time.Time{}, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	var resResource resources.NonceResponse
+	if err := json.Unmarshal(resBody, &resResource); err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to unmarshal nonce response: %w", err)
+	}
+
+	if nonce, err = base64.StdEncoding.DecodeString(resResource.Data.Attributes.Nonce); err != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid base64 nonce: %w", err)
+	}
+
+	return nonce, resResource.Data.Attributes.ExpiresAt, nil
+}
+
+func (c *Client) newSignAttestationRequest(attestationDocument []byte, fieldsToSign []string) resources.SignAttestationsRequest {
+	var ttl *uint64
+	if c.ttl != nil {
+		ttl = utils.AsPointer(uint64(c.ttl.Seconds()))
+	}
+
 	return resources.SignAttestationsRequest{
 		Data: resources.SignAttestations{
 			Key: resources.Key{
 				Type: resources.ATTESTATIONS,
 			},
 			Attributes: resources.SignAttestationsAttributes{
-				Attestation:  attestationB64,
-				Domain:       domain,
-				PrimaryType:  primaryType,
+				Attestation:  base64.StdEncoding.EncodeToString(attestationDocument),
+				Domain:       c.domain,
+				PrimaryType:  &c.primaryType,
 				FieldsToSign: fieldsToSign,
+				Scheme:       string(c.scheme),
+				Encodings:    c.encodings,
+				TTL:          ttl,
 			},
 		},
 	}
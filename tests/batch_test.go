@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"context"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/config"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/icrypto"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/utils"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/service/handlers"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/sdk"
+	"github.com/distributed-lab/enclave-extras/attestation"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/distributed_lab/ape"
+	"gitlab.com/distributed_lab/logan/v3"
+)
+
+// newSimulatedService returns config of the service running on the
+// simulator with fake KMS, and the address of its signer
+func newSimulatedService(t *testing.T, values map[string]map[string]interface{}) (config.Config, common.Address) {
+	var (
+		caDirectory      = t.TempDir()
+		attestationsPath = t.TempDir()
+	)
+
+	if values == nil {
+		values = make(map[string]map[string]interface{})
+	}
+	values["signer"] = map[string]interface{}{
+		"attestations_directory": attestationsPath,
+		"nsm":                    config.NSMSimulator,
+		"kms":                    config.KMSFake,
+	}
+	values["nsm_simulator"] = map[string]interface{}{
+		"ca_directory": caDirectory,
+		"pcrs":         map[string]interface{}{"0": "01" + strings.Repeat("00", 47)},
+	}
+	values["fake_kms"] = map[string]interface{}{"directory": t.TempDir()}
+	if values["verifier"] == nil {
+		values["verifier"] = map[string]interface{}{}
+	}
+	values["verifier"]["roots"] = []interface{}{path.Join(caDirectory, "simulator_root.pem")}
+
+	cfg := newTestConfig(values)
+
+	addressDocRaw, err := cfg.GetSigner().Current().Storage.Read("address.coses1")
+	require.NoError(t, err, "failed to read address document")
+	addressDoc, err := attestation.ParseNSMAttestationDoc(addressDocRaw)
+	require.NoError(t, err, "failed to parse address document")
+
+	return cfg, common.BytesToAddress(addressDoc.UserData)
+}
+
+// newTestServer serves the handler with context of the config, extenders
+// override it
+func newTestServer(cfg config.Config, handler http.HandlerFunc, extenders ...func(context.Context) context.Context) *httptest.Server {
+	return httptest.NewServer(ape.CtxMiddleware(append([]func(context.Context) context.Context{
+		handlers.CtxLog(logan.New()),
+		handlers.CtxSigner(cfg.GetSigner()),
+		handlers.CtxVerifier(cfg.GetVerifier()),
+		handlers.CtxNonces(cfg.GetNonces()),
+		handlers.CtxPolicies(cfg.GetPolicies()),
+		handlers.CtxBatch(cfg.GetBatch()),
+		handlers.CtxValidity(cfg.GetValidity()),
+		handlers.CtxDomainResolver(cfg.GetDomainResolver()),
+	}, extenders...)...)(handler))
+}
+
+func TestBatchSigning(t *testing.T) {
+	cfg, address := newSimulatedService(t, map[string]map[string]interface{}{
+		"batch": {"workers": 2},
+	})
+
+	server := newTestServer(cfg, handlers.SignAttestationBatch)
+	defer server.Close()
+
+	provider := cfg.GetAttestationProvider()
+	docs := make([][]byte, 5)
+	for i := range docs {
+		var err error
+		docs[i], err = provider.GetAttestationDoc([]byte{byte(i)}, nil, []byte{0x04, byte(i)})
+		require.NoError(t, err, "failed to get attestation document")
+	}
+	// Invalid item must not reject the whole batch
+	docs[2] = []byte("not a document")
+
+	domains := []apitypes.TypedDataDomain{
+		{Name: "Test", Version: "v1", ChainId: (*math.HexOrDecimal256)(big.NewInt(1))},
+		{Name: "Test", Version: "v1", ChainId: (*math.HexOrDecimal256)(big.NewInt(137))},
+	}
+
+	client, err := sdk.NewInetClient(server.URL, domains[0], nil)
+	require.NoError(t, err, "failed to create inet client")
+
+	fields := []string{"pcr0", "public_key", "user_data"}
+	results, err := client.SignAttestationDocuments(docs, fields, domains...)
+	require.NoError(t, err, "failed to sign batch")
+	require.Len(t, results, len(docs))
+
+	for i, result := range results {
+		if i == 2 {
+			require.Error(t, result.Err, "invalid document must be rejected")
+			continue
+		}
+		require.NoError(t, result.Err, "item %d must be signed", i)
+		require.Len(t, result.Signatures, len(domains))
+
+		doc, err := attestation.ParseNSMAttestationDoc(docs[i])
+		require.NoError(t, err, "failed to parse attestation document")
+		msg, err := utils.BuildTypedDataAttestationMessage(doc, utils.DefaultPrimaryType, fields, "")
+		require.NoError(t, err, "failed to build typed data message")
+
+		for j, domain := range domains {
+			err = icrypto.GetDomain(domain).VerifyTypedData(msg, result.Signatures[j], address)
+			require.NoError(t, err, "invalid signature of item %d for domain %d", i, j)
+		}
+		require.NotEqual(t, result.Signatures[0], result.Signatures[1], "domains must be separated")
+	}
+
+	_, err = client.SignAttestationDocuments(nil, fields)
+	require.Error(t, err, "empty batch must be rejected")
+}
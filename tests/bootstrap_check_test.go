@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/fakekms"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/nitro"
+	"github.com/distributed-lab/enclave-extras/attestation"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBootstrapDocumentsMismatch(t *testing.T) {
+	var (
+		caDirectory      = t.TempDir()
+		attestationsPath = t.TempDir()
+		keyPolicy        = nitro.KeyPolicy{PCRs: []int{0}}
+	)
+
+	provider, err := nitro.NewSimulator("", map[int][]byte{0: bytes.Repeat([]byte{0x01}, 48)}, caDirectory)
+	require.NoError(t, err, "failed to create simulator")
+
+	kmsBackend, err := fakekms.New(t.TempDir(), "", provider.RootFingerprint())
+	require.NoError(t, err, "failed to create fake KMS")
+
+	_, _, address := bootstrapSigner(t, kmsBackend, provider, attestationsPath)
+
+	meta := nitro.GenerationMeta{ID: nitro.LegacyGenerationID, Storage: newLocalStorage(t, attestationsPath)}
+
+	// Properly signed document attesting another address
+	tampered, err := provider.GetAttestationDoc(common.HexToAddress("0x1").Bytes(), nil, nil)
+	require.NoError(t, err, "failed to get attestation document")
+	require.NoError(t, os.WriteFile(path.Join(attestationsPath, "address.coses1"), tampered, 0644))
+
+	_, err = nitro.LoadGeneration(kmsBackend, provider, keyPolicy, nitro.OnMismatchFail, meta)
+	require.ErrorIs(t, err, nitro.ErrDocumentMismatch, "tampered address document must be refused")
+
+	generation, err := nitro.LoadGeneration(kmsBackend, provider, keyPolicy, nitro.OnMismatchRegenerate, meta)
+	require.NoError(t, err, "tampered address document must be regenerated")
+	require.Equal(t, address, generation.Address)
+	require.Equal(t, []string{"address.coses1"}, generation.Regenerated)
+
+	generation, err = nitro.LoadGeneration(kmsBackend, provider, keyPolicy, nitro.OnMismatchFail, meta)
+	require.NoError(t, err, "regenerated document must match")
+	require.Empty(t, generation.Regenerated)
+
+	// Public key document of the enclave with another image
+	otherImage, err := nitro.NewSimulator("", map[int][]byte{0: bytes.Repeat([]byte{0x02}, 48)}, caDirectory)
+	require.NoError(t, err, "failed to create simulator")
+
+	docs, err := nitro.ReadAttestationDocs(meta.Storage)
+	require.NoError(t, err, "failed to read attestation documents")
+	publicKeyDoc, err := attestation.ParseNSMAttestationDoc(docs.PublicKey)
+	require.NoError(t, err, "failed to parse public key document")
+	tampered, err = otherImage.GetAttestationDoc(publicKeyDoc.UserData, nil, publicKeyDoc.PublicKey)
+	require.NoError(t, err, "failed to get attestation document")
+	require.NoError(t, os.WriteFile(path.Join(attestationsPath, "public_key.coses1"), tampered, 0644))
+
+	_, err = nitro.LoadGeneration(kmsBackend, provider, keyPolicy, nitro.OnMismatchFail, meta)
+	require.ErrorIs(t, err, nitro.ErrDocumentMismatch, "document with mismatched PCR0 must be refused")
+	require.ErrorContains(t, err, "PCR0 mismatch")
+
+	// Documents the key is recovered from are never regenerated
+	kmsKeyIDDoc, err := os.ReadFile(path.Join(attestationsPath, "kms_key_id.coses1"))
+	require.NoError(t, err, "failed to read KMS Key ID document")
+	require.NoError(t, os.WriteFile(path.Join(attestationsPath, "kms_key_id.coses1"), kmsKeyIDDoc[:len(kmsKeyIDDoc)-1], 0644))
+
+	_, err = nitro.LoadGeneration(kmsBackend, provider, keyPolicy, nitro.OnMismatchRegenerate, meta)
+	require.ErrorIs(t, err, nitro.ErrDocumentMismatch, "corrupted KMS Key ID document must be refused")
+}
@@ -0,0 +1,233 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/icrypto"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/service/handlers"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/resources"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/sdk"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient/simulated"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"github.com/stretchr/testify/require"
+)
+
+// simulatedChainID is chain ID of the simulated backend
+const simulatedChainID = 1337
+
+var (
+	eip5267Contract    = common.HexToAddress("0x5267000000000000000000000000000000000001")
+	extendedContract   = common.HexToAddress("0x5267000000000000000000000000000000000002")
+	otherChainContract = common.HexToAddress("0x5267000000000000000000000000000000000003")
+	revertingContract  = common.HexToAddress("0x5267000000000000000000000000000000000004")
+	noCodeAddress      = common.HexToAddress("0x5267000000000000000000000000000000000005")
+	partialContract    = common.HexToAddress("0x5267000000000000000000000000000000000006")
+)
+
+func TestContractDomains(t *testing.T) {
+	backend := simulated.NewBackend(types.GenesisAlloc{
+		eip5267Contract:    {Code: returningCode(t, eip712DomainResult(t, 0x0f, simulatedChainID, eip5267Contract, nil)), Balance: big.NewInt(0)},
+		extendedContract:   {Code: returningCode(t, eip712DomainResult(t, 0x0f, simulatedChainID, extendedContract, []*big.Int{big.NewInt(1)})), Balance: big.NewInt(0)},
+		otherChainContract: {Code: returningCode(t, eip712DomainResult(t, 0x0f, 1, otherChainContract, nil)), Balance: big.NewInt(0)},
+		// Name and version only, chain and contract aren't bound
+		partialContract: {Code: returningCode(t, eip712DomainResult(t, 0x03, simulatedChainID, partialContract, nil)), Balance: big.NewInt(0)},
+		// PUSH1 0 PUSH1 0 REVERT
+		revertingContract: {Code: []byte{0x60, 0x00, 0x60, 0x00, 0xfd}, Balance: big.NewInt(0)},
+	})
+	defer backend.Close()
+
+	caller := &countingCaller{ContractCaller: backend.Client()}
+	resolver := icrypto.NewDomainResolver(map[string]bind.ContractCaller{"1337": caller}, time.Hour, time.Hour, 16, 5*time.Second)
+
+	cfg, address := newSimulatedService(t, nil)
+	server := newTestServer(cfg, handlers.VerifyAttestation, handlers.CtxDomainResolver(resolver))
+	defer server.Close()
+
+	rawDoc, err := cfg.GetAttestationProvider().GetAttestationDoc(nil, nil, []byte{0x04, 0x01})
+	require.NoError(t, err, "failed to get attestation document")
+
+	contractDomain := func(contract common.Address) apitypes.TypedDataDomain {
+		return apitypes.TypedDataDomain{
+			ChainId:           math.NewHexOrDecimal256(simulatedChainID),
+			VerifyingContract: contract.Hex(),
+		}
+	}
+
+	// Domain is read from the contract and cached
+	attrs, _ := signForDomain(t, server.URL, rawDoc, contractDomain(eip5267Contract), http.StatusOK)
+	var typedData apitypes.TypedData
+	require.NoError(t, json.Unmarshal(attrs.TypedData, &typedData), "failed to unmarshal typed data")
+	require.Equal(t, "Test", typedData.Domain.Name)
+	require.Equal(t, "1", typedData.Domain.Version)
+	require.Len(t, typedData.Types[icrypto.DomainType], 4)
+
+	fullDomain := apitypes.TypedDataDomain{
+		Name:              "Test",
+		Version:           "1",
+		ChainId:           math.NewHexOrDecimal256(simulatedChainID),
+		VerifyingContract: eip5267Contract.Hex(),
+	}
+	client, err := sdk.NewInetClient(server.URL, fullDomain, nil)
+	require.NoError(t, err, "failed to create client")
+	signature, err := base64.StdEncoding.DecodeString(attrs.Signature)
+	require.NoError(t, err, "failed to decode signature")
+	require.NoError(t, client.VerifySignature(rawDoc, []string{"pcr0", "public_key"}, "", signature, address))
+
+	signForDomain(t, server.URL, rawDoc, contractDomain(eip5267Contract), http.StatusOK)
+	require.EqualValues(t, 1, caller.calls.Load(), "domain must be cached")
+
+	// Domains with other fields and chains without endpoint are signed as is
+	named := contractDomain(eip5267Contract)
+	named.Name = "Other"
+	attrs, _ = signForDomain(t, server.URL, rawDoc, named, http.StatusOK)
+	var namedTypedData apitypes.TypedData
+	require.NoError(t, json.Unmarshal(attrs.TypedData, &namedTypedData), "failed to unmarshal typed data")
+	require.Equal(t, "Other", namedTypedData.Domain.Name)
+	require.Empty(t, namedTypedData.Domain.Version)
+
+	otherChain := contractDomain(eip5267Contract)
+	otherChain.ChainId = math.NewHexOrDecimal256(1)
+	signForDomain(t, server.URL, rawDoc, otherChain, http.StatusOK)
+	require.EqualValues(t, 1, caller.calls.Load(), "only configured chains must be called")
+
+	for name, tc := range map[string]struct {
+		contract common.Address
+		status   int
+		code     string
+	}{
+		"no code":               {contract: noCodeAddress, status: http.StatusBadRequest, code: handlers.CodeDomainUnsupported},
+		"reverting contract":    {contract: revertingContract, status: http.StatusBadRequest, code: handlers.CodeDomainUnsupported},
+		"extended domain":       {contract: extendedContract, status: http.StatusBadRequest, code: handlers.CodeDomainUnsupported},
+		"domain without chain":  {contract: partialContract, status: http.StatusBadRequest, code: handlers.CodeDomainUnsupported},
+		"domain of other chain": {contract: otherChainContract, status: http.StatusBadGateway, code: handlers.CodeDomainUnavailable},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, code := signForDomain(t, server.URL, rawDoc, contractDomain(tc.contract), tc.status)
+			require.Equal(t, tc.code, code)
+		})
+	}
+
+	// Failures are cached as well
+	calls := caller.calls.Load()
+	for contract, status := range map[common.Address]int{
+		noCodeAddress:      http.StatusBadRequest,
+		revertingContract:  http.StatusBadRequest,
+		partialContract:    http.StatusBadRequest,
+		otherChainContract: http.StatusBadGateway,
+	} {
+		signForDomain(t, server.URL, rawDoc, contractDomain(contract), status)
+	}
+	require.Equal(t, calls, caller.calls.Load(), "failures must be cached")
+
+	// Expired domains are fetched again
+	caller.calls.Store(0)
+	expiring := icrypto.NewDomainResolver(map[string]bind.ContractCaller{"1337": caller}, time.Nanosecond, time.Nanosecond, 16, 5*time.Second)
+	for range 2 {
+		_, err = expiring.Resolve(context.Background(), contractDomain(eip5267Contract))
+		require.NoError(t, err, "failed to resolve domain")
+		_, err = expiring.Resolve(context.Background(), contractDomain(revertingContract))
+		require.ErrorIs(t, err, icrypto.ErrDomainNotSupported)
+	}
+	require.EqualValues(t, 4, caller.calls.Load())
+
+	// Full cache drops the domain expiring first
+	caller.calls.Store(0)
+	bounded := icrypto.NewDomainResolver(map[string]bind.ContractCaller{"1337": caller}, time.Hour, time.Minute, 1, 5*time.Second)
+	for _, contract := range []common.Address{eip5267Contract, eip5267Contract, revertingContract, eip5267Contract} {
+		_, _ = bounded.Resolve(context.Background(), contractDomain(contract))
+	}
+	require.EqualValues(t, 3, caller.calls.Load(), "only one domain must be cached")
+}
+
+// countingCaller counts eip712Domain calls
+type countingCaller struct {
+	bind.ContractCaller
+	calls atomic.Int64
+}
+
+func (c *countingCaller) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	c.calls.Add(1)
+	return c.ContractCaller.CallContract(ctx, call, blockNumber)
+}
+
+// eip712DomainResult returns ABI-encoded eip712Domain result of the contract
+// with name Test and version 1
+func eip712DomainResult(t *testing.T, fields byte, chainID int64, contract common.Address, extensions []*big.Int) []byte {
+	parsed, err := abi.JSON(strings.NewReader(`[{"type":"function","name":"eip712Domain","inputs":[],"outputs":[
+		{"type":"bytes1"},{"type":"string"},{"type":"string"},{"type":"uint256"},{"type":"address"},{"type":"bytes32"},{"type":"uint256[]"}
+	]}]`))
+	require.NoError(t, err, "failed to parse ABI")
+
+	if extensions == nil {
+		extensions = []*big.Int{}
+	}
+	result, err := parsed.Methods["eip712Domain"].Outputs.Pack(
+		[1]byte{fields}, "Test", "1", big.NewInt(chainID), contract, [32]byte{}, extensions)
+	require.NoError(t, err, "failed to pack eip712Domain result")
+
+	return result
+}
+
+// returningCode returns runtime code returning the data on every call
+func returningCode(t *testing.T, data []byte) []byte {
+	require.Less(t, len(data), 1<<16)
+	size := binary.BigEndian.AppendUint16(nil, uint16(len(data)))
+
+	// PUSH2 size DUP1 PUSH2 offset PUSH1 0 CODECOPY PUSH1 0 RETURN
+	code := append([]byte{0x61}, size...)
+	code = append(code, 0x80, 0x61, 0x00, 13, 0x60, 0x00, 0x39, 0x60, 0x00, 0xf3)
+
+	return append(code, data...)
+}
+
+// signForDomain signs the document for the domain, returns the error code
+// if the request fails
+func signForDomain(t *testing.T, url string, doc []byte, typedDataDomain apitypes.TypedDataDomain, status int) (resources.SignedAttestationsAttributes, string) {
+	body, err := json.Marshal(resources.SignAttestationsRequest{
+		Data: resources.SignAttestations{
+			Key: resources.Key{Type: resources.ATTESTATIONS},
+			Attributes: resources.SignAttestationsAttributes{
+				Attestation: base64.StdEncoding.EncodeToString(doc),
+				Domain:      typedDataDomain,
+			},
+		},
+	})
+	require.NoError(t, err, "failed to marshal request")
+
+	res, err := http.Post(url, "application/json", bytes.NewReader(body))
+	require.NoError(t, err, "failed to send request")
+	defer res.Body.Close()
+	require.Equal(t, status, res.StatusCode)
+
+	if status != http.StatusOK {
+		var errs struct {
+			Errors []struct {
+				Code string `json:"code"`
+			} `json:"errors"`
+		}
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&errs), "failed to decode errors")
+		require.NotEmpty(t, errs.Errors)
+		return resources.SignedAttestationsAttributes{}, errs.Errors[0].Code
+	}
+
+	var signed resources.SignedAttestationsResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&signed), "failed to decode response")
+
+	return signed.Data.Attributes, ""
+}
@@ -0,0 +1,158 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"testing"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/icrypto"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/service/handlers"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/resources"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/sdk"
+	"github.com/distributed-lab/enclave-extras/attestation"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFieldEncodings(t *testing.T) {
+	cfg, address := newSimulatedService(t, nil)
+
+	server := newTestServer(cfg, handlers.VerifyAttestation)
+	defer server.Close()
+
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err, "failed to generate key")
+	wallet := common.HexToAddress("0x1c56346cd2a2bf3202f771f50d3d14a367b48070")
+	userData := encodeTuple(t, wallet, big.NewInt(1700000000))
+
+	rawDoc, err := cfg.GetAttestationProvider().GetAttestationDoc(userData, nil, crypto.FromECDSAPub(&key.PublicKey))
+	require.NoError(t, err, "failed to get attestation document")
+	doc, err := attestation.ParseNSMAttestationDoc(rawDoc)
+	require.NoError(t, err, "failed to parse attestation document")
+
+	fields := []string{"pcr0", "public_key", "user_data", "module_id"}
+	encodings := map[string]string{
+		"pcr0":       "split",
+		"public_key": "address",
+		"user_data":  "abi(address wallet,uint256 deadline)",
+		"module_id":  "keccak256",
+	}
+
+	attrs := signEncoded(t, server.URL, rawDoc, fields, encodings, icrypto.SchemeEIP712, http.StatusOK)
+
+	var typedData apitypes.TypedData
+	require.NoError(t, json.Unmarshal(attrs.TypedData, &typedData), "failed to unmarshal typed data")
+	require.Equal(t, []apitypes.Type{{Name: "wallet", Type: "address"}, {Name: "deadline", Type: "uint256"}}, typedData.Types["UserData"])
+	require.Equal(t, hexutil.Encode(doc.PCRs[0][:32]), typedData.Message["pcr0_hi"])
+	require.Equal(t, hexutil.Encode(doc.PCRs[0][32:]), typedData.Message["pcr0_lo"])
+	require.Equal(t, crypto.PubkeyToAddress(key.PublicKey).Hex(), typedData.Message["public_key"])
+	require.Equal(t, hexutil.Encode(crypto.Keccak256([]byte(doc.ModuleID))), typedData.Message["module_id"])
+	require.Equal(t, wallet.Hex(), typedData.Message["user_data"].(map[string]interface{})["wallet"])
+	hash, _, err := apitypes.TypedDataAndHash(typedData)
+	require.NoError(t, err, "failed to hash typed data")
+	require.Equal(t, hexutil.Encode(hash), attrs.Digest)
+
+	client, err := sdk.NewInetClient(server.URL, domain.TypedDataDomain, nil)
+	require.NoError(t, err, "failed to create client")
+	encodedClient := client.WithEncodings(encodings)
+	sig, err := encodedClient.SignAttestationDocument(rawDoc, fields)
+	require.NoError(t, err, "failed to sign with SDK")
+	require.NoError(t, encodedClient.VerifySignature(rawDoc, fields, "", sig, address))
+	require.Error(t, client.VerifySignature(rawDoc, fields, "", sig, address), "signature must be bound to the encodings")
+
+	// Fixed-size encodings keep packed encoding unambiguous
+	packedEncodings := map[string]string{"pcr0": "split", "public_key": "address", "user_data": "keccak256"}
+	packedFields := []string{"pcr0", "public_key", "user_data", "module_id"}
+	attrs = signEncoded(t, server.URL, rawDoc, packedFields, packedEncodings, icrypto.SchemeRaw, http.StatusOK)
+	packed := append(append(append(append([]byte{}, doc.PCRs[0]...),
+		crypto.PubkeyToAddress(key.PublicKey).Bytes()...), crypto.Keccak256(userData)...), doc.ModuleID...)
+	packed = packedWithLayout(icrypto.SchemeRaw, "Register(bytes32 pcr0_hi,bytes16 pcr0_lo,address public_key,bytes32 user_data,string module_id)", packed)
+	require.Equal(t, hexutil.Encode(packed), attrs.Message)
+
+	rawClient := client.WithScheme(icrypto.SchemeRaw).WithEncodings(packedEncodings)
+	sig, err = rawClient.SignAttestationDocument(rawDoc, packedFields)
+	require.NoError(t, err, "failed to sign packed with SDK")
+	require.NoError(t, rawClient.VerifySignature(rawDoc, packedFields, "", sig, address))
+
+	// Placeholders are filled with encoded values
+	types := apitypes.Types{"Registration": {{Name: "image", Type: "bytes32"}, {Name: "wallet", Type: "address"}}}
+	message := map[string]interface{}{"image": "$pcr0", "wallet": "$public_key"}
+	signTypedData(t, server.URL, rawDoc, "Registration", types, message, func(a *resources.SignAttestationsAttributes) {
+		a.Encodings = map[string]string{"pcr0": "keccak256", "public_key": "address"}
+	}, http.StatusOK)
+	signTypedData(t, server.URL, rawDoc, "Registration", types, message, func(a *resources.SignAttestationsAttributes) {
+		a.Encodings = map[string]string{"pcr0": "split", "public_key": "address"}
+	}, http.StatusBadRequest)
+
+	for name, tc := range map[string]struct {
+		fields    []string
+		encodings map[string]string
+		scheme    icrypto.Scheme
+	}{
+		"split of not PCR":         {fields: []string{"public_key"}, encodings: map[string]string{"public_key": "split"}},
+		"address of PCR":           {fields: []string{"pcr0"}, encodings: map[string]string{"pcr0": "address"}},
+		"keccak256 of timestamp":   {fields: []string{"timestamp"}, encodings: map[string]string{"timestamp": "keccak256"}},
+		"unknown encoding":         {fields: []string{"pcr0"}, encodings: map[string]string{"pcr0": "sha256"}},
+		"encoding of unsigned":     {fields: []string{"pcr0"}, encodings: map[string]string{"user_data": "keccak256"}},
+		"nested abi type":          {fields: []string{"user_data"}, encodings: map[string]string{"user_data": "abi(uint256[] values)"}},
+		"unnamed abi element":      {fields: []string{"user_data"}, encodings: map[string]string{"user_data": "abi(address,uint256)"}},
+		"address of not a key":     {fields: []string{"user_data"}, encodings: map[string]string{"user_data": "address"}},
+		"undecodable abi":          {fields: []string{"user_data"}, encodings: map[string]string{"user_data": "abi(string name)"}},
+		"abi with packed encoding": {fields: []string{"pcr0", "user_data"}, encodings: map[string]string{"user_data": "abi(address wallet,uint256 deadline)"}, scheme: icrypto.SchemeEIP191},
+	} {
+		t.Run(name, func(t *testing.T) {
+			scheme := tc.scheme
+			if scheme == "" {
+				scheme = icrypto.SchemeEIP712
+			}
+			signEncoded(t, server.URL, rawDoc, tc.fields, tc.encodings, scheme, http.StatusBadRequest)
+		})
+	}
+}
+
+func encodeTuple(t *testing.T, wallet common.Address, deadline *big.Int) []byte {
+	addressType, err := abi.NewType("address", "", nil)
+	require.NoError(t, err)
+	uintType, err := abi.NewType("uint256", "", nil)
+	require.NoError(t, err)
+
+	encoded, err := abi.Arguments{{Type: addressType}, {Type: uintType}}.Pack(wallet, deadline)
+	require.NoError(t, err, "failed to encode tuple")
+
+	return encoded
+}
+
+func signEncoded(t *testing.T, url string, doc []byte, fields []string, encodings map[string]string, scheme icrypto.Scheme, status int) resources.SignedAttestationsAttributes {
+	body, err := json.Marshal(resources.SignAttestationsRequest{
+		Data: resources.SignAttestations{
+			Key: resources.Key{Type: resources.ATTESTATIONS},
+			Attributes: resources.SignAttestationsAttributes{
+				Attestation:  base64.StdEncoding.EncodeToString(doc),
+				Domain:       domain.TypedDataDomain,
+				FieldsToSign: fields,
+				Encodings:    encodings,
+				Scheme:       string(scheme),
+			},
+		},
+	})
+	require.NoError(t, err, "failed to marshal request")
+
+	res, err := http.Post(url, "application/json", bytes.NewReader(body))
+	require.NoError(t, err, "failed to send request")
+	defer res.Body.Close()
+	require.Equal(t, status, res.StatusCode)
+
+	var signed resources.SignedAttestationsResponse
+	if status == http.StatusOK {
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&signed), "failed to decode response")
+	}
+
+	return signed.Data.Attributes
+}
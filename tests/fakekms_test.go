@@ -0,0 +1,244 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/fakekms"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/nitro"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/storage"
+	"github.com/distributed-lab/enclave-extras/attestation"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+func bootstrapSigner(t *testing.T, kmsBackend nitro.KMSBackend, provider nitro.AttestationProvider, attestationsPath string) (string, *ecdsa.PrivateKey, common.Address) {
+	store := newLocalStorage(t, attestationsPath)
+
+	kmsKeyID, err := nitro.GetAttestedKMSKeyID(kmsBackend, provider, nitro.KeyPolicy{PCRs: []int{0}}, store)
+	require.NoError(t, err, "failed to get attested KMS Key ID")
+
+	privateKey, err := nitro.GetAttestedPrivateKey(kmsBackend, provider, nitro.KeyPolicy{PCRs: []int{0}}, kmsKeyID, store)
+	require.NoError(t, err, "failed to get attested private key")
+
+	publicKey, err := nitro.GetAttestedPublicKey(provider, nitro.KeyPolicy{PCRs: []int{0}}, privateKey, store)
+	require.NoError(t, err, "failed to get attested public key")
+
+	address, err := nitro.GetAttestedAddress(provider, nitro.KeyPolicy{PCRs: []int{0}}, publicKey, store)
+	require.NoError(t, err, "failed to get attested address")
+
+	return kmsKeyID, privateKey, address
+}
+
+func newLocalStorage(t *testing.T, root string) storage.Storage {
+	store, err := storage.NewLocal(root, nitro.PrivateFiles...)
+	require.NoError(t, err, "failed to create local storage")
+
+	return store
+}
+
+func TestFakeKMSBootstrap(t *testing.T) {
+	var (
+		caDirectory      = t.TempDir()
+		kmsDirectory     = t.TempDir()
+		attestationsPath = t.TempDir()
+	)
+
+	provider, err := nitro.NewSimulator("", map[int][]byte{0: bytes.Repeat([]byte{0x01}, 48)}, caDirectory)
+	require.NoError(t, err, "failed to create simulator")
+
+	kmsBackend, err := fakekms.New(kmsDirectory, "", provider.RootFingerprint())
+	require.NoError(t, err, "failed to create fake KMS")
+
+	kmsKeyID, privateKey, address := bootstrapSigner(t, kmsBackend, provider, attestationsPath)
+
+	// Restart reuses stored key
+	restartedKMSKeyID, restartedPrivateKey, restartedAddress := bootstrapSigner(t, kmsBackend, provider, attestationsPath)
+	require.Equal(t, kmsKeyID, restartedKMSKeyID, "KMS key must be reused")
+	require.True(t, privateKey.Equal(restartedPrivateKey), "private key must be reused")
+	require.Equal(t, address, restartedAddress, "address must be reused")
+
+	// Enclave with another image is refused by KMS even if it bypasses stored documents check
+	otherImage, err := nitro.NewSimulator("", map[int][]byte{0: bytes.Repeat([]byte{0x02}, 48)}, caDirectory)
+	require.NoError(t, err, "failed to create simulator")
+
+	privateKeyDocRaw, err := os.ReadFile(path.Join(attestationsPath, "private_key.coses1"))
+	require.NoError(t, err, "failed to read private key document")
+	privateKeyDoc, err := attestation.ParseNSMAttestationDoc(privateKeyDocRaw)
+	require.NoError(t, err, "failed to parse private key document")
+
+	otherClient, err := kmsBackend.NewClient(otherImage)
+	require.NoError(t, err, "failed to create fake KMS client")
+
+	_, err = otherClient.Decrypt(context.Background(), &kms.DecryptInput{
+		KeyId:          aws.String(kmsKeyID),
+		CiphertextBlob: privateKeyDoc.UserData,
+	})
+	require.ErrorIs(t, err, fakekms.ErrAccessDenied, "decrypt with mismatched PCR0 must be refused")
+	require.ErrorContains(t, err, nitro.PcrXCondition(0))
+
+	_, err = nitro.GetAttestedPrivateKey(kmsBackend, otherImage, nitro.KeyPolicy{PCRs: []int{0}}, kmsKeyID, newLocalStorage(t, attestationsPath))
+	require.Error(t, err, "bootstrap with mismatched PCR0 must fail")
+}
+
+func TestFakeKMSUntrustedRecipient(t *testing.T) {
+	provider, err := nitro.NewSimulator("", nil, "")
+	require.NoError(t, err, "failed to create simulator")
+
+	// Fake KMS trusts AWS root only, simulated documents are rejected
+	kmsBackend, err := fakekms.New(t.TempDir(), "", attestation.AWSNitroEnclavesRootCertFingerprint)
+	require.NoError(t, err, "failed to create fake KMS")
+
+	kmsKeyID, err := nitro.GetAttestedKMSKeyID(kmsBackend, provider, nitro.KeyPolicy{PCRs: []int{0}}, newLocalStorage(t, t.TempDir()))
+	require.NoError(t, err, "key creation doesn't require attestation")
+
+	_, err = nitro.GetAttestedPrivateKey(kmsBackend, provider, nitro.KeyPolicy{PCRs: []int{0}}, kmsKeyID, newLocalStorage(t, t.TempDir()))
+	require.ErrorIs(t, err, fakekms.ErrAccessDenied, "untrusted recipient must be refused")
+}
+
+func TestFakeKMSPolicyLockout(t *testing.T) {
+	provider, err := nitro.NewSimulator("", nil, "")
+	require.NoError(t, err, "failed to create simulator")
+
+	kmsBackend, err := fakekms.New(t.TempDir(), "", provider.RootFingerprint())
+	require.NoError(t, err, "failed to create fake KMS")
+
+	client, err := kmsBackend.NewClient(provider)
+	require.NoError(t, err, "failed to create fake KMS client")
+
+	// Without explicit admins nobody is allowed to update the policy
+	policy, err := nitro.KeyPolicy{PCRs: []int{0}}.Render(kmsBackend, provider)
+	require.NoError(t, err, "failed to render key policy")
+
+	_, err = client.CreateKey(context.Background(), &kms.CreateKeyInput{
+		Policy: aws.String(policy),
+	})
+	require.Error(t, err, "policy without kms:PutKeyPolicy must be refused without bypass")
+
+	_, err = client.CreateKey(context.Background(), &kms.CreateKeyInput{
+		BypassPolicyLockoutSafetyCheck: true,
+		Policy:                         aws.String(policy),
+	})
+	require.NoError(t, err, "policy must be accepted with bypass")
+
+	policy, err = nitro.KeyPolicy{PCRs: []int{0}, AdminArns: []string{"arn:aws:iam::000000000000:root"}}.Render(kmsBackend, provider)
+	require.NoError(t, err, "failed to render key policy")
+
+	_, err = client.CreateKey(context.Background(), &kms.CreateKeyInput{
+		Policy: aws.String(policy),
+	})
+	require.NoError(t, err, "explicit account root admin must pass the lockout check")
+}
+
+func TestFakeKMSKeyPolicyPCRs(t *testing.T) {
+	var (
+		caDirectory      = t.TempDir()
+		attestationsPath = t.TempDir()
+		pcr0             = bytes.Repeat([]byte{0x01}, 48)
+	)
+
+	keyPolicy, err := nitro.NewKeyPolicy([]int{8}, true)
+	require.NoError(t, err, "failed to create key policy")
+	require.Equal(t, []int{0, 8}, keyPolicy.PCRs, "PCR0 must always be included")
+
+	_, err = nitro.NewKeyPolicy([]int{5}, false)
+	require.Error(t, err, "PCR5 must be refused")
+
+	provider, err := nitro.NewSimulator("", map[int][]byte{0: pcr0, 8: bytes.Repeat([]byte{0x08}, 48)}, caDirectory)
+	require.NoError(t, err, "failed to create simulator")
+
+	kmsBackend, err := fakekms.New(t.TempDir(), "", provider.RootFingerprint())
+	require.NoError(t, err, "failed to create fake KMS")
+
+	kmsKeyID, err := nitro.GetAttestedKMSKeyID(kmsBackend, provider, keyPolicy, newLocalStorage(t, attestationsPath))
+	require.NoError(t, err, "failed to get attested KMS Key ID")
+	_, err = nitro.GetAttestedPrivateKey(kmsBackend, provider, keyPolicy, kmsKeyID, newLocalStorage(t, attestationsPath))
+	require.NoError(t, err, "failed to get attested private key")
+
+	// Same image signed with another certificate
+	otherSigner, err := nitro.NewSimulator("", map[int][]byte{0: pcr0, 8: bytes.Repeat([]byte{0x09}, 48)}, caDirectory)
+	require.NoError(t, err, "failed to create simulator")
+
+	_, err = nitro.GetAttestedPrivateKey(kmsBackend, otherSigner, keyPolicy, kmsKeyID, newLocalStorage(t, attestationsPath))
+	require.ErrorContains(t, err, "PCR8 mismatch", "stored documents check must compare PCR8")
+
+	privateKeyDocRaw, err := os.ReadFile(path.Join(attestationsPath, "private_key.coses1"))
+	require.NoError(t, err, "failed to read private key document")
+	privateKeyDoc, err := attestation.ParseNSMAttestationDoc(privateKeyDocRaw)
+	require.NoError(t, err, "failed to parse private key document")
+
+	otherClient, err := kmsBackend.NewClient(otherSigner)
+	require.NoError(t, err, "failed to create fake KMS client")
+
+	_, err = otherClient.Decrypt(context.Background(), &kms.DecryptInput{
+		KeyId:          aws.String(kmsKeyID),
+		CiphertextBlob: privateKeyDoc.UserData,
+	})
+	require.ErrorIs(t, err, fakekms.ErrAccessDenied, "decrypt with mismatched PCR8 must be refused")
+	require.ErrorContains(t, err, nitro.PcrXCondition(8))
+
+	conditions, err := keyPolicy.Conditions(provider)
+	require.NoError(t, err, "failed to get key policy conditions")
+	require.Equal(t, conditions[nitro.PcrXCondition(0)], conditions[nitro.ImageSha384Condition])
+	require.Len(t, conditions, 3)
+}
+
+func TestFakeKMSPolicyActionsWithoutRecipient(t *testing.T) {
+	provider, err := nitro.NewSimulator("", map[int][]byte{0: bytes.Repeat([]byte{0x01}, 48)}, t.TempDir())
+	require.NoError(t, err, "failed to create simulator")
+
+	kmsBackend, err := fakekms.New(t.TempDir(), "", provider.RootFingerprint())
+	require.NoError(t, err, "failed to create fake KMS")
+
+	client, err := kmsBackend.NewClient(provider)
+	require.NoError(t, err, "failed to create fake KMS client")
+
+	conditions, err := nitro.KeyPolicy{PCRs: []int{0}}.Conditions(provider)
+	require.NoError(t, err, "failed to get key policy conditions")
+	rawConditions, err := json.Marshal(conditions)
+	require.NoError(t, err, "failed to marshal conditions")
+
+	// Policy actions granted on the measurements, like the enclave
+	// statement of the built-in template used to
+	policy := `{
+  "Version": "2012-10-17",
+  "Statement": [{
+    "Effect": "Allow",
+    "Principal": {"AWS": "` + fakekms.DefaultPrincipalArn + `"},
+    "Action": ["kms:GenerateDataKeyPair", "kms:GetKeyPolicy", "kms:PutKeyPolicy"],
+    "Resource": "*",
+    "Condition": {"StringEqualsIgnoreCase": ` + string(rawConditions) + `}
+  }]
+}`
+	createKeyOutput, err := client.CreateKey(context.Background(), &kms.CreateKeyInput{
+		BypassPolicyLockoutSafetyCheck: true,
+		Policy:                         aws.String(policy),
+	})
+	require.NoError(t, err, "failed to create key")
+	keyID := createKeyOutput.KeyMetadata.KeyId
+
+	_, err = client.GenerateDataKeyPair(context.Background(), &kms.GenerateDataKeyPairInput{
+		KeyId:       keyID,
+		KeyPairSpec: kmstypes.DataKeyPairSpecEccSecgP256k1,
+	})
+	require.NoError(t, err, "request with recipient must match the measurements")
+
+	// KMS takes no recipient in policy requests, so the conditions never match
+	_, err = client.GetKeyPolicy(context.Background(), &kms.GetKeyPolicyInput{KeyId: keyID})
+	require.ErrorIs(t, err, fakekms.ErrAccessDenied, "kms:GetKeyPolicy must be refused")
+
+	_, err = client.PutKeyPolicy(context.Background(), &kms.PutKeyPolicyInput{
+		KeyId:                          keyID,
+		BypassPolicyLockoutSafetyCheck: true,
+		Policy:                         aws.String(policy),
+	})
+	require.ErrorIs(t, err, fakekms.ErrAccessDenied, "kms:PutKeyPolicy must be refused")
+}
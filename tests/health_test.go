@@ -0,0 +1,83 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/config"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/fakekms"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/health"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/service/handlers"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/resources"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadiness(t *testing.T) {
+	cfg, _ := newSimulatedService(t, nil)
+	signer := cfg.GetSigner()
+
+	state := health.New("signer", "self_test", "vsock_listener")
+	server := newTestServer(cfg, handlers.Readyz, handlers.CtxHealth(state))
+	defer server.Close()
+
+	readyz := func(status int) resources.HealthAttributes {
+		res, err := http.Get(server.URL)
+		require.NoError(t, err, "failed to send request")
+		defer res.Body.Close()
+		require.Equal(t, status, res.StatusCode)
+
+		var report resources.HealthResponse
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&report), "failed to decode response")
+		return report.Data.Attributes
+	}
+
+	report := readyz(http.StatusServiceUnavailable)
+	require.False(t, report.Ready)
+	require.Equal(t, string(health.StatusPending), report.Components["signer"].Status)
+
+	state.SetResult("signer", nil)
+	state.SetResult("self_test", signer.SelfTest())
+	state.Set("vsock_listener", health.StatusDisabled, nil)
+	require.True(t, readyz(http.StatusOK).Ready)
+
+	// Disabled KMS key fails the self-test
+	backend, ok := cfg.GetKMSBackend().(*fakekms.Backend)
+	require.True(t, ok, "fake KMS backend expected")
+	require.NoError(t, backend.SetKeyEnabled(signer.Current().KMSKeyID, false))
+
+	err := signer.SelfTest()
+	var disabled *kmstypes.DisabledException
+	require.True(t, errors.As(err, &disabled), "self-test must fail on disabled key, got %v", err)
+
+	state.SetResult("self_test", err)
+	report = readyz(http.StatusServiceUnavailable)
+	require.Equal(t, string(health.StatusFailed), report.Components["self_test"].Status)
+	require.Contains(t, report.Components["self_test"].Error, "disabled")
+
+	require.NoError(t, backend.SetKeyEnabled(signer.Current().KMSKeyID, true))
+	require.NoError(t, signer.SelfTest())
+}
+
+func TestRequireSigner(t *testing.T) {
+	cfg, _ := newSimulatedService(t, nil)
+
+	// Zero signer is never bootstrapped
+	server := newTestServer(cfg, handlers.RequireSigner(http.HandlerFunc(handlers.GetSigner)).ServeHTTP, handlers.CtxSigner(&config.Signer{}))
+	defer server.Close()
+
+	res, err := http.Get(server.URL)
+	require.NoError(t, err, "failed to send request")
+	defer res.Body.Close()
+	require.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+
+	var errs struct {
+		Errors []struct {
+			Code string `json:"code"`
+		} `json:"errors"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&errs), "failed to decode errors")
+	require.Equal(t, handlers.CodeSignerUnavailable, errs.Errors[0].Code)
+}
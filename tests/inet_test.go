@@ -51,7 +51,7 @@ func TestInetHttpAttestations(t *testing.T) {
 			attestationDocument, err := attestation.ParseNSMAttestationDoc(attestationDocumentRaw)
 			require.NoError(t, err, "failed to parse attestation document")
 
-			msg, err := utils.BuildTypedDataAttestationMessage(attestationDocument, primaryType, fields)
+			msg, err := utils.BuildTypedDataAttestationMessage(attestationDocument, primaryType, fields, "")
 			require.NoError(t, err, "failed to build typed data message")
 
 			err = domain.VerifyTypedData(msg, sig, address)
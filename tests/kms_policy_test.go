@@ -0,0 +1,209 @@
+package tests
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/fakekms"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/nitro"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	enclaveSessionArn = "arn:aws:sts::111111111111:assumed-role/enclave/i-0123456789abcdef0"
+	crossAccountAdmin = "arn:aws:iam::222222222222:role/security/key-admin"
+)
+
+func TestEnsureArnIsIam(t *testing.T) {
+	cases := []struct {
+		name     string
+		arn      string
+		rolePath string
+		expected string
+		invalid  bool
+	}{
+		{name: "iam role with path", arn: "arn:aws:iam::111111111111:role/service/enclave", expected: "arn:aws:iam::111111111111:role/service/enclave"},
+		{name: "assumed role", arn: enclaveSessionArn, expected: "arn:aws:iam::111111111111:role/enclave"},
+		{name: "assumed role with path", arn: enclaveSessionArn, rolePath: "/service/nitro/", expected: "arn:aws:iam::111111111111:role/service/nitro/enclave"},
+		{name: "invalid path", arn: enclaveSessionArn, rolePath: "service", invalid: true},
+		{name: "no session", arn: "arn:aws:sts::111111111111:assumed-role/enclave", invalid: true},
+		{name: "federated user", arn: "arn:aws:sts::111111111111:federated-user/alice", invalid: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			actual, err := nitro.EnsureArnIsIam(c.arn, c.rolePath)
+			if c.invalid {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expected, actual)
+		})
+	}
+}
+
+func TestKeyPolicyRender(t *testing.T) {
+	provider, err := nitro.NewSimulator("", nil, t.TempDir())
+	require.NoError(t, err, "failed to create simulator")
+
+	kmsBackend, err := fakekms.New(t.TempDir(), enclaveSessionArn, provider.RootFingerprint())
+	require.NoError(t, err, "failed to create fake KMS")
+
+	keyPolicy, err := nitro.NewKeyPolicy([]int{8}, false)
+	require.NoError(t, err, "failed to create key policy")
+	keyPolicy.AdminArns = []string{crossAccountAdmin}
+	keyPolicy.RolePath = "/service/"
+	keyPolicy.Alias = "alias/attestation-verifier"
+	keyPolicy.Tags = map[string]string{"project": "av"}
+	require.NoError(t, keyPolicy.Validate())
+
+	policy, err := keyPolicy.Render(kmsBackend, provider)
+	require.NoError(t, err, "failed to render key policy")
+
+	var doc struct {
+		Statement []struct {
+			Sid       string
+			Principal struct{ AWS any }
+		}
+	}
+	require.NoError(t, json.Unmarshal([]byte(policy), &doc), "rendered policy must be JSON")
+	require.Len(t, doc.Statement, 5)
+	require.Equal(t, []any{crossAccountAdmin}, doc.Statement[0].Principal.AWS)
+	require.Equal(t, []any{crossAccountAdmin}, doc.Statement[1].Principal.AWS, "explicit admin must update the policy")
+	require.Equal(t, "arn:aws:iam::111111111111:role/service/enclave", doc.Statement[2].Principal.AWS)
+	require.Equal(t, "Allow enclave to read the key policy", doc.Statement[3].Sid)
+
+	// No admin of the enclave account, so the enclave role creating the key
+	// isn't allowed to update the policy
+	_, err = nitro.GetAttestedKMSKeyID(kmsBackend, provider, keyPolicy, newLocalStorage(t, t.TempDir()))
+	require.ErrorContains(t, err, "MalformedPolicyDocumentException")
+
+	// Session of the role with path is allowed to bootstrap
+	keyPolicy.BypassLockoutCheck = true
+	store := newLocalStorage(t, t.TempDir())
+	kmsKeyID, err := nitro.GetAttestedKMSKeyID(kmsBackend, provider, keyPolicy, store)
+	require.NoError(t, err, "failed to get attested KMS Key ID")
+	_, err = nitro.GetAttestedPrivateKey(kmsBackend, provider, keyPolicy, kmsKeyID, store)
+	require.NoError(t, err, "failed to get attested private key")
+
+	// Alias is already taken by the first key
+	_, err = nitro.GetAttestedKMSKeyID(kmsBackend, provider, keyPolicy, newLocalStorage(t, t.TempDir()))
+	require.ErrorContains(t, err, "already exists")
+}
+
+func TestKeyPolicyTemplateValidation(t *testing.T) {
+	provider, err := nitro.NewSimulator("", nil, t.TempDir())
+	require.NoError(t, err, "failed to create simulator")
+
+	kmsBackend, err := fakekms.New(t.TempDir(), enclaveSessionArn, provider.RootFingerprint())
+	require.NoError(t, err, "failed to create fake KMS")
+
+	cases := []struct {
+		name      string
+		adminArns []string
+		template  string
+		err       string
+	}{
+		{
+			name: "tags placeholder",
+			template: `{"Version": "2012-10-17", "Statement": [
+				{"Effect": "Allow", "Principal": {"AWS": {{ json .AdminArns }}}, "Action": "kms:DescribeKey", "Resource": "*",
+				 "Condition": {"StringEquals": {"aws:ResourceTag/project": {{ json .Tags.project }}}}},
+				{"Effect": "Allow", "Principal": {"AWS": {{ json .PrincipalArn }}}, "Action": "kms:Decrypt", "Resource": "*",
+				 "Condition": {"StringEqualsIgnoreCase": {{ json .Conditions }}}}
+			]}`,
+		},
+		{
+			name: "unconditional decrypt",
+			template: `{"Version": "2012-10-17", "Statement": [
+				{"Effect": "Allow", "Principal": {"AWS": {{ json .AdminArns }}}, "Action": "kms:*", "Resource": "*"}
+			]}`,
+			err: "without kms:RecipientAttestation:PCR0 condition",
+		},
+		{
+			name:      "admin updates policy",
+			adminArns: []string{crossAccountAdmin},
+			template: `{"Version": "2012-10-17", "Statement": [
+				{"Effect": "Allow", "Principal": {"AWS": {{ json .AdminArns }}}, "Action": ["kms:DescribeKey", "kms:PutKeyPolicy"], "Resource": "*"},
+				{"Effect": "Allow", "Principal": {"AWS": {{ json .PrincipalArn }}}, "Action": "kms:Decrypt", "Resource": "*",
+				 "Condition": {"StringEqualsIgnoreCase": {{ json .Conditions }}}}
+			]}`,
+		},
+		{
+			name: "default root admin updates policy",
+			template: `{"Version": "2012-10-17", "Statement": [
+				{"Effect": "Allow", "Principal": {"AWS": {{ json .AdminArns }}}, "Action": ["kms:DescribeKey", "kms:PutKeyPolicy"], "Resource": "*"},
+				{"Effect": "Allow", "Principal": {"AWS": {{ json .PrincipalArn }}}, "Action": "kms:Decrypt", "Resource": "*",
+				 "Condition": {"StringEqualsIgnoreCase": {{ json .Conditions }}}}
+			]}`,
+			err: "grants kms:PutKeyPolicy without kms:RecipientAttestation:PCR0 condition",
+		},
+		{
+			name: "unconditional policy update",
+			template: `{"Version": "2012-10-17", "Statement": [
+				{"Effect": "Allow", "Principal": {"AWS": {{ json .AdminArns }}}, "Action": "kms:DescribeKey", "Resource": "*"},
+				{"Effect": "Allow", "Principal": {"AWS": {{ json .PrincipalArn }}}, "Action": "kms:Put*", "Resource": "*"}
+			]}`,
+			err: "grants kms:Put* without kms:RecipientAttestation:PCR0 condition",
+		},
+		{
+			name: "wildcard principal",
+			template: `{"Version": "2012-10-17", "Statement": [
+				{"Effect": "Allow", "Principal": {"AWS": {{ json .AdminArns }}}, "Action": "kms:DescribeKey", "Resource": "*"},
+				{"Effect": "Allow", "Principal": "*", "Action": "kms:Decrypt", "Resource": "*",
+				 "Condition": {"StringEqualsIgnoreCase": {{ json .Conditions }}}}
+			]}`,
+			err: "grants kms:Decrypt to *, not the enclave principal",
+		},
+		{
+			name: "admin decrypts on measurements",
+			template: `{"Version": "2012-10-17", "Statement": [
+				{"Effect": "Allow", "Principal": {"AWS": {{ json .AdminArns }}}, "Action": "kms:DescribeKey", "Resource": "*"},
+				{"Effect": "Allow", "Principal": {"AWS": [{{ json .PrincipalArn }}, {{ json (index .AdminArns 0) }}]}, "Action": "kms:Decrypt", "Resource": "*",
+				 "Condition": {"StringEqualsIgnoreCase": {{ json .Conditions }}}}
+			]}`,
+			err: "not the enclave principal",
+		},
+		{
+			name: "partial conditions",
+			template: `{"Version": "2012-10-17", "Statement": [
+				{"Effect": "Allow", "Principal": {"AWS": {{ json .AdminArns }}}, "Action": "kms:DescribeKey", "Resource": "*"},
+				{"Effect": "Allow", "Principal": {"AWS": {{ json .PrincipalArn }}}, "Action": "kms:ReEncrypt*", "Resource": "*",
+				 "Condition": {"StringEqualsIgnoreCase": {"kms:RecipientAttestation:PCR0": {{ json (index .Conditions "kms:RecipientAttestation:PCR0") }}}}}
+			]}`,
+			err: "without kms:RecipientAttestation:PCR8 condition",
+		},
+		{
+			name: "admin locked out",
+			template: `{"Version": "2012-10-17", "Statement": [
+				{"Effect": "Allow", "Principal": {"AWS": {{ json .PrincipalArn }}}, "Action": "kms:DescribeKey", "Resource": "*"}
+			]}`,
+			err: "is not granted by any statement",
+		},
+		{
+			name:     "not JSON",
+			template: `{"Version": "2012-10-17", "Statement": [{{ .PrincipalArn }}]}`,
+			err:      "failed to unmarshal key policy",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			keyPolicy, err := nitro.NewKeyPolicy([]int{8}, false)
+			require.NoError(t, err, "failed to create key policy")
+			keyPolicy.Tags = map[string]string{"project": "av"}
+			keyPolicy.AdminArns = c.adminArns
+
+			keyPolicy.Template, err = nitro.ParseKeyPolicyTemplate(c.template)
+			require.NoError(t, err, "failed to parse template")
+
+			_, err = keyPolicy.Render(kmsBackend, provider)
+			if c.err == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, c.err)
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenerServer(t *testing.T) {
+	cfg := newTestConfig(map[string]map[string]interface{}{
+		"inet_listener": {
+			"addr":            "127.0.0.1:0",
+			"max_body_bytes":  16,
+			"max_connections": 1,
+			"idle_timeout":    "1s",
+		},
+	})
+
+	listener := cfg.GetInetListener()
+	server := listener.Server()
+	require.EqualValues(t, 16, server.MaxBodyBytes)
+	require.Equal(t, 1, server.MaxConnections)
+	require.Equal(t, time.Second, server.IdleTimeout)
+	require.Equal(t, config.DefaultServerReadHeaderTimeout, server.ReadHeaderTimeout)
+	require.Equal(t, config.DefaultServerMaxHeaderBytes, server.MaxHeaderBytes)
+
+	httpServer := server.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	go func() { _ = httpServer.Serve(server.Limit(listener)) }()
+	defer httpServer.Close()
+
+	url := "http://" + listener.Addr().String()
+	client := &http.Client{Timeout: 500 * time.Millisecond}
+	post := func(body []byte) (int, error) {
+		res, err := client.Post(url, "application/octet-stream", bytes.NewReader(body))
+		if err != nil {
+			return 0, err
+		}
+		defer res.Body.Close()
+		return res.StatusCode, nil
+	}
+
+	status, err := post(make([]byte, 16))
+	require.NoError(t, err, "failed to send request")
+	require.Equal(t, http.StatusOK, status)
+	client.CloseIdleConnections()
+
+	status, err = post(make([]byte, 17))
+	require.NoError(t, err, "failed to send request")
+	require.Equal(t, http.StatusRequestEntityTooLarge, status)
+	client.CloseIdleConnections()
+
+	// The only connection slot is taken
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err, "failed to connect")
+	_, err = post(nil)
+	require.Error(t, err, "connection above the limit must wait")
+
+	require.NoError(t, conn.Close())
+	require.Eventually(t, func() bool {
+		status, err := post(nil)
+		return err == nil && status == http.StatusOK
+	}, 5*time.Second, 100*time.Millisecond)
+}
@@ -0,0 +1,58 @@
+package tests
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/icrypto"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/metrics"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/service/handlers"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	// Metrics are global, so the test checks increments only
+	decrypts := testutil.ToFloat64(metrics.KMSCalls.WithLabelValues("Decrypt", "ok"))
+	cfg, _ := newSimulatedService(t, nil)
+	require.Greater(t, testutil.ToFloat64(metrics.KMSCalls.WithLabelValues("Decrypt", "ok")), decrypts, "bootstrap must decrypt the key")
+
+	server := newTestServer(cfg, handlers.VerifyAttestation)
+	defer server.Close()
+
+	rawDoc, err := cfg.GetAttestationProvider().GetAttestationDoc(nil, nil, []byte{0x04, 0x01})
+	require.NoError(t, err, "failed to get attestation document")
+
+	for _, tc := range []struct {
+		result string
+		doc    []byte
+		fields []string
+		status int
+	}{
+		{result: metrics.ResultSigned, doc: rawDoc, fields: []string{"pcr0", "public_key"}, status: http.StatusOK},
+		{result: metrics.ResultParseError, doc: []byte{0x01}, fields: []string{"pcr0"}, status: http.StatusBadRequest},
+		{result: metrics.ResultAbsentField, doc: rawDoc, fields: []string{"nonce"}, status: http.StatusBadRequest},
+	} {
+		counter := metrics.SignRequests.WithLabelValues("single", tc.result)
+		before := testutil.ToFloat64(counter)
+		signEncoded(t, server.URL, tc.doc, tc.fields, nil, icrypto.SchemeEIP712, tc.status)
+		require.Equal(t, before+1, testutil.ToFloat64(counter), tc.result)
+	}
+
+	res := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, res.Code)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err, "failed to read metrics")
+	for _, name := range []string{
+		`nitro_av_sign_requests_total{endpoint="single",result="signed"}`,
+		`nitro_av_sign_stage_duration_seconds_count{stage="sign"}`,
+		`nitro_av_bootstrap_step_duration_seconds_count{step="private_key"}`,
+		`nitro_av_kms_call_duration_seconds_count{operation="Decrypt"}`,
+		"go_goroutines",
+	} {
+		require.Contains(t, string(body), name)
+	}
+}
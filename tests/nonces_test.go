@@ -0,0 +1,215 @@
+package tests
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/nonces"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/service/handlers"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/sdk"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"github.com/google/jsonapi"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNoncesSingleUse(t *testing.T) {
+	store, err := nonces.NewStore(nonces.Options{})
+	require.NoError(t, err, "failed to create nonce store")
+
+	nonce, expiresAt, err := store.Issue("")
+	require.NoError(t, err, "failed to issue nonce")
+	require.Len(t, nonce, nonces.DefaultSize)
+	require.WithinDuration(t, time.Now().Add(nonces.DefaultTTL), expiresAt, time.Second)
+
+	require.NoError(t, store.Consume(nonce), "failed to consume issued nonce")
+	require.ErrorIs(t, store.Consume(nonce), nonces.ErrUsed)
+	require.ErrorIs(t, store.Consume(nil), nonces.ErrMissing)
+	require.ErrorIs(t, store.Consume([]byte("never issued")), nonces.ErrUnknown)
+}
+
+func TestNoncesExpiration(t *testing.T) {
+	store, err := nonces.NewStore(nonces.Options{TTL: 50 * time.Millisecond})
+	require.NoError(t, err, "failed to create nonce store")
+
+	nonce, _, err := store.Issue("")
+	require.NoError(t, err, "failed to issue nonce")
+
+	time.Sleep(100 * time.Millisecond)
+	require.ErrorIs(t, store.Consume(nonce), nonces.ErrExpired)
+}
+
+func TestNoncesMaxActive(t *testing.T) {
+	store, err := nonces.NewStore(nonces.Options{MaxActive: 2})
+	require.NoError(t, err, "failed to create nonce store")
+
+	for i := 0; i < 2; i++ {
+		_, _, err = store.Issue("")
+		require.NoError(t, err, "failed to issue nonce")
+	}
+
+	_, _, err = store.Issue("")
+	require.ErrorIs(t, err, nonces.ErrTooMany)
+
+	// Consumed nonces aren't active
+	store, err = nonces.NewStore(nonces.Options{MaxActive: 1})
+	require.NoError(t, err, "failed to create nonce store")
+	for i := 0; i < 3; i++ {
+		nonce, _, err := store.Issue("")
+		require.NoError(t, err, "failed to issue nonce")
+		require.NoError(t, store.Consume(nonce), "failed to consume issued nonce")
+	}
+
+	_, err = nonces.NewStore(nonces.Options{Size: nonces.MaxSize + 1})
+	require.ErrorIs(t, err, nonces.ErrBadSize)
+}
+
+func TestNoncesMaxActivePerClient(t *testing.T) {
+	persistencePath := path.Join(t.TempDir(), "nonces.jsonl")
+	opts := nonces.Options{MaxActive: 3, MaxActivePerClient: 2, PersistencePath: persistencePath}
+
+	store, err := nonces.NewStore(opts)
+	require.NoError(t, err, "failed to create nonce store")
+
+	first, _, err := store.Issue("10.0.0.1")
+	require.NoError(t, err, "failed to issue nonce")
+	_, _, err = store.Issue("10.0.0.1")
+	require.NoError(t, err, "failed to issue nonce")
+	_, _, err = store.Issue("10.0.0.1")
+	require.ErrorIs(t, err, nonces.ErrClientTooMany)
+	require.ErrorIs(t, err, nonces.ErrTooMany)
+
+	// Other clients keep their share
+	_, _, err = store.Issue("10.0.0.2")
+	require.NoError(t, err, "failed to issue nonce to other client")
+
+	// Client counts survive restarts
+	restarted, err := nonces.NewStore(opts)
+	require.NoError(t, err, "failed to restore nonce store")
+	_, _, err = restarted.Issue("10.0.0.1")
+	require.ErrorIs(t, err, nonces.ErrClientTooMany)
+
+	// Consumed nonces aren't active
+	require.NoError(t, restarted.Consume(first), "failed to consume restored nonce")
+	_, _, err = restarted.Issue("10.0.0.1")
+	require.NoError(t, err, "failed to issue nonce after consumption")
+
+	// Global limit still applies
+	_, _, err = restarted.Issue("")
+	require.ErrorIs(t, err, nonces.ErrTooMany)
+	require.NotErrorIs(t, err, nonces.ErrClientTooMany)
+}
+
+func TestNoncesPersistence(t *testing.T) {
+	persistencePath := path.Join(t.TempDir(), "nonces.json")
+
+	store, err := nonces.NewStore(nonces.Options{PersistencePath: persistencePath})
+	require.NoError(t, err, "failed to create nonce store")
+
+	used, _, err := store.Issue("")
+	require.NoError(t, err, "failed to issue nonce")
+	fresh, _, err := store.Issue("")
+	require.NoError(t, err, "failed to issue nonce")
+	require.NoError(t, store.Consume(used), "failed to consume issued nonce")
+
+	restarted, err := nonces.NewStore(nonces.Options{PersistencePath: persistencePath})
+	require.NoError(t, err, "failed to restore nonce store")
+
+	require.ErrorIs(t, restarted.Consume(used), nonces.ErrUsed)
+	require.NoError(t, restarted.Consume(fresh), "failed to consume restored nonce")
+
+	// Every change is appended as a single record
+	raw, err := os.ReadFile(persistencePath)
+	require.NoError(t, err, "failed to read nonces log")
+	before := bytes.Count(raw, []byte("\n"))
+	_, _, err = restarted.Issue("")
+	require.NoError(t, err, "failed to issue nonce")
+	raw, err = os.ReadFile(persistencePath)
+	require.NoError(t, err, "failed to read nonces log")
+	require.Equal(t, before+1, bytes.Count(raw, []byte("\n")))
+
+	// Partial record of a crash is skipped
+	file, err := os.OpenFile(persistencePath, os.O_WRONLY|os.O_APPEND, 0600)
+	require.NoError(t, err, "failed to open nonces log")
+	_, err = file.WriteString(`{"nonce":"ab`)
+	require.NoError(t, err, "failed to write partial record")
+	require.NoError(t, file.Close())
+
+	restarted, err = nonces.NewStore(nonces.Options{PersistencePath: persistencePath})
+	require.NoError(t, err, "failed to restore nonce store with partial record")
+	require.ErrorIs(t, restarted.Consume(fresh), nonces.ErrUsed)
+}
+
+func TestNoncesPersistencePruning(t *testing.T) {
+	persistencePath := path.Join(t.TempDir(), "nonces.jsonl")
+	opts := nonces.Options{TTL: 50 * time.Millisecond, MaxActive: 1, PersistencePath: persistencePath}
+
+	store, err := nonces.NewStore(opts)
+	require.NoError(t, err, "failed to create nonce store")
+
+	nonce, _, err := store.Issue("")
+	require.NoError(t, err, "failed to issue nonce")
+	require.NoError(t, store.Consume(nonce), "failed to consume issued nonce")
+	_, _, err = store.Issue("")
+	require.NoError(t, err, "consumed nonce must not count as active")
+
+	time.Sleep(100 * time.Millisecond)
+
+	restarted, err := nonces.NewStore(opts)
+	require.NoError(t, err, "failed to restore nonce store")
+	require.ErrorIs(t, restarted.Consume(nonce), nonces.ErrUnknown, "expired nonce must be pruned")
+
+	raw, err := os.ReadFile(persistencePath)
+	require.NoError(t, err, "failed to read nonces log")
+	require.Empty(t, raw, "log of expired nonces must be compacted")
+}
+
+func TestIssueNonceHandler(t *testing.T) {
+	cfg, _ := newSimulatedService(t, nil)
+
+	server := newTestServer(cfg, handlers.IssueNonce)
+	defer server.Close()
+
+	res, err := http.Post(server.URL, "", nil)
+	require.NoError(t, err, "failed to send request")
+	defer res.Body.Close()
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	require.Equal(t, jsonapi.MediaType, res.Header.Get("Content-Type"))
+
+	client, err := sdk.NewInetClient(server.URL, apitypes.TypedDataDomain{Name: "Test", Version: "v1"}, nil)
+	require.NoError(t, err, "failed to create inet client")
+
+	nonce, expiresAt, err := client.GetNonce()
+	require.NoError(t, err, "failed to get nonce")
+	require.True(t, expiresAt.After(time.Now()), "nonce must not be expired")
+	require.NoError(t, cfg.GetNonces().Consume(nonce), "failed to consume issued nonce")
+}
+
+func TestIssueNonceHandlerClientLimit(t *testing.T) {
+	cfg, _ := newSimulatedService(t, map[string]map[string]interface{}{
+		"nonces": {"max_active_per_client": 1, "client_header": "X-Forwarded-For"},
+	})
+
+	server := newTestServer(cfg, handlers.IssueNonce)
+	defer server.Close()
+
+	issue := func(forwardedFor string) int {
+		req, err := http.NewRequest(http.MethodPost, server.URL, nil)
+		require.NoError(t, err, "failed to create request")
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err, "failed to send request")
+		defer res.Body.Close()
+		return res.StatusCode
+	}
+
+	require.Equal(t, http.StatusCreated, issue("10.0.0.1"))
+	require.Equal(t, http.StatusTooManyRequests, issue("10.0.0.1"))
+	// Client can't spoof the address appended by the proxy
+	require.Equal(t, http.StatusTooManyRequests, issue("10.0.0.2, 10.0.0.1"))
+	require.Equal(t, http.StatusCreated, issue("10.0.0.1, 10.0.0.2"))
+}
@@ -0,0 +1,123 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/config"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/nitro"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/utils"
+	"github.com/distributed-lab/enclave-extras/attestation"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoliciesMatch(t *testing.T) {
+	var (
+		oldImage = bytes.Repeat([]byte{1}, 48)
+		newImage = bytes.Repeat([]byte{2}, 48)
+		kernel   = bytes.Repeat([]byte{3}, 48)
+		signer   = bytes.Repeat([]byte{8}, 48)
+	)
+
+	cfg := newTestConfig(map[string]map[string]interface{}{
+		"policies": {
+			"default": "production",
+			"profiles": map[string]interface{}{
+				"production": []interface{}{
+					map[string]interface{}{"pcr0": hex.EncodeToString(oldImage), "pcr1": hex.EncodeToString(kernel), "pcr8": hex.EncodeToString(signer)},
+					map[string]interface{}{"pcr0": hex.EncodeToString(newImage), "pcr1": hex.EncodeToString(kernel), "pcr8": hex.EncodeToString(signer)},
+				},
+				"staging": []interface{}{
+					map[string]interface{}{"pcr8": hex.EncodeToString(signer)},
+				},
+			},
+		},
+	})
+	policies := cfg.GetPolicies()
+
+	tests := []struct {
+		name        string
+		profile     string
+		pcrs        map[int][]byte
+		wantProfile string
+		wantPCRs    []int
+		wantErr     error
+	}{
+		{
+			name:        "default profile, old image",
+			pcrs:        map[int][]byte{0: oldImage, 1: kernel, 8: signer},
+			wantProfile: "production",
+		},
+		{
+			name:        "default profile, new image",
+			pcrs:        map[int][]byte{0: newImage, 1: kernel, 8: signer},
+			wantProfile: "production",
+		},
+		{
+			name:     "default profile, unknown image and signer",
+			pcrs:     map[int][]byte{0: kernel, 1: kernel, 8: oldImage},
+			wantPCRs: []int{0, 8},
+			wantErr:  config.ErrPolicyMatch,
+		},
+		{
+			name:        "selected profile",
+			profile:     "staging",
+			pcrs:        map[int][]byte{0: kernel, 8: signer},
+			wantProfile: "staging",
+		},
+		{
+			name:    "unknown profile",
+			profile: "testing",
+			pcrs:    map[int][]byte{0: oldImage, 1: kernel, 8: signer},
+			wantErr: config.ErrUnknownPolicy,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile, err := policies.Match(tt.profile, tt.pcrs)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				if tt.wantPCRs != nil {
+					var mismatch *config.PolicyMismatchError
+					require.ErrorAs(t, err, &mismatch)
+					require.Equal(t, tt.wantPCRs, mismatch.PCRs)
+				}
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.wantProfile, profile)
+		})
+	}
+}
+
+func TestPoliciesAbsent(t *testing.T) {
+	policies := newTestConfig(nil).GetPolicies()
+
+	profile, err := policies.Match("", map[int][]byte{0: make([]byte, 48)})
+	require.NoError(t, err, "documents must be accepted without policies")
+	require.Empty(t, profile)
+
+	_, err = policies.Match("production", nil)
+	require.ErrorIs(t, err, config.ErrUnknownPolicy)
+}
+
+func TestPolicyTypedDataField(t *testing.T) {
+	simulator, err := nitro.NewSimulator("", nil, t.TempDir())
+	require.NoError(t, err, "failed to create simulator")
+
+	docRaw, err := simulator.GetAttestationDoc(nil, nil, nil)
+	require.NoError(t, err, "failed to get attestation document")
+
+	doc, err := attestation.ParseNSMAttestationDoc(docRaw)
+	require.NoError(t, err, "failed to parse attestation document")
+
+	msg, err := utils.BuildTypedDataAttestationMessage(doc, utils.DefaultPrimaryType, []string{"pcr0", "policy"}, "production")
+	require.NoError(t, err, "failed to build typed data message")
+	require.Equal(t, "production", msg.TypedDataMessage["policy"])
+
+	_, err = utils.BuildTypedDataAttestationMessage(doc, utils.DefaultPrimaryType, []string{"pcr0", "policy"}, "")
+	require.ErrorIs(t, err, utils.ErrAbsentField)
+}
@@ -0,0 +1,148 @@
+package tests
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/config"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/fakekms"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/icrypto"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/nitro"
+	"github.com/stretchr/testify/require"
+)
+
+func newRotationConfig(caDirectory, kmsDirectory, attestationsPath string, overlap string) config.Config {
+	return newTestConfig(map[string]map[string]interface{}{
+		"signer": {
+			"attestations_directory": attestationsPath,
+			"nsm":                    config.NSMSimulator,
+			"kms":                    config.KMSFake,
+			"overlap":                overlap,
+			"reload_interval":        "1ms",
+		},
+		"nsm_simulator": {"ca_directory": caDirectory},
+		"fake_kms":      {"directory": kmsDirectory},
+	})
+}
+
+func TestSignerRotation(t *testing.T) {
+	var (
+		caDirectory      = t.TempDir()
+		kmsDirectory     = t.TempDir()
+		attestationsPath = t.TempDir()
+	)
+
+	signer := newRotationConfig(caDirectory, kmsDirectory, attestationsPath, "1h").GetSigner()
+
+	first := signer.Current()
+	require.Equal(t, "1", first.ID)
+	require.Empty(t, signer.Previous())
+
+	second, err := signer.Rotate()
+	require.NoError(t, err, "failed to rotate key")
+	require.Equal(t, "2", second.ID)
+	require.Equal(t, second.ID, signer.Current().ID)
+	require.NotEqual(t, first.Address, second.Address, "rotated key must differ")
+	require.Equal(t, first.KMSKeyID, second.KMSKeyID, "KMS key must be reused")
+
+	previous := signer.Previous()
+	require.Len(t, previous, 1)
+	require.Equal(t, first.ID, previous[0].ID)
+	require.Equal(t, first.Address, previous[0].Address)
+	require.WithinDuration(t, second.CreatedAt.Add(time.Hour), previous[0].ExpiresAt, time.Second)
+
+	_, err = previous[0].Sign(make([]byte, 32))
+	require.Error(t, err, "superseded generation must not sign")
+
+	digest := bytes.Repeat([]byte{1}, 32)
+	sig, err := signer.Sign(digest)
+	require.NoError(t, err, "failed to sign with current generation")
+	require.NoError(t, icrypto.VerifySignature(digest, sig, second.Address), "current generation must sign")
+
+	// Generation created by another process, e.g. rotate-key command, is picked up on reload
+	time.Sleep(10 * time.Millisecond)
+	restarted := newRotationConfig(caDirectory, kmsDirectory, attestationsPath, "1ms").GetSigner()
+	require.Equal(t, second.ID, restarted.Current().ID)
+	require.Equal(t, second.Address, restarted.Current().Address)
+	require.Empty(t, restarted.Previous(), "generations out of overlap must be unloaded")
+
+	third, err := restarted.Rotate()
+	require.NoError(t, err, "failed to rotate key")
+	require.NoError(t, signer.Reload(), "failed to reload generations")
+	require.Equal(t, third.ID, signer.Current().ID)
+	require.Len(t, signer.Previous(), 2)
+	require.Equal(t, second.ID, signer.Previous()[0].ID, "previous generations must be newest first")
+}
+
+func TestSignerLegacyGeneration(t *testing.T) {
+	var (
+		caDirectory      = t.TempDir()
+		kmsDirectory     = t.TempDir()
+		attestationsPath = t.TempDir()
+	)
+
+	provider, err := nitro.NewSimulator("", nil, caDirectory)
+	require.NoError(t, err, "failed to create simulator")
+	kmsBackend, err := fakekms.New(kmsDirectory, "", provider.RootFingerprint())
+	require.NoError(t, err, "failed to create fake KMS")
+
+	// Key bootstrapped directly in the attestations directory before generations
+	_, _, legacyAddress := bootstrapSigner(t, kmsBackend, provider, attestationsPath)
+
+	signer := newRotationConfig(caDirectory, kmsDirectory, attestationsPath, "1h").GetSigner()
+	require.Equal(t, nitro.LegacyGenerationID, signer.Current().ID)
+	require.Equal(t, legacyAddress, signer.Current().Address)
+
+	generation, err := signer.Rotate()
+	require.NoError(t, err, "failed to rotate legacy key")
+	require.Equal(t, "1", generation.ID)
+	require.Equal(t, legacyAddress, signer.Previous()[0].Address)
+
+	_, err = os.Stat(path.Join(attestationsPath, "address.coses1"))
+	require.NoError(t, err, "legacy documents must be kept")
+}
+
+func TestSignerRotateFirstGeneration(t *testing.T) {
+	// rotate-key on empty storage creates the first generation only
+	generation, err := newRotationConfig(t.TempDir(), t.TempDir(), t.TempDir(), "1h").GetPendingSigner().Rotate()
+	require.NoError(t, err, "failed to rotate key")
+	require.Equal(t, "1", generation.ID)
+}
+
+func TestSignerIntervalsValidation(t *testing.T) {
+	cases := []struct {
+		name   string
+		signer map[string]interface{}
+		err    string
+	}{
+		{name: "non-positive rotation interval", signer: map[string]interface{}{"rotation_interval": "0s"}, err: "rotation_interval must be positive"},
+		{name: "overlap shorter than reload", signer: map[string]interface{}{"overlap": "30s", "reload_interval": "1m"}, err: "must not be shorter than reload_interval"},
+		{name: "overlap shorter than default reload", signer: map[string]interface{}{"overlap": "0s"}, err: "must not be shorter than reload_interval"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := newTestConfig(map[string]map[string]interface{}{"signer": c.signer})
+			defer func() {
+				err, ok := recover().(error)
+				require.True(t, ok, "invalid config must panic with error")
+				require.ErrorContains(t, err, c.err)
+			}()
+			cfg.GetPendingSigner()
+		})
+	}
+}
+
+func TestSignerNotBootstrapped(t *testing.T) {
+	signer := newRotationConfig(t.TempDir(), t.TempDir(), t.TempDir(), "1h").GetPendingSigner()
+
+	_, err := signer.Sign(make([]byte, 32))
+	require.ErrorIs(t, err, config.ErrSignerNotBootstrapped)
+
+	require.NoError(t, signer.Bootstrap(), "failed to bootstrap signer")
+	_, err = signer.Sign(make([]byte, 32))
+	require.NoError(t, err, "bootstrapped signer must sign")
+}
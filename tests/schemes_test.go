@@ -0,0 +1,158 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/icrypto"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/nitro"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/utils"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/service/handlers"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/resources"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/sdk"
+	"github.com/distributed-lab/enclave-extras/attestation"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignatureSchemes(t *testing.T) {
+	cfg, address := newSimulatedService(t, nil)
+
+	server := newTestServer(cfg, handlers.VerifyAttestation)
+	defer server.Close()
+
+	rawDoc, err := cfg.GetAttestationProvider().GetAttestationDoc([]byte("user data"), nil, nil)
+	require.NoError(t, err, "failed to get attestation document")
+	doc, err := attestation.ParseNSMAttestationDoc(rawDoc)
+	require.NoError(t, err, "failed to parse attestation document")
+
+	fields := []string{"pcr0", "user_data", "timestamp"}
+	packed := append(append([]byte{}, doc.PCRs[0]...), doc.UserData...)
+	packed = binary.BigEndian.AppendUint64(packed, uint64(doc.Timestamp.Unix()))
+
+	client, err := sdk.NewInetClient(server.URL, domain.TypedDataDomain, nil)
+	require.NoError(t, err, "failed to create client")
+
+	layout := "Register(bytes pcr0,bytes user_data,uint64 timestamp)"
+	for scheme, digest := range map[icrypto.Scheme][]byte{
+		icrypto.SchemeEIP191: accounts.TextHash(crypto.Keccak256(packedWithLayout(icrypto.SchemeEIP191, layout, packed))),
+		icrypto.SchemeRaw:    crypto.Keccak256(packedWithLayout(icrypto.SchemeRaw, layout, packed)),
+	} {
+		t.Run(string(scheme), func(t *testing.T) {
+			packed := packedWithLayout(scheme, layout, packed)
+			attrs := signWithScheme(t, server.URL, rawDoc, fields, scheme, http.StatusOK)
+			require.Equal(t, string(scheme), attrs.Scheme)
+			require.Equal(t, hexutil.Encode(packed), attrs.Message)
+			require.Equal(t, hexutil.Encode(digest), attrs.Digest)
+			require.Empty(t, attrs.TypedData, "typed data is eip712 only")
+
+			sig, err := base64.StdEncoding.DecodeString(attrs.Signature)
+			require.NoError(t, err, "failed to decode signature")
+			require.NoError(t, icrypto.VerifySignature(digest, sig, address))
+
+			schemeClient := client.WithScheme(scheme)
+			sig, err = schemeClient.SignAttestationDocument(rawDoc, fields)
+			require.NoError(t, err, "failed to sign with SDK")
+			require.NoError(t, schemeClient.VerifySignature(rawDoc, fields, "", sig, address))
+			require.Error(t, client.VerifySignature(rawDoc, fields, "", sig, address), "signature must be bound to the scheme")
+		})
+	}
+
+	// Two dynamic fields could be split differently with the same encoding
+	signWithScheme(t, server.URL, rawDoc, []string{"user_data", "module_id"}, icrypto.SchemeRaw, http.StatusBadRequest)
+	// Fields the enclave chooses alone endorse nothing
+	signWithScheme(t, server.URL, rawDoc, []string{"user_data"}, icrypto.SchemeRaw, http.StatusBadRequest)
+	signWithScheme(t, server.URL, rawDoc, []string{"public_key"}, icrypto.SchemeEIP191, http.StatusBadRequest)
+	signWithScheme(t, server.URL, rawDoc, fields, "eip2612", http.StatusBadRequest)
+
+	sig, err := client.SignAttestationDocument(rawDoc, fields)
+	require.NoError(t, err, "failed to sign with default scheme")
+	require.NoError(t, client.VerifySignature(rawDoc, fields, "", sig, address))
+}
+
+// packedWithLayout prepends the layout hash of the scheme and type encoding
+func packedWithLayout(scheme icrypto.Scheme, layout string, packed []byte) []byte {
+	layoutHash := crypto.Keccak256([]byte(string(scheme) + ":" + layout))
+	return append(layoutHash, packed...)
+}
+
+func TestPackedSchemesCollisions(t *testing.T) {
+	provider, err := nitro.NewSimulator("", nil, "")
+	require.NoError(t, err, "failed to create simulator")
+
+	trustedDoc := newParsedDoc(t, provider, []byte("user data"), []byte{0x04, 0x01})
+	trusted, err := utils.BuildTypedDataAttestationMessage(trustedDoc, utils.DefaultPrimaryType, []string{"pcr0", "public_key"}, "")
+	require.NoError(t, err, "failed to build message")
+
+	// Enclave puts bytes of another layout in the field it chooses
+	packed, err := trusted.EncodePacked()
+	require.NoError(t, err, "failed to encode packed message")
+	forged, err := utils.BuildTypedDataAttestationMessage(newParsedDoc(t, provider, packed, nil), utils.DefaultPrimaryType, []string{"user_data"}, "")
+	require.NoError(t, err, "failed to build message")
+
+	for _, scheme := range []icrypto.Scheme{icrypto.SchemeEIP191, icrypto.SchemeRaw} {
+		trustedDigest, err := icrypto.Digest(scheme, nil, trusted)
+		require.NoError(t, err, "failed to get digest")
+		forgedDigest, err := icrypto.Digest(scheme, nil, forged)
+		require.NoError(t, err, "failed to get digest")
+		require.NotEqual(t, trustedDigest, forgedDigest, "%s digest of other layout must differ", scheme)
+	}
+
+	eip191Digest, err := icrypto.Digest(icrypto.SchemeEIP191, nil, trusted)
+	require.NoError(t, err, "failed to get digest")
+	rawDigest, err := icrypto.Digest(icrypto.SchemeRaw, nil, trusted)
+	require.NoError(t, err, "failed to get digest")
+	require.NotEqual(t, eip191Digest, rawDigest, "digest must be bound to the scheme")
+
+	// Enclave puts EIP712 preimage of the trusted measurements in user_data
+	eip712Digest, preimage, err := domain.TypedDataAndHash(trusted)
+	require.NoError(t, err, "failed to get typed data hash")
+	forged, err = utils.BuildTypedDataAttestationMessage(newParsedDoc(t, provider, []byte(preimage), nil), utils.DefaultPrimaryType, []string{"user_data"}, "")
+	require.NoError(t, err, "failed to build message")
+	rawDigest, err = icrypto.Digest(icrypto.SchemeRaw, nil, forged)
+	require.NoError(t, err, "failed to get digest")
+	require.Equal(t, eip712Digest, crypto.Keccak256([]byte(preimage)), "preimage must be of the EIP712 digest")
+	require.NotEqual(t, eip712Digest, rawDigest, "raw digest must not pass for EIP712 one")
+}
+
+func newParsedDoc(t *testing.T, provider nitro.AttestationProvider, userData, publicKey []byte) *attestation.NSMAttestationDoc {
+	raw, err := provider.GetAttestationDoc(userData, nil, publicKey)
+	require.NoError(t, err, "failed to get attestation document")
+	doc, err := attestation.ParseNSMAttestationDoc(raw)
+	require.NoError(t, err, "failed to parse attestation document")
+
+	return doc
+}
+
+func signWithScheme(t *testing.T, url string, doc []byte, fields []string, scheme icrypto.Scheme, status int) resources.SignedAttestationsAttributes {
+	body, err := json.Marshal(resources.SignAttestationsRequest{
+		Data: resources.SignAttestations{
+			Key: resources.Key{Type: resources.ATTESTATIONS},
+			Attributes: resources.SignAttestationsAttributes{
+				Attestation:  base64.StdEncoding.EncodeToString(doc),
+				Domain:       domain.TypedDataDomain,
+				FieldsToSign: fields,
+				Scheme:       string(scheme),
+			},
+		},
+	})
+	require.NoError(t, err, "failed to marshal request")
+
+	res, err := http.Post(url, "application/json", bytes.NewReader(body))
+	require.NoError(t, err, "failed to send request")
+	defer res.Body.Close()
+	require.Equal(t, status, res.StatusCode)
+
+	var signed resources.SignedAttestationsResponse
+	if status == http.StatusOK {
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&signed), "failed to decode response")
+	}
+
+	return signed.Data.Attributes
+}
@@ -0,0 +1,78 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/icrypto"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/service/handlers"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/resources"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignResponseTypedData(t *testing.T) {
+	cfg, address := newSimulatedService(t, nil)
+
+	server := newTestServer(cfg, handlers.VerifyAttestation)
+	defer server.Close()
+
+	doc, err := cfg.GetAttestationProvider().GetAttestationDoc([]byte("user data"), nil, []byte{0x04, 0x01})
+	require.NoError(t, err, "failed to get attestation document")
+
+	body, err := json.Marshal(resources.SignAttestationsRequest{
+		Data: resources.SignAttestations{
+			Key: resources.Key{Type: resources.ATTESTATIONS},
+			Attributes: resources.SignAttestationsAttributes{
+				Attestation:  base64.StdEncoding.EncodeToString(doc),
+				Domain:       domain.TypedDataDomain,
+				FieldsToSign: []string{"pcr0", "public_key", "user_data", "timestamp", "module_id"},
+			},
+		},
+	})
+	require.NoError(t, err, "failed to marshal request")
+
+	res, err := http.Post(server.URL, "application/json", bytes.NewReader(body))
+	require.NoError(t, err, "failed to send request")
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	var signed resources.SignedAttestationsResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&signed), "failed to decode response")
+	attrs := signed.Data.Attributes
+
+	require.Equal(t, address.Hex(), attrs.Signer)
+
+	// Typed data is enough to reproduce the digest without the service helpers
+	var typedData apitypes.TypedData
+	require.NoError(t, json.Unmarshal(attrs.TypedData, &typedData), "typed data must be eth_signTypedData_v4 JSON")
+	require.Contains(t, typedData.Types, icrypto.DomainType)
+
+	digest, _, err := apitypes.TypedDataAndHash(typedData)
+	require.NoError(t, err, "failed to hash returned typed data")
+	require.Equal(t, hexutil.Encode(digest), attrs.Digest)
+
+	separator, err := typedData.HashStruct(icrypto.DomainType, typedData.Domain.Map())
+	require.NoError(t, err, "failed to hash returned domain")
+	require.Equal(t, separator.String(), attrs.DomainSeparator)
+	require.Equal(t, typedData.TypeHash(typedData.PrimaryType).String(), attrs.TypeHash)
+
+	rawDigest := crypto.Keccak256(append(append([]byte("\x19\x01"), separator...), mustHashStruct(t, typedData)...))
+	require.Equal(t, []byte(digest), rawDigest)
+
+	sig, err := base64.StdEncoding.DecodeString(attrs.Signature)
+	require.NoError(t, err, "failed to decode signature")
+	require.NoError(t, icrypto.VerifySignature(digest, sig, common.HexToAddress(attrs.Signer)))
+}
+
+func mustHashStruct(t *testing.T, typedData apitypes.TypedData) []byte {
+	hash, err := typedData.HashStruct(typedData.PrimaryType, typedData.Message)
+	require.NoError(t, err, "failed to hash message")
+	return hash
+}
@@ -0,0 +1,53 @@
+package tests
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/service/handlers"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/sdk"
+	"github.com/distributed-lab/enclave-extras/attestation"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignerIdentity(t *testing.T) {
+	cfg, address := newSimulatedService(t, nil)
+
+	server := newTestServer(cfg, handlers.GetSigner)
+	defer server.Close()
+
+	client, err := sdk.NewInetClient(server.URL, domain.TypedDataDomain, nil)
+	require.NoError(t, err, "failed to create inet client")
+
+	identity, err := client.GetSigner()
+	require.NoError(t, err, "failed to get signer")
+	require.Equal(t, address, identity.Address)
+
+	publicKey, err := crypto.UnmarshalPubkey(identity.PublicKey)
+	require.NoError(t, err, "public key must be uncompressed secp256k1 key")
+	require.Equal(t, address, crypto.PubkeyToAddress(*publicKey))
+
+	// Trust is bootstrapped from the documents alone
+	verifier := cfg.GetVerifier()
+	for name, raw := range map[string][]byte{
+		"kms_key_id": identity.KMSKeyIDAttestation,
+		"public_key": identity.PublicKeyAttestation,
+		"address":    identity.AddressAttestation,
+	} {
+		doc, err := attestation.ParseNSMAttestationDoc(raw)
+		require.NoError(t, err, "failed to parse %s document", name)
+		_, err = verifier.Verify(doc)
+		require.NoError(t, err, "invalid %s document", name)
+		require.True(t, bytes.Equal(identity.PCRs[0], doc.PCRs[0]), "%s document PCR0 must match running PCR0", name)
+
+		switch name {
+		case "kms_key_id":
+			require.Equal(t, identity.KMSKeyID, string(doc.UserData))
+		case "public_key":
+			require.Equal(t, identity.PublicKey, doc.PublicKey)
+		case "address":
+			require.Equal(t, address.Bytes(), doc.UserData)
+		}
+	}
+}
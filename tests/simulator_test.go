@@ -0,0 +1,69 @@
+package tests
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/nitro"
+	"github.com/distributed-lab/enclave-extras/attestation"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSimulatorAttestationDoc(t *testing.T) {
+	pcr0 := bytes.Repeat([]byte{0xaa}, 48)
+
+	simulator, err := nitro.NewSimulator("", map[int][]byte{0: pcr0}, t.TempDir())
+	require.NoError(t, err, "failed to create simulator")
+
+	actualPCR0, err := simulator.DescribePCR(0)
+	require.NoError(t, err, "failed to describe PCR0")
+	require.Equal(t, pcr0, actualPCR0, "unexpected PCR0")
+
+	docRaw, err := simulator.GetAttestationDoc([]byte("user data"), []byte("nonce"), []byte("public key"))
+	require.NoError(t, err, "failed to get attestation document")
+
+	doc, err := attestation.ParseNSMAttestationDoc(docRaw)
+	require.NoError(t, err, "failed to parse simulated attestation document")
+	require.Equal(t, pcr0, doc.PCRs[0], "unexpected PCR0 in document")
+	require.Equal(t, []byte("user data"), doc.UserData, "unexpected user data")
+	require.Equal(t, []byte("nonce"), doc.Nonce, "unexpected nonce")
+	require.Equal(t, []byte("public key"), doc.PublicKey, "unexpected public key")
+
+	root, err := nitro.VerifyAttestationDoc(doc, simulator.RootFingerprint())
+	require.NoError(t, err, "simulated document must be verifiable against simulator root")
+	require.Equal(t, simulator.RootFingerprint(), root, "unexpected matched root")
+
+	_, err = nitro.VerifyAttestationDoc(doc, attestation.AWSNitroEnclavesRootCertFingerprint)
+	require.ErrorIs(t, err, nitro.ErrUntrustedRoot, "simulated document must not chain to AWS root")
+
+	require.Error(t, doc.Verify(), "simulated document must not pass AWS verification")
+}
+
+func TestSimulatorPersistentRoot(t *testing.T) {
+	caDirectory := t.TempDir()
+
+	first, err := nitro.NewSimulator("", nil, caDirectory)
+	require.NoError(t, err, "failed to create simulator")
+
+	second, err := nitro.NewSimulator("", nil, caDirectory)
+	require.NoError(t, err, "failed to create simulator")
+
+	require.Equal(t, first.RootFingerprint(), second.RootFingerprint(), "root must be reused from CA directory")
+
+	docRaw, err := first.GetAttestationDoc(nil, nil, nil)
+	require.NoError(t, err, "failed to get attestation document")
+
+	doc, err := attestation.ParseNSMAttestationDoc(docRaw)
+	require.NoError(t, err, "failed to parse simulated attestation document")
+
+	_, err = nitro.VerifyAttestationDoc(doc, second.RootFingerprint())
+	require.NoError(t, err, "document must survive simulator restart")
+}
+
+func TestSimulatorInvalidPCR(t *testing.T) {
+	_, err := nitro.NewSimulator("", map[int][]byte{0: {0x01}}, "")
+	require.ErrorIs(t, err, nitro.ErrInvalidPCRValue)
+
+	_, err = nitro.NewSimulator("", map[int][]byte{16: make([]byte, 48)}, "")
+	require.ErrorIs(t, err, nitro.ErrInvalidPCRIndex)
+}
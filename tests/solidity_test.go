@@ -0,0 +1,92 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/icrypto"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/solidity"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/utils"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/service/handlers"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateSolidity(t *testing.T) {
+	cfg, _ := newSimulatedService(t, nil)
+
+	server := newTestServer(cfg, handlers.VerifyAttestation)
+	defer server.Close()
+
+	fields := []string{"pcr0", "public_key", "module_id"}
+	encodings := map[string]string{"pcr0": "split", "module_id": "keccak256"}
+	generator := &solidity.Generator{
+		Library:     "RegisterVerifier",
+		Domain:      &domain,
+		PrimaryType: utils.DefaultPrimaryType,
+		Fields:      fields,
+		Encodings:   encodings,
+	}
+
+	source, err := generator.Solidity()
+	require.NoError(t, err, "failed to generate library")
+	vectors, err := generator.Vectors()
+	require.NoError(t, err, "failed to generate vectors")
+
+	require.Contains(t, string(source), "library RegisterVerifier {")
+	require.Contains(t, string(source), `keccak256("`+vectors.TypeString+`")`)
+	require.Contains(t, string(source), "DOMAIN_SEPARATOR = "+vectors.DomainSeparator+";")
+	require.Contains(t, string(source), "keccak256(data.public_key)")
+
+	// Generated constants are the ones the service signs with
+	rawDoc, err := cfg.GetAttestationProvider().GetAttestationDoc(nil, nil, []byte{0x04, 0x01})
+	require.NoError(t, err, "failed to get attestation document")
+	attrs := signEncoded(t, server.URL, rawDoc, fields, encodings, icrypto.SchemeEIP712, http.StatusOK)
+	require.Equal(t, attrs.TypeHash, vectors.TypeHash)
+	require.Equal(t, attrs.DomainSeparator, vectors.DomainSeparator)
+
+	require.Len(t, vectors.Vectors, 2)
+	require.NotEqual(t, vectors.Vectors[0].Digest, vectors.Vectors[1].Digest)
+	for _, vector := range vectors.Vectors {
+		var typedData apitypes.TypedData
+		require.NoError(t, json.Unmarshal(vector.TypedData, &typedData), "failed to unmarshal typed data")
+		digest, _, err := apitypes.TypedDataAndHash(typedData)
+		require.NoError(t, err, "failed to hash typed data")
+		require.Equal(t, hexutil.Encode(digest), vector.Digest)
+
+		structHash, err := typedData.HashStruct(typedData.PrimaryType, typedData.Message)
+		require.NoError(t, err, "failed to hash message")
+		require.Equal(t, hexutil.Encode(structHash), vector.StructHash)
+
+		signature, err := hexutil.Decode(vector.Signature)
+		require.NoError(t, err, "failed to decode signature")
+		require.NoError(t, icrypto.VerifySignature(digest, signature, vectors.Signer))
+	}
+
+	// Validity adds the deadline check
+	generator.TTL = time.Hour
+	source, err = generator.Solidity()
+	require.NoError(t, err, "failed to generate expiring library")
+	require.Contains(t, string(source), "uint64 deadline;")
+	require.Contains(t, string(source), "block.timestamp > data.deadline")
+	vectors, err = generator.Vectors()
+	require.NoError(t, err, "failed to generate expiring vectors")
+	require.True(t, strings.HasSuffix(vectors.TypeString, ",uint64 issued_at,uint64 deadline)"))
+	require.EqualValues(t, 3600, vectors.Vectors[0].Inputs["deadline"].(int64)-vectors.Vectors[0].Inputs["issued_at"].(int64))
+
+	for name, generator := range map[string]*solidity.Generator{
+		"library named as primary type": {Library: "Register", Domain: &domain, PrimaryType: "Register", Fields: fields},
+		"solidity keyword":              {Library: "Verifier", Domain: &domain, PrimaryType: "Register", Fields: []string{"user_data"}, Encodings: map[string]string{"user_data": "abi(address address)"}},
+		"unknown field":                 {Library: "Verifier", Domain: &domain, PrimaryType: "Register", Fields: []string{"wallet"}},
+		"encoding of unsigned":          {Library: "Verifier", Domain: &domain, PrimaryType: "Register", Fields: fields, Encodings: map[string]string{"nonce": "keccak256"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := generator.Solidity()
+			require.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package tests
+
+import (
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/config"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/fakes3"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+const testBucket = "attestations"
+
+func newS3Storage(t *testing.T, prefix string) storage.Storage {
+	server := httptest.NewServer(fakes3.New(testBucket))
+	t.Cleanup(server.Close)
+
+	client := s3.New(s3.Options{
+		Region:                     "us-east-1",
+		BaseEndpoint:               aws.String(server.URL),
+		UsePathStyle:               true,
+		Credentials:                credentials.NewStaticCredentialsProvider("test", "test", ""),
+		RequestChecksumCalculation: aws.RequestChecksumCalculationWhenRequired,
+		ResponseChecksumValidation: aws.ResponseChecksumValidationWhenRequired,
+	})
+
+	return storage.NewS3(client, testBucket, prefix)
+}
+
+func TestStorage(t *testing.T) {
+	backends := map[string]func(t *testing.T) storage.Storage{
+		"local":     func(t *testing.T) storage.Storage { return newLocalStorage(t, t.TempDir()) },
+		"s3":        func(t *testing.T) storage.Storage { return newS3Storage(t, "") },
+		"s3 prefix": func(t *testing.T) storage.Storage { return newS3Storage(t, "enclave/prod") },
+	}
+
+	for name, newStorage := range backends {
+		t.Run(name, func(t *testing.T) {
+			store := newStorage(t)
+
+			_, err := store.Read("address.coses1")
+			require.ErrorIs(t, err, storage.ErrNotExist)
+			_, err = store.ModTime("address.coses1")
+			require.ErrorIs(t, err, storage.ErrNotExist)
+
+			require.NoError(t, store.Write("address.coses1", []byte("v1")))
+			require.NoError(t, store.Write("address.coses1", []byte("v2")), "write must replace")
+			data, err := store.Read("address.coses1")
+			require.NoError(t, err)
+			require.Equal(t, []byte("v2"), data)
+
+			modTime, err := store.ModTime("address.coses1")
+			require.NoError(t, err)
+			require.False(t, modTime.IsZero())
+
+			require.NoError(t, store.Create("generations/1/.claim", nil))
+			require.ErrorIs(t, store.Create("generations/1/.claim", []byte("other")), storage.ErrExist)
+			require.NoError(t, store.Write("generations/1/generation.json", []byte("{}")))
+			require.NoError(t, store.Write("generations/10/generation.json", []byte("{}")))
+
+			names, err := store.List("generations")
+			require.NoError(t, err)
+			require.Equal(t, []string{"generations/1/.claim", "generations/1/generation.json", "generations/10/generation.json"}, names)
+
+			names, err = store.List("missing")
+			require.NoError(t, err)
+			require.Empty(t, names)
+
+			sub := storage.Sub(store, "generations/1")
+			data, err = sub.Read("generation.json")
+			require.NoError(t, err)
+			require.Equal(t, []byte("{}"), data)
+
+			names, err = sub.List("")
+			require.NoError(t, err)
+			require.Equal(t, []string{".claim", "generation.json"}, names)
+		})
+	}
+}
+
+func TestLocalStorageInterruptedWrite(t *testing.T) {
+	root := t.TempDir()
+	store := newLocalStorage(t, root)
+
+	require.NoError(t, store.Write("address.coses1", []byte("complete")))
+
+	// Temporary file left by a write interrupted before rename
+	require.NoError(t, os.WriteFile(path.Join(root, ".tmp-123456"), []byte("trunc"), 0600))
+
+	names, err := store.List("")
+	require.NoError(t, err)
+	require.Equal(t, []string{"address.coses1"}, names, "temporary files must not be listed")
+
+	data, err := store.Read("address.coses1")
+	require.NoError(t, err)
+	require.Equal(t, []byte("complete"), data)
+
+	// Names can't escape the root
+	require.NoError(t, store.Write("../escaped", []byte("data")))
+	_, err = os.Stat(path.Join(root, "escaped"))
+	require.NoError(t, err)
+}
+
+func TestLocalStoragePermissions(t *testing.T) {
+	root := t.TempDir()
+	store := newLocalStorage(t, root)
+
+	modes := map[string]os.FileMode{
+		"generations/1/kms_key_id.coses1":  0644,
+		"generations/1/public_key.coses1":  0644,
+		"generations/1/address.coses1":     0644,
+		"generations/1/generation.json":    0644,
+		"generations/1/private_key.coses1": 0600,
+	}
+	for name := range modes {
+		require.NoError(t, store.Write(name, []byte("data")))
+	}
+	require.NoError(t, store.Create("generations/2/.claim", nil))
+	modes["generations/2/.claim"] = 0644
+
+	for name, mode := range modes {
+		info, err := os.Stat(path.Join(root, name))
+		require.NoError(t, err)
+		require.Equal(t, mode, info.Mode().Perm(), "unexpected mode of %s", name)
+	}
+}
+
+func TestSignerS3Storage(t *testing.T) {
+	server := httptest.NewServer(fakes3.New(testBucket))
+	t.Cleanup(server.Close)
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+
+	newConfig := func() config.Config {
+		return newTestConfig(map[string]map[string]interface{}{
+			"signer": {
+				"storage": config.StorageS3,
+				"nsm":     config.NSMSimulator,
+				"kms":     config.KMSFake,
+			},
+			"s3_storage": {
+				"bucket":     testBucket,
+				"prefix":     "enclave",
+				"region":     "us-east-1",
+				"endpoint":   server.URL,
+				"path_style": true,
+			},
+			"nsm_simulator": {"ca_directory": t.TempDir()},
+			"fake_kms":      {"directory": t.TempDir()},
+		})
+	}
+
+	cfg := newConfig()
+	signer := cfg.GetSigner()
+	require.Equal(t, "1", signer.Current().ID)
+
+	rotated, err := signer.Rotate()
+	require.NoError(t, err, "failed to rotate key on S3 storage")
+	require.Equal(t, "2", rotated.ID)
+
+	names, err := cfg.GetStorage().List("generations/2")
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"generations/2/.claim",
+		"generations/2/address.coses1",
+		"generations/2/generation.json",
+		"generations/2/kms_key_id.coses1",
+		"generations/2/private_key.coses1",
+		"generations/2/public_key.coses1",
+	}, names)
+}
@@ -0,0 +1,215 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/icrypto"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/service/handlers"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/resources"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/sdk"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCallerSuppliedTypedData(t *testing.T) {
+	cfg, address := newSimulatedService(t, nil)
+
+	server := newTestServer(cfg, handlers.VerifyAttestation)
+	defer server.Close()
+
+	rawDoc, err := cfg.GetAttestationProvider().GetAttestationDoc(nil, nil, []byte{0x04, 0x01})
+	require.NoError(t, err, "failed to get attestation document")
+
+	types := apitypes.Types{
+		"Registration": {
+			{Name: "image", Type: "Image"},
+			{Name: "wallet", Type: "address"},
+			{Name: "deadline", Type: "uint256"},
+			{Name: "issuedAt", Type: "uint256"},
+			{Name: "tags", Type: "string[]"},
+		},
+		"Image": {
+			{Name: "pcr0", Type: "bytes"},
+			{Name: "key", Type: "bytes"},
+		},
+	}
+	message := func() map[string]interface{} {
+		return map[string]interface{}{
+			"image":    map[string]interface{}{"pcr0": "$pcr0", "key": "$public_key"},
+			"wallet":   "0x1c56346cd2a2bf3202f771f50d3d14a367b48070",
+			"deadline": "1700000000",
+			"issuedAt": "$timestamp",
+			"tags":     []interface{}{"prod"},
+		}
+	}
+
+	attrs := signTypedData(t, server.URL, rawDoc, "Registration", types, message(), nil, http.StatusOK)
+
+	// Returned typed data reproduces the digest with attested values filled
+	var typedData apitypes.TypedData
+	require.NoError(t, json.Unmarshal(attrs.TypedData, &typedData), "failed to unmarshal typed data")
+	require.Equal(t, "Registration", typedData.PrimaryType)
+	image := typedData.Message["image"].(map[string]interface{})
+	require.Equal(t, hexutil.Encode([]byte{0x04, 0x01}), image["key"])
+	hash, _, err := apitypes.TypedDataAndHash(typedData)
+	require.NoError(t, err, "failed to hash typed data")
+	require.Equal(t, hexutil.Encode(hash), attrs.Digest)
+
+	client, err := sdk.NewInetClient(server.URL, domain.TypedDataDomain, &[]string{"Registration"}[0])
+	require.NoError(t, err, "failed to create client")
+	endorsement, err := client.EndorseTypedData(rawDoc, types, message())
+	require.NoError(t, err, "failed to sign typed data with SDK")
+	require.NoError(t, client.VerifyTypedDataEndorsement(rawDoc, types, message(), "", endorsement, address))
+
+	otherDoc, err := cfg.GetAttestationProvider().GetAttestationDoc(nil, nil, []byte{0x04, 0x02})
+	require.NoError(t, err, "failed to get attestation document")
+	require.Error(t, client.VerifyTypedDataEndorsement(otherDoc, types, message(), "", endorsement, address), "placeholders must be bound to the document")
+
+	withTypes := func(name string, fields []apitypes.Type) apitypes.Types {
+		changed := apitypes.Types{}
+		for typeName, typeFields := range types {
+			changed[typeName] = typeFields
+		}
+		changed[name] = fields
+		return changed
+	}
+
+	// Fields named after attestation fields in another case hold their placeholders
+	signTypedData(t, server.URL, rawDoc, "Registration",
+		withTypes("Image", []apitypes.Type{{Name: "PCR0", Type: "bytes"}, {Name: "publicKey", Type: "bytes"}}),
+		map[string]interface{}{
+			"image":    map[string]interface{}{"PCR0": "$pcr0", "publicKey": "$public_key"},
+			"wallet":   "0x1c56346cd2a2bf3202f771f50d3d14a367b48070",
+			"deadline": "1700000000",
+			"issuedAt": "$timestamp",
+			"tags":     []interface{}{"prod"},
+		}, nil, http.StatusOK)
+
+	for name, tc := range map[string]struct {
+		types   apitypes.Types
+		message func(map[string]interface{})
+		attrs   func(*resources.SignAttestationsAttributes)
+	}{
+		"literal attestation field": {
+			message: func(m map[string]interface{}) {
+				m["image"] = map[string]interface{}{"pcr0": "0x01", "key": "$public_key"}
+			},
+		},
+		"placeholder of other field": {
+			message: func(m map[string]interface{}) {
+				m["image"] = map[string]interface{}{"pcr0": "$pcr1", "key": "$public_key"}
+			},
+		},
+		"literal camel case attestation field": {
+			types: withTypes("Image", []apitypes.Type{{Name: "pcr0", Type: "bytes"}, {Name: "publicKey", Type: "bytes"}}),
+			message: func(m map[string]interface{}) {
+				m["image"] = map[string]interface{}{"pcr0": "$pcr0", "publicKey": "0x0401"}
+			},
+		},
+		"literal field named after user data": {
+			types: withTypes("Image", []apitypes.Type{{Name: "pcr0", Type: "bytes"}, {Name: "key", Type: "bytes"}, {Name: "userData", Type: "bytes"}}),
+			message: func(m map[string]interface{}) {
+				m["image"] = map[string]interface{}{"pcr0": "$pcr0", "key": "$public_key", "userData": "0x01"}
+			},
+		},
+		"literal field prefixed with pcr": {
+			types: withTypes("Image", []apitypes.Type{{Name: "pcr0Value", Type: "bytes"}, {Name: "key", Type: "bytes"}}),
+			message: func(m map[string]interface{}) {
+				m["image"] = map[string]interface{}{"pcr0Value": "0x01", "key": "$public_key"}
+			},
+		},
+		"other placeholder in field prefixed with pcr": {
+			types: withTypes("Image", []apitypes.Type{{Name: "PCR0_hash", Type: "bytes"}, {Name: "key", Type: "bytes"}}),
+			message: func(m map[string]interface{}) {
+				m["image"] = map[string]interface{}{"PCR0_hash": "$pcr1", "key": "$public_key"}
+			},
+		},
+		"truncated placeholder": {
+			types: withTypes("Image", []apitypes.Type{{Name: "pcr0", Type: "bytes32"}, {Name: "key", Type: "bytes"}}),
+		},
+		"narrow integer placeholder": {
+			types: withTypes("Registration", append(append([]apitypes.Type{}, types["Registration"][:3]...),
+				apitypes.Type{Name: "issuedAt", Type: "uint32"}, types["Registration"][4])),
+		},
+		"unknown placeholder": {
+			message: func(m map[string]interface{}) { m["wallet"] = "$wallet" },
+		},
+		"placeholder in array": {
+			message: func(m map[string]interface{}) { m["tags"] = []interface{}{"$module_id"} },
+		},
+		"no placeholders": {
+			types: apitypes.Types{"Registration": {{Name: "wallet", Type: "address"}}},
+			message: func(m map[string]interface{}) {
+				for key := range m {
+					if key != "wallet" {
+						delete(m, key)
+					}
+				}
+			},
+		},
+		"extra message field": {
+			message: func(m map[string]interface{}) { m["chainId"] = "1" },
+		},
+		"domain type redefined": {
+			types: withTypes(icrypto.DomainType, []apitypes.Type{{Name: "name", Type: "string"}}),
+		},
+		"invalid literal value": {
+			message: func(m map[string]interface{}) { m["wallet"] = "not an address" },
+		},
+		"fields to sign with types": {
+			attrs: func(a *resources.SignAttestationsAttributes) { a.FieldsToSign = []string{"pcr0"} },
+		},
+		"packed scheme with types": {
+			attrs: func(a *resources.SignAttestationsAttributes) { a.Scheme = string(icrypto.SchemeRaw) },
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			reqTypes, reqMessage := types, message()
+			if tc.types != nil {
+				reqTypes = tc.types
+			}
+			if tc.message != nil {
+				tc.message(reqMessage)
+			}
+			signTypedData(t, server.URL, rawDoc, "Registration", reqTypes, reqMessage, tc.attrs, http.StatusBadRequest)
+		})
+	}
+}
+
+func signTypedData(t *testing.T, url string, doc []byte, primaryType string, types apitypes.Types, message map[string]interface{}, modify func(*resources.SignAttestationsAttributes), status int) resources.SignedAttestationsAttributes {
+	attrs := resources.SignAttestationsAttributes{
+		Attestation: base64.StdEncoding.EncodeToString(doc),
+		Domain:      domain.TypedDataDomain,
+		PrimaryType: &primaryType,
+		Types:       types,
+		Message:     message,
+	}
+	if modify != nil {
+		modify(&attrs)
+	}
+
+	body, err := json.Marshal(resources.SignAttestationsRequest{
+		Data: resources.SignAttestations{
+			Key:        resources.Key{Type: resources.ATTESTATIONS},
+			Attributes: attrs,
+		},
+	})
+	require.NoError(t, err, "failed to marshal request")
+
+	res, err := http.Post(url, "application/json", bytes.NewReader(body))
+	require.NoError(t, err, "failed to send request")
+	defer res.Body.Close()
+	require.Equal(t, status, res.StatusCode)
+
+	var signed resources.SignedAttestationsResponse
+	if status == http.StatusOK {
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&signed), "failed to decode response")
+	}
+
+	return signed.Data.Attributes
+}
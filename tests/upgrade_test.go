@@ -0,0 +1,208 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/fakekms"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/nitro"
+	"github.com/distributed-lab/enclave-extras/attestation"
+	"github.com/stretchr/testify/require"
+)
+
+const upgradeAdminArn = "arn:aws:iam::000000000000:role/admin"
+
+func TestImageUpgrade(t *testing.T) {
+	var (
+		caDirectory = t.TempDir()
+		store       = newLocalStorage(t, t.TempDir())
+		oldPCR0     = bytes.Repeat([]byte{0x01}, 48)
+		newPCR0     = bytes.Repeat([]byte{0x02}, 48)
+		pcr8        = bytes.Repeat([]byte{0x08}, 48)
+	)
+
+	keyPolicy, err := nitro.NewKeyPolicy([]int{8}, true)
+	require.NoError(t, err, "failed to create key policy")
+	// Only the explicit admin updates the policy, so the enclave role
+	// creating the key doesn't pass the lockout check
+	keyPolicy.AdminArns = []string{upgradeAdminArn}
+	keyPolicy.BypassLockoutCheck = true
+
+	oldImage, err := nitro.NewSimulator("", map[int][]byte{0: oldPCR0, 8: pcr8}, caDirectory)
+	require.NoError(t, err, "failed to create simulator")
+	newImage, err := nitro.NewSimulator("", map[int][]byte{0: newPCR0, 8: pcr8}, caDirectory)
+	require.NoError(t, err, "failed to create simulator")
+
+	kmsBackend, err := fakekms.New(t.TempDir(), "", oldImage.RootFingerprint())
+	require.NoError(t, err, "failed to create fake KMS")
+	// Admin updates the policy outside the enclave
+	adminBackend, err := kmsBackend.WithPrincipal(upgradeAdminArn)
+	require.NoError(t, err, "failed to create fake KMS of the admin")
+
+	generation, err := nitro.CreateGeneration(kmsBackend, oldImage, keyPolicy, nitro.OnMismatchFail, store)
+	require.NoError(t, err, "failed to create generation")
+	generations, err := nitro.ListGenerations(store)
+	require.NoError(t, err, "failed to list generations")
+
+	// New image is refused before authorization
+	_, err = nitro.ReattestGenerations(kmsBackend, newImage, keyPolicy, store)
+	require.ErrorIs(t, err, fakekms.ErrAccessDenied, "unauthorized image must be refused by KMS")
+
+	// Enclave role isn't allowed to update the policy, even outside the enclave
+	_, err = nitro.AuthorizeImage(kmsBackend, oldImage, keyPolicy, store, map[int][]byte{0: newPCR0})
+	require.ErrorIs(t, err, fakekms.ErrAccessDenied, "enclave role must not update the policy")
+
+	// PCR8 is taken from the authorized image
+	image, err := nitro.AuthorizeImage(adminBackend, oldImage, keyPolicy, store, map[int][]byte{0: newPCR0})
+	require.NoError(t, err, "failed to authorize image")
+	require.Equal(t, image[nitro.PcrXCondition(0)], image[nitro.ImageSha384Condition])
+	require.Equal(t, hex.EncodeToString(pcr8), image[nitro.PcrXCondition(8)])
+
+	images, _, err := nitro.GetAuthorizedImages(kmsBackend, oldImage, store)
+	require.NoError(t, err, "failed to get authorized images")
+	require.Len(t, images, 2, "both images must be authorized")
+
+	// Stored documents of the old image are refused until re-attested
+	_, err = nitro.LoadGeneration(kmsBackend, newImage, keyPolicy, nitro.OnMismatchFail, generations[0])
+	require.ErrorIs(t, err, nitro.ErrDocumentMismatch)
+	_, err = nitro.LoadGenerationPublic(newImage, keyPolicy, generations[0])
+	require.ErrorIs(t, err, nitro.ErrDocumentMismatch)
+
+	// unless the old image is authorized by the key policy
+	authorized := keyPolicy
+	authorized.Images = images
+	public, err := nitro.LoadGenerationPublic(newImage, authorized, generations[0])
+	require.NoError(t, err, "documents of authorized image must load")
+	require.Equal(t, generation.Address, public.Address)
+
+	err = nitro.RetireImage(adminBackend, newImage, keyPolicy, store, oldPCR0)
+	require.ErrorContains(t, err, "must be re-attested first")
+
+	reattested, err := nitro.ReattestGenerations(kmsBackend, newImage, keyPolicy, store)
+	require.NoError(t, err, "failed to re-attest generations")
+	require.Equal(t, []string{generation.ID}, reattested)
+
+	upgraded, err := nitro.LoadGeneration(kmsBackend, newImage, keyPolicy, nitro.OnMismatchFail, generations[0])
+	require.NoError(t, err, "re-attested generation must load in the new image")
+	require.Equal(t, generation.Address, upgraded.Address, "signer key must be kept")
+
+	reattested, err = nitro.ReattestGenerations(kmsBackend, newImage, keyPolicy, store)
+	require.NoError(t, err, "failed to re-attest generations")
+	require.Empty(t, reattested, "re-attested documents must be kept")
+
+	err = nitro.RetireImage(adminBackend, newImage, keyPolicy, store, newPCR0)
+	require.ErrorContains(t, err, "must be re-attested first", "image of the stored documents must not be retired")
+
+	require.NoError(t, nitro.RetireImage(adminBackend, newImage, keyPolicy, store, oldPCR0), "failed to retire image")
+
+	err = nitro.RetireImage(adminBackend, newImage, keyPolicy, store, oldPCR0)
+	require.ErrorIs(t, err, nitro.ErrImageNotAuthorized)
+
+	err = nitro.RetireImage(adminBackend, newImage, keyPolicy, store, newPCR0)
+	require.ErrorContains(t, err, "only authorized image")
+
+	// Old image is refused by KMS after retirement
+	privateKeyDocRaw, err := generations[0].Storage.Read("private_key.coses1")
+	require.NoError(t, err, "failed to read private key document")
+	privateKeyDoc, err := attestation.ParseNSMAttestationDoc(privateKeyDocRaw)
+	require.NoError(t, err, "failed to parse private key document")
+
+	oldClient, err := kmsBackend.NewClient(oldImage)
+	require.NoError(t, err, "failed to create fake KMS client")
+	_, err = oldClient.Decrypt(context.Background(), &kms.DecryptInput{
+		KeyId:          aws.String(upgraded.KMSKeyID),
+		CiphertextBlob: privateKeyDoc.UserData,
+	})
+	require.ErrorIs(t, err, fakekms.ErrAccessDenied, "retired image must be refused")
+
+	_, err = nitro.LoadGeneration(kmsBackend, newImage, keyPolicy, nitro.OnMismatchFail, generations[0])
+	require.NoError(t, err, "new image must keep access")
+}
+
+// racingKMSBackend runs the hook once right after the first policy update,
+// like another process authorizing an image at the same time
+type racingKMSBackend struct {
+	*fakekms.Backend
+	hook func()
+}
+
+type racingKMSClient struct {
+	nitro.KMSClient
+	backend *racingKMSBackend
+}
+
+func (b *racingKMSBackend) NewAdminClient() (nitro.KMSClient, error) {
+	client, err := b.Backend.NewAdminClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return &racingKMSClient{KMSClient: client, backend: b}, nil
+}
+
+func (c *racingKMSClient) PutKeyPolicy(ctx context.Context, params *kms.PutKeyPolicyInput, optFns ...func(*kms.Options)) (*kms.PutKeyPolicyOutput, error) {
+	output, err := c.KMSClient.PutKeyPolicy(ctx, params, optFns...)
+	if hook := c.backend.hook; err == nil && hook != nil {
+		c.backend.hook = nil
+		hook()
+	}
+
+	return output, err
+}
+
+func TestImageUpgradeConcurrentUpdate(t *testing.T) {
+	var (
+		store = newLocalStorage(t, t.TempDir())
+		pcr8  = bytes.Repeat([]byte{0x08}, 48)
+	)
+
+	keyPolicy, err := nitro.NewKeyPolicy([]int{8}, false)
+	require.NoError(t, err, "failed to create key policy")
+	keyPolicy.AdminArns = []string{upgradeAdminArn}
+	keyPolicy.BypassLockoutCheck = true
+
+	provider, err := nitro.NewSimulator("", map[int][]byte{0: bytes.Repeat([]byte{0x01}, 48), 8: pcr8}, t.TempDir())
+	require.NoError(t, err, "failed to create simulator")
+
+	fakeBackend, err := fakekms.New(t.TempDir(), "", provider.RootFingerprint())
+	require.NoError(t, err, "failed to create fake KMS")
+	adminBackend, err := fakeBackend.WithPrincipal(upgradeAdminArn)
+	require.NoError(t, err, "failed to create fake KMS of the admin")
+	kmsBackend := &racingKMSBackend{Backend: adminBackend}
+
+	_, err = nitro.CreateGeneration(fakeBackend, provider, keyPolicy, nitro.OnMismatchFail, store)
+	require.NoError(t, err, "failed to create generation")
+
+	images, kmsKeyID, err := nitro.GetAuthorizedImages(fakeBackend, provider, store)
+	require.NoError(t, err, "failed to get authorized images")
+	other, err := keyPolicy.ImageConditions(map[int][]byte{0: bytes.Repeat([]byte{0x03}, 48), 8: pcr8})
+	require.NoError(t, err, "failed to get image conditions")
+
+	// Other process read the policy before this update and puts its own
+	// right after it, so this update is lost
+	kmsBackend.hook = func() {
+		otherPolicy := keyPolicy
+		otherPolicy.Images = append(images, other)
+		policy, err := otherPolicy.Render(fakeBackend, provider)
+		require.NoError(t, err, "failed to render key policy")
+
+		client, err := adminBackend.NewAdminClient()
+		require.NoError(t, err, "failed to create fake KMS client")
+		_, err = client.PutKeyPolicy(context.Background(), &kms.PutKeyPolicyInput{
+			KeyId:  aws.String(kmsKeyID),
+			Policy: aws.String(policy),
+		})
+		require.NoError(t, err, "failed to put key policy concurrently")
+	}
+	_, err = nitro.AuthorizeImage(kmsBackend, provider, keyPolicy, store, map[int][]byte{0: bytes.Repeat([]byte{0x02}, 48)})
+	require.ErrorIs(t, err, nitro.ErrKeyPolicyChanged, "lost update must be detected")
+
+	images, _, err = nitro.GetAuthorizedImages(fakeBackend, provider, store)
+	require.NoError(t, err, "failed to get authorized images")
+	require.Len(t, images, 2)
+	require.Equal(t, other, images[1], "only the other update must be kept")
+}
@@ -0,0 +1,118 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/icrypto"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/nitro"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/utils"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/service/handlers"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/resources"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/sdk"
+	"github.com/distributed-lab/enclave-extras/attestation"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpiringEndorsements(t *testing.T) {
+	cfg, address := newSimulatedService(t, map[string]map[string]interface{}{
+		"validity": {"ttl": "10m", "max_ttl": "1h"},
+	})
+
+	server := newTestServer(cfg, handlers.VerifyAttestation)
+	defer server.Close()
+
+	rawDoc, err := cfg.GetAttestationProvider().GetAttestationDoc([]byte("user data"), nil, []byte{0x04, 0x01})
+	require.NoError(t, err, "failed to get attestation document")
+	doc, err := attestation.ParseNSMAttestationDoc(rawDoc)
+	require.NoError(t, err, "failed to parse attestation document")
+
+	client, err := sdk.NewInetClient(server.URL, domain.TypedDataDomain, nil)
+	require.NoError(t, err, "failed to create client")
+
+	fields := []string{"pcr0", "public_key"}
+	for name, tc := range map[string]struct {
+		client *sdk.Client
+		ttl    time.Duration
+	}{
+		"config ttl":      {client: client, ttl: 10 * time.Minute},
+		"requested ttl":   {client: client.WithTTL(time.Minute), ttl: time.Minute},
+		"capped ttl":      {client: client.WithTTL(2 * time.Hour), ttl: time.Hour},
+		"packed with ttl": {client: client.WithScheme(icrypto.SchemeRaw), ttl: 10 * time.Minute},
+	} {
+		t.Run(name, func(t *testing.T) {
+			endorsement, err := tc.client.Endorse(rawDoc, fields)
+			require.NoError(t, err, "failed to endorse")
+			require.WithinDuration(t, time.Now(), endorsement.IssuedAt, time.Minute)
+			require.Equal(t, tc.ttl, endorsement.Deadline.Sub(endorsement.IssuedAt))
+
+			require.NoError(t, tc.client.VerifyEndorsement(rawDoc, fields, "", endorsement, address))
+			require.Error(t, tc.client.VerifySignature(rawDoc, fields, "", endorsement.Signature, address), "validity must be signed")
+
+			tampered := *endorsement
+			tampered.Deadline = tampered.Deadline.Add(time.Hour)
+			require.Error(t, tc.client.VerifyEndorsement(rawDoc, fields, "", &tampered, address), "deadline must be signed")
+
+			expired := *endorsement
+			expired.IssuedAt, expired.Deadline = expired.IssuedAt.Add(-2*time.Hour), expired.Deadline.Add(-2*time.Hour)
+			require.ErrorIs(t, tc.client.VerifyEndorsement(rawDoc, fields, "", &expired, address), icrypto.ErrExpired)
+		})
+	}
+
+	// Validity is packed as two uint64 after the fields
+	attrs := signWithScheme(t, server.URL, rawDoc, fields, icrypto.SchemeRaw, http.StatusOK)
+	require.NotNil(t, attrs.IssuedAt)
+	require.NotNil(t, attrs.Deadline)
+	packed := append(append([]byte{}, doc.PCRs[0]...), doc.PublicKey...)
+	packed = binary.BigEndian.AppendUint64(packed, uint64(attrs.IssuedAt.Unix()))
+	packed = binary.BigEndian.AppendUint64(packed, uint64(attrs.Deadline.Unix()))
+	packed = packedWithLayout(icrypto.SchemeRaw, "Register(bytes pcr0,bytes public_key,uint64 issued_at,uint64 deadline)", packed)
+	require.Equal(t, hexutil.Encode(packed), attrs.Message)
+
+	// Caller-supplied schema must carry the deadline
+	types := apitypes.Types{"Registration": {{Name: "pcr0", Type: "bytes"}, {Name: "deadline", Type: "uint256"}}}
+	typedClient, err := sdk.NewInetClient(server.URL, domain.TypedDataDomain, utils.AsPointer("Registration"))
+	require.NoError(t, err, "failed to create client")
+	message := map[string]interface{}{"pcr0": "$pcr0", "deadline": "$deadline"}
+	endorsement, err := typedClient.EndorseTypedData(rawDoc, types, message)
+	require.NoError(t, err, "failed to endorse typed data")
+	require.NoError(t, typedClient.VerifyTypedDataEndorsement(rawDoc, types, message, "", endorsement, address))
+
+	signTypedData(t, server.URL, rawDoc, "Registration", types, map[string]interface{}{"pcr0": "$pcr0", "deadline": "4102444800"}, nil, http.StatusBadRequest)
+	signTypedData(t, server.URL, rawDoc, "Registration", apitypes.Types{"Registration": {{Name: "pcr0", Type: "bytes"}}},
+		map[string]interface{}{"pcr0": "$pcr0"}, nil, http.StatusBadRequest)
+	signTypedData(t, server.URL, rawDoc, "Registration", types, message, func(a *resources.SignAttestationsAttributes) {
+		a.TTL = utils.AsPointer(uint64(0))
+	}, http.StatusBadRequest)
+}
+
+func TestCheckDeadline(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err, "failed to generate key")
+
+	provider, err := nitro.NewSimulator("", map[int][]byte{0: bytes.Repeat([]byte{0x01}, 48)}, t.TempDir())
+	require.NoError(t, err, "failed to create simulator")
+	rawDoc, err := provider.GetAttestationDoc(nil, nil, []byte{0x04, 0x01})
+	require.NoError(t, err, "failed to get attestation document")
+	doc, err := attestation.ParseNSMAttestationDoc(rawDoc)
+	require.NoError(t, err, "failed to parse attestation document")
+
+	issuedAt := time.Now().Add(-2 * time.Hour).Truncate(time.Second)
+	message, err := utils.BuildTypedDataAttestationMessage(doc, utils.DefaultPrimaryType, []string{"pcr0"}, "")
+	require.NoError(t, err, "failed to build message")
+	require.NoError(t, utils.WithValidity(message, utils.Validity{IssuedAt: issuedAt, Deadline: issuedAt.Add(time.Hour)}))
+
+	sig, _, err := domain.SignTypedData(message, key)
+	require.NoError(t, err, "failed to sign")
+
+	signer := crypto.PubkeyToAddress(key.PublicKey)
+	require.ErrorIs(t, domain.VerifyTypedData(message, sig, signer), icrypto.ErrExpired)
+	require.ErrorIs(t, icrypto.Verify(icrypto.SchemeEIP712, &domain, message, sig, signer), icrypto.ErrExpired)
+	require.NoError(t, icrypto.CheckDeadline(message, issuedAt.Add(time.Minute)))
+}
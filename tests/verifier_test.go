@@ -0,0 +1,168 @@
+package tests
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/config"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/nitro"
+	"github.com/distributed-lab/enclave-extras/attestation"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/distributed_lab/kit/kv"
+)
+
+func newTestConfig(values map[string]map[string]interface{}) config.Config {
+	return config.New(kv.GetterFunc(func(key string) (map[string]interface{}, error) {
+		return values[key], nil
+	}))
+}
+
+func TestVerifierRoots(t *testing.T) {
+	caDirectory := t.TempDir()
+
+	simulator, err := nitro.NewSimulator("", nil, caDirectory)
+	require.NoError(t, err, "failed to create simulator")
+
+	docRaw, err := simulator.GetAttestationDoc(nil, nil, nil)
+	require.NoError(t, err, "failed to get attestation document")
+
+	doc, err := attestation.ParseNSMAttestationDoc(docRaw)
+	require.NoError(t, err, "failed to parse attestation document")
+
+	tests := []struct {
+		name     string
+		verifier map[string]interface{}
+		wantErr  bool
+	}{
+		{
+			name:     "Default AWS root",
+			verifier: nil,
+			wantErr:  true,
+		},
+		{
+			name: "Pinned PEM root",
+			verifier: map[string]interface{}{
+				"roots": []interface{}{path.Join(caDirectory, "simulator_root.pem")},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Pinned fingerprint",
+			verifier: map[string]interface{}{
+				"root_fingerprints": []interface{}{hex.EncodeToString(simulator.RootFingerprint())},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Pinned another fingerprint",
+			verifier: map[string]interface{}{
+				"root_fingerprints": []interface{}{hex.EncodeToString(attestation.AWSNitroEnclavesRootCertFingerprint)},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			verifier := newTestConfig(map[string]map[string]interface{}{
+				"verifier": test.verifier,
+			}).GetVerifier()
+
+			root, err := verifier.Verify(doc)
+			if test.wantErr {
+				require.ErrorIs(t, err, nitro.ErrUntrustedRoot, "unexpected result")
+				return
+			}
+			require.NoError(t, err, "unexpected result")
+			require.Equal(t, simulator.RootFingerprint(), root, "unexpected matched root")
+		})
+	}
+}
+
+func TestVerifierTimePolicy(t *testing.T) {
+	caDirectory := t.TempDir()
+
+	simulator, err := nitro.NewSimulator("", nil, caDirectory)
+	require.NoError(t, err, "failed to create simulator")
+
+	docRaw, err := simulator.GetAttestationDoc(nil, nil, nil)
+	require.NoError(t, err, "failed to get attestation document")
+
+	doc, err := attestation.ParseNSMAttestationDoc(docRaw)
+	require.NoError(t, err, "failed to parse attestation document")
+
+	rootFingerprint := hex.EncodeToString(simulator.RootFingerprint())
+
+	tests := []struct {
+		name     string
+		verifier map[string]interface{}
+		at       time.Time
+		wantErr  error
+	}{
+		{
+			name:     "Fresh document",
+			verifier: map[string]interface{}{"max_age": "1m", "max_future_skew": "30s"},
+			at:       doc.Timestamp.Add(10 * time.Second),
+		},
+		{
+			name:     "Stale document",
+			verifier: map[string]interface{}{"max_age": "1m"},
+			at:       doc.Timestamp.Add(2 * time.Minute),
+			wantErr:  config.ErrStaleAttestation,
+		},
+		{
+			name:     "Future-dated document",
+			verifier: map[string]interface{}{"max_future_skew": "30s"},
+			at:       doc.Timestamp.Add(-time.Minute),
+			wantErr:  config.ErrFutureAttestation,
+		},
+		{
+			name:     "Chain checked at document timestamp",
+			verifier: map[string]interface{}{"chain_time": "document"},
+			at:       doc.Timestamp.Add(24 * time.Hour),
+		},
+		{
+			name:     "Chain checked at verification time",
+			verifier: map[string]interface{}{"chain_time": "now"},
+			at:       doc.Timestamp.Add(24 * time.Hour),
+			wantErr:  nitro.ErrCertificateExpired,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.verifier["root_fingerprints"] = []interface{}{rootFingerprint}
+			verifier := newTestConfig(map[string]map[string]interface{}{
+				"verifier": test.verifier,
+			}).GetVerifier()
+
+			_, err := verifier.VerifyAt(doc, test.at)
+			if test.wantErr != nil {
+				require.ErrorIs(t, err, test.wantErr, "unexpected result")
+				return
+			}
+			require.NoError(t, err, "unexpected result")
+		})
+	}
+}
+
+func TestVerifierHistoricalDocument(t *testing.T) {
+	docRaw, err := base64.StdEncoding.DecodeString(testAttDoc)
+	require.NoError(t, err, "failed to decode base64 attestation document")
+
+	doc, err := attestation.ParseNSMAttestationDoc(docRaw)
+	require.NoError(t, err, "failed to parse attestation document")
+
+	verifier := newTestConfig(map[string]map[string]interface{}{
+		"verifier": {"chain_time": "now"},
+	}).GetVerifier()
+
+	_, err = verifier.Verify(doc)
+	require.ErrorIs(t, err, nitro.ErrCertificateExpired, "fixture chain is expired at current time")
+
+	_, err = verifier.VerifyAt(doc, doc.Timestamp.Add(time.Minute))
+	require.NoError(t, err, "fixture must be valid at its own timestamp")
+}
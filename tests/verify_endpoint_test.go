@@ -0,0 +1,84 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/config"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/pkg/nitro"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/internal/service/handlers"
+	"github.com/distributed-lab/aws-nitro-enclaves-av/resources"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/distributed_lab/ape"
+	"gitlab.com/distributed_lab/logan/v3"
+)
+
+func verifyDocument(t *testing.T, cfg config.Config, doc []byte) (int, resources.AttestationDocumentAttributes) {
+	handler := ape.CtxMiddleware(
+		handlers.CtxLog(logan.New()),
+		handlers.CtxVerifier(cfg.GetVerifier()),
+		handlers.CtxPolicies(cfg.GetPolicies()),
+	)(http.HandlerFunc(handlers.VerifyAttestationDocument))
+
+	body, err := json.Marshal(resources.VerifyAttestationsRequest{
+		Data: resources.VerifyAttestations{
+			Key: resources.Key{Type: resources.ATTESTATIONS},
+			Attributes: resources.VerifyAttestationsAttributes{
+				Attestation: base64.StdEncoding.EncodeToString(doc),
+			},
+		},
+	})
+	require.NoError(t, err, "failed to marshal request")
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/v1/attestations/verify", bytes.NewReader(body)))
+
+	var res resources.AttestationDocumentResponse
+	if recorder.Code == http.StatusOK {
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &res), "failed to unmarshal response")
+	}
+
+	return recorder.Code, res.Data.Attributes
+}
+
+func TestVerifyEndpoint(t *testing.T) {
+	pcr0 := bytes.Repeat([]byte{0xaa}, 48)
+
+	simulator, err := nitro.NewSimulator("i-test-enc", map[int][]byte{0: pcr0}, t.TempDir())
+	require.NoError(t, err, "failed to create simulator")
+
+	doc, err := simulator.GetAttestationDoc([]byte("user data"), []byte("nonce"), nil)
+	require.NoError(t, err, "failed to get attestation document")
+
+	trusted := newTestConfig(map[string]map[string]interface{}{
+		"verifier": {"root_fingerprints": []interface{}{hex.EncodeToString(simulator.RootFingerprint())}},
+	})
+
+	status, attrs := verifyDocument(t, trusted, doc)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "i-test-enc", attrs.ModuleId)
+	require.Equal(t, "SHA384", attrs.Digest)
+	require.Equal(t, hex.EncodeToString(pcr0), attrs.Pcrs["pcr0"])
+	require.Equal(t, base64.StdEncoding.EncodeToString([]byte("user data")), *attrs.UserData)
+	require.Equal(t, base64.StdEncoding.EncodeToString([]byte("nonce")), *attrs.Nonce)
+	require.Nil(t, attrs.PublicKey)
+	require.Len(t, attrs.Certificates, 3, "leaf, intermediate and root expected")
+	require.Equal(t, hex.EncodeToString(simulator.RootFingerprint()), attrs.Certificates[2].Fingerprint)
+	require.True(t, attrs.Verdict.Valid)
+	require.Equal(t, hex.EncodeToString(simulator.RootFingerprint()), attrs.Verdict.RootFingerprint)
+
+	// AWS root is trusted by default, so the simulated document is rejected but still decoded
+	status, attrs = verifyDocument(t, newTestConfig(nil), doc)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "i-test-enc", attrs.ModuleId)
+	require.False(t, attrs.Verdict.Valid)
+	require.Contains(t, attrs.Verdict.Error, nitro.ErrUntrustedRoot.Error())
+
+	status, _ = verifyDocument(t, trusted, []byte("not a document"))
+	require.Equal(t, http.StatusBadRequest, status)
+}